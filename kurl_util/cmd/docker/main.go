@@ -3,11 +3,14 @@ package main
 import (
 	"bytes"
 	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/replicatedhq/kurl/pkg/docker"
 	kurlversion "github.com/replicatedhq/kurl/pkg/version"
 	kurlscheme "github.com/replicatedhq/kurlkinds/client/kurlclientset/scheme"
 	kurlv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
@@ -34,27 +37,34 @@ func main() {
 		os.Exit(-1)
 	}
 
-	if err := saveConfig(*configPath, *yamlSpecPath); err != nil {
+	if err := saveConfig(os.Stdout, *configPath, *yamlSpecPath); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func saveConfig(configPath string, yamlSpecPath string) error {
+// saveConfig merges the daemon config from the installer spec with the existing config at
+// configPath and writes the action dockerd needs to pick up the change (none, reload or restart)
+// to w.
+func saveConfig(w io.Writer, configPath string, yamlSpecPath string) error {
 	config, err := getDockerConfigFromYaml(yamlSpecPath)
 	if err != nil {
 		return errors.Wrap(err, "failed to load config")
 	}
 
-	if len(config) == 0 {
-		// don't mess with file's existence and permissions if both configs are empty
-		return nil
+	// the file is left untouched when the config is empty or does not change any setting
+	change, err := docker.ApplyDaemonConfig(configPath, config)
+	if err != nil {
+		return errors.Wrap(err, "failed to apply config")
 	}
 
-	// TODO: preserve permissions
-	if err := os.WriteFile(configPath, config, 0644); err != nil {
-		return errors.Wrapf(err, "failed to write file %s", configPath)
+	for _, warning := range change.Warnings {
+		log.Printf("warning: %s: %s", configPath, warning)
+	}
+	if change.BackupPath != "" {
+		log.Printf("updated %s keys %s, previous config saved to %s", configPath, strings.Join(change.ChangedKeys, ", "), change.BackupPath)
 	}
 
+	fmt.Fprintln(w, change.Action)
 	return nil
 }
 
@@ -3,7 +3,6 @@ package main
 import (
 	"bufio"
 	"bytes"
-	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -12,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/replicatedhq/kurl/pkg/docker"
 	kurlversion "github.com/replicatedhq/kurl/pkg/version"
 	kurlscheme "github.com/replicatedhq/kurlkinds/client/kurlclientset/scheme"
 	"gopkg.in/yaml.v2"
@@ -106,10 +106,11 @@ func mergeYAMLMaps(oldConfig map[string]interface{}, newConfig map[string]interf
 			continue
 		}
 
-		oldValMap, isOldMap := oldVal.(map[interface{}]interface{})
-		newValMap, isNewMap := newVal.(map[interface{}]interface{})
+		// maps already merged by a previous layer are map[string]interface{}
+		oldValMap, isOldMap := asStringMap(oldVal)
+		newValMap, isNewMap := asStringMap(newVal)
 		if isNewMap && isOldMap {
-			mergedConfig[key] = mergeYAMLMaps(convertToMapStringInterface(oldValMap), convertToMapStringInterface(newValMap))
+			mergedConfig[key] = mergeYAMLMaps(oldValMap, newValMap)
 			continue
 		}
 
@@ -131,41 +132,6 @@ func mergeYAMLMaps(oldConfig map[string]interface{}, newConfig map[string]interf
 	return mergedConfig
 }
 
-func mergeJSONMaps(oldConfig map[string]interface{}, newConfig map[string]interface{}) map[string]interface{} {
-	mergedConfig := make(map[string]interface{})
-
-	allKeys := mergeKeys(oldConfig, newConfig)
-	for _, key := range allKeys {
-		oldVal, oldOk := oldConfig[key]
-		newVal, newOk := newConfig[key]
-
-		if oldOk && !newOk {
-			mergedConfig[key] = oldVal
-			continue
-		}
-
-		if !oldOk && newOk {
-			mergedConfig[key] = newVal
-			continue
-		}
-
-		oldValMap, isOldMap := oldVal.(map[string]interface{})
-		if !isOldMap {
-			mergedConfig[key] = newVal
-			continue
-		}
-
-		newValMap, isNewMap := newVal.(map[string]interface{})
-		if !isNewMap {
-			mergedConfig[key] = newVal
-			continue
-		}
-
-		mergedConfig[key] = mergeJSONMaps(oldValMap, newValMap)
-	}
-	return mergedConfig
-}
-
 func mergeKeys(config1 map[string]interface{}, config2 map[string]interface{}) []string {
 	mergedMap := make(map[string]struct{})
 	for key := range config1 {
@@ -184,39 +150,7 @@ func mergeKeys(config1 map[string]interface{}, config2 map[string]interface{}) [
 }
 
 func mergeDockerConfigData(oldconfigdata []byte, newconfigdata []byte) ([]byte, error) {
-	oldconfigdata = bytes.TrimSpace(oldconfigdata)
-	newconfigdata = bytes.TrimSpace(newconfigdata)
-
-	if len(oldconfigdata) == 0 && len(newconfigdata) == 0 {
-		return nil, nil
-	}
-
-	if len(oldconfigdata) == 0 {
-		return newconfigdata, nil
-	}
-
-	if len(newconfigdata) == 0 {
-		return oldconfigdata, nil
-	}
-
-	oldconfig := make(map[string]interface{})
-	if err := json.Unmarshal(oldconfigdata, &oldconfig); err != nil {
-		return nil, errors.Wrap(err, "failed to parse existing config")
-	}
-
-	newconfig := make(map[string]interface{})
-	if err := json.Unmarshal(newconfigdata, &newconfig); err != nil {
-		return nil, errors.Wrap(err, "failed to parse new config")
-	}
-
-	mergedconfig := mergeJSONMaps(oldconfig, newconfig)
-
-	mergedconfigdata, err := json.MarshalIndent(mergedconfig, "", "  ")
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to marshal merged config")
-	}
-
-	return mergedconfigdata, nil
+	return docker.MergeDaemonConfig(oldconfigdata, newconfigdata)
 }
 
 func mergeYamlConfigData(oldconfigdata []byte, newconfigdata []byte) ([]byte, error) {
@@ -255,34 +189,90 @@ func mergeYamlConfigData(oldconfigdata []byte, newconfigdata []byte) ([]byte, er
 	return mergedconfigdata, nil
 }
 
-func mergeConfig(mergedYAMLPath string, baseYamlPath string, overlayYamlPath string) error {
-	baseConfig, err := getInstallerConfigFromYaml(baseYamlPath)
+// mergeOptions holds the files and settings used by mergeConfig.
+type mergeOptions struct {
+	MergedYAMLPath   string
+	ReportPath       string
+	Explain          bool
+	Schema           bool
+	BaseYAMLPath     string
+	OverlayYAMLPaths []string
+	Assignments      []string
+}
+
+// mergeConfig merges the base spec with all overlays and the flags layer, in this order, and
+// writes the result to MergedYAMLPath. when ReportPath is set the provenance of every field is
+// written to it and when Explain is set the fields changed by more than one layer are printed.
+// Schema selects the schema aware merge (see mergeInstallers).
+func mergeConfig(opts mergeOptions) error {
+	baseConfig, err := getInstallerConfigFromYaml(opts.BaseYAMLPath)
 	if err != nil {
 		return errors.Wrap(err, "failed to load base config")
 	}
+	layers := []installerLayer{{Source: opts.BaseYAMLPath, Data: baseConfig}}
+
+	for _, overlayYamlPath := range opts.OverlayYAMLPaths {
+		overlayConfig, err := getInstallerConfigFromYaml(overlayYamlPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to load overlay config %s", overlayYamlPath)
+		}
+		layers = append(layers, installerLayer{Source: overlayYamlPath, Data: overlayConfig})
+	}
 
-	overlayConfig, err := getInstallerConfigFromYaml(overlayYamlPath)
+	flags, err := flagsLayer(opts.Assignments)
 	if err != nil {
-		return errors.Wrap(err, "failed to load overlay config")
+		return errors.Wrap(err, "failed to parse flags")
 	}
+	layers = append(layers, flags)
 
-	mergedConfig, err := mergeYamlConfigData(baseConfig, overlayConfig)
+	merge := mergeUntyped
+	if opts.Schema {
+		merge = mergeInstallers
+	}
+
+	mergedConfig, report, err := mergeLayers(layers, merge)
 	if err != nil {
 		return errors.Wrap(err, "failed to merge configs")
 	}
 
+	if opts.ReportPath != "" {
+		reportData, err := yaml.Marshal(report)
+		if err != nil {
+			return errors.Wrap(err, "failed to marshal provenance report")
+		}
+		if err := writeSpec(opts.ReportPath, reportData); err != nil {
+			return errors.Wrapf(err, "failed to write file %s", opts.ReportPath)
+		}
+	}
+
+	if opts.Explain {
+		fmt.Print(report.String())
+	}
+
 	if len(mergedConfig) == 0 {
 		// don't mess with file's existence and permissions if both configs are empty
 		return nil
 	}
 
-	if err := writeSpec(mergedYAMLPath, mergedConfig); err != nil {
-		return errors.Wrapf(err, "failed to write file %s", mergedYAMLPath)
+	if err := writeSpec(opts.MergedYAMLPath, mergedConfig); err != nil {
+		return errors.Wrapf(err, "failed to write file %s", opts.MergedYAMLPath)
 	}
 
 	return nil
 }
 
+// stringSliceFlag is a flag that can be provided multiple times.
+type stringSliceFlag []string
+
+func (s *stringSliceFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSliceFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
 func writeSpec(filename string, spec []byte) error {
 	err := os.MkdirAll(filepath.Dir(filename), 0755)
 	if err != nil {
@@ -309,7 +299,13 @@ func main() {
 	version := flag.Bool("v", false, "Print version info")
 	mergedYAMLPath := flag.String("m", "", "combined file name")
 	baseYAMLPath := flag.String("b", "", "base YAML file name")
-	overlayYAMLPath := flag.String("o", "", "overlay YAML file name")
+	reportPath := flag.String("p", "", "provenance report file name, lists the layer that set each field of the combined file")
+	schema := flag.Bool("schema", false, "merge using the Installer schema: lists are combined, add-ons set to null are removed and values with the wrong type are rejected. without it null values keep the base value and lists are replaced")
+	explain := flag.Bool("explain", false, "print the fields set by more than one layer and the value set by each layer")
+	var overlayYAMLPaths stringSliceFlag
+	flag.Var(&overlayYAMLPaths, "o", "overlay YAML file name, can be provided multiple times. overlays are applied in order")
+	var assignments stringSliceFlag
+	flag.Var(&assignments, "s", "set a field, in the form path=value (e.g. spec.kubernetes.version=1.27.x), applied after all overlays. can be provided multiple times")
 
 	flag.Parse()
 
@@ -318,12 +314,20 @@ func main() {
 		return
 	}
 
-	if *mergedYAMLPath == "" || *baseYAMLPath == "" || *overlayYAMLPath == "" {
+	if *mergedYAMLPath == "" || *baseYAMLPath == "" || (len(overlayYAMLPaths) == 0 && len(assignments) == 0) {
 		flag.PrintDefaults()
 		os.Exit(-1)
 	}
 
-	if err := mergeConfig(*mergedYAMLPath, *baseYAMLPath, *overlayYAMLPath); err != nil {
+	if err := mergeConfig(mergeOptions{
+		MergedYAMLPath:   *mergedYAMLPath,
+		ReportPath:       *reportPath,
+		Explain:          *explain,
+		Schema:           *schema,
+		BaseYAMLPath:     *baseYAMLPath,
+		OverlayYAMLPaths: overlayYAMLPaths,
+		Assignments:      assignments,
+	}); err != nil {
 		log.Fatal(err)
 	}
 }
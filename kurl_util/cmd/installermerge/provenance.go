@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// flagsLayerSource is the source reported for fields set with the -s flag.
+const flagsLayerSource = "flags"
+
+// installerLayer is one of the specs being merged. layers are merged in order, each layer taking
+// precedence over the previous ones.
+type installerLayer struct {
+	Source string
+	Data   []byte
+}
+
+// provenanceReport describes where each field of a merged spec came from.
+type provenanceReport struct {
+	Layers []string          `yaml:"layers"`
+	Fields []fieldProvenance `yaml:"fields"`
+}
+
+// fieldProvenance holds the final value of a field, the layer that set it and the changes made
+// to it by every layer that contained it.
+type fieldProvenance struct {
+	Path    string        `yaml:"path"`
+	Source  string        `yaml:"source"`
+	Value   interface{}   `yaml:"value"`
+	History []fieldChange `yaml:"history,omitempty"`
+}
+
+// fieldChange is a change made to a field by a layer. OldValue is the value the field had before
+// the layer was merged and NewValue is the value after the merge, these can be equal when a layer
+// sets a field to its current value or when the new value is ignored (i.e. nil).
+type fieldChange struct {
+	Source   string      `yaml:"source"`
+	Action   string      `yaml:"action"`
+	OldValue interface{} `yaml:"oldValue,omitempty"`
+	NewValue interface{} `yaml:"newValue,omitempty"`
+}
+
+const (
+	fieldActionAdded     = "added"
+	fieldActionReplaced  = "replaced"
+	fieldActionUnchanged = "unchanged"
+	fieldActionIgnored   = "ignored"
+)
+
+// mergeLayers merges all layers in order using merge and returns the merged spec together with
+// the provenance of each field. empty layers are skipped. when only one layer has content it is
+// returned as is.
+func mergeLayers(layers []installerLayer, merge mergeFunc) ([]byte, *provenanceReport, error) {
+	report := &provenanceReport{}
+
+	var merged map[string]interface{}
+	var single []byte
+	history := map[string][]fieldChange{}
+	for _, layer := range layers {
+		if len(layer.Data) == 0 {
+			continue
+		}
+		report.Layers = append(report.Layers, layer.Source)
+
+		config := make(map[string]interface{})
+		if err := yaml.Unmarshal(layer.Data, &config); err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to parse %s", layer.Source)
+		}
+
+		if merged == nil {
+			merged = config
+			single = layer.Data
+			recordChanges(history, layer.Source, config, nil, config)
+			continue
+		}
+
+		previous := merged
+		var err error
+		if merged, err = merge(previous, config); err != nil {
+			return nil, nil, errors.Wrapf(err, "failed to merge %s", layer.Source)
+		}
+		single = nil
+		recordChanges(history, layer.Source, config, previous, merged)
+	}
+
+	if merged == nil {
+		return nil, report, nil
+	}
+
+	for path, changes := range history {
+		field := fieldProvenance{Path: path, History: changes}
+		for _, change := range changes {
+			if change.Action != fieldActionIgnored {
+				field.Source = change.Source
+			}
+		}
+		field.Value, _ = lookupPath(merged, path)
+		report.Fields = append(report.Fields, field)
+	}
+	sort.Slice(report.Fields, func(i, j int) bool {
+		return report.Fields[i].Path < report.Fields[j].Path
+	})
+
+	if single != nil {
+		return single, report, nil
+	}
+
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal merged config")
+	}
+	return data, report, nil
+}
+
+// recordChanges appends to history a change for every field in layer. previous is the merged
+// spec before the layer was applied and merged the spec after it.
+func recordChanges(history map[string][]fieldChange, source string, layer, previous, merged map[string]interface{}) {
+	for path, value := range flattenYAML("", layer) {
+		oldValue, existed := lookupPath(previous, path)
+		newValue, _ := lookupPath(merged, path)
+
+		change := fieldChange{Source: source, OldValue: oldValue, NewValue: newValue}
+		switch {
+		case !existed:
+			change.Action = fieldActionAdded
+		case value == nil:
+			change.Action = fieldActionIgnored
+		case reflect.DeepEqual(oldValue, newValue):
+			change.Action = fieldActionUnchanged
+		default:
+			change.Action = fieldActionReplaced
+		}
+		history[path] = append(history[path], change)
+	}
+}
+
+// flattenYAML returns all the leaf values in the map keyed by their dot separated path. lists
+// are considered leaf values.
+func flattenYAML(prefix string, config map[string]interface{}) map[string]interface{} {
+	result := map[string]interface{}{}
+	for key, value := range config {
+		path := key
+		if prefix != "" {
+			path = prefix + "." + key
+		}
+		if child, ok := asStringMap(value); ok && len(child) > 0 {
+			for childPath, childValue := range flattenYAML(path, child) {
+				result[childPath] = childValue
+			}
+			continue
+		}
+		result[path] = value
+	}
+	return result
+}
+
+// lookupPath returns the value at the dot separated path.
+func lookupPath(config map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = config
+	for _, key := range strings.Split(path, ".") {
+		m, ok := asStringMap(current)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = m[key]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// asStringMap converts both map representations produced by the yaml decoder and by
+// mergeYAMLMaps into a map[string]interface{}.
+func asStringMap(value interface{}) (map[string]interface{}, bool) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return value, true
+	case map[interface{}]interface{}:
+		return convertToMapStringInterface(value), true
+	default:
+		return nil, false
+	}
+}
+
+// flagsLayer builds a layer out of path=value assignments. values are parsed as yaml so numbers
+// and booleans keep their type.
+func flagsLayer(assignments []string) (installerLayer, error) {
+	if len(assignments) == 0 {
+		return installerLayer{Source: flagsLayerSource}, nil
+	}
+
+	config := map[string]interface{}{}
+	for _, assignment := range assignments {
+		path, raw, ok := strings.Cut(assignment, "=")
+		if !ok || path == "" {
+			return installerLayer{}, errors.Errorf("invalid assignment %q, expected path=value", assignment)
+		}
+
+		var value interface{}
+		if err := yaml.Unmarshal([]byte(raw), &value); err != nil {
+			return installerLayer{}, errors.Wrapf(err, "failed to parse value for %s", path)
+		}
+
+		keys := strings.Split(path, ".")
+		current := config
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := current[key].(map[string]interface{})
+			if !ok {
+				if _, exists := current[key]; exists {
+					return installerLayer{}, errors.Errorf("conflicting assignments for %s", path)
+				}
+				child = map[string]interface{}{}
+				current[key] = child
+			}
+			current = child
+		}
+		current[keys[len(keys)-1]] = value
+	}
+
+	data, err := yaml.Marshal(config)
+	if err != nil {
+		return installerLayer{}, errors.Wrap(err, "failed to marshal flags")
+	}
+	return installerLayer{Source: flagsLayerSource, Data: data}, nil
+}
+
+// String returns a human readable version of the report, listing only the fields changed by
+// more than one layer.
+func (r *provenanceReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Merged layers (lowest precedence first): %s\n", strings.Join(r.Layers, ", "))
+	for _, field := range r.Fields {
+		if len(field.History) < 2 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s: set by %s\n", field.Path, field.Source)
+		for _, change := range field.History {
+			fmt.Fprintf(&b, "  %s %s: %v -> %v\n", change.Source, change.Action, change.OldValue, change.NewValue)
+		}
+	}
+	return b.String()
+}
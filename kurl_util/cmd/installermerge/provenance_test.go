@@ -0,0 +1,303 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	kurlscheme "github.com/replicatedhq/kurlkinds/client/kurlclientset/scheme"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+const (
+	vendorSpec = `apiVersion: cluster.kurl.sh/v1beta1
+kind: Installer
+metadata:
+  name: vendor
+spec:
+  kubernetes:
+    version: 1.27.x
+    serviceCIDR: 10.96.0.0/22
+  containerd:
+    version: 1.6.x
+  ekco:
+    version: latest
+    nodeUnreachableToleration: 5m
+`
+	customerSpec = `apiVersion: cluster.kurl.sh/v1beta1
+kind: Installer
+metadata:
+  name: customer
+spec:
+  kubernetes:
+    serviceCIDR: 10.100.0.0/22
+  ekco:
+    version: latest
+    nodeUnreachableToleration: null
+  rook:
+    version: 1.12.x
+`
+)
+
+func findField(t *testing.T, report *provenanceReport, path string) fieldProvenance {
+	t.Helper()
+	for _, field := range report.Fields {
+		if field.Path == path {
+			return field
+		}
+	}
+	t.Fatalf("field %s not found in report", path)
+	return fieldProvenance{}
+}
+
+func Test_mergeLayers(t *testing.T) {
+	flags, err := flagsLayer([]string{"spec.kubernetes.version=1.28.x", "spec.rook.isBlockStorageEnabled=true"})
+	require.NoError(t, err)
+
+	merged, report, err := mergeLayers([]installerLayer{
+		{Source: "vendor.yaml", Data: []byte(vendorSpec)},
+		{Source: "empty.yaml"},
+		{Source: "customer.yaml", Data: []byte(customerSpec)},
+		flags,
+	}, mergeUntyped)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"vendor.yaml", "customer.yaml", "flags"}, report.Layers)
+
+	var spec map[string]interface{}
+	require.NoError(t, yaml.Unmarshal(merged, &spec))
+	for path, want := range map[string]interface{}{
+		"metadata.name":                       "merged",
+		"spec.kubernetes.version":             "1.28.x",
+		"spec.kubernetes.serviceCIDR":         "10.100.0.0/22",
+		"spec.containerd.version":             "1.6.x",
+		"spec.ekco.nodeUnreachableToleration": "5m",
+		"spec.rook.version":                   "1.12.x",
+		"spec.rook.isBlockStorageEnabled":     true,
+	} {
+		got, ok := lookupPath(spec, path)
+		assert.True(t, ok, path)
+		assert.Equal(t, want, got, path)
+	}
+
+	field := findField(t, report, "spec.kubernetes.version")
+	assert.Equal(t, "flags", field.Source)
+	assert.Equal(t, "1.28.x", field.Value)
+	assert.Equal(t, []fieldChange{
+		{Source: "vendor.yaml", Action: fieldActionAdded, NewValue: "1.27.x"},
+		{Source: "flags", Action: fieldActionReplaced, OldValue: "1.27.x", NewValue: "1.28.x"},
+	}, field.History)
+
+	field = findField(t, report, "spec.kubernetes.serviceCIDR")
+	assert.Equal(t, "customer.yaml", field.Source)
+	assert.Equal(t, []fieldChange{
+		{Source: "vendor.yaml", Action: fieldActionAdded, NewValue: "10.96.0.0/22"},
+		{Source: "customer.yaml", Action: fieldActionReplaced, OldValue: "10.96.0.0/22", NewValue: "10.100.0.0/22"},
+	}, field.History)
+
+	field = findField(t, report, "spec.ekco.nodeUnreachableToleration")
+	assert.Equal(t, "vendor.yaml", field.Source)
+	assert.Equal(t, fieldActionIgnored, field.History[1].Action)
+
+	field = findField(t, report, "spec.ekco.version")
+	assert.Equal(t, "customer.yaml", field.Source)
+	assert.Equal(t, fieldActionUnchanged, field.History[1].Action)
+
+	field = findField(t, report, "spec.containerd.version")
+	assert.Equal(t, "vendor.yaml", field.Source)
+	assert.Len(t, field.History, 1)
+
+	assert.Contains(t, report.String(), "spec.kubernetes.serviceCIDR: set by customer.yaml\n  vendor.yaml added: <nil> -> 10.96.0.0/22\n  customer.yaml replaced: 10.96.0.0/22 -> 10.100.0.0/22\n")
+}
+
+func Test_mergeLayers_MatchesTwoWayMerge(t *testing.T) {
+	want, err := mergeYamlConfigData([]byte(vendorSpec), []byte(customerSpec))
+	require.NoError(t, err)
+
+	got, _, err := mergeLayers([]installerLayer{
+		{Source: "vendor.yaml", Data: []byte(vendorSpec)},
+		{Source: "customer.yaml", Data: []byte(customerSpec)},
+	}, mergeUntyped)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+
+	// a single layer is returned untouched.
+	got, report, err := mergeLayers([]installerLayer{{Source: "vendor.yaml", Data: []byte(vendorSpec)}, {Source: "flags"}}, mergeUntyped)
+	require.NoError(t, err)
+	assert.Equal(t, vendorSpec, string(got))
+	assert.Equal(t, "vendor.yaml", findField(t, report, "spec.kubernetes.version").Source)
+}
+
+func Test_flagsLayer(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		assignments []string
+		want        string
+		wantErr     string
+	}{
+		{
+			name: "no assignments",
+		},
+		{
+			name:        "typed values",
+			assignments: []string{"spec.kubernetes.version=1.28.x", "spec.ekco.minReadyMasterNodeCount=2", "spec.rook.isBlockStorageEnabled=false"},
+			want: `spec:
+  ekco:
+    minReadyMasterNodeCount: 2
+  kubernetes:
+    version: 1.28.x
+  rook:
+    isBlockStorageEnabled: false
+`,
+		},
+		{
+			name:        "missing value",
+			assignments: []string{"spec.kubernetes.version"},
+			wantErr:     `invalid assignment "spec.kubernetes.version", expected path=value`,
+		},
+		{
+			name:        "conflicting assignments",
+			assignments: []string{"spec.kubernetes=1", "spec.kubernetes.version=1.28.x"},
+			wantErr:     "conflicting assignments for spec.kubernetes.version",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			layer, err := flagsLayer(tt.assignments)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, flagsLayerSource, layer.Source)
+			assert.Equal(t, tt.want, string(layer.Data))
+		})
+	}
+}
+
+func Test_mergeConfig(t *testing.T) {
+	utilruntime.Must(kurlscheme.AddToScheme(scheme.Scheme))
+
+	dir := t.TempDir()
+	vendorPath := filepath.Join(dir, "vendor.yaml")
+	require.NoError(t, os.WriteFile(vendorPath, []byte(vendorSpec), 0644))
+	customerPath := filepath.Join(dir, "customer.yaml")
+	require.NoError(t, os.WriteFile(customerPath, []byte(customerSpec), 0644))
+	mergedPath := filepath.Join(dir, "merged.yaml")
+	reportPath := filepath.Join(dir, "provenance.yaml")
+
+	err := mergeConfig(mergeOptions{
+		MergedYAMLPath:   mergedPath,
+		ReportPath:       reportPath,
+		BaseYAMLPath:     vendorPath,
+		OverlayYAMLPaths: []string{customerPath},
+		Assignments:      []string{"spec.kubernetes.version=1.28.x"},
+	})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(reportPath)
+	require.NoError(t, err)
+	var report provenanceReport
+	require.NoError(t, yaml.Unmarshal(data, &report))
+	assert.Equal(t, []string{vendorPath, customerPath, flagsLayerSource}, report.Layers)
+	assert.Equal(t, customerPath, findField(t, &report, "spec.rook.version").Source)
+
+	_, err = os.Stat(mergedPath)
+	require.NoError(t, err)
+}
+
+// Test_mergeConfig_Schema merges a customer spec on top of a vendor spec the way the install
+// script does.
+func Test_mergeConfig_Schema(t *testing.T) {
+	utilruntime.Must(kurlscheme.AddToScheme(scheme.Scheme))
+
+	vendor := `apiVersion: cluster.kurl.sh/v1beta1
+kind: Installer
+metadata:
+  name: vendor
+spec:
+  kubernetes:
+    version: 1.27.x
+  kurl:
+    additionalNoProxyAddresses:
+    - registry.vendor.example.com
+    - 10.0.0.0/8
+  firewalldConfig:
+    firewalld: enabled
+    firewalldCmds:
+    - - --permanent
+      - --add-port=6443/tcp
+  selinuxConfig:
+    semanageCmds:
+    - - port
+      - -a
+      - -t
+      - http_port_t
+      - -p
+      - tcp
+      - "8800"
+`
+	customer := `apiVersion: cluster.kurl.sh/v1beta1
+kind: Installer
+metadata:
+  name: customer
+spec:
+  kurl:
+    additionalNoProxyAddresses:
+    - proxy.customer.example.com
+    - 10.0.0.0/8
+  firewalldConfig:
+    firewalldCmds:
+    - - --permanent
+      - --add-port=8800/tcp
+`
+
+	dir := t.TempDir()
+	vendorPath := filepath.Join(dir, "vendor.yaml")
+	require.NoError(t, os.WriteFile(vendorPath, []byte(vendor), 0644))
+	customerPath := filepath.Join(dir, "customer.yaml")
+	require.NoError(t, os.WriteFile(customerPath, []byte(customer), 0644))
+	mergedPath := filepath.Join(dir, "merged.yaml")
+
+	err := mergeConfig(mergeOptions{
+		MergedYAMLPath:   mergedPath,
+		Schema:           true,
+		BaseYAMLPath:     vendorPath,
+		OverlayYAMLPaths: []string{customerPath},
+	})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(mergedPath)
+	require.NoError(t, err)
+	assert.Equal(t, `apiVersion: cluster.kurl.sh/v1beta1
+kind: Installer
+metadata:
+  name: merged
+spec:
+  firewalldConfig:
+    firewalld: enabled
+    firewalldCmds:
+    - - --permanent
+      - --add-port=6443/tcp
+    - - --permanent
+      - --add-port=8800/tcp
+  kubernetes:
+    version: 1.27.x
+  kurl:
+    additionalNoProxyAddresses:
+    - registry.vendor.example.com
+    - 10.0.0.0/8
+    - proxy.customer.example.com
+  selinuxConfig:
+    semanageCmds:
+    - - port
+      - -a
+      - -t
+      - http_port_t
+      - -p
+      - tcp
+      - "8800"
+`, string(data))
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	kurlv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+)
+
+// mergeFunc merges a new layer on top of the already merged config.
+type mergeFunc func(oldConfig map[string]interface{}, newConfig map[string]interface{}) (map[string]interface{}, error)
+
+// mergeUntyped is the mergeFunc for mergeYAMLMaps.
+func mergeUntyped(oldConfig map[string]interface{}, newConfig map[string]interface{}) (map[string]interface{}, error) {
+	return mergeYAMLMaps(oldConfig, newConfig), nil
+}
+
+var installerSpecType = reflect.TypeOf(kurlv1beta1.InstallerSpec{})
+
+// mergeInstallers merges two Installer documents using the InstallerSpec types to decide how each
+// field of the spec is merged:
+//   - lists of scalars (e.g. kurl.additionalNoProxyAddresses) and lists of commands (e.g.
+//     firewalldConfig.firewalldCmds) are merged as the union of both lists, keeping their order.
+//   - an add-on explicitly set to null (e.g. "rook: null") is removed from the merged spec.
+//   - values that do not match the field type are rejected with the path of the field.
+//
+// fields unknown to the schema and types defined outside of the kurl api (e.g. host preflights
+// spec) are merged as mergeYAMLMaps does.
+func mergeInstallers(oldConfig map[string]interface{}, newConfig map[string]interface{}) (map[string]interface{}, error) {
+	for _, config := range []map[string]interface{}{oldConfig, newConfig} {
+		if spec, ok := config["spec"]; ok && spec != nil {
+			if err := validateSchema("spec", installerSpecType, spec); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	oldSpec, oldOk := oldConfig["spec"]
+	newSpec, newOk := newConfig["spec"]
+
+	// everything but the spec (apiVersion, kind and metadata) uses the untyped merge.
+	merged := mergeYAMLMaps(withoutKey(oldConfig, "spec"), withoutKey(newConfig, "spec"))
+	switch {
+	case oldOk && newOk && newSpec != nil:
+		spec, err := mergeTyped("spec", installerSpecType, oldSpec, newSpec)
+		if err != nil {
+			return nil, err
+		}
+		merged["spec"] = spec
+	case oldOk:
+		merged["spec"] = oldSpec
+	case newOk:
+		merged["spec"] = newSpec
+	}
+	return merged, nil
+}
+
+// mergeTyped merges newVal on top of oldVal according to the type t.
+func mergeTyped(path string, t reflect.Type, oldVal, newVal interface{}) (interface{}, error) {
+	t = derefType(t)
+
+	if !isKurlType(t) {
+		return mergeUntypedValue(oldVal, newVal), nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		oldMap, _ := asStringMap(oldVal)
+		newMap, _ := asStringMap(newVal)
+		return mergeStruct(path, t, oldMap, newMap)
+
+	case reflect.Slice:
+		oldList, isOldList := oldVal.([]interface{})
+		newList, isNewList := newVal.([]interface{})
+		if isOldList && isNewList && isUnionList(t) {
+			return unionLists(oldList, newList), nil
+		}
+		return newVal, nil
+
+	case reflect.String:
+		if strings.HasSuffix(path, ".daemonConfig") {
+			mergedDockerConfig, err := mergeDockerConfigData([]byte(oldVal.(string)), []byte(newVal.(string)))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to merge %s", path)
+			}
+			return string(mergedDockerConfig), nil
+		}
+		return newVal, nil
+
+	default:
+		return newVal, nil
+	}
+}
+
+// mergeStruct merges two maps representing a struct of type t.
+func mergeStruct(path string, t reflect.Type, oldMap, newMap map[string]interface{}) (map[string]interface{}, error) {
+	fields := structFields(t)
+
+	merged := make(map[string]interface{})
+	for _, key := range mergeKeys(oldMap, newMap) {
+		oldVal, oldOk := oldMap[key]
+		newVal, newOk := newMap[key]
+		fieldPath := path + "." + key
+
+		if oldOk && !newOk {
+			merged[key] = oldVal
+			continue
+		}
+
+		if !oldOk && newOk {
+			if newVal == nil && isAddOn(t, key) {
+				continue
+			}
+			merged[key] = newVal
+			continue
+		}
+
+		if newVal == nil {
+			if isAddOn(t, key) {
+				log.Printf("removing add-on %q explicitly set to null\n", key)
+				continue
+			}
+			// don't replace old values with nil, as that indicates a likely yaml issue
+			merged[key] = oldVal
+			log.Printf("not overwriting existing key %q with nil\n", fieldPath)
+			continue
+		}
+
+		if oldVal == nil {
+			merged[key] = newVal
+			continue
+		}
+
+		field, ok := fields[key]
+		if !ok {
+			merged[key] = mergeUntypedValue(oldVal, newVal)
+			continue
+		}
+
+		value, err := mergeTyped(fieldPath, field.Type, oldVal, newVal)
+		if err != nil {
+			return nil, err
+		}
+		merged[key] = value
+	}
+	return merged, nil
+}
+
+// mergeUntypedValue merges maps with mergeYAMLMaps, any other value is replaced.
+func mergeUntypedValue(oldVal, newVal interface{}) interface{} {
+	oldMap, isOldMap := asStringMap(oldVal)
+	newMap, isNewMap := asStringMap(newVal)
+	if isOldMap && isNewMap {
+		return mergeYAMLMaps(oldMap, newMap)
+	}
+	return newVal
+}
+
+// validateSchema returns an error if value can not be decoded into type t.
+func validateSchema(path string, t reflect.Type, value interface{}) error {
+	t = derefType(t)
+	if value == nil || !isKurlType(t) {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := asStringMap(value)
+		if !ok {
+			return typeMismatch(path, "object", value)
+		}
+		fields := structFields(t)
+		for key, child := range m {
+			if field, ok := fields[key]; ok {
+				if err := validateSchema(path+"."+key, field.Type, child); err != nil {
+					return err
+				}
+			}
+		}
+
+	case reflect.Slice:
+		list, ok := value.([]interface{})
+		if !ok {
+			return typeMismatch(path, "list", value)
+		}
+		for i, item := range list {
+			if err := validateSchema(fmt.Sprintf("%s[%d]", path, i), t.Elem(), item); err != nil {
+				return err
+			}
+		}
+
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			return typeMismatch(path, "string", value)
+		}
+
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			return typeMismatch(path, "boolean", value)
+		}
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch value.(type) {
+		case int, int64, uint64:
+		default:
+			return typeMismatch(path, "integer", value)
+		}
+	}
+	return nil
+}
+
+func typeMismatch(path, expected string, value interface{}) error {
+	got := "object"
+	switch value.(type) {
+	case string:
+		got = "string"
+	case bool:
+		got = "boolean"
+	case int, int64, uint64:
+		got = "integer"
+	case float64:
+		got = "number"
+	case []interface{}:
+		got = "list"
+	}
+	return errors.Errorf("%s: expected %s, got %s", path, expected, got)
+}
+
+// structFields returns the fields of a struct keyed by their json name. inlined structs fields
+// are included.
+func structFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, opts, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" && (field.Anonymous || strings.Contains(opts, "inline")) {
+			for key, value := range structFields(derefType(field.Type)) {
+				fields[key] = value
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+// isAddOn returns true if key is an add-on of the InstallerSpec.
+func isAddOn(t reflect.Type, key string) bool {
+	if t != installerSpecType {
+		return false
+	}
+	field, ok := structFields(t)[key]
+	return ok && field.Type.Kind() == reflect.Ptr
+}
+
+// isUnionList returns true for lists of scalars and lists of lists of scalars.
+func isUnionList(t reflect.Type) bool {
+	elem := t.Elem()
+	if elem.Kind() == reflect.Slice {
+		elem = elem.Elem()
+	}
+	switch elem.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// unionLists returns all items in oldList followed by the items in newList not in oldList.
+func unionLists(oldList, newList []interface{}) []interface{} {
+	result := append([]interface{}{}, oldList...)
+	for _, item := range newList {
+		found := false
+		for _, existing := range result {
+			if reflect.DeepEqual(existing, item) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// isKurlType returns true for types defined in the kurl api package and for builtin types.
+func isKurlType(t reflect.Type) bool {
+	t = derefType(t)
+	if t.Kind() == reflect.Slice {
+		return isKurlType(t.Elem())
+	}
+	return t.PkgPath() == "" || t.PkgPath() == installerSpecType.PkgPath()
+}
+
+func derefType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+func withoutKey(config map[string]interface{}, key string) map[string]interface{} {
+	result := make(map[string]interface{}, len(config))
+	for k, v := range config {
+		if k != key {
+			result[k] = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+)
+
+func Test_mergeInstallers(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		base    string
+		overlay string
+		want    string
+		wantErr string
+	}{
+		{
+			name: "no proxy addresses are combined",
+			base: `apiVersion: cluster.kurl.sh/v1beta1
+kind: Installer
+metadata:
+  name: vendor
+spec:
+  kurl:
+    proxyAddress: http://proxy:3128
+    additionalNoProxyAddresses:
+    - registry.vendor.com
+    - 10.0.0.0/8
+`,
+			overlay: `apiVersion: cluster.kurl.sh/v1beta1
+kind: Installer
+metadata:
+  name: customer
+spec:
+  kurl:
+    additionalNoProxyAddresses:
+    - 10.0.0.0/8
+    - internal.customer.com
+`,
+			want: `apiVersion: cluster.kurl.sh/v1beta1
+kind: Installer
+metadata:
+  name: merged
+spec:
+  kurl:
+    additionalNoProxyAddresses:
+    - registry.vendor.com
+    - 10.0.0.0/8
+    - internal.customer.com
+    proxyAddress: http://proxy:3128
+`,
+		},
+		{
+			name: "command lists are combined",
+			base: `spec:
+  firewalldConfig:
+    firewalld: enabled
+    firewalldCmds:
+    - ["--permanent", "--zone=trusted", "--add-interface=weave"]
+  selinuxConfig:
+    chconCmds:
+    - ["chcon", "-Rt", "svirt_sandbox_file_t", "/var/lib/etcd"]
+`,
+			overlay: `spec:
+  firewalldConfig:
+    firewalldCmds:
+    - ["--permanent", "--zone=trusted", "--add-interface=weave"]
+    - ["--reload"]
+  selinuxConfig:
+    selinux: permissive
+`,
+			want: `spec:
+  firewalldConfig:
+    firewalld: enabled
+    firewalldCmds:
+    - - --permanent
+      - --zone=trusted
+      - --add-interface=weave
+    - - --reload
+  selinuxConfig:
+    chconCmds:
+    - - chcon
+      - -Rt
+      - svirt_sandbox_file_t
+      - /var/lib/etcd
+    selinux: permissive
+`,
+		},
+		{
+			name: "add-on explicitly disabled",
+			base: `spec:
+  kubernetes:
+    version: 1.27.x
+  rook:
+    version: 1.12.x
+  kotsadm:
+    version: latest
+`,
+			overlay: `spec:
+  rook: null
+  longhorn:
+    version: 1.4.x
+  kotsadm:
+    applicationSlug: null
+`,
+			want: `spec:
+  kotsadm:
+    applicationSlug: null
+    version: latest
+  kubernetes:
+    version: 1.27.x
+  longhorn:
+    version: 1.4.x
+`,
+		},
+		{
+			name: "docker daemon config is merged",
+			base: `spec:
+  docker:
+    version: 20.10.x
+    daemonConfig: '{"log-driver": "json-file"}'
+`,
+			overlay: `spec:
+  docker:
+    daemonConfig: '{"debug": true}'
+`,
+			want: `spec:
+  docker:
+    daemonConfig: |-
+      {
+        "debug": true,
+        "log-driver": "json-file"
+      }
+    version: 20.10.x
+`,
+		},
+		{
+			name: "host preflights are merged untyped",
+			base: `spec:
+  kurl:
+    hostPreflights:
+      apiVersion: troubleshoot.sh/v1beta2
+      kind: HostPreflight
+      spec:
+        collectors:
+        - cpu: {}
+`,
+			overlay: `spec:
+  kurl:
+    hostPreflights:
+      spec:
+        collectors:
+        - memory: {}
+`,
+			want: `spec:
+  kurl:
+    hostPreflights:
+      apiVersion: troubleshoot.sh/v1beta2
+      kind: HostPreflight
+      spec:
+        collectors:
+        - memory: {}
+`,
+		},
+		{
+			name: "string field with a number",
+			base: `spec:
+  kubernetes:
+    version: 1.27.x
+`,
+			overlay: `spec:
+  kubernetes:
+    version: 1.28
+`,
+			wantErr: "spec.kubernetes.version: expected string, got number",
+		},
+		{
+			name: "list field with a string",
+			base: `spec:
+  kurl:
+    additionalNoProxyAddresses: ["10.0.0.0/8"]
+`,
+			overlay: `spec:
+  kurl:
+    additionalNoProxyAddresses: 192.168.0.0/16
+`,
+			wantErr: "spec.kurl.additionalNoProxyAddresses: expected list, got string",
+		},
+		{
+			name: "command with a number",
+			base: `spec:
+  iptablesConfig:
+    iptablesCmds:
+    - ["-A", "INPUT"]
+    - ["-P", 1]
+`,
+			overlay: `spec: {}`,
+			wantErr: "spec.iptablesConfig.iptablesCmds[1][1]: expected string, got integer",
+		},
+		{
+			name: "add-on with a scalar",
+			base: `spec:
+  ekco:
+    version: latest
+`,
+			overlay: `spec:
+  ekco: latest
+`,
+			wantErr: "spec.ekco: expected object, got string",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			base := map[string]interface{}{}
+			require.NoError(t, yaml.Unmarshal([]byte(tt.base), &base))
+			overlay := map[string]interface{}{}
+			require.NoError(t, yaml.Unmarshal([]byte(tt.overlay), &overlay))
+
+			merged, err := mergeInstallers(base, overlay)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			got, err := yaml.Marshal(merged)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func Test_mergeLayers_Schema(t *testing.T) {
+	flags, err := flagsLayer([]string{"spec.kurl.additionalNoProxyAddresses=[flags.example.com]"})
+	require.NoError(t, err)
+
+	merged, report, err := mergeLayers([]installerLayer{
+		{Source: "vendor.yaml", Data: []byte("spec:\n  kurl:\n    additionalNoProxyAddresses: [vendor.example.com]\n")},
+		{Source: "customer.yaml", Data: []byte("spec:\n  kurl:\n    additionalNoProxyAddresses: [customer.example.com]\n")},
+		flags,
+	}, mergeInstallers)
+	require.NoError(t, err)
+	assert.Equal(t, `spec:
+  kurl:
+    additionalNoProxyAddresses:
+    - vendor.example.com
+    - customer.example.com
+    - flags.example.com
+`, string(merged))
+
+	field := findField(t, report, "spec.kurl.additionalNoProxyAddresses")
+	assert.Equal(t, "flags", field.Source)
+	assert.Len(t, field.History, 3)
+
+	_, _, err = mergeLayers([]installerLayer{
+		{Source: "vendor.yaml", Data: []byte("spec:\n  kubernetes:\n    version: 1.27.x\n")},
+		{Source: "customer.yaml", Data: []byte("spec:\n  kubernetes:\n    version: true\n")},
+	}, mergeInstallers)
+	assert.EqualError(t, err, "failed to merge customer.yaml: spec.kubernetes.version: expected string, got boolean")
+}
+
+// Test_mergeLayers_NullAndLists covers the behaviors that differ between the default merge and
+// the opt-in schema merge.
+func Test_mergeLayers_NullAndLists(t *testing.T) {
+	vendor := installerLayer{Source: "vendor.yaml", Data: []byte(`spec:
+  rook:
+    version: 1.12.x
+  firewalldConfig:
+    firewalldCmds:
+    - - --add-port=6443/tcp
+`)}
+	overlay := installerLayer{Source: "overlay.yaml", Data: []byte(`spec:
+  rook: null
+  firewalldConfig:
+    firewalldCmds:
+    - - --add-port=8800/tcp
+`)}
+
+	for _, tt := range []struct {
+		name  string
+		merge mergeFunc
+		want  string
+	}{
+		{
+			name:  "default merge keeps the vendor add-on and replaces lists",
+			merge: mergeUntyped,
+			want: `spec:
+  firewalldConfig:
+    firewalldCmds:
+    - - --add-port=8800/tcp
+  rook:
+    version: 1.12.x
+`,
+		},
+		{
+			name:  "schema merge removes the add-on and combines lists",
+			merge: mergeInstallers,
+			want: `spec:
+  firewalldConfig:
+    firewalldCmds:
+    - - --add-port=6443/tcp
+    - - --add-port=8800/tcp
+`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, _, err := mergeLayers([]installerLayer{vendor, overlay}, tt.merge)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(merged))
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	checkKindEndpoint = "endpoint"
+	checkKindRegistry = "registry"
+	checkKindPeer     = "peer"
+	checkKindUDPPeer  = "udp-peer"
+
+	proxyDirect   = "direct"
+	proxyBypassed = "bypassed by NO_PROXY"
+)
+
+// dnsResult is the local resolution of the host of a checked address.
+type dnsResult struct {
+	Host      string        `json:"host"`
+	Addresses []string      `json:"addresses,omitempty"`
+	Duration  time.Duration `json:"duration"`
+	Error     string        `json:"error,omitempty"`
+}
+
+// tlsResult describes the tls handshake with a checked address.
+type tlsResult struct {
+	Version     string    `json:"version"`
+	CipherSuite string    `json:"cipherSuite"`
+	ServerName  string    `json:"serverName"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	NotAfter    time.Time `json:"notAfter,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// checkResult is the result of checking the connectivity to an endpoint, the registry or a peer agent.
+type checkResult struct {
+	Kind       string        `json:"kind"`
+	Address    string        `json:"address"`
+	DNS        *dnsResult    `json:"dns,omitempty"`
+	Proxy      string        `json:"proxy,omitempty"`
+	TLS        *tlsResult    `json:"tls,omitempty"`
+	StatusCode int           `json:"statusCode,omitempty"`
+	Identity   *identity     `json:"identity,omitempty"`
+	Duration   time.Duration `json:"duration"`
+	OK         bool          `json:"ok"`
+	Error      string        `json:"error,omitempty"`
+}
+
+// diagnoser runs the client checks. proxy decides the proxy used for http requests, usually
+// http.ProxyFromEnvironment.
+type diagnoser struct {
+	timeout   time.Duration
+	proxy     func(*http.Request) (*url.URL, error)
+	tlsConfig *tls.Config
+}
+
+// check runs the check matching the kind of the address.
+func (d diagnoser) check(ctx context.Context, kind, address string) checkResult {
+	start := time.Now()
+	result := checkResult{Kind: kind, Address: address}
+	var err error
+	switch kind {
+	case checkKindRegistry:
+		err = d.checkHTTP(ctx, &result, registryURL(address), nil)
+		if err == nil && result.StatusCode != http.StatusOK && result.StatusCode != http.StatusUnauthorized {
+			err = errors.Errorf("unexpected status code %d from the registry api", result.StatusCode)
+		}
+	case checkKindPeer:
+		err = d.checkPeer(ctx, &result)
+	case checkKindUDPPeer:
+		err = d.checkUDPPeer(ctx, &result)
+	default:
+		if strings.Contains(address, "://") {
+			err = d.checkHTTP(ctx, &result, address, nil)
+		} else {
+			err = d.checkTCP(ctx, &result)
+		}
+	}
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.OK = true
+	}
+	return result
+}
+
+// checkHTTP sends a get request to the url, any answer from the server is a success. the response body is passed to
+// decode, if provided.
+func (d diagnoser) checkHTTP(ctx context.Context, result *checkResult, rawURL string, decode func(io.Reader) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return errors.Wrap(err, "create request")
+	}
+
+	proxyURL, err := d.proxy(req)
+	if err != nil {
+		return errors.Wrap(err, "get proxy")
+	}
+	result.Proxy = describeProxy(proxyURL, req.URL.Scheme)
+
+	// with a proxy the host is resolved by the proxy, a local resolution failure is not an error.
+	result.DNS = d.resolve(ctx, req.URL.Hostname())
+	if result.DNS.Error != "" && proxyURL == nil {
+		return errors.Errorf("resolve %s: %s", req.URL.Hostname(), result.DNS.Error)
+	}
+
+	trace := &httptrace.ClientTrace{
+		TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+			result.TLS = describeTLS(state, err)
+		},
+	}
+	transport := &http.Transport{
+		Proxy:           d.proxy,
+		TLSClientConfig: d.tlsConfig,
+	}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   d.timeout,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req.WithContext(httptrace.WithClientTrace(ctx, trace)))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	result.StatusCode = resp.StatusCode
+	if decode != nil {
+		return decode(resp.Body)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return nil
+}
+
+// checkTCP opens a tcp connection to the address, proxies are not used.
+func (d diagnoser) checkTCP(ctx context.Context, result *checkResult) error {
+	host, _, err := net.SplitHostPort(result.Address)
+	if err != nil {
+		return errors.Wrap(err, "parse address")
+	}
+	result.Proxy = proxyDirect
+	result.DNS = d.resolve(ctx, host)
+	if result.DNS.Error != "" {
+		return errors.Errorf("resolve %s: %s", host, result.DNS.Error)
+	}
+	dialer := net.Dialer{Timeout: d.timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", result.Address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// checkPeer requests the identity of the agent listening on the tcp address. traffic between nodes does not go
+// through the proxy, whatever the proxy environment says.
+func (d diagnoser) checkPeer(ctx context.Context, result *checkResult) error {
+	d.proxy = noProxy
+	defer func() {
+		result.Proxy = proxyDirect
+	}()
+	return d.checkHTTP(ctx, result, fmt.Sprintf("http://%s/identity", result.Address), func(body io.Reader) error {
+		if result.StatusCode != http.StatusOK {
+			return errors.Errorf("unexpected status code %d", result.StatusCode)
+		}
+		var id identity
+		if err := json.NewDecoder(body).Decode(&id); err != nil {
+			return errors.Wrap(err, "parse identity")
+		}
+		result.Identity = &id
+		return nil
+	})
+}
+
+// checkUDPPeer sends a datagram to the agent listening on the udp address and waits for its identity.
+func (d diagnoser) checkUDPPeer(ctx context.Context, result *checkResult) error {
+	host, _, err := net.SplitHostPort(result.Address)
+	if err != nil {
+		return errors.Wrap(err, "parse address")
+	}
+	result.Proxy = proxyDirect
+	result.DNS = d.resolve(ctx, host)
+	if result.DNS.Error != "" {
+		return errors.Errorf("resolve %s: %s", host, result.DNS.Error)
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", result.Address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(d.timeout)); err != nil {
+		return errors.Wrap(err, "set deadline")
+	}
+	if _, err := conn.Write([]byte("identity")); err != nil {
+		return errors.Wrap(err, "send datagram")
+	}
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return errors.Wrap(err, "read answer")
+	}
+	var id identity
+	if err := json.Unmarshal(buf[:n], &id); err != nil {
+		return errors.Wrap(err, "parse identity")
+	}
+	result.Identity = &id
+	return nil
+}
+
+// noProxy sends the requests directly to the host.
+func noProxy(*http.Request) (*url.URL, error) {
+	return nil, nil
+}
+
+// resolve resolves the host with the local resolver, ip addresses are returned as is.
+func (d diagnoser) resolve(ctx context.Context, host string) *dnsResult {
+	result := &dnsResult{Host: host}
+	if ip := net.ParseIP(host); ip != nil {
+		result.Addresses = []string{ip.String()}
+		return result
+	}
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	start := time.Now()
+	addresses, err := net.DefaultResolver.LookupHost(ctx, host)
+	result.Duration = time.Since(start)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Addresses = addresses
+	return result
+}
+
+// describeProxy tells if the request goes through a proxy, and when it does not if a proxy is configured but the host
+// is excluded by NO_PROXY.
+func describeProxy(proxyURL *url.URL, scheme string) string {
+	if proxyURL != nil {
+		return proxyURL.Redacted()
+	}
+	names := []string{"HTTP_PROXY", "http_proxy"}
+	if scheme == "https" {
+		names = []string{"HTTPS_PROXY", "https_proxy"}
+	}
+	for _, name := range names {
+		if os.Getenv(name) != "" {
+			return proxyBypassed
+		}
+	}
+	return proxyDirect
+}
+
+func describeTLS(state tls.ConnectionState, err error) *tlsResult {
+	result := &tlsResult{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ServerName:  state.ServerName,
+	}
+	if len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		result.Subject = cert.Subject.String()
+		result.Issuer = cert.Issuer.String()
+		result.NotAfter = cert.NotAfter
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// registryURL returns the url of the registry api base endpoint, https is used unless a scheme is provided.
+func registryURL(registry string) string {
+	if !strings.Contains(registry, "://") {
+		registry = "https://" + registry
+	}
+	return strings.TrimSuffix(registry, "/") + "/v2/"
+}
+
+// writeReport writes the results as text, one block per checked address.
+func writeReport(w io.Writer, results []checkResult) {
+	for i, result := range results {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s %s\n", result.Kind, result.Address)
+		if result.DNS != nil {
+			if result.DNS.Error != "" {
+				fmt.Fprintf(w, "  dns:      %s\n", result.DNS.Error)
+			} else {
+				fmt.Fprintf(w, "  dns:      %s (%s)\n", strings.Join(result.DNS.Addresses, ", "), result.DNS.Duration.Round(time.Millisecond))
+			}
+		}
+		if result.Proxy != "" {
+			fmt.Fprintf(w, "  proxy:    %s\n", result.Proxy)
+		}
+		if result.TLS != nil {
+			fmt.Fprintf(w, "  tls:      %s, %s\n", result.TLS.Version, result.TLS.CipherSuite)
+			if result.TLS.Subject != "" {
+				fmt.Fprintf(w, "  cert:     %s, issued by %s, expires %s\n", result.TLS.Subject, result.TLS.Issuer, result.TLS.NotAfter.Format("2006-01-02"))
+			}
+			if result.TLS.Error != "" {
+				fmt.Fprintf(w, "  tls err:  %s\n", result.TLS.Error)
+			}
+		}
+		if result.Identity != nil {
+			fmt.Fprintf(w, "  identity: node %s, pod %s, hostname %s\n", valueOrNone(result.Identity.Node), valueOrNone(result.Identity.Pod), result.Identity.Hostname)
+		}
+		status := "ok"
+		if !result.OK {
+			status = "FAILED: " + result.Error
+		} else if result.StatusCode != 0 {
+			status = fmt.Sprintf("ok, status %d", result.StatusCode)
+		}
+		fmt.Fprintf(w, "  result:   %s (%s)\n", status, result.Duration.Round(time.Millisecond))
+	}
+}
+
+func valueOrNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
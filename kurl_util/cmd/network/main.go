@@ -2,13 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
 	"flag"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
+
+	"github.com/pkg/errors"
 )
 
 func main() {
@@ -17,82 +24,75 @@ func main() {
 	var address string
 	var request string
 	var response string
+	var nodeName string
+	var tcpPorts string
+	var udpPorts string
+	var registry string
+	var endpoints string
+	var peers string
+	var udpPeers string
+	var caCert string
+	var timeout time.Duration
+	var output string
 
 	flag.BoolVar(&isServer, "server", false, "Run the server component")
 	flag.BoolVar(&isClient, "client", false, "Run the client component")
-	flag.StringVar(&address, "address", "", "IP to attempt to connect to the server")
+	flag.StringVar(&address, "address", "", "URL of the server, the client retries until the server answers the response body")
 	flag.StringVar(&request, "request", "kurl-client", "Request body sent by client")
 	flag.StringVar(&response, "response", "kurl-server", "Response body sent by server")
+	flag.StringVar(&nodeName, "node-name", os.Getenv("NODE_NAME"), "Node name answered by the server")
+	flag.StringVar(&tcpPorts, "tcp-ports", "8080", "Comma separated tcp ports the server listens on")
+	flag.StringVar(&udpPorts, "udp-ports", "", "Comma separated udp ports the server listens on")
+	flag.StringVar(&registry, "registry", "", "Registry host[:port] checked by the client")
+	flag.StringVar(&endpoints, "endpoints", "", "Comma separated URLs or host:port addresses checked by the client")
+	flag.StringVar(&peers, "peers", "", "Comma separated host:port addresses of servers queried for their identity over tcp")
+	flag.StringVar(&udpPeers, "udp-peers", "", "Comma separated host:port addresses of servers queried for their identity over udp")
+	flag.StringVar(&caCert, "cacert", "", "CA bundle used to verify tls certificates, the system pool is used by default")
+	flag.DurationVar(&timeout, "timeout", 10*time.Second, "Timeout of each client check")
+	flag.StringVar(&output, "output", "text", "Client report format, text or json")
 
 	flag.Parse()
 
-	exit := make(chan bool)
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer cancel()
 
 	if isServer {
-		server := &http.Server{
-			Addr: ":8080",
+		tcp, err := parsePorts(tcpPorts)
+		if err != nil {
+			log.Fatalf("Invalid -tcp-ports: %s", err)
 		}
-		http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-			body, err := io.ReadAll(r.Body)
-			if err != nil {
-				log.Println(err.Error())
-				return
-			}
-			if string(body) != request {
-				log.Printf("Got request %q, want %q", string(body), request)
-				http.Error(w, "Bad Request", http.StatusBadRequest)
-				return
-			}
-			_, err = w.Write([]byte(response))
-			if err != nil {
-				log.Println(err.Error())
-				return
-			}
-			go func() {
-				_ = server.Shutdown(context.Background())
-				exit <- true
-			}()
-		})
-
-		log.Printf("Listening on %s", server.Addr)
-		err := server.ListenAndServe()
-		if err != http.ErrServerClosed {
-			log.Println(err.Error())
-			os.Exit(1)
+		udp, err := parsePorts(udpPorts)
+		if err != nil {
+			log.Fatalf("Invalid -udp-ports: %s", err)
+		}
+		if err := newServer(nodeName, request, response).serve(ctx, tcp, udp); err != nil {
+			log.Fatal(err.Error())
 		}
-
-		<-exit
-		log.Println("Success")
 		return
 	}
 
 	if isClient {
-		for {
-			time.Sleep(time.Second)
-
-			req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, address, strings.NewReader(request))
-			if err != nil {
-				log.Println(err.Error())
-				continue
-			}
-			req.Header.Set("Content-Type", "text/plain")
-			resp, err := http.DefaultClient.Do(req)
-			if err != nil {
-				log.Println(err.Error())
-				continue
-			}
-			defer resp.Body.Close()
-			body, err := io.ReadAll(resp.Body)
-			if err != nil {
-				log.Println(err.Error())
-				continue
+		checks := map[string][]string{
+			checkKindRegistry: splitList(registry),
+			checkKindEndpoint: splitList(endpoints),
+			checkKindPeer:     splitList(peers),
+			checkKindUDPPeer:  splitList(udpPeers),
+		}
+		if address != "" {
+			if err := checkAddressConflicts(checks); err != nil {
+				log.Fatal(err.Error())
 			}
-			if string(body) != response {
-				log.Printf("Got response %q, want %q", string(body), response)
-				continue
+			if err := runEchoClient(ctx, address, request, response); err != nil {
+				log.Fatal(err.Error())
 			}
-			log.Println("Success")
-			break
+			return
+		}
+		ok, err := runDiagnostics(ctx, os.Stdout, checks, caCert, timeout, output)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if !ok {
+			os.Exit(1)
 		}
 		return
 	}
@@ -100,3 +100,127 @@ func main() {
 	log.Println("Either --server or --client is required")
 	os.Exit(1)
 }
+
+// checkFlags are the flags listing the addresses of each kind of check.
+var checkFlags = map[string]string{
+	checkKindRegistry: "registry",
+	checkKindEndpoint: "endpoints",
+	checkKindPeer:     "peers",
+	checkKindUDPPeer:  "udp-peers",
+}
+
+// checkAddressConflicts returns an error if checks are requested along with -address, the echo client does not run
+// them.
+func checkAddressConflicts(checks map[string][]string) error {
+	var flags []string
+	for _, kind := range []string{checkKindRegistry, checkKindEndpoint, checkKindPeer, checkKindUDPPeer} {
+		if len(checks[kind]) > 0 {
+			flags = append(flags, "-"+checkFlags[kind])
+		}
+	}
+	if len(flags) > 0 {
+		return errors.Errorf("-address cannot be combined with %s", strings.Join(flags, ", "))
+	}
+	return nil
+}
+
+// runEchoClient posts the request body to the address until the server answers the response body.
+// returns an error if the context ends before the server answers, so an interrupted client does
+// not report success.
+func runEchoClient(ctx context.Context, address, request, response string) error {
+	for ctx.Err() == nil {
+		time.Sleep(time.Second)
+		if err := echo(ctx, address, request, response); err != nil {
+			log.Println(err.Error())
+			continue
+		}
+		log.Println("Success")
+		return nil
+	}
+	return errors.Wrap(ctx.Err(), "interrupted before the server answered")
+}
+
+func echo(ctx context.Context, address, request, response string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, address, strings.NewReader(request))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if string(body) != response {
+		return errors.Errorf("Got response %q, want %q", string(body), response)
+	}
+	return nil
+}
+
+// runDiagnostics runs the checks in a stable order and writes the report. returns false if any check failed.
+func runDiagnostics(ctx context.Context, w io.Writer, checks map[string][]string, caCert string, timeout time.Duration, output string) (bool, error) {
+	if output != "text" && output != "json" {
+		return false, errors.Errorf("invalid output %q, must be text or json", output)
+	}
+	tlsConfig, err := loadTLSConfig(caCert)
+	if err != nil {
+		return false, err
+	}
+	d := diagnoser{timeout: timeout, proxy: http.ProxyFromEnvironment, tlsConfig: tlsConfig}
+
+	var results []checkResult
+	for _, kind := range []string{checkKindRegistry, checkKindEndpoint, checkKindPeer, checkKindUDPPeer} {
+		for _, address := range checks[kind] {
+			results = append(results, d.check(ctx, kind, address))
+		}
+	}
+	if len(results) == 0 {
+		return false, errors.New("at least one of -address, -registry, -endpoints, -peers or -udp-peers is required")
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			return false, errors.Wrap(err, "encode results")
+		}
+	} else {
+		writeReport(w, results)
+	}
+
+	for _, result := range results {
+		if !result.OK {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func loadTLSConfig(caCert string) (*tls.Config, error) {
+	if caCert == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(caCert)
+	if err != nil {
+		return nil, errors.Wrap(err, "read ca bundle")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.Errorf("no certificates found in %s", caCert)
+	}
+	return &tls.Config{RootCAs: pool}, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// identity is the answer of the server to identity requests, it tells the client which node and pod answered.
+type identity struct {
+	Node       string `json:"node,omitempty"`
+	Pod        string `json:"pod,omitempty"`
+	PodIP      string `json:"podIP,omitempty"`
+	Hostname   string `json:"hostname"`
+	Protocol   string `json:"protocol"`
+	Port       int    `json:"port"`
+	RemoteAddr string `json:"remoteAddr"`
+}
+
+// server answers identity requests on tcp (http) and udp ports. the root http path keeps answering the request and
+// response bodies used by the cluster networking check of the install script.
+type server struct {
+	identity identity
+	request  string
+	response string
+}
+
+func newServer(node, request, response string) server {
+	hostname, _ := os.Hostname()
+	return server{
+		identity: identity{
+			Node:     node,
+			Pod:      os.Getenv("POD_NAME"),
+			PodIP:    os.Getenv("POD_IP"),
+			Hostname: hostname,
+		},
+		request:  request,
+		response: response,
+	}
+}
+
+// identityFor returns the identity answered to the remote address on the provided protocol and port.
+func (s server) identityFor(protocol string, port int, remote string) identity {
+	id := s.identity
+	id.Protocol = protocol
+	id.Port = port
+	id.RemoteAddr = remote
+	return id
+}
+
+func (s server) handler(port int) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/identity", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.identityFor("tcp", port, r.RemoteAddr)); err != nil {
+			log.Println(err.Error())
+		}
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			log.Println(err.Error())
+			return
+		}
+		if string(body) != s.request {
+			log.Printf("Got request %q, want %q", string(body), s.request)
+			http.Error(w, "Bad Request", http.StatusBadRequest)
+			return
+		}
+		if _, err := w.Write([]byte(s.response)); err != nil {
+			log.Println(err.Error())
+		}
+	})
+	return mux
+}
+
+// serveUDP answers every datagram received with the server identity.
+func (s server) serveUDP(conn net.PacketConn, port int) error {
+	buf := make([]byte, 1500)
+	for {
+		_, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return errors.Wrapf(err, "read udp port %d", port)
+		}
+		data, err := json.Marshal(s.identityFor("udp", port, addr.String()))
+		if err != nil {
+			return errors.Wrap(err, "marshal identity")
+		}
+		if _, err := conn.WriteTo(data, addr); err != nil {
+			log.Printf("Failed to answer %s on udp port %d: %s", addr, port, err)
+		}
+	}
+}
+
+// serve listens on all provided ports until the context is done or one of the listeners fails.
+func (s server) serve(ctx context.Context, tcpPorts, udpPorts []int) error {
+	var closers []io.Closer
+	errs := make(chan error, len(tcpPorts)+len(udpPorts))
+	for _, port := range tcpPorts {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			closeAll(closers)
+			return errors.Wrapf(err, "listen on tcp port %d", port)
+		}
+		httpServer := &http.Server{Handler: s.handler(port)}
+		closers = append(closers, httpServer)
+		log.Printf("Listening on tcp port %d", port)
+		go func() {
+			if err := httpServer.Serve(listener); err != http.ErrServerClosed {
+				errs <- err
+			}
+		}()
+	}
+	for _, port := range udpPorts {
+		conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
+		if err != nil {
+			closeAll(closers)
+			return errors.Wrapf(err, "listen on udp port %d", port)
+		}
+		closers = append(closers, conn)
+		log.Printf("Listening on udp port %d", port)
+		go func() {
+			errs <- s.serveUDP(conn, port)
+		}()
+	}
+
+	defer closeAll(closers)
+	select {
+	case <-ctx.Done():
+		return nil
+	case err := <-errs:
+		return err
+	}
+}
+
+func closeAll(closers []io.Closer) {
+	for _, closer := range closers {
+		closer.Close()
+	}
+}
+
+// parsePorts parses a comma separated list of ports.
+func parsePorts(value string) ([]int, error) {
+	var ports []int
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		port, err := strconv.Atoi(field)
+		if err != nil || port < 1 || port > 65535 {
+			return nil, errors.Errorf("invalid port %q", field)
+		}
+		ports = append(ports, port)
+	}
+	return ports, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parsePorts(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    []int
+		wantErr string
+	}{
+		{name: "empty", value: ""},
+		{name: "list", value: "8080, 6783,", want: []int{8080, 6783}},
+		{name: "not a number", value: "http", wantErr: `invalid port "http"`},
+		{name: "out of range", value: "70000", wantErr: `invalid port "70000"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePorts(tt.value)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_serverHandler(t *testing.T) {
+	s := server{identity: identity{Node: "node-a", Hostname: "kurlnet-server"}, request: "kurl-client", response: "kurl-server"}
+	handler := s.handler(8080)
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/identity", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var id identity
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &id))
+	assert.Equal(t, identity{Node: "node-a", Hostname: "kurlnet-server", Protocol: "tcp", Port: 8080, RemoteAddr: "192.0.2.1:1234"}, id)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("kurl-client")))
+	assert.Equal(t, "kurl-server", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader("other")))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func Test_runEchoClient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("kurl-server"))
+	}))
+	defer srv.Close()
+
+	require.NoError(t, runEchoClient(context.Background(), srv.URL, "kurl-client", "kurl-server"))
+
+	// an interrupted client must not report success.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := runEchoClient(ctx, srv.URL, "kurl-client", "kurl-server")
+	assert.EqualError(t, err, "interrupted before the server answered: context canceled")
+}
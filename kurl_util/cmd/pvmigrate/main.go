@@ -10,18 +10,21 @@ import (
 	"strings"
 	"time"
 
+	"code.cloudfoundry.org/bytefmt"
+	lhv1b1 "github.com/longhorn/longhorn-manager/k8s/pkg/apis/longhorn/v1beta1"
 	clusterspace "github.com/replicatedhq/kurl/pkg/cluster/space"
+	"github.com/replicatedhq/kurl/pkg/k8sutil"
 	"github.com/replicatedhq/kurl/pkg/version"
 	"github.com/replicatedhq/pvmigrate/pkg/migrate"
 	"github.com/replicatedhq/pvmigrate/pkg/preflight"
+	rookcli "github.com/rook/rook/pkg/client/clientset/versioned"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
-const openEBSLocalProvisioner = "openebs.io/local"
-
 func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer stop()
@@ -124,7 +127,7 @@ func checkFreeSpace(ctx context.Context, logger *log.Logger, cfg *rest.Config, c
 		return nil
 	}
 
-	if dstProvisioner == openEBSLocalProvisioner {
+	if dstProvisioner == clusterspace.OpenEBSLocalProvisioner {
 		dfchecker, err := clusterspace.NewOpenEBSDiskSpaceValidator(cfg, logger, opts.RsyncImage, opts.SourceSCName, opts.DestSCName)
 		if err != nil {
 			return fmt.Errorf("failed to create openebs free space checker: %w", err)
@@ -142,28 +145,60 @@ func checkFreeSpace(ctx context.Context, logger *log.Logger, cfg *rest.Config, c
 		return fmt.Errorf("some nodes do not have enough disk space for the migration: %s", strings.Join(nodes, ","))
 	}
 
-	rookProvisioners := map[string]bool{
-		"rook-ceph.rbd.csi.ceph.com":    true,
-		"rook-ceph.cephfs.csi.ceph.com": true,
+	// the remaining provisioners replicate the data across nodes, the free space of the whole
+	// storage class is compared with the total size of the volumes to migrate.
+	supported := map[string]bool{
+		clusterspace.RookRBDProvisioner:    true,
+		clusterspace.RookCephFSProvisioner: true,
+		clusterspace.LonghornProvisioner:   true,
+	}
+	if !supported[dstProvisioner] {
+		logger.Printf("Skipping disk space check, provisioner %s not supported.", dstProvisioner)
+		return nil
 	}
-	if _, ok := rookProvisioners[dstProvisioner]; ok {
-		dfchecker, err := clusterspace.NewRookDiskSpaceValidator(cfg, logger, opts.SourceSCName, opts.DestSCName)
-		if err != nil {
-			return fmt.Errorf("failed to create Rook/Ceph free space checker: %w", err)
-		}
 
-		hasSpace, err := dfchecker.HasEnoughDiskSpace(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to check Rook/Ceph free space: %w", err)
-		}
+	rookCli, err := rookcli.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create rook client: %w", err)
+	}
 
-		if hasSpace {
-			return nil
-		}
+	lhCli, err := client.New(cfg, client.Options{})
+	if err != nil {
+		return fmt.Errorf("failed to create longhorn client: %w", err)
+	}
+	if err := lhv1b1.AddToScheme(lhCli.Scheme()); err != nil {
+		return fmt.Errorf("failed to add longhorn types to scheme: %w", err)
+	}
+
+	provider, err := clusterspace.NewCapacityProvider(ctx, clusterspace.CapacityProviderOptions{
+		KubeClient:     cli,
+		RookClient:     rookCli,
+		LonghornClient: lhCli,
+		Logger:         logger,
+		StorageClass:   opts.DestSCName,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create free space checker: %w", err)
+	}
+
+	capacity, err := provider.Capacity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check %s free space: %w", opts.DestSCName, err)
+	}
+
+	reservedPerNode, reserved, err := k8sutil.PVSReservationPerNode(ctx, cli, opts.SourceSCName)
+	if err != nil {
+		return fmt.Errorf("failed to calculate reserved disk space: %w", err)
+	}
+	for _, nodeReserved := range reservedPerNode {
+		reserved += nodeReserved
+	}
 
-		return fmt.Errorf("not enough space in Ceph to migrate data")
+	logger.Printf("Free space in %q storage class: %s", opts.DestSCName, bytefmt.ByteSize(uint64(capacity.Free)))
+	logger.Printf("Reserved (%q storage class): %s", opts.SourceSCName, bytefmt.ByteSize(uint64(reserved)))
+	if capacity.Free > reserved {
+		return nil
 	}
 
-	logger.Printf("Skipping disk space check, provisioner %s not supported.", dstProvisioner)
-	return nil
+	return fmt.Errorf("not enough space in %s to migrate data", opts.DestSCName)
 }
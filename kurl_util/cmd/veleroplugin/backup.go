@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kurlkinds/client/kurlclientset"
+	"github.com/sirupsen/logrus"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// backupClusterMetadataPlugin records the kURL cluster metadata in an annotation of every backed
+// up namespace, so the restore side can check if the target cluster is able to run the backup.
+// velero creates the namespaces of a restore, keeping their annotations, before restoring any
+// other item.
+type backupClusterMetadataPlugin struct {
+	log     logrus.FieldLogger
+	client  kubernetes.Interface
+	kurlcli kurlclientset.Interface
+
+	mtx    sync.Mutex
+	backup types.UID
+	data   string
+}
+
+func newBackupClusterMetadataPlugin(logger logrus.FieldLogger) (interface{}, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	kurlcli, err := kurlclientset.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &backupClusterMetadataPlugin{
+		log:     logger,
+		client:  client,
+		kurlcli: kurlcli,
+	}, nil
+}
+
+// nolint:unparam
+func (p *backupClusterMetadataPlugin) AppliesTo() (velero.ResourceSelector, error) {
+	return velero.ResourceSelector{
+		IncludedResources: []string{"namespaces"},
+	}, nil
+}
+
+func (p *backupClusterMetadataPlugin) Execute(item runtime.Unstructured, backup *velerov1.Backup) (runtime.Unstructured, []velero.ResourceIdentifier, error) {
+	metadata, err := meta.Accessor(item)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data, err := p.clusterMetadata(backup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	labels := metadata.GetLabels()
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[clusterMetadataLabel] = "true"
+	metadata.SetLabels(labels)
+
+	annotations := metadata.GetAnnotations()
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[clusterMetadataAnnotation] = data
+	if backup != nil {
+		annotations[clusterMetadataBackupAnnotation] = backup.Name
+	}
+	metadata.SetAnnotations(annotations)
+
+	return item, nil, nil
+}
+
+// clusterMetadata collects the cluster metadata and encodes it as json. this is done only once
+// per backup.
+func (p *backupClusterMetadataPlugin) clusterMetadata(backup *velerov1.Backup) (string, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if backup != nil && p.data != "" && p.backup == backup.UID {
+		return p.data, nil
+	}
+
+	md, err := collectClusterMetadata(context.TODO(), p.client, p.kurlcli)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to collect cluster metadata")
+	}
+
+	data, err := json.Marshal(md)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to encode cluster metadata")
+	}
+
+	p.data = string(data)
+	if backup != nil {
+		p.backup = backup.UID
+		p.log.Infof("Collected kURL cluster metadata for backup %s", backup.Name)
+	}
+	return p.data, nil
+}
@@ -1,5 +1,8 @@
-// This plugin changes the proxy env vars and host CA path on restored kotsadm deployments (<1.46)
-// and statefulsets (>=1.46). All replicasets and pods belonging to the kotsadm deployment/statefulset
+// This plugin rewrites restored workloads according to a set of rules read from the plugin
+// ConfigMap. Each rule matches objects by namespace, kind, name and label selector and may rewrite
+// env vars, host path volumes, node selectors, image registries and storage class names. The legacy proxy env vars
+// and host CA path settings are converted into rules for the kotsadm deployment (<1.46) and
+// statefulset (>=1.46). All replicasets and pods belonging to the kotsadm deployment/statefulset
 // must also be changed to prevent Kubernetes from creating a new replicaset, which can cause the
 // restore to fail because of race conditions.
 package main
@@ -8,18 +11,22 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
 	"github.com/vmware-tanzu/velero/pkg/plugin/framework"
 	"github.com/vmware-tanzu/velero/pkg/plugin/framework/common"
 	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 )
@@ -27,13 +34,23 @@ import (
 type restoreKotsadmPlugin struct {
 	log    logrus.FieldLogger
 	client kubernetes.Interface
+
+	mtx     sync.Mutex
+	restore types.UID
+	rules   []rule
 }
 
-const name = "kurl.sh/restore-kotsadm-plugin"
+const (
+	name                       = "kurl.sh/restore-kotsadm-plugin"
+	backupClusterMetadataName  = "kurl.sh/backup-cluster-metadata-plugin"
+	restoreClusterMetadataName = "kurl.sh/restore-cluster-metadata-plugin"
+)
 
 func main() {
 	framework.NewServer().
 		RegisterRestoreItemAction(name, newRestorePlugin).
+		RegisterBackupItemAction(backupClusterMetadataName, newBackupClusterMetadataPlugin).
+		RegisterRestoreItemAction(restoreClusterMetadataName, newRestoreClusterMetadataPlugin).
 		Serve()
 }
 
@@ -58,8 +75,9 @@ func newRestorePlugin(logger logrus.FieldLogger) (interface{}, error) {
 // (*restoreKotsadmPlugin).AppliesTo - result 1 (error) is always nil (unparam)
 func (p *restoreKotsadmPlugin) AppliesTo() (velero.ResourceSelector, error) {
 	return velero.ResourceSelector{
-		IncludedNamespaces: []string{"default"},
-		IncludedResources:  []string{"deployments", "statefulsets", "replicasets", "pods"},
+		IncludedResources: []string{
+			"deployments", "statefulsets", "replicasets", "daemonsets", "jobs", "cronjobs", "pods", "persistentvolumeclaims",
+		},
 	}, nil
 }
 
@@ -69,89 +87,162 @@ func (p *restoreKotsadmPlugin) Execute(input *velero.RestoreItemActionExecuteInp
 		return nil, err
 	}
 
-	data, err := p.getPluginConfig()
+	rules, err := p.restoreRules(input.Restore)
 	if err != nil {
 		return nil, err
 	}
-	if len(data) == 0 {
+	if len(rules) == 0 {
 		return &velero.RestoreItemActionExecuteOutput{UpdatedItem: input.Item}, nil
 	}
 
-	var updatedObj interface{}
+	// all resources handled by this plugin are namespaced, an empty namespace means default.
+	namespace := metadata.GetNamespace()
+	if namespace == "" {
+		namespace = metav1.NamespaceDefault
+	}
 
 	gvk := input.Item.GetObjectKind().GroupVersionKind()
-	const kotsadm = "kotsadm"
-	switch gvk.Kind {
-	case "Deployment":
-		if metadata.GetName() != kotsadm {
-			return &velero.RestoreItemActionExecuteOutput{UpdatedItem: input.Item}, nil
+	var matching []rule
+	for _, r := range rules {
+		if r.matches(gvk.Kind, namespace, metadata.GetName(), metadata.GetLabels()) {
+			matching = append(matching, r)
 		}
+	}
+	if len(matching) == 0 {
+		return &velero.RestoreItemActionExecuteOutput{UpdatedItem: input.Item}, nil
+	}
 
+	var updatedObj interface{}
+	var podSpec *corev1.PodSpec
+	var claims []**string
+	switch gvk.Kind {
+	case "Deployment":
 		deployment := &appsv1.Deployment{}
 		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.Item.UnstructuredContent(), deployment); err != nil {
-			return nil, errors.Wrap(err, "unable to convert kotsadm deployment from runtime.Unstructured")
+			return nil, errors.Wrap(err, "unable to convert deployment from runtime.Unstructured")
 		}
-		update(&deployment.Spec.Template.Spec, data)
-
+		podSpec = &deployment.Spec.Template.Spec
 		updatedObj = deployment
 
 	case "StatefulSet":
-		if metadata.GetName() != kotsadm {
-			return &velero.RestoreItemActionExecuteOutput{UpdatedItem: input.Item}, nil
-		}
-
 		statefulset := &appsv1.StatefulSet{}
 		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.Item.UnstructuredContent(), statefulset); err != nil {
-			return nil, errors.Wrap(err, "unable to convert kotsadm statefulset from runtime.Unstructured")
+			return nil, errors.Wrap(err, "unable to convert statefulset from runtime.Unstructured")
+		}
+		podSpec = &statefulset.Spec.Template.Spec
+		for i := range statefulset.Spec.VolumeClaimTemplates {
+			claims = append(claims, &statefulset.Spec.VolumeClaimTemplates[i].Spec.StorageClassName)
 		}
-
-		update(&statefulset.Spec.Template.Spec, data)
 		updatedObj = statefulset
 
 	case "ReplicaSet":
-		if metadata.GetLabels()["app"] != kotsadm {
-			return &velero.RestoreItemActionExecuteOutput{UpdatedItem: input.Item}, nil
-		}
-
 		replicaset := &appsv1.ReplicaSet{}
 		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.Item.UnstructuredContent(), replicaset); err != nil {
-			return nil, errors.Wrap(err, "unable to convert kotsadm replicaset from runtime.Unstructured")
+			return nil, errors.Wrap(err, "unable to convert replicaset from runtime.Unstructured")
 		}
-		update(&replicaset.Spec.Template.Spec, data)
-
+		podSpec = &replicaset.Spec.Template.Spec
 		updatedObj = replicaset
 
-	case "Pod":
-		if metadata.GetLabels()["app"] != kotsadm {
-			return &velero.RestoreItemActionExecuteOutput{UpdatedItem: input.Item}, nil
+	case "DaemonSet":
+		daemonset := &appsv1.DaemonSet{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.Item.UnstructuredContent(), daemonset); err != nil {
+			return nil, errors.Wrap(err, "unable to convert daemonset from runtime.Unstructured")
+		}
+		podSpec = &daemonset.Spec.Template.Spec
+		updatedObj = daemonset
+
+	case "Job":
+		job := &batchv1.Job{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.Item.UnstructuredContent(), job); err != nil {
+			return nil, errors.Wrap(err, "unable to convert job from runtime.Unstructured")
+		}
+		podSpec = &job.Spec.Template.Spec
+		updatedObj = job
+
+	case "CronJob":
+		cronjob := &batchv1.CronJob{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.Item.UnstructuredContent(), cronjob); err != nil {
+			return nil, errors.Wrap(err, "unable to convert cronjob from runtime.Unstructured")
 		}
+		podSpec = &cronjob.Spec.JobTemplate.Spec.Template.Spec
+		updatedObj = cronjob
 
+	case "Pod":
 		pod := &corev1.Pod{}
 		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.Item.UnstructuredContent(), pod); err != nil {
-			return nil, errors.Wrap(err, "unable to convert kotsadm pod from runtime.Unstructured")
+			return nil, errors.Wrap(err, "unable to convert pod from runtime.Unstructured")
 		}
-		update(&pod.Spec, data)
-
+		podSpec = &pod.Spec
 		updatedObj = pod
 
+	case "PersistentVolumeClaim":
+		pvc := &corev1.PersistentVolumeClaim{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(input.Item.UnstructuredContent(), pvc); err != nil {
+			return nil, errors.Wrap(err, "unable to convert persistentvolumeclaim from runtime.Unstructured")
+		}
+		claims = append(claims, &pvc.Spec.StorageClassName)
+		updatedObj = pvc
+
 	default:
 		return &velero.RestoreItemActionExecuteOutput{UpdatedItem: input.Item}, nil
 	}
 
+	for _, r := range matching {
+		p.log.Infof("Applying restore rule %q to %s %s/%s", r.Name, gvk.Kind, namespace, metadata.GetName())
+		if podSpec != nil {
+			if err := r.applyToPodSpec(podSpec); err != nil {
+				return nil, errors.Wrapf(err, "unable to apply rule %s", r.Name)
+			}
+		}
+		for _, claim := range claims {
+			*claim = r.applyToStorageClassName(*claim)
+		}
+	}
+
 	updated, err := runtime.DefaultUnstructuredConverter.ToUnstructured(updatedObj)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to convert kotsadm resource to runtime.Unstructured")
+		return nil, errors.Wrapf(err, "unable to convert %s to runtime.Unstructured", gvk.Kind)
 	}
 	item := &unstructured.Unstructured{Object: updated}
 
 	return &velero.RestoreItemActionExecuteOutput{UpdatedItem: item}, err
 }
 
-func (p *restoreKotsadmPlugin) getPluginConfig() (map[string]string, error) {
+// restoreRules returns the rules read from the plugin ConfigMap, including the legacy kotsadm
+// settings. the ConfigMap is read only once per restore.
+func (p *restoreKotsadmPlugin) restoreRules(restore *velerov1.Restore) ([]rule, error) {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if restore != nil && p.restore == restore.UID {
+		return p.rules, nil
+	}
+
+	data, err := getPluginConfig(p.client, name, common.PluginKindRestoreItemAction)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := parseRules(data)
+	if err != nil {
+		return nil, err
+	}
+	rules = append(legacyKotsadmRules(data), rules...)
+
+	p.rules = rules
+	if restore != nil {
+		p.restore = restore.UID
+	}
+	return p.rules, nil
+}
+
+// getPluginConfig returns the data of the plugin ConfigMap labeled with the provided plugin name
+// and kind. returns nil if no ConfigMap exists.
+func getPluginConfig(client kubernetes.Interface, plugin string, kind common.PluginKind) (map[string]string, error) {
 	opts := metav1.ListOptions{
-		LabelSelector: fmt.Sprintf("velero.io/plugin-config,%s=%s", name, common.PluginKindRestoreItemAction),
+		LabelSelector: fmt.Sprintf("velero.io/plugin-config,%s=%s", plugin, kind),
 	}
-	list, err := p.client.CoreV1().ConfigMaps(os.Getenv("VELERO_NAMESPACE")).List(context.TODO(), opts)
+	list, err := client.CoreV1().ConfigMaps(os.Getenv("VELERO_NAMESPACE")).List(context.TODO(), opts)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -170,52 +261,3 @@ func (p *restoreKotsadmPlugin) getPluginConfig() (map[string]string, error) {
 
 	return list.Items[0].Data, nil
 }
-
-func update(pod *corev1.PodSpec, data map[string]string) {
-	if noProxy, ok := data["NO_PROXY"]; ok {
-		setKotsadmEnv(pod, "NO_PROXY", noProxy)
-	}
-	if httpProxy, ok := data["HTTP_PROXY"]; ok {
-		setKotsadmEnv(pod, "HTTP_PROXY", httpProxy)
-	}
-	if httpsProxy, ok := data["HTTPS_PROXY"]; ok {
-		setKotsadmEnv(pod, "HTTPS_PROXY", httpsProxy)
-	}
-	if caHostPath, ok := data["hostCAPath"]; ok && caHostPath != "" {
-		setKotsadmHostCAPath(pod, caHostPath)
-	}
-}
-
-func setKotsadmEnv(pod *corev1.PodSpec, key string, value string) {
-	for i, container := range pod.Containers {
-		if container.Name != "kotsadm" {
-			continue
-		}
-		for j, env := range container.Env {
-			if env.Name == key {
-				container.Env[j].Value = value
-				return
-			}
-		}
-		// append since not found (unless empty)
-		if value == "" {
-			return
-		}
-		pod.Containers[i].Env = append(container.Env, corev1.EnvVar{
-			Name:  key,
-			Value: value,
-		})
-	}
-}
-
-func setKotsadmHostCAPath(pod *corev1.PodSpec, caHostPath string) {
-	for i, volume := range pod.Volumes {
-		if volume.Name != "host-cacerts" {
-			continue
-		}
-		if volume.HostPath == nil {
-			continue
-		}
-		pod.Volumes[i].HostPath.Path = caHostPath
-	}
-}
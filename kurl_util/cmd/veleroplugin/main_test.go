@@ -3,6 +3,7 @@ package main
 import (
 	"testing"
 
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
@@ -419,6 +420,7 @@ func TestRestoreKotsadmPluginExecute(t *testing.T) {
 		t.Run(test.name, func(t *testing.T) {
 			clientset := fake.NewClientset(test.configMaps...)
 			p := &restoreKotsadmPlugin{
+				log:    logrus.New(),
 				client: clientset,
 			}
 
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kurlkinds/client/kurlclientset"
+	kurlv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilversion "k8s.io/apimachinery/pkg/util/version"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// clusterMetadataAnnotation holds the kURL cluster metadata (json) on every backed up
+	// namespace and clusterMetadataBackupAnnotation the name of the backup it was collected for.
+	// the namespaces are identified on restore by the clusterMetadataLabel label.
+	clusterMetadataLabel            = "kurl.sh/cluster-metadata"
+	clusterMetadataAnnotation       = "kurl.sh/cluster-metadata"
+	clusterMetadataBackupAnnotation = "kurl.sh/cluster-metadata-backup"
+	isDefaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+	controlPlaneLabel               = "node-role.kubernetes.io/control-plane"
+)
+
+// clusterMetadata describes the kURL cluster a backup was taken from.
+type clusterMetadata struct {
+	KurlVersion       string                     `json:"kurlVersion,omitempty"`
+	InstallerID       string                     `json:"installerID,omitempty"`
+	Installer         *kurlv1beta1.InstallerSpec `json:"installer,omitempty"`
+	AddOns            map[string]string          `json:"addOns,omitempty"`
+	KubernetesVersion string                     `json:"kubernetesVersion"`
+	Nodes             []nodeMetadata             `json:"nodes"`
+	StorageClasses    []storageClassMetadata     `json:"storageClasses"`
+	Proxy             proxyMetadata              `json:"proxy"`
+}
+
+type nodeMetadata struct {
+	Name           string `json:"name"`
+	ControlPlane   bool   `json:"controlPlane"`
+	KubeletVersion string `json:"kubeletVersion"`
+	OSImage        string `json:"osImage"`
+	Architecture   string `json:"architecture"`
+}
+
+// storageClassMetadata describes a storage class. Claims is the number of persistent volume
+// claims using the storage class when the backup was taken.
+type storageClassMetadata struct {
+	Name        string `json:"name"`
+	Provisioner string `json:"provisioner"`
+	Default     bool   `json:"default"`
+	Claims      int    `json:"claims"`
+}
+
+type proxyMetadata struct {
+	ProxyAddress               string   `json:"proxyAddress,omitempty"`
+	AdditionalNoProxyAddresses []string `json:"additionalNoProxyAddresses,omitempty"`
+	ServiceCIDR                string   `json:"serviceCIDR,omitempty"`
+	PodCIDR                    string   `json:"podCIDR,omitempty"`
+}
+
+// collectClusterMetadata reads the kURL configuration, installer spec, nodes and storage classes
+// from the cluster. the installer spec is optional as it may have been removed from the cluster.
+func collectClusterMetadata(ctx context.Context, kcli kubernetes.Interface, kurlcli kurlclientset.Interface) (*clusterMetadata, error) {
+	md := &clusterMetadata{}
+
+	serverVersion, err := kcli.Discovery().ServerVersion()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get kubernetes version")
+	}
+	md.KubernetesVersion = serverVersion.GitVersion
+
+	kurlConfig, err := kcli.CoreV1().ConfigMaps("kube-system").Get(ctx, "kurl-config", metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "unable to get kurl-config configmap")
+	} else if err == nil {
+		md.InstallerID = kurlConfig.Data["installer_id"]
+		md.Proxy.ServiceCIDR = kurlConfig.Data["service_cidr"]
+		md.Proxy.PodCIDR = kurlConfig.Data["pod_cidr"]
+	}
+
+	currentConfig, err := kcli.CoreV1().ConfigMaps("kurl").Get(ctx, "kurl-current-config", metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, errors.Wrap(err, "unable to get kurl-current-config configmap")
+	} else if err == nil {
+		md.KurlVersion = currentConfig.Data["kurl-version"]
+	}
+
+	if md.InstallerID != "" {
+		installer, err := kurlcli.ClusterV1beta1().Installers(metav1.NamespaceDefault).Get(ctx, md.InstallerID, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, errors.Wrapf(err, "unable to get installer %s", md.InstallerID)
+		} else if err == nil {
+			md.Installer = &installer.Spec
+			md.AddOns = addOnVersions(installer.Spec)
+			if installer.Spec.Kurl != nil {
+				md.Proxy.ProxyAddress = installer.Spec.Kurl.ProxyAddress
+				md.Proxy.AdditionalNoProxyAddresses = installer.Spec.Kurl.AdditionalNoProxyAddresses
+			}
+		}
+	}
+
+	nodes, err := kcli.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list nodes")
+	}
+	for _, node := range nodes.Items {
+		_, controlPlane := node.Labels[controlPlaneLabel]
+		md.Nodes = append(md.Nodes, nodeMetadata{
+			Name:           node.Name,
+			ControlPlane:   controlPlane,
+			KubeletVersion: node.Status.NodeInfo.KubeletVersion,
+			OSImage:        node.Status.NodeInfo.OSImage,
+			Architecture:   node.Status.NodeInfo.Architecture,
+		})
+	}
+
+	md.StorageClasses, err = storageClasses(ctx, kcli)
+	if err != nil {
+		return nil, err
+	}
+	return md, nil
+}
+
+// storageClasses returns the storage classes in the cluster, including the number of claims
+// using each of them. claims without storage class are accounted to the default one.
+func storageClasses(ctx context.Context, kcli kubernetes.Interface) ([]storageClassMetadata, error) {
+	classes, err := kcli.StorageV1().StorageClasses().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list storage classes")
+	}
+
+	pvcs, err := kcli.CoreV1().PersistentVolumeClaims(metav1.NamespaceAll).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list persistent volume claims")
+	}
+
+	var result []storageClassMetadata
+	for _, class := range classes.Items {
+		result = append(result, storageClassMetadata{
+			Name:        class.Name,
+			Provisioner: class.Provisioner,
+			Default:     class.Annotations[isDefaultStorageClassAnnotation] == "true",
+			Claims:      countClaims(pvcs.Items, class.Name, class.Annotations[isDefaultStorageClassAnnotation] == "true"),
+		})
+	}
+	return result, nil
+}
+
+func countClaims(pvcs []corev1.PersistentVolumeClaim, scname string, isDefault bool) int {
+	var count int
+	for _, pvc := range pvcs {
+		if pvc.Spec.StorageClassName == nil {
+			if isDefault {
+				count++
+			}
+			continue
+		}
+		if *pvc.Spec.StorageClassName == scname {
+			count++
+		}
+	}
+	return count
+}
+
+// addOnVersions returns the version of every add-on present in the installer spec, indexed by
+// the add-on json name.
+func addOnVersions(spec kurlv1beta1.InstallerSpec) map[string]string {
+	result := map[string]string{}
+	value := reflect.ValueOf(spec)
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if field.Kind() != reflect.Ptr || field.IsNil() {
+			continue
+		}
+		version := field.Elem().FieldByName("Version")
+		if !version.IsValid() || version.Kind() != reflect.String || version.String() == "" {
+			continue
+		}
+		jsonName := strings.Split(value.Type().Field(i).Tag.Get("json"), ",")[0]
+		result[jsonName] = version.String()
+	}
+	return result
+}
+
+// compatibilityReport holds the result of comparing a backup cluster metadata against the
+// cluster where the backup is being restored. Errors make the restore impossible while warnings
+// point out differences that may need operator attention.
+type compatibilityReport struct {
+	Errors   []string
+	Warnings []string
+}
+
+func (c *compatibilityReport) errorf(format string, args ...interface{}) {
+	c.Errors = append(c.Errors, fmt.Sprintf(format, args...))
+}
+
+func (c *compatibilityReport) warnf(format string, args ...interface{}) {
+	c.Warnings = append(c.Warnings, fmt.Sprintf(format, args...))
+}
+
+// checkCompatibility compares the backup cluster metadata (from) against the target cluster (to).
+// storageClassMapping holds the velero change storage class configuration (old name -> new name).
+func checkCompatibility(from, to *clusterMetadata, storageClassMapping map[string]string) (*compatibilityReport, error) {
+	report := &compatibilityReport{}
+
+	fromVersion, err := utilversion.ParseGeneric(from.KubernetesVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse backup kubernetes version %s", from.KubernetesVersion)
+	}
+	toVersion, err := utilversion.ParseGeneric(to.KubernetesVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse cluster kubernetes version %s", to.KubernetesVersion)
+	}
+	if toVersion.Major() < fromVersion.Major() || (toVersion.Major() == fromVersion.Major() && toVersion.Minor() < fromVersion.Minor()) {
+		report.errorf("kubernetes version %s is older than the backup kubernetes version %s", to.KubernetesVersion, from.KubernetesVersion)
+	}
+
+	targetClasses := map[string]bool{}
+	for _, sc := range to.StorageClasses {
+		targetClasses[sc.Name] = true
+	}
+	for _, sc := range from.StorageClasses {
+		if sc.Claims == 0 || targetClasses[sc.Name] {
+			continue
+		}
+		if mapped, ok := storageClassMapping[sc.Name]; ok {
+			if !targetClasses[mapped] {
+				report.errorf("storage class %s is mapped to %s but it does not exist", sc.Name, mapped)
+			}
+			continue
+		}
+		report.errorf("storage class %s used by %d claims does not exist", sc.Name, sc.Claims)
+	}
+
+	var addons []string
+	for addon := range from.AddOns {
+		addons = append(addons, addon)
+	}
+	sort.Strings(addons)
+	for _, addon := range addons {
+		if _, ok := to.AddOns[addon]; !ok && to.Installer != nil {
+			report.warnf("add-on %s (%s) is not installed", addon, from.AddOns[addon])
+		}
+	}
+
+	if len(to.Nodes) < len(from.Nodes) {
+		report.warnf("cluster has %d nodes while the backup was taken on a cluster with %d nodes", len(to.Nodes), len(from.Nodes))
+	}
+
+	if from.Proxy.ProxyAddress != to.Proxy.ProxyAddress {
+		report.warnf("proxy address changed from %q to %q", from.Proxy.ProxyAddress, to.Proxy.ProxyAddress)
+	}
+	if from.Proxy.ServiceCIDR != "" && to.Proxy.ServiceCIDR != "" && from.Proxy.ServiceCIDR != to.Proxy.ServiceCIDR {
+		report.warnf("service cidr changed from %s to %s", from.Proxy.ServiceCIDR, to.Proxy.ServiceCIDR)
+	}
+	if from.Proxy.PodCIDR != "" && to.Proxy.PodCIDR != "" && from.Proxy.PodCIDR != to.Proxy.PodCIDR {
+		report.warnf("pod cidr changed from %s to %s", from.Proxy.PodCIDR, to.Proxy.PodCIDR)
+	}
+	return report, nil
+}
@@ -0,0 +1,357 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	kurlfake "github.com/replicatedhq/kurlkinds/client/kurlclientset/fake"
+	kurlv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func newMetadataClients(t *testing.T, k8sVersion string, objects ...runtime.Object) (*fake.Clientset, *kurlfake.Clientset) {
+	t.Helper()
+	kcli := fake.NewClientset(objects...)
+	kcli.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &version.Info{GitVersion: k8sVersion}
+	kurlcli := kurlfake.NewSimpleClientset(&kurlv1beta1.Installer{
+		ObjectMeta: metav1.ObjectMeta{Name: "abc1234", Namespace: "default"},
+		Spec: kurlv1beta1.InstallerSpec{
+			Kubernetes: &kurlv1beta1.Kubernetes{Version: "1.29.4"},
+			Longhorn:   &kurlv1beta1.Longhorn{Version: "1.4.1"},
+			Kurl: &kurlv1beta1.Kurl{
+				ProxyAddress:               "http://proxy:3128",
+				AdditionalNoProxyAddresses: []string{"10.0.0.1"},
+			},
+		},
+	})
+	return kcli, kurlcli
+}
+
+func metadataObjects() []runtime.Object {
+	return []runtime.Object{
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kurl-config", Namespace: "kube-system"},
+			Data: map[string]string{
+				"installer_id": "abc1234",
+				"service_cidr": "10.96.0.0/22",
+				"pod_cidr":     "10.32.0.0/20",
+			},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kurl-current-config", Namespace: "kurl"},
+			Data:       map[string]string{"kurl-version": "v2024.01.01-0"},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node0", Labels: map[string]string{controlPlaneLabel: ""}},
+			Status: corev1.NodeStatus{
+				NodeInfo: corev1.NodeSystemInfo{KubeletVersion: "v1.29.4", OSImage: "Ubuntu 22.04", Architecture: "amd64"},
+			},
+		},
+		&storagev1.StorageClass{
+			ObjectMeta:  metav1.ObjectMeta{Name: "longhorn", Annotations: map[string]string{isDefaultStorageClassAnnotation: "true"}},
+			Provisioner: "driver.longhorn.io",
+		},
+		&storagev1.StorageClass{
+			ObjectMeta:  metav1.ObjectMeta{Name: "unused"},
+			Provisioner: "openebs.io/local",
+		},
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "a", Namespace: "default"},
+			Spec:       corev1.PersistentVolumeClaimSpec{StorageClassName: ptr.To("longhorn")},
+		},
+		&corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "b", Namespace: "default"},
+		},
+	}
+}
+
+func TestCollectClusterMetadata(t *testing.T) {
+	kcli, kurlcli := newMetadataClients(t, "v1.29.4", metadataObjects()...)
+	md, err := collectClusterMetadata(t.Context(), kcli, kurlcli)
+	require.NoError(t, err)
+
+	assert.Equal(t, "v1.29.4", md.KubernetesVersion)
+	assert.Equal(t, "v2024.01.01-0", md.KurlVersion)
+	assert.Equal(t, "abc1234", md.InstallerID)
+	assert.Equal(t, map[string]string{"kubernetes": "1.29.4", "longhorn": "1.4.1"}, md.AddOns)
+	assert.Equal(t, []nodeMetadata{
+		{Name: "node0", ControlPlane: true, KubeletVersion: "v1.29.4", OSImage: "Ubuntu 22.04", Architecture: "amd64"},
+	}, md.Nodes)
+	assert.ElementsMatch(t, []storageClassMetadata{
+		{Name: "longhorn", Provisioner: "driver.longhorn.io", Default: true, Claims: 2},
+		{Name: "unused", Provisioner: "openebs.io/local", Claims: 0},
+	}, md.StorageClasses)
+	assert.Equal(t, proxyMetadata{
+		ProxyAddress:               "http://proxy:3128",
+		AdditionalNoProxyAddresses: []string{"10.0.0.1"},
+		ServiceCIDR:                "10.96.0.0/22",
+		PodCIDR:                    "10.32.0.0/20",
+	}, md.Proxy)
+}
+
+func TestCollectClusterMetadataWithoutKurl(t *testing.T) {
+	kcli, kurlcli := newMetadataClients(t, "v1.28.0")
+	md, err := collectClusterMetadata(t.Context(), kcli, kurlcli)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.28.0", md.KubernetesVersion)
+	assert.Nil(t, md.Installer)
+	assert.Empty(t, md.AddOns)
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	base := func() *clusterMetadata {
+		return &clusterMetadata{
+			Installer:         &kurlv1beta1.InstallerSpec{},
+			AddOns:            map[string]string{"kubernetes": "1.29.4", "longhorn": "1.4.1"},
+			KubernetesVersion: "v1.29.4",
+			Nodes:             []nodeMetadata{{Name: "node0"}},
+			StorageClasses:    []storageClassMetadata{{Name: "longhorn", Claims: 2}, {Name: "unused"}},
+		}
+	}
+
+	tests := []struct {
+		name         string
+		mutateFrom   func(*clusterMetadata)
+		mutateTo     func(*clusterMetadata)
+		mapping      map[string]string
+		wantErrors   []string
+		wantWarnings []string
+	}{
+		{
+			name: "same cluster",
+		},
+		{
+			name:       "older kubernetes",
+			mutateTo:   func(m *clusterMetadata) { m.KubernetesVersion = "v1.28.9" },
+			wantErrors: []string{"kubernetes version v1.28.9 is older than the backup kubernetes version v1.29.4"},
+		},
+		{
+			name:     "newer kubernetes",
+			mutateTo: func(m *clusterMetadata) { m.KubernetesVersion = "v1.30.0" },
+		},
+		{
+			name: "missing storage class",
+			mutateTo: func(m *clusterMetadata) {
+				m.StorageClasses = []storageClassMetadata{{Name: "openebs"}}
+				m.AddOns = map[string]string{"kubernetes": "1.29.4", "openebs": "3.10.0"}
+			},
+			wantErrors:   []string{"storage class longhorn used by 2 claims does not exist"},
+			wantWarnings: []string{"add-on longhorn (1.4.1) is not installed"},
+		},
+		{
+			name:     "storage class mapped by velero",
+			mutateTo: func(m *clusterMetadata) { m.StorageClasses = []storageClassMetadata{{Name: "openebs"}} },
+			mapping:  map[string]string{"longhorn": "openebs"},
+		},
+		{
+			name:       "storage class mapped to a missing class",
+			mutateTo:   func(m *clusterMetadata) { m.StorageClasses = []storageClassMetadata{{Name: "openebs"}} },
+			mapping:    map[string]string{"longhorn": "rook"},
+			wantErrors: []string{"storage class longhorn is mapped to rook but it does not exist"},
+		},
+		{
+			name: "topology and proxy changes",
+			mutateFrom: func(m *clusterMetadata) {
+				m.Nodes = append(m.Nodes, nodeMetadata{Name: "node1"})
+				m.Proxy = proxyMetadata{ProxyAddress: "http://proxy:3128", ServiceCIDR: "10.96.0.0/22"}
+			},
+			mutateTo: func(m *clusterMetadata) {
+				m.Proxy = proxyMetadata{ServiceCIDR: "10.100.0.0/22"}
+			},
+			wantWarnings: []string{
+				"cluster has 1 nodes while the backup was taken on a cluster with 2 nodes",
+				`proxy address changed from "http://proxy:3128" to ""`,
+				"service cidr changed from 10.96.0.0/22 to 10.100.0.0/22",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			from, to := base(), base()
+			if test.mutateFrom != nil {
+				test.mutateFrom(from)
+			}
+			if test.mutateTo != nil {
+				test.mutateTo(to)
+			}
+			report, err := checkCompatibility(from, to, test.mapping)
+			require.NoError(t, err)
+			assert.Equal(t, test.wantErrors, report.Errors)
+			assert.Equal(t, test.wantWarnings, report.Warnings)
+		})
+	}
+}
+
+func TestBackupAndRestoreClusterMetadata(t *testing.T) {
+	t.Setenv("VELERO_NAMESPACE", "velero")
+
+	backupKcli, backupKurlcli := newMetadataClients(t, "v1.29.4", metadataObjects()...)
+	backupPlugin := &backupClusterMetadataPlugin{
+		log:     logrus.New(),
+		client:  backupKcli,
+		kurlcli: backupKurlcli,
+	}
+
+	namespace := func(name string) *unstructured.Unstructured {
+		obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&corev1.Namespace{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+			ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"app": name}},
+		})
+		require.NoError(t, err)
+		return &unstructured.Unstructured{Object: obj}
+	}
+
+	// velero runs the backup item action on every namespace and stores the returned item.
+	backup := &velerov1.Backup{ObjectMeta: metav1.ObjectMeta{Name: "backup", UID: "backup-uid"}}
+	var backedUp []*corev1.Namespace
+	for _, ns := range []string{"app", "kotsadm"} {
+		item, additional, err := backupPlugin.Execute(namespace(ns), backup)
+		require.NoError(t, err)
+		assert.Empty(t, additional)
+
+		backedUpNamespace := &corev1.Namespace{}
+		require.NoError(t, runtime.DefaultUnstructuredConverter.FromUnstructured(item.UnstructuredContent(), backedUpNamespace))
+		assert.Equal(t, map[string]string{"app": ns, clusterMetadataLabel: "true"}, backedUpNamespace.Labels)
+		assert.Equal(t, "backup", backedUpNamespace.Annotations[clusterMetadataBackupAnnotation])
+		backedUp = append(backedUp, backedUpNamespace)
+	}
+	assert.Equal(t, backedUp[0].Annotations, backedUp[1].Annotations)
+
+	var md clusterMetadata
+	require.NoError(t, json.Unmarshal([]byte(backedUp[0].Annotations[clusterMetadataAnnotation]), &md))
+	assert.Equal(t, "abc1234", md.InstallerID)
+
+	item := func(obj runtime.Object) *unstructured.Unstructured {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		require.NoError(t, err)
+		return &unstructured.Unstructured{Object: content}
+	}
+	// velero restores persistent volumes before any namespaced item.
+	pv := item(&corev1.PersistentVolume{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolume"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pv"},
+	})
+	pod := item(&corev1.Pod{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "Pod"},
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "app"},
+	})
+
+	for _, test := range []struct {
+		name        string
+		mode        string
+		backup      string
+		namespaces  []*corev1.Namespace
+		wantErr     string
+		wantSkip    bool
+		wantWarning string
+	}{
+		{
+			name:        "warn mode restores incompatible backups",
+			mode:        metadataCheckModeWarn,
+			backup:      "backup",
+			namespaces:  backedUp,
+			wantWarning: "Backup cluster metadata: cluster has 0 nodes while the backup was taken on a cluster with 1 nodes",
+		},
+		{
+			name:       "refuse mode skips incompatible backups",
+			mode:       metadataCheckModeRefuse,
+			backup:     "backup",
+			namespaces: backedUp,
+			wantErr:    "storage class longhorn used by 2 claims does not exist",
+			wantSkip:   true,
+		},
+		{
+			name:   "namespaces that already exist do not hold the metadata",
+			mode:   metadataCheckModeRefuse,
+			backup: "backup",
+			namespaces: []*corev1.Namespace{
+				{ObjectMeta: metav1.ObjectMeta{Name: "app"}},
+			},
+			wantWarning: `No kURL cluster metadata found for backup "backup" in the restored namespaces, skipping the cluster compatibility check`,
+		},
+		{
+			name:        "metadata of another backup is ignored",
+			mode:        metadataCheckModeRefuse,
+			backup:      "backup2",
+			namespaces:  backedUp,
+			wantWarning: `No kURL cluster metadata found for backup "backup2" in the restored namespaces, skipping the cluster compatibility check`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			objects := []runtime.Object{
+				&corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "kurl-restore-cluster-metadata",
+						Namespace: "velero",
+						Labels: map[string]string{
+							"velero.io/plugin-config":  "",
+							restoreClusterMetadataName: "RestoreItemAction",
+						},
+					},
+					Data: map[string]string{metadataCheckModeKey: test.mode},
+				},
+			}
+			for _, ns := range test.namespaces {
+				objects = append(objects, ns.DeepCopy())
+			}
+			kcli, kurlcli := newMetadataClients(t, "v1.29.4", objects...)
+			logger, hook := logtest.NewNullLogger()
+			restorePlugin := &restoreClusterMetadataPlugin{
+				log:     logger,
+				client:  kcli,
+				kurlcli: kurlcli,
+				checked: map[types.UID]bool{},
+				refuse:  map[types.UID]bool{},
+			}
+			restore := &velerov1.Restore{
+				ObjectMeta: metav1.ObjectMeta{Name: "restore", UID: "restore-uid"},
+				Spec:       velerov1.RestoreSpec{BackupName: test.backup},
+			}
+
+			output, err := restorePlugin.Execute(&velero.RestoreItemActionExecuteInput{
+				Item:           pv.DeepCopy(),
+				ItemFromBackup: pv.DeepCopy(),
+				Restore:        restore,
+			})
+			if test.wantErr != "" {
+				assert.ErrorContains(t, err, test.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.False(t, output.SkipRestore)
+			}
+
+			output, err = restorePlugin.Execute(&velero.RestoreItemActionExecuteInput{
+				Item:           pod.DeepCopy(),
+				ItemFromBackup: pod.DeepCopy(),
+				Restore:        restore,
+			})
+			require.NoError(t, err)
+			assert.Equal(t, test.wantSkip, output.SkipRestore)
+
+			if test.wantWarning != "" {
+				var warnings []string
+				for _, entry := range hook.AllEntries() {
+					if entry.Level == logrus.WarnLevel {
+						warnings = append(warnings, entry.Message)
+					}
+				}
+				assert.Contains(t, warnings, test.wantWarning)
+			}
+		})
+	}
+}
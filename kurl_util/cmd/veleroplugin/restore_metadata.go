@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kurlkinds/client/kurlclientset"
+	"github.com/sirupsen/logrus"
+	"github.com/vmware-tanzu/velero/pkg/plugin/framework/common"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// changeStorageClassName is the name of the velero built in plugin used to map storage classes.
+	changeStorageClassName = "velero.io/change-storage-class"
+
+	// metadataCheckModeKey is the key in the plugin ConfigMap that selects what to do when the
+	// cluster is not compatible with the backup. "warn" (default) only logs the problems while
+	// "refuse" skips the restore of all items.
+	metadataCheckModeKey    = "mode"
+	metadataCheckModeWarn   = "warn"
+	metadataCheckModeRefuse = "refuse"
+)
+
+// restoreClusterMetadataPlugin compares the cluster metadata recorded by
+// backupClusterMetadataPlugin against the cluster where the backup is being restored. velero
+// creates the backed up namespaces, keeping their annotations, before restoring any other item and
+// without running restore item actions on them, so the check reads the metadata from the
+// namespaces and runs when the first item of the restore is restored. the outcome is used for all
+// the items of the same restore: when the restore is refused the first item fails and all the
+// others are skipped. velero does not update namespaces that already exist in the cluster, when no
+// namespace holds the metadata of the backup a warning is logged and the check is skipped.
+type restoreClusterMetadataPlugin struct {
+	log     logrus.FieldLogger
+	client  kubernetes.Interface
+	kurlcli kurlclientset.Interface
+
+	mtx     sync.Mutex
+	checked map[types.UID]bool
+	refuse  map[types.UID]bool
+}
+
+func newRestoreClusterMetadataPlugin(logger logrus.FieldLogger) (interface{}, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	kurlcli, err := kurlclientset.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &restoreClusterMetadataPlugin{
+		log:     logger,
+		client:  client,
+		kurlcli: kurlcli,
+		checked: map[types.UID]bool{},
+		refuse:  map[types.UID]bool{},
+	}, nil
+}
+
+// nolint:unparam
+func (p *restoreClusterMetadataPlugin) AppliesTo() (velero.ResourceSelector, error) {
+	return velero.ResourceSelector{}, nil
+}
+
+func (p *restoreClusterMetadataPlugin) Execute(input *velero.RestoreItemActionExecuteInput) (*velero.RestoreItemActionExecuteOutput, error) {
+	var restore types.UID
+	var backup string
+	if input.Restore != nil {
+		restore = input.Restore.UID
+		backup = input.Restore.Spec.BackupName
+	}
+
+	if err := p.check(restore, backup); err != nil {
+		return nil, err
+	}
+
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return &velero.RestoreItemActionExecuteOutput{
+		UpdatedItem: input.Item,
+		SkipRestore: p.refuse[restore],
+	}, nil
+}
+
+// check compares the backup cluster metadata against the current cluster. this is done only once
+// per restore.
+func (p *restoreClusterMetadataPlugin) check(restore types.UID, backup string) error {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+
+	if p.checked[restore] {
+		return nil
+	}
+
+	data, err := p.backupClusterMetadata(backup)
+	if err != nil {
+		return err
+	}
+	if data == "" {
+		p.log.Warnf("No kURL cluster metadata found for backup %q in the restored namespaces, skipping the cluster compatibility check", backup)
+		p.checked[restore] = true
+		return nil
+	}
+
+	var from clusterMetadata
+	if err := json.Unmarshal([]byte(data), &from); err != nil {
+		return errors.Wrap(err, "unable to decode backup cluster metadata")
+	}
+
+	to, err := collectClusterMetadata(context.TODO(), p.client, p.kurlcli)
+	if err != nil {
+		return errors.Wrap(err, "unable to collect cluster metadata")
+	}
+
+	config, err := getPluginConfig(p.client, restoreClusterMetadataName, common.PluginKindRestoreItemAction)
+	if err != nil {
+		return err
+	}
+	mode := metadataCheckModeWarn
+	if value := config[metadataCheckModeKey]; value != "" {
+		mode = value
+	}
+	if mode != metadataCheckModeWarn && mode != metadataCheckModeRefuse {
+		return errors.Errorf("invalid %s %q, expected %s or %s", metadataCheckModeKey, mode, metadataCheckModeWarn, metadataCheckModeRefuse)
+	}
+
+	mapping, err := getPluginConfig(p.client, changeStorageClassName, common.PluginKindRestoreItemAction)
+	if err != nil {
+		return err
+	}
+
+	report, err := checkCompatibility(&from, to, mapping)
+	if err != nil {
+		return errors.Wrap(err, "unable to check cluster compatibility")
+	}
+
+	for _, warning := range report.Warnings {
+		p.log.Warnf("Backup cluster metadata: %s", warning)
+	}
+	for _, problem := range report.Errors {
+		p.log.Errorf("Cluster is not compatible with the backup: %s", problem)
+	}
+
+	p.checked[restore] = true
+	if len(report.Errors) > 0 && mode == metadataCheckModeRefuse {
+		p.refuse[restore] = true
+		return errors.Errorf("cluster is not compatible with the backup: %s", strings.Join(report.Errors, "; "))
+	}
+	return nil
+}
+
+// backupClusterMetadata returns the cluster metadata recorded for backup in the namespaces of the
+// cluster. returns an empty string if no namespace holds the metadata of the backup.
+func (p *restoreClusterMetadataPlugin) backupClusterMetadata(backup string) (string, error) {
+	namespaces, err := p.client.CoreV1().Namespaces().List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=true", clusterMetadataLabel),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "unable to list namespaces")
+	}
+	for _, namespace := range namespaces.Items {
+		if namespace.Annotations[clusterMetadataBackupAnnotation] == backup {
+			return namespace.Annotations[clusterMetadataAnnotation], nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/distribution/reference"
+	"github.com/pkg/errors"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// rulesConfigKey is the key in the plugin ConfigMap holding the list of rewrite rules (yaml).
+const rulesConfigKey = "rules"
+
+// rule describes a set of rewrites applied to every restored object matching the rule.
+type rule struct {
+	Name             string                   `json:"name"`
+	Match            ruleMatch                `json:"match"`
+	Env              *envRewrite              `json:"env,omitempty"`
+	Volumes          []volumeRewrite          `json:"volumes,omitempty"`
+	ImageRegistry    *imageRegistryRewrite    `json:"imageRegistry,omitempty"`
+	StorageClassName *storageClassNameRewrite `json:"storageClassName,omitempty"`
+	NodeSelectors    []nodeSelectorRewrite    `json:"nodeSelectors,omitempty"`
+}
+
+// ruleMatch selects the objects a rule applies to. empty fields match everything.
+type ruleMatch struct {
+	Namespaces    []string `json:"namespaces,omitempty"`
+	Kinds         []string `json:"kinds,omitempty"`
+	Names         []string `json:"names,omitempty"`
+	LabelSelector string   `json:"labelSelector,omitempty"`
+}
+
+// envRewrite sets environment variables in the containers listed in Containers (or in all
+// containers if empty). variables not yet present are appended unless their value is empty.
+type envRewrite struct {
+	Containers []string        `json:"containers,omitempty"`
+	Vars       []corev1.EnvVar `json:"vars"`
+}
+
+// volumeRewrite changes the host path of a pod volume. the volume is selected by Name, by its
+// current host path (FromHostPath) or by both.
+type volumeRewrite struct {
+	Name         string `json:"name,omitempty"`
+	FromHostPath string `json:"fromHostPath,omitempty"`
+	HostPath     string `json:"hostPath"`
+}
+
+// imageRegistryRewrite replaces the registry (or registry and path prefix) of container images.
+type imageRegistryRewrite struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// storageClassNameRewrite replaces the storage class name of persistent volume claims and
+// stateful set volume claim templates. if From is empty all storage class names are replaced.
+type storageClassNameRewrite struct {
+	From string `json:"from,omitempty"`
+	To   string `json:"to"`
+}
+
+// nodeSelectorRewrite replaces the value of a node label (Key) in the node selector and the node
+// affinity terms of pods. if From is empty all values of the label are replaced. if To is empty the
+// matching values are removed, and so is the label when From is also empty.
+type nodeSelectorRewrite struct {
+	Key  string `json:"key"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// parseRules parses and validates the rules present in the plugin configuration.
+func parseRules(data map[string]string) ([]rule, error) {
+	raw, ok := data[rulesConfigKey]
+	if !ok || strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+
+	var rules []rule
+	if err := yaml.UnmarshalStrict([]byte(raw), &rules); err != nil {
+		return nil, errors.Wrap(err, "unable to parse restore rules")
+	}
+
+	for i, r := range rules {
+		if err := r.validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid rule %d (%s)", i, r.Name)
+		}
+	}
+	return rules, nil
+}
+
+// legacyKotsadmRules converts the proxy and host CA settings historically supported by this
+// plugin into rules applied to kotsadm deployments, statefulsets, replicasets and pods in the
+// default namespace.
+func legacyKotsadmRules(data map[string]string) []rule {
+	env := &envRewrite{Containers: []string{"kotsadm"}}
+	for _, key := range []string{"NO_PROXY", "HTTP_PROXY", "HTTPS_PROXY"} {
+		if value, ok := data[key]; ok {
+			env.Vars = append(env.Vars, corev1.EnvVar{Name: key, Value: value})
+		}
+	}
+	if len(env.Vars) == 0 {
+		env = nil
+	}
+
+	var volumes []volumeRewrite
+	if caHostPath := data["hostCAPath"]; caHostPath != "" {
+		volumes = append(volumes, volumeRewrite{Name: "host-cacerts", HostPath: caHostPath})
+	}
+
+	if env == nil && volumes == nil {
+		return nil
+	}
+
+	return []rule{
+		{
+			Name: "kotsadm",
+			Match: ruleMatch{
+				Namespaces: []string{"default"},
+				Kinds:      []string{"Deployment", "StatefulSet"},
+				Names:      []string{"kotsadm"},
+			},
+			Env:     env,
+			Volumes: volumes,
+		},
+		{
+			Name: "kotsadm-pods",
+			Match: ruleMatch{
+				Namespaces:    []string{"default"},
+				Kinds:         []string{"ReplicaSet", "Pod"},
+				LabelSelector: "app=kotsadm",
+			},
+			Env:     env,
+			Volumes: volumes,
+		},
+	}
+}
+
+func (r rule) validate() error {
+	if r.Match.LabelSelector != "" {
+		if _, err := labels.Parse(r.Match.LabelSelector); err != nil {
+			return errors.Wrap(err, "invalid label selector")
+		}
+	}
+	if r.Env != nil {
+		for _, env := range r.Env.Vars {
+			if env.Name == "" {
+				return errors.New("env var without name")
+			}
+		}
+	}
+	for _, vol := range r.Volumes {
+		if vol.Name == "" && vol.FromHostPath == "" {
+			return errors.New("volume rewrite requires a name or a fromHostPath")
+		}
+		if vol.HostPath == "" {
+			return errors.New("volume rewrite without hostPath")
+		}
+	}
+	if r.ImageRegistry != nil && (r.ImageRegistry.From == "" || r.ImageRegistry.To == "") {
+		return errors.New("image registry rewrite requires from and to")
+	}
+	if r.StorageClassName != nil && r.StorageClassName.To == "" {
+		return errors.New("storage class name rewrite without to")
+	}
+	for _, selector := range r.NodeSelectors {
+		if selector.Key == "" {
+			return errors.New("node selector rewrite without key")
+		}
+	}
+	if r.Env == nil && r.Volumes == nil && r.ImageRegistry == nil && r.StorageClassName == nil && r.NodeSelectors == nil {
+		return errors.New("no rewrite defined")
+	}
+	return nil
+}
+
+// matches returns true if the rule applies to an object of the provided kind, namespace, name
+// and labels.
+func (r rule) matches(kind, namespace, name string, objLabels map[string]string) bool {
+	if len(r.Match.Kinds) > 0 && !slices.Contains(r.Match.Kinds, kind) {
+		return false
+	}
+	if len(r.Match.Namespaces) > 0 && !slices.Contains(r.Match.Namespaces, namespace) {
+		return false
+	}
+	if len(r.Match.Names) > 0 && !slices.Contains(r.Match.Names, name) {
+		return false
+	}
+	if r.Match.LabelSelector == "" {
+		return true
+	}
+	// selector has already been validated by parseRules.
+	selector, err := labels.Parse(r.Match.LabelSelector)
+	if err != nil {
+		return false
+	}
+	return selector.Matches(labels.Set(objLabels))
+}
+
+// applyToPodSpec applies the env, volume, node selector and image registry rewrites to the
+// provided pod spec.
+func (r rule) applyToPodSpec(pod *corev1.PodSpec) error {
+	if r.Env != nil {
+		for _, env := range r.Env.Vars {
+			setEnv(pod, r.Env.Containers, env.Name, env.Value)
+		}
+	}
+	for _, vol := range r.Volumes {
+		setHostPath(pod, vol)
+	}
+	for _, selector := range r.NodeSelectors {
+		setNodeSelector(pod, selector)
+	}
+	if r.ImageRegistry != nil {
+		for i := range pod.InitContainers {
+			image, err := rewriteImageRegistry(pod.InitContainers[i].Image, r.ImageRegistry)
+			if err != nil {
+				return err
+			}
+			pod.InitContainers[i].Image = image
+		}
+		for i := range pod.Containers {
+			image, err := rewriteImageRegistry(pod.Containers[i].Image, r.ImageRegistry)
+			if err != nil {
+				return err
+			}
+			pod.Containers[i].Image = image
+		}
+	}
+	return nil
+}
+
+// applyToStorageClassName rewrites the provided storage class name if the rule says so.
+func (r rule) applyToStorageClassName(scname *string) *string {
+	if r.StorageClassName == nil {
+		return scname
+	}
+	if r.StorageClassName.From != "" && (scname == nil || *scname != r.StorageClassName.From) {
+		return scname
+	}
+	to := r.StorageClassName.To
+	return &to
+}
+
+func setEnv(pod *corev1.PodSpec, containers []string, key string, value string) {
+	for i, container := range pod.Containers {
+		if len(containers) > 0 && !slices.Contains(containers, container.Name) {
+			continue
+		}
+		found := false
+		for j, env := range container.Env {
+			if env.Name == key {
+				pod.Containers[i].Env[j].Value = value
+				found = true
+				break
+			}
+		}
+		// append since not found (unless empty)
+		if found || value == "" {
+			continue
+		}
+		pod.Containers[i].Env = append(container.Env, corev1.EnvVar{
+			Name:  key,
+			Value: value,
+		})
+	}
+}
+
+func setHostPath(pod *corev1.PodSpec, rewrite volumeRewrite) {
+	for i, volume := range pod.Volumes {
+		if volume.HostPath == nil {
+			continue
+		}
+		if rewrite.Name != "" && volume.Name != rewrite.Name {
+			continue
+		}
+		if rewrite.FromHostPath != "" && volume.HostPath.Path != rewrite.FromHostPath {
+			continue
+		}
+		pod.Volumes[i].HostPath.Path = rewrite.HostPath
+	}
+}
+
+func setNodeSelector(pod *corev1.PodSpec, rewrite nodeSelectorRewrite) {
+	if value, ok := pod.NodeSelector[rewrite.Key]; ok && (rewrite.From == "" || value == rewrite.From) {
+		if rewrite.To == "" {
+			delete(pod.NodeSelector, rewrite.Key)
+		} else {
+			pod.NodeSelector[rewrite.Key] = rewrite.To
+		}
+	}
+
+	if pod.Affinity == nil || pod.Affinity.NodeAffinity == nil {
+		return
+	}
+	affinity := pod.Affinity.NodeAffinity
+
+	// terms left without requirements are removed, an empty required term matches no node.
+	if required := affinity.RequiredDuringSchedulingIgnoredDuringExecution; required != nil {
+		var terms []corev1.NodeSelectorTerm
+		for _, term := range required.NodeSelectorTerms {
+			expressions := rewriteNodeSelectorRequirements(term.MatchExpressions, rewrite)
+			if len(term.MatchExpressions) > 0 && len(expressions) == 0 && len(term.MatchFields) == 0 {
+				continue
+			}
+			term.MatchExpressions = expressions
+			terms = append(terms, term)
+		}
+		if len(required.NodeSelectorTerms) > 0 && len(terms) == 0 {
+			affinity.RequiredDuringSchedulingIgnoredDuringExecution = nil
+		} else {
+			required.NodeSelectorTerms = terms
+		}
+	}
+
+	var preferred []corev1.PreferredSchedulingTerm
+	for _, term := range affinity.PreferredDuringSchedulingIgnoredDuringExecution {
+		expressions := rewriteNodeSelectorRequirements(term.Preference.MatchExpressions, rewrite)
+		if len(term.Preference.MatchExpressions) > 0 && len(expressions) == 0 && len(term.Preference.MatchFields) == 0 {
+			continue
+		}
+		term.Preference.MatchExpressions = expressions
+		preferred = append(preferred, term)
+	}
+	affinity.PreferredDuringSchedulingIgnoredDuringExecution = preferred
+}
+
+// rewriteNodeSelectorRequirements rewrites the values of the requirements on the rewrite key.
+// requirements left without values are removed.
+func rewriteNodeSelectorRequirements(requirements []corev1.NodeSelectorRequirement, rewrite nodeSelectorRewrite) []corev1.NodeSelectorRequirement {
+	var result []corev1.NodeSelectorRequirement
+	for _, requirement := range requirements {
+		if requirement.Key != rewrite.Key {
+			result = append(result, requirement)
+			continue
+		}
+		if rewrite.From == "" && rewrite.To == "" {
+			continue
+		}
+		if len(requirement.Values) == 0 {
+			result = append(result, requirement)
+			continue
+		}
+
+		var values []string
+		for _, value := range requirement.Values {
+			if rewrite.From == "" || value == rewrite.From {
+				value = rewrite.To
+			}
+			if value != "" && !slices.Contains(values, value) {
+				values = append(values, value)
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+		requirement.Values = values
+		result = append(result, requirement)
+	}
+	return result
+}
+
+// rewriteImageRegistry replaces the registry of the provided image. images are normalized
+// before comparison so "nginx" is seen as "docker.io/library/nginx".
+func rewriteImageRegistry(image string, rewrite *imageRegistryRewrite) (string, error) {
+	if image == "" {
+		return image, nil
+	}
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to parse image %s", image)
+	}
+	from := strings.TrimSuffix(rewrite.From, "/") + "/"
+	if !strings.HasPrefix(named.String(), from) {
+		return image, nil
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(rewrite.To, "/"), strings.TrimPrefix(named.String(), from)), nil
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	velerov1 "github.com/vmware-tanzu/velero/pkg/apis/velero/v1"
+	"github.com/vmware-tanzu/velero/pkg/plugin/velero"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string]string
+		want    []rule
+		wantErr string
+	}{
+		{
+			name: "no rules",
+			data: map[string]string{"HTTP_PROXY": "http://proxy"},
+		},
+		{
+			name: "all rule types",
+			data: map[string]string{
+				"rules": `
+- name: everything
+  match:
+    namespaces: [app]
+    kinds: [Deployment]
+    labelSelector: app=foo
+  env:
+    containers: [foo]
+    vars:
+    - name: FOO
+      value: bar
+  volumes:
+  - name: data
+    hostPath: /var/lib/data
+  imageRegistry:
+    from: registry.old
+    to: registry.new
+  storageClassName:
+    from: rook
+    to: longhorn
+  nodeSelectors:
+  - key: kubernetes.io/hostname
+    from: node-a
+    to: node-b
+`,
+			},
+			want: []rule{
+				{
+					Name: "everything",
+					Match: ruleMatch{
+						Namespaces:    []string{"app"},
+						Kinds:         []string{"Deployment"},
+						LabelSelector: "app=foo",
+					},
+					Env: &envRewrite{
+						Containers: []string{"foo"},
+						Vars:       []corev1.EnvVar{{Name: "FOO", Value: "bar"}},
+					},
+					Volumes:          []volumeRewrite{{Name: "data", HostPath: "/var/lib/data"}},
+					ImageRegistry:    &imageRegistryRewrite{From: "registry.old", To: "registry.new"},
+					StorageClassName: &storageClassNameRewrite{From: "rook", To: "longhorn"},
+					NodeSelectors:    []nodeSelectorRewrite{{Key: "kubernetes.io/hostname", From: "node-a", To: "node-b"}},
+				},
+			},
+		},
+		{
+			name:    "unknown field",
+			data:    map[string]string{"rules": "- name: foo\n  unknown: true\n"},
+			wantErr: "unable to parse restore rules",
+		},
+		{
+			name:    "invalid label selector",
+			data:    map[string]string{"rules": "- name: foo\n  match:\n    labelSelector: '!!'\n  storageClassName:\n    to: bar\n"},
+			wantErr: "invalid label selector",
+		},
+		{
+			name:    "no rewrite",
+			data:    map[string]string{"rules": "- name: foo\n"},
+			wantErr: "invalid rule 0 (foo): no rewrite defined",
+		},
+		{
+			name:    "volume without selector",
+			data:    map[string]string{"rules": "- name: foo\n  volumes:\n  - hostPath: /foo\n"},
+			wantErr: "volume rewrite requires a name or a fromHostPath",
+		},
+		{
+			name:    "node selector without key",
+			data:    map[string]string{"rules": "- name: foo\n  nodeSelectors:\n  - to: bar\n"},
+			wantErr: "node selector rewrite without key",
+		},
+		{
+			name:    "image registry without destination",
+			data:    map[string]string{"rules": "- name: foo\n  imageRegistry:\n    from: foo\n"},
+			wantErr: "image registry rewrite requires from and to",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseRules(test.data)
+			if test.wantErr != "" {
+				assert.ErrorContains(t, err, test.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	r := rule{
+		Match: ruleMatch{
+			Namespaces:    []string{"app"},
+			Kinds:         []string{"Deployment", "Pod"},
+			LabelSelector: "tier in (web,api)",
+		},
+	}
+	assert.True(t, r.matches("Deployment", "app", "foo", map[string]string{"tier": "web"}))
+	assert.True(t, r.matches("Pod", "app", "bar", map[string]string{"tier": "api"}))
+	assert.False(t, r.matches("StatefulSet", "app", "foo", map[string]string{"tier": "web"}))
+	assert.False(t, r.matches("Deployment", "default", "foo", map[string]string{"tier": "web"}))
+	assert.False(t, r.matches("Deployment", "app", "foo", map[string]string{"tier": "db"}))
+	assert.True(t, rule{}.matches("Pod", "any", "any", nil))
+}
+
+func TestRuleEnvRewrite(t *testing.T) {
+	pod := &corev1.PodSpec{
+		Containers: []corev1.Container{
+			{Name: "foo", Env: []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "old"}}},
+			{Name: "bar"},
+		},
+	}
+	r := rule{
+		Env: &envRewrite{
+			Containers: []string{"foo"},
+			Vars: []corev1.EnvVar{
+				{Name: "HTTP_PROXY", Value: "new"},
+				{Name: "NO_PROXY", Value: ".svc"},
+				{Name: "EMPTY", Value: ""},
+			},
+		},
+	}
+	require.NoError(t, r.applyToPodSpec(pod))
+	assert.Equal(t, []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "new"}, {Name: "NO_PROXY", Value: ".svc"}}, pod.Containers[0].Env)
+	assert.Empty(t, pod.Containers[1].Env)
+
+	r.Env.Containers = nil
+	require.NoError(t, r.applyToPodSpec(pod))
+	assert.Equal(t, []corev1.EnvVar{{Name: "HTTP_PROXY", Value: "new"}, {Name: "NO_PROXY", Value: ".svc"}}, pod.Containers[1].Env)
+}
+
+func TestRuleVolumeRewrite(t *testing.T) {
+	hostPath := func(name, path string) corev1.Volume {
+		return corev1.Volume{
+			Name:         name,
+			VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: path}},
+		}
+	}
+	pod := &corev1.PodSpec{
+		Volumes: []corev1.Volume{
+			hostPath("certs", "/etc/ssl/certs"),
+			hostPath("data", "/var/lib/foo"),
+			hostPath("other", "/var/lib/foo"),
+			{Name: "empty", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+		},
+	}
+	r := rule{
+		Volumes: []volumeRewrite{
+			{Name: "certs", HostPath: "/etc/pki/tls/certs"},
+			{FromHostPath: "/var/lib/foo", HostPath: "/opt/foo"},
+			{Name: "empty", HostPath: "/ignored"},
+		},
+	}
+	require.NoError(t, r.applyToPodSpec(pod))
+	assert.Equal(t, []corev1.Volume{
+		hostPath("certs", "/etc/pki/tls/certs"),
+		hostPath("data", "/opt/foo"),
+		hostPath("other", "/opt/foo"),
+		{Name: "empty", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+	}, pod.Volumes)
+}
+
+func TestRuleNodeSelectorRewrite(t *testing.T) {
+	hostname := func(operator corev1.NodeSelectorOperator, values ...string) corev1.NodeSelectorRequirement {
+		return corev1.NodeSelectorRequirement{Key: corev1.LabelHostname, Operator: operator, Values: values}
+	}
+	zone := corev1.NodeSelectorRequirement{Key: corev1.LabelTopologyZone, Operator: corev1.NodeSelectorOpIn, Values: []string{"a"}}
+	newPod := func() *corev1.PodSpec {
+		return &corev1.PodSpec{
+			NodeSelector: map[string]string{corev1.LabelHostname: "node-a", corev1.LabelOSStable: "linux"},
+			Affinity: &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{
+							{MatchExpressions: []corev1.NodeSelectorRequirement{hostname(corev1.NodeSelectorOpIn, "node-a", "node-c")}},
+							{MatchExpressions: []corev1.NodeSelectorRequirement{zone, hostname(corev1.NodeSelectorOpNotIn, "node-a")}},
+						},
+					},
+					PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{
+						{Weight: 1, Preference: corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{hostname(corev1.NodeSelectorOpIn, "node-a")}}},
+					},
+				},
+			},
+		}
+	}
+
+	tests := []struct {
+		name             string
+		rewrite          nodeSelectorRewrite
+		wantNodeSelector map[string]string
+		wantAffinity     *corev1.NodeAffinity
+	}{
+		{
+			name:             "replace a node",
+			rewrite:          nodeSelectorRewrite{Key: corev1.LabelHostname, From: "node-a", To: "node-b"},
+			wantNodeSelector: map[string]string{corev1.LabelHostname: "node-b", corev1.LabelOSStable: "linux"},
+			wantAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{hostname(corev1.NodeSelectorOpIn, "node-b", "node-c")}},
+						{MatchExpressions: []corev1.NodeSelectorRequirement{zone, hostname(corev1.NodeSelectorOpNotIn, "node-b")}},
+					},
+				},
+				PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{
+					{Weight: 1, Preference: corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{hostname(corev1.NodeSelectorOpIn, "node-b")}}},
+				},
+			},
+		},
+		{
+			name:             "replace all nodes",
+			rewrite:          nodeSelectorRewrite{Key: corev1.LabelHostname, To: "node-b"},
+			wantNodeSelector: map[string]string{corev1.LabelHostname: "node-b", corev1.LabelOSStable: "linux"},
+			wantAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{hostname(corev1.NodeSelectorOpIn, "node-b")}},
+						{MatchExpressions: []corev1.NodeSelectorRequirement{zone, hostname(corev1.NodeSelectorOpNotIn, "node-b")}},
+					},
+				},
+				PreferredDuringSchedulingIgnoredDuringExecution: []corev1.PreferredSchedulingTerm{
+					{Weight: 1, Preference: corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{hostname(corev1.NodeSelectorOpIn, "node-b")}}},
+				},
+			},
+		},
+		{
+			name:             "remove a node",
+			rewrite:          nodeSelectorRewrite{Key: corev1.LabelHostname, From: "node-a"},
+			wantNodeSelector: map[string]string{corev1.LabelOSStable: "linux"},
+			wantAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{hostname(corev1.NodeSelectorOpIn, "node-c")}},
+						{MatchExpressions: []corev1.NodeSelectorRequirement{zone}},
+					},
+				},
+			},
+		},
+		{
+			name:             "remove the label",
+			rewrite:          nodeSelectorRewrite{Key: corev1.LabelHostname},
+			wantNodeSelector: map[string]string{corev1.LabelOSStable: "linux"},
+			wantAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{zone}},
+					},
+				},
+			},
+		},
+		{
+			name:             "other labels are not changed",
+			rewrite:          nodeSelectorRewrite{Key: "node-role.kubernetes.io/control-plane"},
+			wantNodeSelector: newPod().NodeSelector,
+			wantAffinity:     newPod().Affinity.NodeAffinity,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := newPod()
+			r := rule{NodeSelectors: []nodeSelectorRewrite{test.rewrite}}
+			require.NoError(t, r.applyToPodSpec(pod))
+			assert.Equal(t, test.wantNodeSelector, pod.NodeSelector)
+			assert.Equal(t, test.wantAffinity, pod.Affinity.NodeAffinity)
+		})
+	}
+
+	// removing the only requirement of the required terms removes the node affinity requirement.
+	pod := &corev1.PodSpec{
+		Affinity: &corev1.Affinity{
+			NodeAffinity: &corev1.NodeAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+					NodeSelectorTerms: []corev1.NodeSelectorTerm{
+						{MatchExpressions: []corev1.NodeSelectorRequirement{hostname(corev1.NodeSelectorOpIn, "node-a")}},
+					},
+				},
+			},
+		},
+	}
+	require.NoError(t, rule{NodeSelectors: []nodeSelectorRewrite{{Key: corev1.LabelHostname, From: "node-a"}}}.applyToPodSpec(pod))
+	assert.Nil(t, pod.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution)
+}
+
+func TestRuleImageRegistryRewrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		rewrite imageRegistryRewrite
+		image   string
+		want    string
+	}{
+		{
+			name:    "registry with port",
+			rewrite: imageRegistryRewrite{From: "10.96.0.10:443", To: "registry.kurl.svc:443"},
+			image:   "10.96.0.10:443/app/api:1.0.0",
+			want:    "registry.kurl.svc:443/app/api:1.0.0",
+		},
+		{
+			name:    "docker hub image is normalized",
+			rewrite: imageRegistryRewrite{From: "docker.io", To: "mirror.local"},
+			image:   "nginx:1.25",
+			want:    "mirror.local/library/nginx:1.25",
+		},
+		{
+			name:    "registry and path prefix",
+			rewrite: imageRegistryRewrite{From: "quay.io/org", To: "mirror.local/quay"},
+			image:   "quay.io/org/app@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+			want:    "mirror.local/quay/app@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+		},
+		{
+			name:    "other registries are not changed",
+			rewrite: imageRegistryRewrite{From: "quay.io", To: "mirror.local"},
+			image:   "registry.k8s.io/pause:3.9",
+			want:    "registry.k8s.io/pause:3.9",
+		},
+		{
+			name:    "partial host names do not match",
+			rewrite: imageRegistryRewrite{From: "quay.io", To: "mirror.local"},
+			image:   "quay.io.example.com/app:1",
+			want:    "quay.io.example.com/app:1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pod := &corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "init", Image: test.image}},
+				Containers:     []corev1.Container{{Name: "main", Image: test.image}},
+			}
+			r := rule{ImageRegistry: &test.rewrite}
+			require.NoError(t, r.applyToPodSpec(pod))
+			assert.Equal(t, test.want, pod.InitContainers[0].Image)
+			assert.Equal(t, test.want, pod.Containers[0].Image)
+		})
+	}
+}
+
+func TestRuleStorageClassNameRewrite(t *testing.T) {
+	r := rule{StorageClassName: &storageClassNameRewrite{From: "rook", To: "longhorn"}}
+	assert.Equal(t, ptr.To("longhorn"), r.applyToStorageClassName(ptr.To("rook")))
+	assert.Equal(t, ptr.To("openebs"), r.applyToStorageClassName(ptr.To("openebs")))
+	assert.Nil(t, r.applyToStorageClassName(nil))
+
+	r.StorageClassName.From = ""
+	assert.Equal(t, ptr.To("longhorn"), r.applyToStorageClassName(ptr.To("openebs")))
+	assert.Equal(t, ptr.To("longhorn"), r.applyToStorageClassName(nil))
+
+	assert.Equal(t, ptr.To("rook"), rule{}.applyToStorageClassName(ptr.To("rook")))
+}
+
+func TestRestorePluginExecuteRules(t *testing.T) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "kurl-restore-rules",
+			Namespace: "velero",
+			Labels: map[string]string{
+				"velero.io/plugin-config":        "",
+				"kurl.sh/restore-kotsadm-plugin": "RestoreItemAction",
+			},
+		},
+		Data: map[string]string{
+			"rules": `
+- name: storage
+  match:
+    namespaces: [app]
+  storageClassName:
+    from: rook
+    to: longhorn
+- name: registry
+  match:
+    kinds: [StatefulSet]
+    labelSelector: app=db
+  imageRegistry:
+    from: 10.96.0.10
+    to: 10.96.0.20
+`,
+		},
+	}
+
+	t.Setenv("VELERO_NAMESPACE", "velero")
+	client := fake.NewClientset(configMap)
+	p := &restoreKotsadmPlugin{
+		log:    logrus.New(),
+		client: client,
+	}
+	restore := &velerov1.Restore{ObjectMeta: metav1.ObjectMeta{Name: "restore", UID: "restore-uid"}}
+
+	execute := func(obj runtime.Object, into runtime.Object) {
+		content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		require.NoError(t, err)
+		output, err := p.Execute(&velero.RestoreItemActionExecuteInput{
+			Item:    &unstructured.Unstructured{Object: content},
+			Restore: restore,
+		})
+		require.NoError(t, err)
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(output.UpdatedItem.UnstructuredContent(), into)
+		require.NoError(t, err)
+	}
+
+	pvc := &corev1.PersistentVolumeClaim{}
+	execute(&corev1.PersistentVolumeClaim{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "app"},
+		Spec:       corev1.PersistentVolumeClaimSpec{StorageClassName: ptr.To("rook")},
+	}, pvc)
+	assert.Equal(t, ptr.To("longhorn"), pvc.Spec.StorageClassName)
+
+	otherPVC := &corev1.PersistentVolumeClaim{}
+	execute(&corev1.PersistentVolumeClaim{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "PersistentVolumeClaim"},
+		ObjectMeta: metav1.ObjectMeta{Name: "data", Namespace: "other"},
+		Spec:       corev1.PersistentVolumeClaimSpec{StorageClassName: ptr.To("rook")},
+	}, otherPVC)
+	assert.Equal(t, ptr.To("rook"), otherPVC.Spec.StorageClassName)
+
+	sts := &appsv1.StatefulSet{}
+	execute(&appsv1.StatefulSet{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "app", Labels: map[string]string{"app": "db"}},
+		Spec: appsv1.StatefulSetSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "db", Image: "10.96.0.10/app/db:1"}},
+				},
+			},
+			VolumeClaimTemplates: []corev1.PersistentVolumeClaim{
+				{Spec: corev1.PersistentVolumeClaimSpec{StorageClassName: ptr.To("rook")}},
+			},
+		},
+	}, sts)
+	assert.Equal(t, "10.96.0.20/app/db:1", sts.Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, ptr.To("longhorn"), sts.Spec.VolumeClaimTemplates[0].Spec.StorageClassName)
+
+	// the plugin configuration is read once per restore.
+	var lists int
+	for _, action := range client.Actions() {
+		if action.Matches("list", "configmaps") {
+			lists++
+		}
+	}
+	assert.Equal(t, 1, lists)
+}
@@ -12,11 +12,10 @@ import (
 	"strings"
 
 	"github.com/pkg/errors"
+	"github.com/replicatedhq/kurl/pkg/installer"
 	kurlversion "github.com/replicatedhq/kurl/pkg/version"
 	kurlscheme "github.com/replicatedhq/kurlkinds/client/kurlclientset/scheme"
 	kurlv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
 	"gopkg.in/yaml.v2"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
@@ -81,9 +80,7 @@ func getFieldsSet(yamlDoc []byte) (map[string]bool, error) {
 			if !ok {
 				continue
 			}
-			caser := cases.Title(language.English, cases.NoLower)
-			key := fmt.Sprintf("%s.%s", caser.String(categoryKey), caser.String(fieldKey))
-			out[key] = true
+			out[fieldSetKey(categoryKey, fieldKey)] = true
 		}
 	}
 
@@ -148,6 +145,23 @@ func checkIfSkippedVariable(yamlString string) bool {
 }
 
 func convertToBash(kurlValues map[string]interface{}, fieldsSet map[string]bool) (map[string]string, error) {
+	variables, err := convertToVariables(kurlValues, fieldsSet)
+	if err != nil {
+		return nil, err
+	}
+
+	finalDictionary := make(map[string]string)
+	for name, variable := range variables {
+		finalDictionary[name] = variable.bashValue()
+	}
+
+	return finalDictionary, nil
+}
+
+// convertToVariables converts the flattened installer spec into variables keyed by their bash name.
+// Each variable records the spec field it was derived from and whether that field was set
+// explicitly in the yaml.
+func convertToVariables(kurlValues map[string]interface{}, fieldsSet map[string]bool) (map[string]installerVariable, error) {
 	if kurlValues == nil {
 		return nil, errors.New("kurlValues map was nil")
 	}
@@ -328,7 +342,8 @@ func convertToBash(kurlValues map[string]interface{}, fieldsSet map[string]bool)
 		"AWS.ExcludeStorageClass":                         "AWS_EXCLUDE_STORAGE_CLASS",
 	}
 
-	finalDictionary := make(map[string]string)
+	paths := specFieldPaths()
+	variables := make(map[string]installerVariable)
 
 	for yamlKey, val := range kurlValues {
 		if checkIfSkippedVariable(yamlKey) {
@@ -341,74 +356,76 @@ func convertToBash(kurlValues map[string]interface{}, fieldsSet map[string]bool)
 			return nil, fmt.Errorf("%v not found in lookup table, it has not been added to the lookup table or is not in this version of kurlkinds", yamlKey)
 		}
 
-		var bashVal string
+		variable := installerVariable{
+			Name:      bashKey,
+			Field:     paths[yamlKey].String(),
+			Set:       fieldsSet[fieldSetKey(paths[yamlKey].category, paths[yamlKey].field)],
+			SpecValue: val,
+		}
 
 		switch t := val.(type) {
 		case int:
-			if t == 0 {
-				bashVal = ""
-			} else {
-				bashVal = strconv.Itoa(t)
+			variable.Type = variableTypeInteger
+			if t != 0 {
+				variable.Value = strconv.Itoa(t)
 			}
 		case string:
-			if t == "" {
-				bashVal = ""
-			} else {
-				// preserve inner double quotes
-				ts := strings.ReplaceAll(t, `"`, `\"`)
-				bashVal = "\"" + ts + "\""
-			}
+			variable.Type = variableTypeString
+			variable.Value = t
 		case bool:
+			variable.Type = variableTypeBoolean
 			if t {
-				bashVal = "1"
-			} else {
-				bashVal = ""
+				variable.Value = "1"
 			}
 		case *bool:
+			variable.Type = variableTypeBoolean
 			if t != nil {
-				bashVal = strconv.FormatBool(*t)
+				variable.Value = strconv.FormatBool(*t)
 			}
 		case []string:
+			variable.Type = variableTypeList
 			if len(t) > 0 {
-				bashVal = strings.Join(t, ",")
+				variable.Value = strings.Join(t, ",")
 			}
+		default:
+			variable.Type = variableTypeObject
 		}
 
 		switch {
-		case yamlKey == "Kubernetes.LoadBalancerAddress" && bashVal != "":
-			finalDictionary["HA_CLUSTER"] = "1"
-		case yamlKey == "Kurl.Airgap" && bashVal != "":
-			finalDictionary["OFFLINE_DOCKER_INSTALL"] = "1"
-		case yamlKey == "Weave.PodCidrRange" || yamlKey == "Kubernetes.ServiceCidrRange" || yamlKey == "Antrea.PodCidrRange" || yamlKey == "Flannel.PodCIDRRange" && bashVal != "":
-			bashVal = strings.ReplaceAll(bashVal, "/", "")
-		case yamlKey == "Docker.HardFailOnLoopback" && bashVal == "" && !fieldsSet[yamlKey]:
-			bashVal = "1"
+		case yamlKey == "Kubernetes.LoadBalancerAddress" && variable.Value != "":
+			variables["HA_CLUSTER"] = variable.derived("HA_CLUSTER", "1")
+		case yamlKey == "Kurl.Airgap" && variable.Value != "":
+			variables["OFFLINE_DOCKER_INSTALL"] = variable.derived("OFFLINE_DOCKER_INSTALL", "1")
+		case yamlKey == "Weave.PodCidrRange" || yamlKey == "Kubernetes.ServiceCidrRange" || yamlKey == "Antrea.PodCidrRange" || yamlKey == "Flannel.PodCIDRRange" && variable.Value != "":
+			variable.Value = strings.ReplaceAll(variable.Value, "/", "")
+		case yamlKey == "Docker.HardFailOnLoopback" && variable.Value == "" && !fieldsSet[yamlKey]:
+			variable.Value = "1"
 		case yamlKey == "Weave.NoMasqLocal":
-			if bashVal == "true" || bashVal == "" {
-				bashVal = "1"
+			if variable.Value == "true" || variable.Value == "" {
+				variable.Value = "1"
 			}
-			if bashVal == "false" {
-				bashVal = "0"
+			if variable.Value == "false" {
+				variable.Value = "0"
 			}
 		}
 
-		finalDictionary[bashKey] = bashVal
+		variables[bashKey] = variable
 	}
 
 	// If preserve and disable flags are set for selinux and firewalld preserve take precedence
-	val := finalDictionary["PRESERVE_FIREWALLD_CONFIG"]
-
-	if val == "1" {
-		finalDictionary["DISABLE_FIREWALLD"] = ""
+	if variables["PRESERVE_FIREWALLD_CONFIG"].Value == "1" {
+		variable := variables["DISABLE_FIREWALLD"]
+		variable.Name, variable.Value = "DISABLE_FIREWALLD", ""
+		variables["DISABLE_FIREWALLD"] = variable
 	}
 
-	val = finalDictionary["PRESERVE_SELINUX_CONFIG"]
-
-	if val == "1" {
-		finalDictionary["DISABLE_SELINUX"] = ""
+	if variables["PRESERVE_SELINUX_CONFIG"].Value == "1" {
+		variable := variables["DISABLE_SELINUX"]
+		variable.Name, variable.Value = "DISABLE_SELINUX", ""
+		variables["DISABLE_SELINUX"] = variable
 	}
 
-	return finalDictionary, nil
+	return variables, nil
 }
 
 func writeDictionaryToFile(bashDictionary map[string]string, bashPath string) error {
@@ -456,14 +473,13 @@ func addBashVariablesFromYaml(yamlPath, bashPath string) error {
 	return nil
 }
 
+// insertDefaults sets the kURL defaults of the add-ons in the spec so the variables hold the effective configuration.
 func insertDefaults(installerConfig *kurlv1beta1.Installer) {
 	if installerConfig.Spec.Kubernetes == nil {
 		installerConfig.Spec.Kubernetes = &kurlv1beta1.Kubernetes{}
 	}
 
-	if installerConfig.Spec.Kubernetes.ClusterName == "" {
-		installerConfig.Spec.Kubernetes.ClusterName = "kubernetes"
-	}
+	installer.ApplyDefaults(&installerConfig.Spec)
 }
 
 func main() {
@@ -471,7 +487,9 @@ func main() {
 
 	version := flag.Bool("v", false, "Print version info")
 	installerYAMLPath := flag.String("i", "", "installer YAML for kURL script")
-	bashVariablesPath := flag.String("b", "", "the path for the out file of bash variables")
+	bashVariablesPath := flag.String("b", "", "the path for the out file of variables")
+	outputFormat := flag.String("f", outputFormatBash, "the format of the out file: bash, json, env (systemd EnvironmentFile) or template")
+	templatePath := flag.String("t", "", "the go template rendered with the variables keyed by name when the format is template")
 
 	flag.Parse()
 
@@ -485,7 +503,14 @@ func main() {
 		os.Exit(-1)
 	}
 
-	if err := addBashVariablesFromYaml(*installerYAMLPath, *bashVariablesPath); err != nil {
+	if *outputFormat == outputFormatBash {
+		if err := addBashVariablesFromYaml(*installerYAMLPath, *bashVariablesPath); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if err := exportVariablesFromYaml(*installerYAMLPath, *bashVariablesPath, *outputFormat, *templatePath); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -411,3 +411,20 @@ func TestEndToEnd(t *testing.T) {
 		})
 	}
 }
+
+func Test_insertDefaults(t *testing.T) {
+	installerConfig := &kurlv1beta1.Installer{
+		Spec: kurlv1beta1.InstallerSpec{
+			Ekco: &kurlv1beta1.Ekco{Version: "latest", MinReadyMasterNodeCount: 3},
+		},
+	}
+	insertDefaults(installerConfig)
+	assert.Equal(t, &kurlv1beta1.Kubernetes{ClusterName: "kubernetes"}, installerConfig.Spec.Kubernetes)
+	assert.Equal(t, &kurlv1beta1.Ekco{
+		Version:                   "latest",
+		MinReadyMasterNodeCount:   3,
+		NodeUnreachableToleration: "5m",
+		EnvoyPodsNotReadyDuration: "5m",
+	}, installerConfig.Spec.Ekco)
+	assert.Nil(t, installerConfig.Spec.Contour)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/pkg/errors"
+	kurlv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+)
+
+const (
+	variableTypeString  = "string"
+	variableTypeInteger = "integer"
+	variableTypeBoolean = "boolean"
+	variableTypeList    = "list"
+	variableTypeObject  = "object"
+)
+
+const (
+	outputFormatBash     = "bash"
+	outputFormatJSON     = "json"
+	outputFormatEnv      = "env"
+	outputFormatTemplate = "template"
+)
+
+// installerVariable is a variable derived from a field of the Installer spec.
+type installerVariable struct {
+	// Name is the name of the bash variable, e.g. KUBERNETES_VERSION.
+	Name string `json:"name"`
+	// Value is the value as the install scripts use it: booleans are "1" or empty and lists are
+	// comma separated.
+	Value string `json:"value"`
+	// Type is the type of the spec field: string, integer, boolean, list or object.
+	Type string `json:"type"`
+	// Field is the path of the spec field, e.g. spec.kubernetes.version.
+	Field string `json:"field"`
+	// Set is true if the field was set explicitly in the installer yaml.
+	Set bool `json:"set"`
+	// SpecValue is the typed value of the spec field.
+	SpecValue interface{} `json:"specValue"`
+}
+
+// bashValue returns the value quoted for a bash assignment.
+func (v installerVariable) bashValue() string {
+	if v.Type != variableTypeString || v.Value == "" {
+		return v.Value
+	}
+	// preserve inner double quotes
+	return "\"" + strings.ReplaceAll(v.Value, `"`, `\"`) + "\""
+}
+
+// envValue returns the value quoted for a systemd EnvironmentFile.
+func (v installerVariable) envValue() string {
+	if strings.IndexFunc(v.Value, isUnsafeEnvRune) == -1 {
+		return v.Value
+	}
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+	return "\"" + replacer.Replace(v.Value) + "\""
+}
+
+func isUnsafeEnvRune(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return false
+	case strings.ContainsRune("_-.,:/=@%+", r):
+		return false
+	default:
+		return true
+	}
+}
+
+// derived returns a variable computed from the value of v, e.g. HA_CLUSTER from the load balancer
+// address.
+func (v installerVariable) derived(name, value string) installerVariable {
+	v.Name = name
+	v.Value = value
+	v.Type = variableTypeBoolean
+	return v
+}
+
+// specField is the location of a field in the Installer yaml.
+type specField struct {
+	category string
+	field    string
+}
+
+func (f specField) String() string {
+	if f.category == "" {
+		return ""
+	}
+	return "spec." + f.category + "." + f.field
+}
+
+// specFieldPaths returns the yaml location of the fields in the map returned by createMap, keyed the
+// same way.
+func specFieldPaths() map[string]specField {
+	paths := map[string]specField{}
+
+	specType := reflect.TypeOf(kurlv1beta1.InstallerSpec{})
+	for i := 0; i < specType.NumField(); i++ {
+		categoryType := specType.Field(i).Type
+		if categoryType.Kind() == reflect.Ptr {
+			categoryType = categoryType.Elem()
+		}
+		if categoryType.Kind() != reflect.Struct {
+			continue
+		}
+		category := jsonName(specType.Field(i))
+
+		for j := 0; j < categoryType.NumField(); j++ {
+			field := categoryType.Field(j)
+			paths[categoryType.Name()+"."+field.Name] = specField{category: category, field: jsonName(field)}
+		}
+	}
+
+	return paths
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// fieldSetKey returns the key used in the map returned by getFieldsSet for a yaml field.
+func fieldSetKey(category, field string) string {
+	caser := cases.Title(language.English, cases.NoLower)
+	return fmt.Sprintf("%s.%s", caser.String(category), caser.String(field))
+}
+
+// writeVariables writes the variables to w in the given format, bash variables are written by
+// writeDictionaryToFile. templateText is only used by the
+// template format and is executed with the variables keyed by name.
+func writeVariables(w io.Writer, variables map[string]installerVariable, format, templateText string) error {
+	switch format {
+	case outputFormatEnv:
+		lines := make([]string, 0, len(variables))
+		for name, variable := range variables {
+			lines = append(lines, name+"="+variable.envValue())
+		}
+		sort.Strings(lines)
+		for _, line := range lines {
+			if _, err := fmt.Fprintln(w, line); err != nil {
+				return errors.Wrap(err, "failed to write variables")
+			}
+		}
+
+	case outputFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(variables); err != nil {
+			return errors.Wrap(err, "failed to encode variables")
+		}
+
+	case outputFormatTemplate:
+		tmpl, err := template.New("variables").Option("missingkey=error").Parse(templateText)
+		if err != nil {
+			return errors.Wrap(err, "failed to parse template")
+		}
+		if err := tmpl.Execute(w, variables); err != nil {
+			return errors.Wrap(err, "failed to render template")
+		}
+
+	default:
+		return errors.Errorf("unknown output format %q", format)
+	}
+
+	return nil
+}
+
+// exportVariablesFromYaml writes the variables for the installer yaml at yamlPath to outPath.
+func exportVariablesFromYaml(yamlPath, outPath, format, templatePath string) error {
+	switch format {
+	case outputFormatJSON, outputFormatEnv, outputFormatTemplate:
+	default:
+		return errors.Errorf("unknown output format %q", format)
+	}
+
+	var templateText string
+	if format == outputFormatTemplate {
+		if templatePath == "" {
+			return errors.New("a template is required for the template format")
+		}
+		b, err := os.ReadFile(templatePath)
+		if err != nil {
+			return errors.Wrap(err, "failed to read template")
+		}
+		templateText = string(b)
+	}
+
+	installerConfig, fieldsSet, err := getInstallerConfigFromYaml(yamlPath)
+	if err != nil {
+		return errors.Wrap(err, "failed to load installer yaml")
+	}
+
+	insertDefaults(installerConfig)
+
+	variables, err := convertToVariables(createMap(installerConfig), fieldsSet)
+	if err != nil {
+		return errors.Wrap(err, "failed to convert to variables")
+	}
+
+	// render before touching the output file so a failed render does not leave it truncated.
+	var buf bytes.Buffer
+	if err := writeVariables(&buf, variables, format, templateText); err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(outPath, buf.Bytes(), 0644); err != nil {
+		return errors.Wrap(err, "failed to write output file")
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as path and then renames
+// it to path, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write temporary file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close temporary file")
+	}
+
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		return errors.Wrap(err, "failed to set permissions")
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path"
+	"testing"
+
+	kurlscheme "github.com/replicatedhq/kurlkinds/client/kurlclientset/scheme"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+func Test_convertToVariables(t *testing.T) {
+	variables, err := convertToVariables(map[string]interface{}{
+		"Kubernetes.Version":              "1.27.3",
+		"Kubernetes.LoadBalancerAddress":  "10.0.0.1:6443",
+		"Kurl.AdditionalNoProxyAddresses": []string{"10.0.0.0/8", "example.com"},
+		"Rook.CephReplicaCount":           3,
+		"Kurl.IPv6":                       false,
+		"Weave.NoMasqLocal":               (*bool)(nil),
+	}, map[string]bool{
+		"Kubernetes.Version":             true,
+		"Kubernetes.LoadBalancerAddress": true,
+		"Kurl.Ipv6":                      true,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, installerVariable{
+		Name:      "KUBERNETES_VERSION",
+		Value:     "1.27.3",
+		Type:      variableTypeString,
+		Field:     "spec.kubernetes.version",
+		Set:       true,
+		SpecValue: "1.27.3",
+	}, variables["KUBERNETES_VERSION"])
+
+	assert.Equal(t, installerVariable{
+		Name:      "HA_CLUSTER",
+		Value:     "1",
+		Type:      variableTypeBoolean,
+		Field:     "spec.kubernetes.loadBalancerAddress",
+		Set:       true,
+		SpecValue: "10.0.0.1:6443",
+	}, variables["HA_CLUSTER"])
+
+	assert.Equal(t, "10.0.0.0/8,example.com", variables["ADDITIONAL_NO_PROXY_ADDRESSES"].Value)
+	assert.Equal(t, variableTypeList, variables["ADDITIONAL_NO_PROXY_ADDRESSES"].Type)
+	assert.False(t, variables["ADDITIONAL_NO_PROXY_ADDRESSES"].Set)
+
+	assert.Equal(t, "3", variables["CEPH_POOL_REPLICAS"].Value)
+	assert.Equal(t, "spec.rook.cephReplicaCount", variables["CEPH_POOL_REPLICAS"].Field)
+
+	// field names that do not title case to the go name are still reported as set
+	assert.Equal(t, "spec.kurl.ipv6", variables["IPV6_ONLY"].Field)
+	assert.True(t, variables["IPV6_ONLY"].Set)
+	assert.Equal(t, "", variables["IPV6_ONLY"].Value)
+
+	assert.Equal(t, "1", variables["NO_MASQ_LOCAL"].Value)
+	assert.False(t, variables["NO_MASQ_LOCAL"].Set)
+}
+
+func Test_writeVariables(t *testing.T) {
+	variables := map[string]installerVariable{
+		"KUBERNETES_VERSION": {Name: "KUBERNETES_VERSION", Value: "1.27.3", Type: variableTypeString, Field: "spec.kubernetes.version", Set: true, SpecValue: "1.27.3"},
+		"EVICTION_THRESHOLD": {Name: "EVICTION_THRESHOLD", Value: `memory.available<100Mi "x"`, Type: variableTypeString, Field: "spec.kubernetes.evictionThresholdResources"},
+		"AIRGAP":             {Name: "AIRGAP", Value: "", Type: variableTypeBoolean, Field: "spec.kurl.airgap", SpecValue: false},
+	}
+
+	tests := []struct {
+		name     string
+		format   string
+		template string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:   "env",
+			format: outputFormatEnv,
+			want: `AIRGAP=
+EVICTION_THRESHOLD="memory.available<100Mi \"x\""
+KUBERNETES_VERSION=1.27.3
+`,
+		},
+		{
+			name:     "template",
+			format:   outputFormatTemplate,
+			template: `version: {{ .KUBERNETES_VERSION.Value }} from {{ .KUBERNETES_VERSION.Field }}{{ if not .AIRGAP.Set }} (airgap default){{ end }}`,
+			want:     "version: 1.27.3 from spec.kubernetes.version (airgap default)",
+		},
+		{
+			name:     "template with an unknown variable",
+			format:   outputFormatTemplate,
+			template: `{{ .NOT_A_VARIABLE.Value }}`,
+			wantErr:  `failed to render template: template: variables:1:18: executing "variables" at <.NOT_A_VARIABLE.Value>: map has no entry for key "NOT_A_VARIABLE"`,
+		},
+		{
+			name:    "unknown format",
+			format:  "yaml",
+			wantErr: `unknown output format "yaml"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := writeVariables(&buf, variables, tt.format, tt.template)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+}
+
+func Test_exportVariablesFromYaml(t *testing.T) {
+	utilruntime.Must(kurlscheme.AddToScheme(scheme.Scheme))
+
+	yamlPath := path.Join("testdata", "yaml", "weave_no_masq_local_set.yaml")
+	outPath := path.Join(t.TempDir(), "variables.json")
+	require.NoError(t, exportVariablesFromYaml(yamlPath, outPath, outputFormatJSON, ""))
+
+	b, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	variables := map[string]installerVariable{}
+	require.NoError(t, json.Unmarshal(b, &variables))
+
+	assert.Equal(t, installerVariable{
+		Name:      "NO_MASQ_LOCAL",
+		Value:     "1",
+		Type:      variableTypeBoolean,
+		Field:     "spec.weave.noMasqLocal",
+		Set:       true,
+		SpecValue: true,
+	}, variables["NO_MASQ_LOCAL"])
+	assert.Equal(t, "kubernetes", variables["KUBERNETES_CLUSTER_NAME"].Value)
+	assert.False(t, variables["KUBERNETES_CLUSTER_NAME"].Set)
+
+	err = exportVariablesFromYaml(yamlPath, outPath, outputFormatTemplate, "")
+	assert.EqualError(t, err, "a template is required for the template format")
+
+	// a template that fails to render keeps the previous output.
+	templatePath := path.Join(t.TempDir(), "variables.tmpl")
+	require.NoError(t, os.WriteFile(templatePath, []byte(`{{ .UNKNOWN.Value.Field }}`), 0644))
+	err = exportVariablesFromYaml(yamlPath, outPath, outputFormatTemplate, templatePath)
+	assert.Error(t, err)
+
+	after, err := os.ReadFile(outPath)
+	require.NoError(t, err)
+	assert.Equal(t, b, after)
+}
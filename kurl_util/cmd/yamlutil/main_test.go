@@ -53,7 +53,7 @@ func Test_jsonField(t *testing.T) {
 				}
 				return nil
 			}
-			got, err := jsonField(testReader, tt.filePath, tt.jsonPath)
+			got, err := jsonField(testReader, tt.filePath, tt.jsonPath, "")
 			req.Equal(tt.want, got)
 			if tt.wantErr {
 				req.Error(err)
@@ -64,7 +64,7 @@ func Test_jsonField(t *testing.T) {
 	}
 }
 
-func Test_addFieldToContent(t *testing.T) {
+func Test_addField(t *testing.T) {
 	tests := []struct {
 		name        string
 		yamlContent string
@@ -114,6 +114,22 @@ kind: HostPreflight
 kind: HostPreflight
 metadata:
   namespace: longhorn
+`,
+		},
+		{
+			name: "keeps comments and key order",
+			yamlContent: `systemPackages:
+  collectorName: "Host OS Packages" # packages required by the add-ons
+  ubuntu: []
+  centos: []
+`,
+			yamlPath: "systemPackages_ubuntu[]",
+			value:    `open-iscsi`,
+			want: `systemPackages:
+  collectorName: "Host OS Packages" # packages required by the add-ons
+  ubuntu:
+    - open-iscsi
+  centos: []
 `,
 		},
 		{
@@ -210,16 +226,20 @@ kind: HostPreflight
 metadata:
   name: kurl-builtin
 spec:
+  collectors:
+    - diskUsage:
+        collectorName: "Ephemeral Disk Usage /opt/replicated/rook"
+        path: /opt/replicated/rook
   analyzers:
     - blockDevices:
         includeUnmountedPartitions: true
         outcomes:
           - pass:
-              message: One available block device
               when: '{{kurl if (and .Installer.Spec.Rook.Version .Installer.Spec.Rook.BlockDeviceFilter) }}{{kurl .Installer.Spec.Rook.BlockDeviceFilter }}{{kurl else }}.*{{kurl end }} == 1'
+              message: One available block device
           - pass:
-              message: Multiple available block devices
               when: '{{kurl if (and .Installer.Spec.Rook.Version .Installer.Spec.Rook.BlockDeviceFilter) }}{{kurl .Installer.Spec.Rook.BlockDeviceFilter }}{{kurl else }}.*{{kurl end }} > 1'
+              message: Multiple available block devices
           - fail:
               message: No available block devices
     - systemPackages:
@@ -230,24 +250,20 @@ spec:
               when: '{{ not .IsInstalled }}'
           - pass:
               message: Package {{ .Name }} is installed.
-  collectors:
-    - diskUsage:
-        collectorName: Ephemeral Disk Usage /opt/replicated/rook
-        path: /opt/replicated/rook
 `,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req := require.New(t)
-			got, err := addFieldToContent([]byte(tt.yamlContent), tt.yamlPath, tt.value)
+			got, err := addField([]byte(tt.yamlContent), tt.yamlPath, tt.value, "")
 			req.NoError(err)
 			req.Equal(tt.want, got)
 		})
 	}
 }
 
-func Test_removeFieldFromContent(t *testing.T) {
+func Test_removeField(t *testing.T) {
 	tests := []struct {
 		name        string
 		yamlContent string
@@ -370,13 +386,45 @@ metadata: {}
 apiVersion: v1
 kind: ConfigMap
 metadata: {}
+`,
+		},
+		{
+			name: "kubeadm config metadata",
+			yamlContent: `apiVersion: kubeadm.k8s.io/v1beta3
+kind: InitConfiguration
+metadata:
+  name: kubeadm-init-configuration
+bootstrapTokens:
+  - token: abcdef.0123456789abcdef
+    ttl: 24h0m0s # tokens expire after a day
+---
+apiVersion: kubeadm.k8s.io/v1beta3
+kind: ClusterConfiguration
+metadata:
+  name: kubeadm-cluster-configuration
+kubernetesVersion: v1.29.3
+networking:
+  podSubnet: 10.32.0.0/20
+`,
+			yamlPath: "metadata",
+			want: `apiVersion: kubeadm.k8s.io/v1beta3
+kind: InitConfiguration
+bootstrapTokens:
+  - token: abcdef.0123456789abcdef
+    ttl: 24h0m0s # tokens expire after a day
+---
+apiVersion: kubeadm.k8s.io/v1beta3
+kind: ClusterConfiguration
+kubernetesVersion: v1.29.3
+networking:
+  podSubnet: 10.32.0.0/20
 `,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req := require.New(t)
-			got, err := removeFieldFromContent([]byte(tt.yamlContent), tt.yamlPath)
+			got, err := removeField([]byte(tt.yamlContent), tt.yamlPath, "")
 			req.Equal(tt.want, got)
 			req.NoError(err)
 		})
@@ -0,0 +1,616 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+type segmentKind int
+
+const (
+	// segmentKey selects a key in a mapping, e.g. spec or ["app.kubernetes.io/name"].
+	segmentKey segmentKind = iota
+	// segmentIndex selects an element of a sequence by its position, e.g. [0].
+	segmentIndex
+	// segmentSelector selects the elements of a sequence whose field matches a value, e.g. [name=foo].
+	segmentSelector
+	// segmentAppend appends to a sequence, e.g. []. only allowed as the last segment.
+	segmentAppend
+)
+
+// pathSegment is one of the elements of a path expression.
+type pathSegment struct {
+	kind  segmentKind
+	key   string
+	index int
+	value string
+}
+
+func (s pathSegment) String() string {
+	switch s.kind {
+	case segmentIndex:
+		return fmt.Sprintf("[%d]", s.index)
+	case segmentSelector:
+		return fmt.Sprintf("[%s=%s]", s.key, s.value)
+	case segmentAppend:
+		return "[]"
+	default:
+		return s.key
+	}
+}
+
+// isLegacyPath returns true for the paths delineated by '_' that were the only supported paths
+// before path expressions (e.g. spec_collectors[]).
+func isLegacyPath(path string) bool {
+	path = strings.TrimSuffix(path, "[]")
+	return strings.Contains(path, "_") && !strings.ContainsAny(path, `.[]="'`)
+}
+
+// legacyPath translates a legacy path into a path expression (e.g. spec_collectors[] into
+// spec.collectors[]). other paths are returned unchanged.
+func legacyPath(path string) string {
+	if !isLegacyPath(path) {
+		return path
+	}
+	return strings.ReplaceAll(path, "_", ".")
+}
+
+// parsePath parses a path expression such as spec.collectors[name=foo].exclude. keys are
+// separated by '.' and can be quoted inside brackets when they contain special characters (e.g.
+// metadata.labels["app.kubernetes.io/name"]). sequence elements are selected by index ([0]) or by
+// the value of one of their fields ([name=foo]), and [] at the end of the path appends to a
+// sequence.
+func parsePath(expr string) ([]pathSegment, error) {
+	if expr == "" {
+		return nil, errors.New("empty path")
+	}
+
+	var segments []pathSegment
+	for i := 0; i < len(expr); {
+		switch expr[i] {
+		case '.':
+			if i == 0 || i == len(expr)-1 || expr[i+1] == '.' || expr[i+1] == '[' {
+				return nil, errors.Errorf("invalid path %q: unexpected '.' at position %d", expr, i)
+			}
+			i++
+
+		case '[':
+			end := closingBracket(expr, i)
+			if end < 0 {
+				return nil, errors.Errorf("invalid path %q: unterminated '['", expr)
+			}
+			segment, err := parseBracket(expr[i+1 : end])
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid path %q", expr)
+			}
+			if segment.kind == segmentAppend && end != len(expr)-1 {
+				return nil, errors.Errorf("invalid path %q: [] is only allowed at the end", expr)
+			}
+			segments = append(segments, segment)
+			i = end + 1
+			if i < len(expr) && expr[i] != '.' && expr[i] != '[' {
+				return nil, errors.Errorf("invalid path %q: expected '.' or '[' at position %d", expr, i)
+			}
+
+		default:
+			end := strings.IndexAny(expr[i:], ".[")
+			if end < 0 {
+				end = len(expr)
+			} else {
+				end += i
+			}
+			segments = append(segments, pathSegment{kind: segmentKey, key: expr[i:end]})
+			i = end
+		}
+	}
+	return segments, nil
+}
+
+// closingBracket returns the position of the ']' closing the '[' at start, ignoring brackets
+// inside quotes.
+func closingBracket(expr string, start int) int {
+	var quote byte
+	for i := start + 1; i < len(expr); i++ {
+		switch {
+		case quote != 0 && expr[i] == quote:
+			quote = 0
+		case quote != 0:
+		case expr[i] == '"' || expr[i] == '\'':
+			quote = expr[i]
+		case expr[i] == ']':
+			return i
+		}
+	}
+	return -1
+}
+
+func parseBracket(content string) (pathSegment, error) {
+	if content == "" {
+		return pathSegment{kind: segmentAppend}, nil
+	}
+
+	if key, ok := unquote(content); ok {
+		return pathSegment{kind: segmentKey, key: key}, nil
+	}
+
+	if key, value, ok := strings.Cut(content, "="); ok {
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+		if unquoted, ok := unquote(value); ok {
+			value = unquoted
+		}
+		if key == "" {
+			return pathSegment{}, errors.Errorf("invalid selector [%s]", content)
+		}
+		return pathSegment{kind: segmentSelector, key: key, value: value}, nil
+	}
+
+	index, err := strconv.Atoi(content)
+	if err != nil || index < 0 {
+		return pathSegment{}, errors.Errorf("invalid index [%s]", content)
+	}
+	return pathSegment{kind: segmentIndex, index: index}, nil
+}
+
+func unquote(s string) (string, bool) {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1], true
+	}
+	return "", false
+}
+
+// decodeDocuments parses all documents in a (multi document) yaml keeping comments and key
+// order. empty documents are skipped.
+func decodeDocuments(content []byte) ([]*yamlv3.Node, error) {
+	var documents []*yamlv3.Node
+	decoder := yamlv3.NewDecoder(bytes.NewReader(content))
+	for {
+		var document yamlv3.Node
+		if err := decoder.Decode(&document); err != nil {
+			if err == io.EOF {
+				return documents, nil
+			}
+			return nil, errors.Wrap(err, "failed to decode yaml")
+		}
+		if len(document.Content) == 0 || document.Content[0].Tag == "!!null" {
+			continue
+		}
+		documents = append(documents, &document)
+	}
+}
+
+// encodeDocuments writes the documents back as a multi document yaml.
+func encodeDocuments(documents []*yamlv3.Node) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := yamlv3.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	for _, document := range documents {
+		if err := encoder.Encode(document); err != nil {
+			return nil, errors.Wrap(err, "failed to encode yaml")
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, errors.Wrap(err, "failed to encode yaml")
+	}
+	return buf.Bytes(), nil
+}
+
+// documentSelector restricts an operation to the documents where the field at path has value,
+// e.g. kind=Deployment.
+type documentSelector struct {
+	path  []pathSegment
+	value string
+}
+
+func parseDocumentSelector(expr string) (*documentSelector, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	path, value, ok := strings.Cut(expr, "=")
+	if !ok {
+		return nil, errors.Errorf("invalid document selector %q, expected path=value", expr)
+	}
+	segments, err := parsePath(path)
+	if err != nil {
+		return nil, err
+	}
+	return &documentSelector{path: segments, value: value}, nil
+}
+
+func (s *documentSelector) matches(document *yamlv3.Node) bool {
+	if s == nil {
+		return true
+	}
+	nodes, err := lookupNodes(root(document), s.path)
+	if err != nil {
+		return false
+	}
+	for _, node := range nodes {
+		if node.Kind == yamlv3.ScalarNode && node.Value == s.value {
+			return true
+		}
+	}
+	return false
+}
+
+func root(document *yamlv3.Node) *yamlv3.Node {
+	if document.Kind == yamlv3.DocumentNode && len(document.Content) > 0 {
+		return document.Content[0]
+	}
+	return document
+}
+
+// lookupNodes returns all nodes matching the path. an empty result means the path does not
+// exist.
+func lookupNodes(node *yamlv3.Node, path []pathSegment) ([]*yamlv3.Node, error) {
+	nodes := []*yamlv3.Node{node}
+	for i, segment := range path {
+		var next []*yamlv3.Node
+		for _, current := range nodes {
+			children, err := children(current, segment, pathString(path[:i+1]))
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, children...)
+		}
+		nodes = next
+	}
+	return nodes, nil
+}
+
+// children returns the nodes selected by segment in node.
+func children(node *yamlv3.Node, segment pathSegment, path string) ([]*yamlv3.Node, error) {
+	switch segment.kind {
+	case segmentKey:
+		if isNull(node) {
+			return nil, nil
+		}
+		if node.Kind != yamlv3.MappingNode {
+			return nil, errors.Errorf("%s: parent is not a mapping", path)
+		}
+		if value := mappingValue(node, segment.key); value != nil {
+			return []*yamlv3.Node{value}, nil
+		}
+		return nil, nil
+
+	case segmentIndex:
+		if isNull(node) {
+			return nil, nil
+		}
+		if node.Kind != yamlv3.SequenceNode {
+			return nil, errors.Errorf("%s: parent is not a sequence", path)
+		}
+		if segment.index < len(node.Content) {
+			return []*yamlv3.Node{node.Content[segment.index]}, nil
+		}
+		return nil, nil
+
+	case segmentSelector:
+		if isNull(node) {
+			return nil, nil
+		}
+		if node.Kind != yamlv3.SequenceNode {
+			return nil, errors.Errorf("%s: parent is not a sequence", path)
+		}
+		var result []*yamlv3.Node
+		for _, item := range node.Content {
+			if item.Kind != yamlv3.MappingNode {
+				continue
+			}
+			if value := mappingValue(item, segment.key); value != nil && value.Kind == yamlv3.ScalarNode && value.Value == segment.value {
+				result = append(result, item)
+			}
+		}
+		return result, nil
+
+	default:
+		return nil, errors.Errorf("%s: [] can only be used to add values", path)
+	}
+}
+
+// setPath sets the value at path, creating missing mappings along the way. when the path ends
+// with [] the value is appended to the sequence, which is created if missing. selectors must
+// match at least one element and indexes must exist.
+func setPath(node *yamlv3.Node, path []pathSegment, value *yamlv3.Node) error {
+	parents := []*yamlv3.Node{node}
+	for i, segment := range path[:len(path)-1] {
+		var next []*yamlv3.Node
+		for _, parent := range parents {
+			child, err := ensureChild(parent, segment, path[i+1], pathString(path[:i+1]))
+			if err != nil {
+				return err
+			}
+			next = append(next, child...)
+		}
+		parents = next
+	}
+
+	last := path[len(path)-1]
+	for _, parent := range parents {
+		if err := setChild(parent, last, value, pathString(path)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ensureChild returns the nodes selected by segment, creating a mapping or a sequence (depending
+// on the next segment) when a key does not exist.
+func ensureChild(node *yamlv3.Node, segment, next pathSegment, path string) ([]*yamlv3.Node, error) {
+	if segment.kind == segmentKey {
+		if isNull(node) {
+			*node = yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+		}
+		if node.Kind != yamlv3.MappingNode {
+			return nil, errors.Errorf("%s: parent is not a mapping", path)
+		}
+		if value := mappingValue(node, segment.key); value != nil {
+			return []*yamlv3.Node{value}, nil
+		}
+		child := &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+		if next.kind != segmentKey {
+			child = &yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq"}
+		}
+		node.Content = append(node.Content, keyNode(segment.key), child)
+		return []*yamlv3.Node{child}, nil
+	}
+
+	nodes, err := children(node, segment, path)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, errors.Errorf("%s: not found", path)
+	}
+	return nodes, nil
+}
+
+func setChild(node *yamlv3.Node, segment pathSegment, value *yamlv3.Node, path string) error {
+	switch segment.kind {
+	case segmentKey:
+		if isNull(node) {
+			*node = yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+		}
+		if node.Kind != yamlv3.MappingNode {
+			return errors.Errorf("%s: parent is not a mapping", path)
+		}
+		for i := 0; i < len(node.Content)-1; i += 2 {
+			if node.Content[i].Value == segment.key {
+				node.Content[i+1] = copyNode(value)
+				return nil
+			}
+		}
+		node.Content = append(node.Content, keyNode(segment.key), copyNode(value))
+		return nil
+
+	case segmentAppend:
+		if isNull(node) {
+			*node = yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq"}
+		}
+		if node.Kind != yamlv3.SequenceNode {
+			return errors.Errorf("%s: parent is not a sequence", path)
+		}
+		// flow style sequences (e.g. []) are converted to block style once they have elements
+		node.Style &^= yamlv3.FlowStyle
+		node.Content = append(node.Content, copyNode(value))
+		return nil
+
+	case segmentIndex:
+		if node.Kind != yamlv3.SequenceNode {
+			return errors.Errorf("%s: parent is not a sequence", path)
+		}
+		if segment.index >= len(node.Content) {
+			return errors.Errorf("%s: index out of range", path)
+		}
+		node.Content[segment.index] = copyNode(value)
+		return nil
+
+	default:
+		if node.Kind != yamlv3.SequenceNode {
+			return errors.Errorf("%s: parent is not a sequence", path)
+		}
+		found := false
+		for i, item := range node.Content {
+			if item.Kind != yamlv3.MappingNode {
+				continue
+			}
+			if field := mappingValue(item, segment.key); field != nil && field.Value == segment.value {
+				node.Content[i] = copyNode(value)
+				found = true
+			}
+		}
+		if !found {
+			return errors.Errorf("%s: not found", path)
+		}
+		return nil
+	}
+}
+
+// deletePath removes the nodes at path. paths that do not exist are ignored.
+func deletePath(node *yamlv3.Node, path []pathSegment) error {
+	parents, err := lookupNodes(node, path[:len(path)-1])
+	if err != nil {
+		return err
+	}
+
+	last := path[len(path)-1]
+	for _, parent := range parents {
+		switch last.kind {
+		case segmentKey:
+			if parent.Kind != yamlv3.MappingNode {
+				continue
+			}
+			for i := 0; i < len(parent.Content)-1; i += 2 {
+				if parent.Content[i].Value == last.key {
+					parent.Content = append(parent.Content[:i], parent.Content[i+2:]...)
+					break
+				}
+			}
+			if len(parent.Content) == 0 {
+				parent.Style |= yamlv3.FlowStyle
+			}
+
+		case segmentIndex, segmentSelector:
+			if parent.Kind != yamlv3.SequenceNode {
+				continue
+			}
+			var content []*yamlv3.Node
+			for i, item := range parent.Content {
+				if last.kind == segmentIndex && i == last.index {
+					continue
+				}
+				if last.kind == segmentSelector && item.Kind == yamlv3.MappingNode {
+					if field := mappingValue(item, last.key); field != nil && field.Value == last.value {
+						continue
+					}
+				}
+				content = append(content, item)
+			}
+			parent.Content = content
+			if len(parent.Content) == 0 {
+				parent.Style |= yamlv3.FlowStyle
+			}
+
+		default:
+			return errors.Errorf("%s: [] can only be used to add values", pathString(path))
+		}
+	}
+	return nil
+}
+
+func mappingValue(node *yamlv3.Node, key string) *yamlv3.Node {
+	for i := 0; i < len(node.Content)-1; i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func keyNode(key string) *yamlv3.Node {
+	return &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: key}
+}
+
+func isNull(node *yamlv3.Node) bool {
+	return node.Kind == 0 || (node.Kind == yamlv3.ScalarNode && node.Tag == "!!null")
+}
+
+func copyNode(node *yamlv3.Node) *yamlv3.Node {
+	c := *node
+	c.Content = make([]*yamlv3.Node, len(node.Content))
+	for i, child := range node.Content {
+		c.Content[i] = copyNode(child)
+	}
+	return &c
+}
+
+func pathString(path []pathSegment) string {
+	var b strings.Builder
+	for i, segment := range path {
+		if i > 0 && segment.kind == segmentKey {
+			b.WriteString(".")
+		}
+		b.WriteString(segment.String())
+	}
+	return b.String()
+}
+
+// parseValue parses a value provided in the command line as yaml.
+func parseValue(value string) (*yamlv3.Node, error) {
+	var document yamlv3.Node
+	if err := yamlv3.Unmarshal([]byte(value), &document); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal value")
+	}
+	if len(document.Content) == 0 {
+		return &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!null", Value: "null"}, nil
+	}
+	node := document.Content[0]
+	// the value is moved into another document, drop its document level comments
+	node.HeadComment = ""
+	node.FootComment = ""
+	return node, nil
+}
+
+// setPathInContent sets the value at path in all the documents matching selector.
+func setPathInContent(content []byte, expr, value, selector string) (string, error) {
+	return modifyContent(content, expr, selector, func(document *yamlv3.Node, path []pathSegment) error {
+		node, err := parseValue(value)
+		if err != nil {
+			return err
+		}
+		return setPath(root(document), path, node)
+	})
+}
+
+// deletePathInContent removes the value at path from all the documents matching selector.
+func deletePathInContent(content []byte, expr, selector string) (string, error) {
+	return modifyContent(content, expr, selector, func(document *yamlv3.Node, path []pathSegment) error {
+		return deletePath(root(document), path)
+	})
+}
+
+func modifyContent(content []byte, expr, selectorExpr string, modify func(*yamlv3.Node, []pathSegment) error) (string, error) {
+	path, err := parsePath(expr)
+	if err != nil {
+		return "", err
+	}
+	selector, err := parseDocumentSelector(selectorExpr)
+	if err != nil {
+		return "", err
+	}
+
+	documents, err := decodeDocuments(content)
+	if err != nil {
+		return "", err
+	}
+	for _, document := range documents {
+		if !selector.matches(document) {
+			continue
+		}
+		if err := modify(document, path); err != nil {
+			return "", err
+		}
+	}
+
+	result, err := encodeDocuments(documents)
+	if err != nil {
+		return "", err
+	}
+	return string(result), nil
+}
+
+// getPathFromContent returns the first node matching path in the documents matching selector.
+func getPathFromContent(content []byte, expr, selectorExpr string) (*yamlv3.Node, error) {
+	path, err := parsePath(expr)
+	if err != nil {
+		return nil, err
+	}
+	selector, err := parseDocumentSelector(selectorExpr)
+	if err != nil {
+		return nil, err
+	}
+
+	documents, err := decodeDocuments(content)
+	if err != nil {
+		return nil, err
+	}
+	for _, document := range documents {
+		if !selector.matches(document) {
+			continue
+		}
+		nodes, err := lookupNodes(root(document), path)
+		if err != nil {
+			return nil, err
+		}
+		if len(nodes) > 0 {
+			return nodes[0], nil
+		}
+	}
+	return nil, errors.Errorf("field %s is not present", expr)
+}
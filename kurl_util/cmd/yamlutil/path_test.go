@@ -0,0 +1,381 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parsePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    []pathSegment
+		wantErr string
+	}{
+		{
+			name: "keys",
+			expr: "spec.template.metadata",
+			want: []pathSegment{{kind: segmentKey, key: "spec"}, {kind: segmentKey, key: "template"}, {kind: segmentKey, key: "metadata"}},
+		},
+		{
+			name: "selector index and append",
+			expr: "spec.collectors[name=foo].exclude[0].items[]",
+			want: []pathSegment{
+				{kind: segmentKey, key: "spec"},
+				{kind: segmentKey, key: "collectors"},
+				{kind: segmentSelector, key: "name", value: "foo"},
+				{kind: segmentKey, key: "exclude"},
+				{kind: segmentIndex, index: 0},
+				{kind: segmentKey, key: "items"},
+				{kind: segmentAppend},
+			},
+		},
+		{
+			name: "quoted key and selector value",
+			expr: `metadata.labels["app.kubernetes.io/name"].x[image='a/b:1.0']`,
+			want: []pathSegment{
+				{kind: segmentKey, key: "metadata"},
+				{kind: segmentKey, key: "labels"},
+				{kind: segmentKey, key: "app.kubernetes.io/name"},
+				{kind: segmentKey, key: "x"},
+				{kind: segmentSelector, key: "image", value: "a/b:1.0"},
+			},
+		},
+		{
+			name:    "append in the middle",
+			expr:    "spec.collectors[].name",
+			wantErr: `invalid path "spec.collectors[].name": [] is only allowed at the end`,
+		},
+		{
+			name:    "unterminated bracket",
+			expr:    "spec.collectors[0",
+			wantErr: `invalid path "spec.collectors[0": unterminated '['`,
+		},
+		{
+			name:    "empty key",
+			expr:    "spec..collectors",
+			wantErr: `invalid path "spec..collectors": unexpected '.' at position 4`,
+		},
+		{
+			name:    "invalid index",
+			expr:    "spec[-1]",
+			wantErr: `invalid path "spec[-1]": invalid index [-1]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parsePath(tt.expr)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+const preflightYaml = `# kurl builtin host preflights
+apiVersion: troubleshoot.sh/v1beta2
+kind: HostPreflight
+metadata:
+  name: kurl-builtin
+spec:
+  collectors:
+    # disk usage of the rook directory
+    - diskUsage:
+        collectorName: "Ephemeral Disk Usage /opt/replicated/rook"
+        path: /opt/replicated/rook
+    - tcpPortStatus:
+        collectorName: kubelet
+        port: 10250
+  analyzers: []
+`
+
+func Test_setPathInContent(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		path     string
+		value    string
+		selector string
+		want     string
+		wantErr  string
+	}{
+		{
+			name:    "selector does not match",
+			content: preflightYaml,
+			path:    "spec.collectors[collectorName=kubelet].exclude",
+			value:   "true",
+			wantErr: "spec.collectors[collectorName=kubelet]: not found",
+		},
+		{
+			name:    "keeps comments, quotes and key order",
+			content: preflightYaml,
+			path:    "spec.collectors[1].tcpPortStatus.exclude",
+			value:   "true",
+			want: `# kurl builtin host preflights
+apiVersion: troubleshoot.sh/v1beta2
+kind: HostPreflight
+metadata:
+  name: kurl-builtin
+spec:
+  collectors:
+    # disk usage of the rook directory
+    - diskUsage:
+        collectorName: "Ephemeral Disk Usage /opt/replicated/rook"
+        path: /opt/replicated/rook
+    - tcpPortStatus:
+        collectorName: kubelet
+        port: 10250
+        exclude: true
+  analyzers: []
+`,
+		},
+		{
+			name:    "append to flow sequence",
+			content: preflightYaml,
+			path:    "spec.analyzers[]",
+			value:   "diskUsage:\n  checkName: rook",
+			want: `# kurl builtin host preflights
+apiVersion: troubleshoot.sh/v1beta2
+kind: HostPreflight
+metadata:
+  name: kurl-builtin
+spec:
+  collectors:
+    # disk usage of the rook directory
+    - diskUsage:
+        collectorName: "Ephemeral Disk Usage /opt/replicated/rook"
+        path: /opt/replicated/rook
+    - tcpPortStatus:
+        collectorName: kubelet
+        port: 10250
+  analyzers:
+    - diskUsage:
+        checkName: rook
+`,
+		},
+		{
+			name: "creates missing parents",
+			content: `apiVersion: v1
+kind: ConfigMap
+`,
+			path:  `metadata.labels["app.kubernetes.io/name"]`,
+			value: "kurl",
+			want: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  labels:
+    app.kubernetes.io/name: kurl
+`,
+		},
+		{
+			name: "multiple documents with a selector",
+			content: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: kotsadm
+spec:
+  template:
+    spec:
+      containers:
+        - name: kotsadm
+          image: kotsadm/kotsadm:1.0.0
+        - name: sidecar
+          image: busybox
+---
+# the service
+apiVersion: v1
+kind: Service
+metadata:
+  name: kotsadm
+---
+`,
+			path:     "spec.template.spec.containers[name=kotsadm].image",
+			value:    "registry.local/kotsadm:1.0.0",
+			selector: "kind=Deployment",
+			want: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: kotsadm
+spec:
+  template:
+    spec:
+      containers:
+        - name: kotsadm
+          image: registry.local/kotsadm:1.0.0
+        - name: sidecar
+          image: busybox
+---
+# the service
+apiVersion: v1
+kind: Service
+metadata:
+  name: kotsadm
+`,
+		},
+		{
+			name: "values containing document separators",
+			content: `apiVersion: v1
+kind: ConfigMap
+data:
+  script: |
+    echo ---
+`,
+			path:  "data.other",
+			value: "value",
+			want: `apiVersion: v1
+kind: ConfigMap
+data:
+  script: |
+    echo ---
+  other: value
+`,
+		},
+		{
+			name:    "index out of range",
+			content: preflightYaml,
+			path:    "spec.collectors[5]",
+			value:   "{}",
+			wantErr: "spec.collectors[5]: index out of range",
+		},
+		{
+			name:    "key on a sequence",
+			content: preflightYaml,
+			path:    "spec.collectors.name",
+			value:   "foo",
+			wantErr: "spec.collectors.name: parent is not a mapping",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := setPathInContent([]byte(tt.content), tt.path, tt.value, tt.selector)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_deletePathInContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		path    string
+		want    string
+	}{
+		{
+			name: "remove sequence elements by selector",
+			content: `containers:
+  - name: kotsadm
+  - name: sidecar
+  - name: kotsadm
+`,
+			path: "containers[name=kotsadm]",
+			want: `containers:
+  - name: sidecar
+`,
+		},
+		{
+			name:    "remove sequence element by index",
+			content: preflightYaml,
+			path:    "spec.collectors[0]",
+			want: `# kurl builtin host preflights
+apiVersion: troubleshoot.sh/v1beta2
+kind: HostPreflight
+metadata:
+  name: kurl-builtin
+spec:
+  collectors:
+    - tcpPortStatus:
+        collectorName: kubelet
+        port: 10250
+  analyzers: []
+`,
+		},
+		{
+			name:    "remove nested key",
+			content: preflightYaml,
+			path:    "spec.collectors[1].tcpPortStatus.port",
+			want: `# kurl builtin host preflights
+apiVersion: troubleshoot.sh/v1beta2
+kind: HostPreflight
+metadata:
+  name: kurl-builtin
+spec:
+  collectors:
+    # disk usage of the rook directory
+    - diskUsage:
+        collectorName: "Ephemeral Disk Usage /opt/replicated/rook"
+        path: /opt/replicated/rook
+    - tcpPortStatus:
+        collectorName: kubelet
+  analyzers: []
+`,
+		},
+		{
+			name:    "missing path",
+			content: preflightYaml,
+			path:    "spec.missing.field",
+			want:    preflightYaml,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := deletePathInContent([]byte(tt.content), tt.path, "")
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_getPathFromContent(t *testing.T) {
+	content := []byte(`apiVersion: v1
+kind: Service
+metadata:
+  name: kotsadm
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: kotsadm
+spec:
+  template:
+    spec:
+      containers:
+      - name: kotsadm
+        image: kotsadm/kotsadm:1.0.0
+`)
+
+	node, err := getPathFromContent(content, "spec.template.spec.containers[name=kotsadm].image", "")
+	require.NoError(t, err)
+	assert.Equal(t, "kotsadm/kotsadm:1.0.0", node.Value)
+
+	node, err = getPathFromContent(content, "kind", "metadata.name=kotsadm")
+	require.NoError(t, err)
+	assert.Equal(t, "Service", node.Value)
+
+	_, err = getPathFromContent(content, "kind", "kind=StatefulSet")
+	assert.EqualError(t, err, "field kind is not present")
+}
+
+func Test_isLegacyPath(t *testing.T) {
+	assert.True(t, isLegacyPath("spec_collectors[]"))
+	assert.True(t, isLegacyPath("data_app-version-label"))
+	assert.False(t, isLegacyPath("metadata"))
+	assert.False(t, isLegacyPath("spec.collectors[]"))
+	assert.False(t, isLegacyPath("data.app_version"))
+}
+
+func Test_legacyPath(t *testing.T) {
+	assert.Equal(t, "spec.collectors[]", legacyPath("spec_collectors[]"))
+	assert.Equal(t, "systemPackages.centos8[]", legacyPath("systemPackages_centos8[]"))
+	assert.Equal(t, "metadata", legacyPath("metadata"))
+	assert.Equal(t, "data.app_version", legacyPath("data.app_version"))
+}
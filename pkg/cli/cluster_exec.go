@@ -0,0 +1,234 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/replicatedhq/kurl/pkg/k8sutil"
+)
+
+const clusterExecCmdExample = `
+  # Print the disk usage of the ceph monitors
+  $ kurl cluster exec -n rook-ceph -l app=rook-ceph-mon -- df -h /var/lib/ceph/mon
+
+  # Copy a script to the pods, run it and collect its log
+  $ kurl cluster exec -n default -l app=my-app --copy ./debug.sh:/tmp --collect /tmp/debug.log --collect-dir ./logs -- sh /tmp/debug.sh`
+
+// clusterExecOptions are the options of the cluster exec command.
+type clusterExecOptions struct {
+	namespace  string
+	selector   string
+	container  string
+	command    []string
+	stdin      []byte
+	copy       []string
+	collect    []string
+	collectDir string
+	output     string
+	executor   k8sutil.ExecutorFunc
+}
+
+// podExecResult is the outcome of the command in a pod.
+type podExecResult struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	ExitCode  int    `json:"exitCode"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	Error     string `json:"error,omitempty"`
+}
+
+func newClusterExecCmd(_ CLI) *cobra.Command {
+	var opts clusterExecOptions
+	var stdin bool
+	cmd := &cobra.Command{
+		Use:     "exec -l SELECTOR -- COMMAND [ARG...]",
+		Short:   "Runs a command on every running pod matching a selector and prints the output of each pod",
+		Example: clusterExecCmdExample,
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			if opts.selector == "" {
+				return fmt.Errorf("a selector is required")
+			}
+			if opts.output != "text" && opts.output != "json" {
+				return fmt.Errorf("invalid output %q, must be text or json", opts.output)
+			}
+			for _, cp := range opts.copy {
+				if !strings.Contains(cp, ":") {
+					return fmt.Errorf("invalid copy %q, must be LOCAL_PATH:POD_DIR", cp)
+				}
+			}
+			if len(opts.collect) > 0 && opts.collectDir == "" {
+				return fmt.Errorf("--collect-dir is required to collect files")
+			}
+			cmd.SilenceUsage = true
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts.command = args
+			if stdin {
+				var err error
+				if opts.stdin, err = io.ReadAll(cmd.InOrStdin()); err != nil {
+					return fmt.Errorf("failed to read stdin: %w", err)
+				}
+			}
+
+			k8sConfig, err := config.GetConfig()
+			if err != nil {
+				return fmt.Errorf("failed to get kubernetes config: %w", err)
+			}
+			kcli, err := kubernetes.NewForConfig(k8sConfig)
+			if err != nil {
+				return fmt.Errorf("failed to create kubernetes client: %w", err)
+			}
+
+			results, err := execOnPods(cmd.Context(), kcli, k8sConfig, opts)
+			if err != nil {
+				return err
+			}
+			if err := writePodExecResults(cmd.OutOrStdout(), results, opts.output); err != nil {
+				return err
+			}
+
+			var failed int
+			for _, result := range results {
+				if result.Error != "" || result.ExitCode != 0 {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("command failed on %d of %d pods", failed, len(results))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&opts.namespace, "namespace", "n", metav1.NamespaceDefault, "The namespace of the pods.")
+	cmd.Flags().StringVarP(&opts.selector, "selector", "l", "", "The label selector of the pods.")
+	cmd.Flags().StringVarP(&opts.container, "container", "c", "", "The container to run the command in, the first container of the pod if not set.")
+	cmd.Flags().BoolVarP(&stdin, "stdin", "i", false, "Pass the standard input to the command in every pod.")
+	cmd.Flags().StringArrayVar(&opts.copy, "copy", nil, "Copy a local file or directory to a pod directory before running the command, as LOCAL_PATH:POD_DIR.")
+	cmd.Flags().StringArrayVar(&opts.collect, "collect", nil, "Copy a file or directory from each pod once the command has run.")
+	cmd.Flags().StringVar(&opts.collectDir, "collect-dir", "", "The local directory the collected files are copied to, in a sub directory per pod.")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "text", "The output format (text or json).")
+	return cmd
+}
+
+// execOnPods runs the command in the running pods matching the selector, one pod after the other. a failure in a pod
+// is recorded in its result and does not stop the others.
+func execOnPods(ctx context.Context, kcli kubernetes.Interface, k8sConfig *rest.Config, opts clusterExecOptions) ([]podExecResult, error) {
+	pods, err := k8sutil.ListPodsBySelector(ctx, kcli, opts.namespace, opts.selector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pods: %w", err)
+	}
+
+	var results []podExecResult
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || len(pod.Spec.Containers) == 0 {
+			continue
+		}
+		container := opts.container
+		if container == "" {
+			container = pod.Spec.Containers[0].Name
+		}
+		result := podExecResult{Pod: pod.Name, Container: container}
+
+		var stdout, stderr bytes.Buffer
+		execOpts := k8sutil.ExecOptions{
+			CoreClient: kcli.CoreV1(),
+			Config:     k8sConfig,
+			Executor:   opts.executor,
+			StreamOptions: k8sutil.StreamOptions{
+				Namespace:     pod.Namespace,
+				PodName:       pod.Name,
+				ContainerName: container,
+			},
+		}
+		if err := execOnPod(ctx, execOpts, opts, &result, &stdout, &stderr); err != nil {
+			result.Error = err.Error()
+		}
+		result.Stdout, result.Stderr = stdout.String(), stderr.String()
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// execOnPod copies the files to the pod, runs the command and collects the files from the pod.
+func execOnPod(ctx context.Context, execOpts k8sutil.ExecOptions, opts clusterExecOptions, result *podExecResult, stdout, stderr io.Writer) error {
+	for _, cp := range opts.copy {
+		local, podDir, _ := strings.Cut(cp, ":")
+		if err := k8sutil.CopyToPod(ctx, execOpts, local, podDir); err != nil {
+			return err
+		}
+	}
+
+	commandOpts := execOpts
+	commandOpts.Command = opts.command
+	commandOpts.Out, commandOpts.Err = stdout, stderr
+	if opts.stdin != nil {
+		commandOpts.In = bytes.NewReader(opts.stdin)
+	}
+	exitCode, err := k8sutil.ExecStream(ctx, commandOpts)
+	if err != nil {
+		return fmt.Errorf("failed to run command: %w", err)
+	}
+	result.ExitCode = exitCode
+
+	for _, collect := range opts.collect {
+		dest := filepath.Join(opts.collectDir, execOpts.PodName)
+		if err := os.MkdirAll(dest, 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", dest, err)
+		}
+		if err := k8sutil.CopyFromPod(ctx, execOpts, collect, dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writePodExecResults(w io.Writer, results []podExecResult, output string) error {
+	if output == "json" {
+		if results == nil {
+			results = []podExecResult{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			return fmt.Errorf("failed to encode results: %w", err)
+		}
+		return nil
+	}
+
+	if len(results) == 0 {
+		fmt.Fprintln(w, "No running pods found.")
+		return nil
+	}
+	for _, result := range results {
+		fmt.Fprintf(w, "==> %s/%s (exit code %d) <==\n", result.Pod, result.Container, result.ExitCode)
+		for _, out := range []string{result.Stdout, result.Stderr} {
+			if out == "" {
+				continue
+			}
+			fmt.Fprint(w, out)
+			if !strings.HasSuffix(out, "\n") {
+				fmt.Fprintln(w)
+			}
+		}
+		if result.Error != "" {
+			fmt.Fprintf(w, "Error: %s\n", result.Error)
+		}
+	}
+	return nil
+}
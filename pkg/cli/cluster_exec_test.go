@@ -0,0 +1,80 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+
+	"github.com/replicatedhq/kurl/pkg/k8sutil"
+)
+
+// echoExecutor writes the pod name and the stdin to stdout, the command fails in pods named "*-fail".
+type echoExecutor struct {
+	opts k8sutil.ExecOptions
+}
+
+func (e echoExecutor) Stream(options remotecommand.StreamOptions) error {
+	return e.StreamWithContext(context.Background(), options)
+}
+
+func (e echoExecutor) StreamWithContext(_ context.Context, options remotecommand.StreamOptions) error {
+	fmt.Fprintf(options.Stdout, "%s %s: ", e.opts.PodName, strings.Join(e.opts.Command, " "))
+	if options.Stdin != nil {
+		_, _ = io.Copy(options.Stdout, options.Stdin)
+	}
+	if strings.HasSuffix(e.opts.PodName, "-fail") {
+		fmt.Fprint(options.Stderr, "failed")
+		return exec.CodeExitError{Err: fmt.Errorf("command terminated with exit code 1"), Code: 1}
+	}
+	return nil
+}
+
+func Test_execOnPods(t *testing.T) {
+	pod := func(name string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "rook-ceph", Labels: map[string]string{"app": "rook-ceph-mon"}},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "mon"}, {Name: "log-collector"}}},
+			Status:     corev1.PodStatus{Phase: phase},
+		}
+	}
+	kcli := fake.NewClientset(
+		pod("mon-a", corev1.PodRunning),
+		pod("mon-b-fail", corev1.PodRunning),
+		pod("mon-c", corev1.PodPending),
+	)
+
+	results, err := execOnPods(context.Background(), kcli, nil, clusterExecOptions{
+		namespace: "rook-ceph",
+		selector:  "app=rook-ceph-mon",
+		command:   []string{"cat"},
+		stdin:     []byte("hello"),
+		executor: func(opts k8sutil.ExecOptions) (remotecommand.Executor, error) {
+			return echoExecutor{opts: opts}, nil
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []podExecResult{
+		{Pod: "mon-a", Container: "mon", Stdout: "mon-a cat: hello"},
+		{Pod: "mon-b-fail", Container: "mon", ExitCode: 1, Stdout: "mon-b-fail cat: hello", Stderr: "failed"},
+	}, results)
+
+	var buf bytes.Buffer
+	require.NoError(t, writePodExecResults(&buf, results, "text"))
+	assert.Equal(t, `==> mon-a/mon (exit code 0) <==
+mon-a cat: hello
+==> mon-b-fail/mon (exit code 1) <==
+mon-b-fail cat: hello
+failed
+`, buf.String())
+}
@@ -14,9 +14,12 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 
 	"code.cloudfoundry.org/bytefmt"
+	lhv1b1 "github.com/longhorn/longhorn-manager/k8s/pkg/apis/longhorn/v1beta1"
 	rookcli "github.com/rook/rook/pkg/client/clientset/versioned"
 	"github.com/spf13/cobra"
 
@@ -28,9 +31,10 @@ const (
 	// pvmigrate project, it may be any image containing 'df' and 'cat' commands.
 	defaultOpenEBSPodImage          = "eeacms/rsync:2.3"
 	isDefaultStorageClassAnnotation = "storageclass.kubernetes.io/is-default-class"
-	openEBSLocalProvisioner         = "openebs.io/local"
-	rookRBDProvisioner              = "rook-ceph.rbd.csi.ceph.com"
-	rookCephFSProvisioner           = "rook-ceph.cephfs.csi.ceph.com"
+	openEBSLocalProvisioner         = clusterspace.OpenEBSLocalProvisioner
+	rookRBDProvisioner              = clusterspace.RookRBDProvisioner
+	rookCephFSProvisioner           = clusterspace.RookCephFSProvisioner
+	longhornProvisioner             = clusterspace.LonghornProvisioner
 )
 
 // getStorageClassByName returns a storage class by its name. if storageClassName is empty then this function returns the default storage
@@ -149,10 +153,56 @@ func evaluateRookFreeSpace(ctx context.Context, kubeCli kubernetes.Interface, ro
 	return nil
 }
 
+// evaluateLonghornFreeSpace checks how much space is available in a storage class backed by longhornProvisioner. when onNode is
+// provided only the free space in the node disks is compared against the requested space, otherwise the free space for the whole
+// storage class (replicas taken into account) is used.
+func evaluateLonghornFreeSpace(ctx context.Context, kubeCli kubernetes.Interface, lhCli client.Client, scname, onNode string, requested int64) error {
+	provider, err := clusterspace.NewCapacityProvider(ctx, clusterspace.CapacityProviderOptions{
+		KubeClient:     kubeCli,
+		LonghornClient: lhCli,
+		StorageClass:   scname,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start longhorn capacity provider: %w", err)
+	}
+
+	capacity, err := provider.Capacity(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get longhorn free space: %w", err)
+	}
+
+	if onNode != "" {
+		node, ok := capacity.Nodes[onNode]
+		if !ok {
+			return fmt.Errorf("failed to collect longhorn free space: node %q not found", onNode)
+		}
+		msg, hasSpace := hasEnoughSpace(onNode, node.Free, requested)
+		if !hasSpace {
+			return fmt.Errorf("%s", msg)
+		}
+		fmt.Println(msg)
+		return nil
+	}
+
+	requestedString := bytefmt.ByteSize(uint64(requested))
+	freeString := bytefmt.ByteSize(uint64(capacity.Free))
+	if capacity.Free < requested {
+		return fmt.Errorf("not enough space on longhorn (requested %s, available %s)", requestedString, freeString)
+	}
+
+	message := fmt.Sprintf("Available disk space found in longhorn: %s", freeString)
+	if requested > 0 {
+		message = fmt.Sprintf("%s (requested %s)", message, requestedString)
+	}
+	fmt.Println(message)
+	return nil
+}
+
 // NewClusterCheckFreeDiskSpaceCmd returns a command that is capable of reporting back the amount of free space in the cluster for a provided storage class.
 func NewClusterCheckFreeDiskSpaceCmd(_ CLI) *cobra.Command {
-	var forStorageClass, openEBSImage, openEBSNode, biggerThanString string
+	var forStorageClass, openEBSImage, openEBSNode, longhornNode, biggerThanString string
 	var biggerThanBytes int64
+	var k8sConfig *rest.Config
 	var clientSet kubernetes.Interface
 	var rookClientSet rookcli.Interface
 	var selectedClass *storagev1.StorageClass
@@ -174,7 +224,9 @@ func NewClusterCheckFreeDiskSpaceCmd(_ CLI) *cobra.Command {
 			"# checks if there is 10G available on all nodes in the cluster\n"+
 			"kurl cluster check-free-disk-space --storageclass openebs --bigger-than 10G\n"+
 			"# checks if there is 20G available in the cluster on the default storage class\n"+
-			"kurl cluster check-free-disk-space --bigger-than 20G\n",
+			"kurl cluster check-free-disk-space --bigger-than 20G\n"+
+			"# checks if there is 10G available on node node0 for the longhorn storage class\n"+
+			"kurl cluster check-free-disk-space --storageclass longhorn --longhorn-node-name node0 --bigger-than 10G\n",
 			openEBSLocalProvisioner, rookRBDProvisioner, rookCephFSProvisioner,
 		),
 		Long: fmt.Sprintf(""+
@@ -183,11 +235,12 @@ func NewClusterCheckFreeDiskSpaceCmd(_ CLI) *cobra.Command {
 			"free space, while for Rook storage only the available space is returned. When --bigger-than flag is used this program sets the exit code to zero\n"+
 			"if the space available is bigger than the quantity provided. For OpenEBS, if no node has been provided through --openebs-node-name the exit code\n"+
 			"will be zero only if all nodes free space are bigger than the provided quantity (see Examples section for more details).\n\n"+
-			"Supports the following storage provisioners: %s, %s, %s",
-			openEBSLocalProvisioner, rookRBDProvisioner, rookCephFSProvisioner,
+			"Supports the following storage provisioners: %s, %s, %s, %s",
+			openEBSLocalProvisioner, rookRBDProvisioner, rookCephFSProvisioner, longhornProvisioner,
 		),
 		PreRunE: func(cmd *cobra.Command, _ []string) error {
-			k8sConfig, err := config.GetConfig()
+			var err error
+			k8sConfig, err = config.GetConfig()
 			if err != nil {
 				return fmt.Errorf("failed to read kubernetes configuration: %w", err)
 			}
@@ -234,6 +287,16 @@ func NewClusterCheckFreeDiskSpaceCmd(_ CLI) *cobra.Command {
 			case rookCephFSProvisioner, rookRBDProvisioner:
 				return evaluateRookFreeSpace(ctx, clientSet, rookClientSet, selectedClass.Name, biggerThanBytes)
 
+			case longhornProvisioner:
+				lhClient, err := client.New(k8sConfig, client.Options{})
+				if err != nil {
+					return fmt.Errorf("failed to create longhorn client: %w", err)
+				}
+				if err := lhv1b1.AddToScheme(lhClient.Scheme()); err != nil {
+					return fmt.Errorf("failed to add longhorn types to scheme: %w", err)
+				}
+				return evaluateLonghornFreeSpace(ctx, clientSet, lhClient, selectedClass.Name, longhornNode, biggerThanBytes)
+
 			default:
 				fmt.Printf("Provisioner %q is not supported, unable to determine free space.\n", selectedClass.Provisioner)
 				return nil
@@ -245,5 +308,6 @@ func NewClusterCheckFreeDiskSpaceCmd(_ CLI) *cobra.Command {
 	cmd.Flags().StringVar(&biggerThanString, "bigger-than", "", "Compares if the cluster free disk space is bigger than the provided value. Accepts the same format as used when defining storage requests in Kubernetes (e.g. 10G, 5Gi, 500M).")
 	cmd.Flags().StringVar(&openEBSImage, "openebs-image", defaultOpenEBSPodImage, fmt.Sprintf("The image used by OpenEBS disk free evaluation pod. If not informed the default image used is %s", defaultOpenEBSPodImage))
 	cmd.Flags().StringVar(&openEBSNode, "openebs-node-name", "", "Evaluates OpenEBS free disk space only for the provided node name.")
+	cmd.Flags().StringVar(&longhornNode, "longhorn-node-name", "", "Evaluates Longhorn free disk space only for the provided node name.")
 	return cmd
 }
@@ -2,39 +2,51 @@ package cli
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
-	"net/http"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
-	retryablehttp "github.com/hashicorp/go-retryablehttp"
 	"github.com/spf13/cobra"
-	"gopkg.in/yaml.v2"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/kubernetes"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/replicatedhq/kurl/pkg/ekco"
 )
 
 const (
-	ekcoConfigConfigMap          = "ekco-config"
-	ekcoPodsSelector             = "app=ekc-operator"
-	ekcoPort                     = 8080
-	ekcoMigrationStatusFailed    = "failed"
-	ekcoMigrationStatusCompleted = "completed"
+	ekcoConfigConfigMap = "ekco-config"
+	ekcoPodsSelector    = "app=ekc-operator"
+	ekcoPort            = 8080
 )
 
 type migrateOpts struct {
-	log            *log.Logger
-	authToken      string
-	ekcoAddress    string
-	readyTimeout   time.Duration
-	migrateTimeout time.Duration
-	checkStatus    bool
-	assumeYes      bool
+	log                *log.Logger
+	authToken          string
+	ekcoAddress        string
+	caCert             string
+	insecureSkipVerify bool
+	readyTimeout       time.Duration
+	migrateTimeout     time.Duration
+	checkStatus        bool
+	assumeYes          bool
+	output             string
+}
+
+// storageMigrationStatus is printed by --check-status when the output is json.
+type storageMigrationStatus struct {
+	Status       ekco.MigrationStatus     `json:"status"`
+	Completed    bool                     `json:"completed"`
+	Available    bool                     `json:"available"`
+	ClusterReady *ekco.ClusterReadyStatus `json:"clusterReady,omitempty"`
+	Error        string                   `json:"error,omitempty"`
 }
 
 func NewClusterMigrateMultinodeStorageCmd(cli CLI) *cobra.Command {
@@ -44,184 +56,142 @@ func NewClusterMigrateMultinodeStorageCmd(cli CLI) *cobra.Command {
 		Use:   "migrate-multinode-storage",
 		Short: "Migrate persistent volumes from 'scaling' to 'distributed' storage classes.",
 		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			if opts.output != "text" && opts.output != "json" {
+				return fmt.Errorf("invalid output %q, must be text or json", opts.output)
+			}
 			cmd.SilenceUsage = true
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, _ []string) error {
-			return runStorageMigration(cmd.Context(), opts)
+			client, err := newEkcoClient(opts)
+			if err != nil {
+				return err
+			}
+			if opts.checkStatus {
+				return checkStorageMigrationStatus(cmd.Context(), cmd.OutOrStdout(), client, opts)
+			}
+			return runStorageMigration(cmd.Context(), cmd.OutOrStdout(), client, opts)
 		},
 	}
 	cmd.Flags().DurationVar(&opts.readyTimeout, "ready-timeout", 10*time.Minute, "Timeout waiting for the cluster to be ready for the storage migration.")
 	cmd.Flags().DurationVar(&opts.migrateTimeout, "migrate-timeout", 8*time.Hour, "Timeout waiting for the storage migration to finish.")
-	cmd.Flags().StringVar(&opts.ekcoAddress, "ekco-address", "localhost:31880", "The address of the ekco operator.")
-	cmd.Flags().StringVar(&opts.authToken, "ekco-auth-token", "", "The auth token to use to authenticate with the ekco operator.")
+	cmd.Flags().StringVar(&opts.ekcoAddress, "ekco-address", "localhost:31880", "The address of the ekco operator, as HOST:PORT or a http or https url.")
+	cmd.Flags().StringVar(&opts.authToken, "ekco-auth-token", "", "The auth token to use to authenticate with the ekco operator, read from the ekco-config configmap if not set.")
+	cmd.Flags().StringVar(&opts.caCert, "ekco-ca-cert", "", "The CA certificate file used to verify the ekco operator https certificate.")
+	cmd.Flags().BoolVar(&opts.insecureSkipVerify, "ekco-insecure-skip-verify", false, "Do not verify the ekco operator https certificate.")
 	cmd.Flags().BoolVar(&opts.checkStatus, "check-status", false, "Check the status of the storage migration, but do not run it if available.")
 	cmd.Flags().BoolVar(&opts.assumeYes, "assume-yes", false, "Run the storage migration if available without prompting.")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "text", "The output format (text or json). json prints the status with --check-status and one progress event per line while migrating.")
 	return cmd
 }
 
-// getEkcoMigrationLogs returns the logs of the migration as reported back by ekco.
-func getEkcoMigrationLogs(opts migrateOpts) (string, error) {
-	url := fmt.Sprintf("http://%s/storagemigration/logs", opts.ekcoAddress)
-	retryClient := retryablehttp.NewClient()
-	retryClient.Logger = nil
-	retryClient.RetryMax = 5
-	resp, err := retryClient.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("failed to get migration logs: %w", err)
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read migration logs response: %w", err)
-	}
-	return string(body), nil
-}
-
-// getEkcoMigrationStatus returns the status of the storage migration as reported back by ekco.
-func getEkcoMigrationStatus(opts migrateOpts) (string, error) {
-	url := fmt.Sprintf("http://%s/storagemigration/status", opts.ekcoAddress)
-	retryClient := retryablehttp.NewClient()
-	retryClient.Logger = nil
-	retryClient.RetryMax = 5
-	resp, err := retryClient.Get(url)
-	if err != nil {
-		return "", fmt.Errorf("failed to get migration status: %w", err)
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read migration status response: %w", err)
+// newEkcoClient returns a client for the ekco operator. when no auth token is provided it is read from the cluster
+// the first time it is needed.
+func newEkcoClient(opts migrateOpts) (*ekco.Client, error) {
+	clientOpts := []ekco.Option{}
+	if opts.caCert != "" || opts.insecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: opts.insecureSkipVerify}
+		if opts.caCert != "" {
+			pem, err := os.ReadFile(opts.caCert)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read ekco ca certificate: %w", err)
+			}
+			tlsConfig.RootCAs = x509.NewCertPool()
+			if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificate found in %s", opts.caCert)
+			}
+		}
+		clientOpts = append(clientOpts, ekco.WithTLSConfig(tlsConfig))
 	}
-	return string(body), nil
-}
 
-// ClusterReadyStatus represents the status of the cluster readiness check, namely whether
-// the total number of nodes in the cluster exceed or meet the minimum required number of nodes
-// for the migration to start.
-type ClusterReadyStatus struct {
-	MigrationReadyStatus
-	NrNodes         int `json:"nrNodes"`
-	RequiredNrNodes int `json:"requiredNrNodes"`
+	if opts.authToken != "" {
+		clientOpts = append(clientOpts, ekco.WithAuthToken(opts.authToken))
+	} else {
+		clientOpts = append(clientOpts, ekco.WithAuthTokenFunc(func(ctx context.Context) (string, error) {
+			k8sConfig, err := config.GetConfig()
+			if err != nil {
+				return "", fmt.Errorf("failed to read kubernetes configuration: %w", err)
+			}
+			clientSet, err := kubernetes.NewForConfig(k8sConfig)
+			if err != nil {
+				return "", fmt.Errorf("failed to create kubernetes client: %w", err)
+			}
+			return ekco.AuthTokenFromCluster(clientSet)(ctx)
+		}))
+	}
+	return ekco.NewClient(opts.ekcoAddress, clientOpts...)
 }
 
-// MigrationReadyStatus represents the status of the migration readiness check, includes
-// a reason, the total number of nodes in the cluster and the required number of nodes needed to start the migration.
-type MigrationReadyStatus struct {
-	Ready  bool   `json:"ready"`
-	Reason string `json:"reason"`
+func continueWithStorageMigration() bool {
+	fmt.Println("    The installer detected both OpenEBS and Rook installations in your cluster. Migration from OpenEBS to Rook")
+	fmt.Println("    is possible now, but it requires scaling down applications using OpenEBS volumes, causing downtime. You can")
+	fmt.Println("    choose to run the migration later if preferred.")
+	fmt.Print("Would you like to continue with the migration now? (y/N) ")
+	var answer string
+	fmt.Scanln(&answer)
+	return strings.ToLower(answer) == "y"
 }
 
-func isClusterReadyForStorageMigration(opts migrateOpts) (*ClusterReadyStatus, error) {
-	url := fmt.Sprintf("http://%s/storagemigration/cluster-ready", opts.ekcoAddress)
-	retryClient := retryablehttp.NewClient()
-	retryClient.Logger = nil
-	retryClient.RetryMax = 5
-	resp, err := retryClient.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get ekco cluster ready status: %w", err)
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+// getStorageMigrationStatus reads the migration status and, if the migration has not completed yet, whether the
+// cluster meets the node requirements.
+func getStorageMigrationStatus(ctx context.Context, client *ekco.Client) (*storageMigrationStatus, error) {
+	status, err := client.MigrationStatus(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read ekco cluster ready status response: %w", err)
+		return nil, fmt.Errorf("failed to read current status migration: %w", err)
 	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get ekco OK status (%d): %s", resp.StatusCode, string(body))
-	}
-	var clusStatus ClusterReadyStatus
-	if err := json.Unmarshal(body, &clusStatus); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal ekco cluster ready status: %w", err)
+	result := &storageMigrationStatus{Status: status, Completed: status == ekco.MigrationStatusCompleted}
+	if result.Completed {
+		return result, nil
 	}
-	return &clusStatus, nil
-}
 
-func isEkcoReadyForStorageMigration(opts migrateOpts) (*MigrationReadyStatus, error) {
-	url := fmt.Sprintf("http://%s/storagemigration/ready", opts.ekcoAddress)
-	retryClient := retryablehttp.NewClient()
-	retryClient.Logger = nil
-	retryClient.RetryMax = 5
-	resp, err := retryClient.Get(url)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get ekco status: %w", err)
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read ekco status response: %w", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get ekco status (%d): %s", resp.StatusCode, string(body))
-	}
-	var status MigrationReadyStatus
-	if err := json.Unmarshal(body, &status); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal ekco status: %w", err)
+	if result.ClusterReady, err = client.ClusterReady(ctx); err != nil {
+		return nil, fmt.Errorf("failed to check if node requirements are met for migration: %w", err)
 	}
-	return &status, nil
+	result.Available = result.ClusterReady.Ready
+	return result, nil
 }
 
-// approveStorageMigration tells ekco to start the storage migration.
-func approveStorageMigration(ctx context.Context, opts migrateOpts) error {
-	url := fmt.Sprintf("http://%s/storagemigration/approve", opts.ekcoAddress)
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, nil)
-	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
-	}
-
-	authToken := opts.authToken
-	if authToken == "" {
-		if authToken, err = getEkcoStorageMigrationAuthToken(ctx); err != nil {
-			return fmt.Errorf("authentication token missing: %w", err)
+// checkStorageMigrationStatus reports whether the storage migration can run. the command succeeds when the migration
+// is available or already completed.
+func checkStorageMigrationStatus(ctx context.Context, w io.Writer, client *ekco.Client, opts migrateOpts) error {
+	status, err := getStorageMigrationStatus(ctx, client)
+	if opts.output == "json" {
+		if err != nil {
+			status = &storageMigrationStatus{Error: err.Error()}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(status); err != nil {
+			return fmt.Errorf("failed to encode migration status: %w", err)
 		}
 	}
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", authToken))
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to approve storage migration: %w", err)
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("failed to read response body: %w", err)
+		return err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("failed to approve storage migration (%d): %s", resp.StatusCode, string(body))
+
+	switch {
+	case status.Completed:
+		opts.log.Printf("cluster storage migration already marked as completed.")
+	case !status.Available:
+		return fmt.Errorf("cannot begin multi-node storage migration: %s", status.ClusterReady.Reason)
+	default:
+		opts.log.Printf("cluster is ready for storage migration.")
 	}
 	return nil
 }
 
-func continueWithStorageMigration() bool {
-	fmt.Println("    The installer detected both OpenEBS and Rook installations in your cluster. Migration from OpenEBS to Rook")
-	fmt.Println("    is possible now, but it requires scaling down applications using OpenEBS volumes, causing downtime. You can")
-	fmt.Println("    choose to run the migration later if preferred.")
-	fmt.Print("Would you like to continue with the migration now? (y/N) ")
-	var answer string
-	fmt.Scanln(&answer)
-	return strings.ToLower(answer) == "y"
-}
-
-func runStorageMigration(ctx context.Context, opts migrateOpts) error {
-
-	// check if migration already completed or if there's one already in progress
-	if status, err := getEkcoMigrationStatus(opts); err != nil {
-		return fmt.Errorf("failed to read current status migration: %w", err)
-	} else if status == ekcoMigrationStatusCompleted {
+func runStorageMigration(ctx context.Context, w io.Writer, client *ekco.Client, opts migrateOpts) error {
+	// check if migration already completed or if there's one already in progress, and the cluster size requirements
+	status, err := getStorageMigrationStatus(ctx, client)
+	if err != nil {
+		return err
+	}
+	if status.Completed {
 		opts.log.Printf("cluster storage migration already marked as completed.")
 		return nil
 	}
-
-	// check the cluster size requirements
-	if clusStatus, err := isClusterReadyForStorageMigration(opts); err != nil {
-		return fmt.Errorf("failed to check if node requirements are met for migration: %w", err)
-	} else if !clusStatus.Ready {
-		return fmt.Errorf("cannot begin multi-node storage migration: %s", clusStatus.Reason)
-	}
-
-	// if we were just checking whether the migration was available, we're done
-	if opts.checkStatus {
-		opts.log.Printf("cluster is ready for storage migration.")
-		return nil
+	if !status.Available {
+		return fmt.Errorf("cannot begin multi-node storage migration: %s", status.ClusterReady.Reason)
 	}
 
 	if opts.assumeYes {
@@ -230,8 +200,8 @@ func runStorageMigration(ctx context.Context, opts migrateOpts) error {
 		return nil
 	}
 
-	readyfn := func(_ context.Context) (bool, error) {
-		status, err := isEkcoReadyForStorageMigration(opts)
+	readyfn := func(ctx context.Context) (bool, error) {
+		status, err := client.MigrationReady(ctx)
 		if err != nil {
 			return false, err
 		}
@@ -246,58 +216,103 @@ func runStorageMigration(ctx context.Context, opts migrateOpts) error {
 	}
 
 	opts.log.Printf("approving cluster storage migration.")
-	if err := approveStorageMigration(ctx, opts); err != nil {
+	if err := client.ApproveMigration(ctx); err != nil {
 		return fmt.Errorf("failed to approve storage migration: %w", err)
 	}
-	readyfn = func(_ context.Context) (bool, error) {
-		result, err := getEkcoMigrationStatus(opts)
+
+	migrateCtx, cancel := context.WithTimeout(ctx, opts.migrateTimeout)
+	defer cancel()
+	opts.log.Printf("migration has been successfully approved, waiting for it to finish (%s timeout)...", opts.migrateTimeout)
+	tracker := ekco.NewProgressTracker(newMigrationProgressReporter(w, opts.log, opts.output))
+	if err := waitForStorageMigration(migrateCtx, client, tracker, 5*time.Second); err != nil {
+		if logs := tracker.RecentLogs(); len(logs) > 0 {
+			opts.log.Printf("cluster storage migration failed, last logs:")
+			for _, line := range logs {
+				opts.log.Print(line)
+			}
+		}
+		return err
+	}
+	opts.log.Printf("cluster storage migration completed.")
+	return nil
+}
+
+// waitForStorageMigration follows the migration logs and status with the tracker until ekco reports the migration as
+// completed or failed.
+func waitForStorageMigration(ctx context.Context, client *ekco.Client, tracker *ekco.ProgressTracker, interval time.Duration) error {
+	logsCtx, stopLogs := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	var logsErr error
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		logsErr = client.FollowMigrationLogs(logsCtx, tracker, interval)
+	}()
+	defer wg.Wait()
+	defer stopLogs()
+
+	statusfn := func(ctx context.Context) (bool, error) {
+		status, err := client.MigrationStatus(ctx)
 		if err != nil {
 			return false, err
 		}
-		if result == ekcoMigrationStatusFailed {
+		tracker.SetStatus(status)
+		if status == ekco.MigrationStatusFailed {
 			return false, fmt.Errorf("failed to migrate storage classes")
 		}
-		opts.log.Printf("cluster reported migration status: %q", result)
-		return result == ekcoMigrationStatusCompleted, nil
+		return status == ekco.MigrationStatusCompleted, nil
 	}
-	migrateCtx, cancel := context.WithTimeout(ctx, opts.migrateTimeout)
-	defer cancel()
-	opts.log.Printf("migration has been successfully approved, waiting for it to finish (%s timeout)...", opts.migrateTimeout)
-	if err := wait.PollUntilContextCancel(migrateCtx, 5*time.Second, false, readyfn); err != nil {
-		if logs, err := getEkcoMigrationLogs(opts); err == nil {
-			opts.log.Printf("cluster storage migration failed, reading logs:")
-			opts.log.Print(logs)
+	if err := wait.PollUntilContextCancel(ctx, interval, false, statusfn); err != nil {
+		stopLogs()
+		wg.Wait()
+		if logsErr != nil {
+			return fmt.Errorf("failed to wait for ekco to be ready for migration: %w (following logs: %s)", err, logsErr)
 		}
 		return fmt.Errorf("failed to wait for ekco to be ready for migration: %w", err)
 	}
 	return nil
 }
 
-func getEkcoStorageMigrationAuthToken(ctx context.Context) (string, error) {
-	// Get kube client
-	k8sConfig, err := config.GetConfig()
-	if err != nil {
-		return "", fmt.Errorf("failed to read kubernetes configuration: %w", err)
+// newMigrationProgressReporter returns the function printing the migration progress events, as text through the
+// logger or as json lines to w.
+func newMigrationProgressReporter(w io.Writer, logger *log.Logger, output string) func(ekco.Event) {
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		return func(event ekco.Event) {
+			if err := encoder.Encode(event); err != nil {
+				logger.Printf("failed to encode migration progress: %s", err)
+			}
+		}
 	}
-	clientSet, err := kubernetes.NewForConfig(k8sConfig)
-	if err != nil {
-		return "", fmt.Errorf("failed to create kubernetes client: %w", err)
+	return func(event ekco.Event) {
+		logger.Print(formatMigrationEvent(event))
 	}
+}
 
-	// retrieve configmap
-	ekcoConfig, err := clientSet.CoreV1().ConfigMaps("kurl").Get(ctx, "ekco-config", metav1.GetOptions{})
-	if err != nil {
-		return "", fmt.Errorf("failed to get ekco-config configmap in kurl namespace: %w", err)
+func formatMigrationEvent(event ekco.Event) string {
+	progress := event.Progress
+	switch event.Type {
+	case ekco.EventStatus:
+		return fmt.Sprintf("cluster reported migration status: %q", event.Message)
+	case ekco.EventPhase:
+		return fmt.Sprintf("phase %d: migrating volumes from %s", progress.Phase.Number, event.Message)
+	case ekco.EventWarning:
+		return fmt.Sprintf("WARNING: %s", event.Message)
 	}
 
-	// get authentication token
-	ekcoConfigYaml := ekcoConfig.Data["config.yaml"]
-	var authConfig struct {
-		AuthToken string `yaml:"storage_migration_auth_token"`
-	}
-	if err := yaml.Unmarshal([]byte(ekcoConfigYaml), &authConfig); err != nil {
-		return "", fmt.Errorf("failed to parse storage migration authentication token from YAML config: %w", err)
+	total := progress.VolumesDone + progress.VolumesRemaining
+	for _, volume := range progress.Volumes {
+		if volume.ID() != event.Message {
+			continue
+		}
+		if volume.State != ekco.VolumeDone {
+			return fmt.Sprintf("[%d/%d] migrating %s", progress.VolumesDone+1, total, volume.ID())
+		}
+		msg := fmt.Sprintf("[%d/%d] migrated %s in %s", progress.VolumesDone, total, volume.ID(), volume.Duration.Round(time.Second))
+		if progress.EstimatedTimeLeft > 0 {
+			msg += fmt.Sprintf(", about %s left", progress.EstimatedTimeLeft)
+		}
+		return msg
 	}
-
-	return authConfig.AuthToken, nil
+	return event.Message
 }
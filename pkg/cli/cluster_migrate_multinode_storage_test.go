@@ -0,0 +1,114 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/replicatedhq/kurl/pkg/ekco"
+)
+
+func Test_checkStorageMigrationStatus(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       string
+		clusterReady string
+		wantJSON     string
+		wantErr      string
+	}{
+		{
+			name:         "available",
+			status:       "",
+			clusterReady: `{"ready":true,"reason":"","nrNodes":3,"requiredNrNodes":3}`,
+			wantJSON:     `{"status":"","completed":false,"available":true,"clusterReady":{"ready":true,"reason":"","nrNodes":3,"requiredNrNodes":3}}`,
+		},
+		{
+			name:         "not enough nodes",
+			status:       "",
+			clusterReady: `{"ready":false,"reason":"3 nodes are required","nrNodes":1,"requiredNrNodes":3}`,
+			wantJSON:     `{"status":"","completed":false,"available":false,"clusterReady":{"ready":false,"reason":"3 nodes are required","nrNodes":1,"requiredNrNodes":3}}`,
+			wantErr:      "cannot begin multi-node storage migration: 3 nodes are required",
+		},
+		{
+			name:     "completed",
+			status:   "completed",
+			wantJSON: `{"status":"completed","completed":true,"available":false}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/storagemigration/status":
+					fmt.Fprint(w, tt.status)
+				case "/storagemigration/cluster-ready":
+					fmt.Fprint(w, tt.clusterReady)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			client, err := ekco.NewClient(server.URL)
+			require.NoError(t, err)
+			var logs, out bytes.Buffer
+			opts := migrateOpts{log: log.New(&logs, "", 0), output: "json"}
+
+			err = checkStorageMigrationStatus(context.Background(), &out, client, opts)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.JSONEq(t, tt.wantJSON, out.String())
+		})
+	}
+}
+
+func Test_formatMigrationEvent(t *testing.T) {
+	volumes := []ekco.VolumeProgress{
+		{Namespace: "default", Name: "www-web-0", State: ekco.VolumeDone, Duration: 2 * time.Minute},
+		{Namespace: "default", Name: "www-web-1", State: ekco.VolumeMigrating},
+		{Namespace: "default", Name: "www-web-2", State: ekco.VolumePending},
+	}
+	phase := &ekco.Phase{Number: 1, Source: "OpenEBS", Destination: "Rook"}
+	tests := []struct {
+		name  string
+		event ekco.Event
+		want  string
+	}{
+		{
+			name:  "phase",
+			event: ekco.Event{Type: ekco.EventPhase, Message: "OpenEBS → Rook", Progress: ekco.Progress{Phase: phase}},
+			want:  "phase 1: migrating volumes from OpenEBS → Rook",
+		},
+		{
+			name:  "volume done",
+			event: ekco.Event{Type: ekco.EventVolume, Message: "default/www-web-0", Progress: ekco.Progress{Phase: phase, Volumes: volumes, VolumesDone: 1, VolumesRemaining: 2, EstimatedTimeLeft: 4 * time.Minute}},
+			want:  "[1/3] migrated default/www-web-0 in 2m0s, about 4m0s left",
+		},
+		{
+			name:  "volume started",
+			event: ekco.Event{Type: ekco.EventVolume, Message: "default/www-web-1", Progress: ekco.Progress{Phase: phase, Volumes: volumes, VolumesDone: 1, VolumesRemaining: 2}},
+			want:  "[2/3] migrating default/www-web-1",
+		},
+		{
+			name:  "warning",
+			event: ekco.Event{Type: ekco.EventWarning, Message: "pod restarted"},
+			want:  "WARNING: pod restarted",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatMigrationEvent(tt.event))
+		})
+	}
+}
@@ -12,6 +12,10 @@ func AddCommands(cmd *cobra.Command, cli CLI) {
 	hostCmd.AddCommand(newHostProtectedidCmd(cli))
 	hostCmd.AddCommand(newHostPreflightCmd(cli))
 	hostCmd.AddCommand(newHostnameCmd(cli))
+	hostCmd.AddCommand(newHostInfoCmd(cli))
+	hostProxyCmd := newHostProxyCmd(cli)
+	hostProxyCmd.AddCommand(newHostProxyCheckCmd(cli))
+	hostCmd.AddCommand(hostProxyCmd)
 	cmd.AddCommand(hostCmd)
 
 	rookCmd := NewRookCmd(cli)
@@ -34,6 +38,7 @@ func AddCommands(cmd *cobra.Command, cli CLI) {
 	clusterCmd.AddCommand(NewClusterCheckFreeDiskSpaceCmd(cli))
 	clusterCmd.AddCommand(newPreflightCmd(cli))
 	clusterCmd.AddCommand(NewClusterMigrateMultinodeStorageCmd(cli))
+	clusterCmd.AddCommand(newClusterExecCmd(cli))
 	cmd.AddCommand(clusterCmd)
 
 	netutilCmd := newNetutilCommand(cli)
@@ -41,8 +46,15 @@ func AddCommands(cmd *cobra.Command, cli CLI) {
 	netutilCmd.AddCommand(newNetutilDefaultIfaceCommand(cli))
 	netutilCmd.AddCommand(newNetutilFormatIPAddressCmd(cli))
 	netutilCmd.AddCommand(newNetutilNodesConnectivity(cli))
+	netutilCmd.AddCommand(newNetutilProbeServerCmd(cli))
+	netutilCmd.AddCommand(newNetutilProbeCmd(cli))
+	netutilCmd.AddCommand(newNetutilPodNetworkCheckCmd(cli))
 	cmd.AddCommand(netutilCmd)
 
+	installerCmd := newInstallerCmd(cli)
+	installerCmd.AddCommand(newInstallerRenderTemplateCmd(cli))
+	cmd.AddCommand(installerCmd)
+
 	objectStoreCmd := newObjectStoreCmd(cli)
 	objectStoreCmd.AddCommand(newSyncObjectStoreCmd(cli))
 	cmd.AddCommand(objectStoreCmd)
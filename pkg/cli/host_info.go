@@ -0,0 +1,30 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicatedhq/kurl/pkg/host"
+)
+
+func newHostInfoCmd(_ CLI) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "info",
+		Short:        "Prints the kURL host inventory as json",
+		Long:         "Prints the os, kernel, cpu, memory, swap, block devices, container runtime, kubelet, SELinux and firewalld state of the host along with its protected machine id. parts that cannot be collected are listed in errors.",
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			info := host.NewInfoCollector().Collect(cmd.Context())
+			encoder := json.NewEncoder(cmd.OutOrStdout())
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(info); err != nil {
+				return fmt.Errorf("failed to encode host info: %w", err)
+			}
+			return nil
+		},
+	}
+	return cmd
+}
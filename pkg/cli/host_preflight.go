@@ -66,17 +66,8 @@ func newHostPreflightCmd(cli CLI) *cobra.Command {
 				return errors.Wrap(err, "decode installer spec")
 			}
 
-			remotes := append([]string{}, v.GetStringSlice("primary-host")...)
-			remotes = append(remotes, v.GetStringSlice("secondary-host")...)
-			data := installer.TemplateData{
-				Installer:      *installerSpec,
-				IsPrimary:      v.GetBool("is-primary"),
-				IsJoin:         v.GetBool("is-join"),
-				IsUpgrade:      v.GetBool("is-upgrade"),
-				PrimaryHosts:   v.GetStringSlice("primary-host"),
-				SecondaryHosts: v.GetStringSlice("secondary-host"),
-				RemoteHosts:    remotes,
-			}
+			data := templateDataFromFlags(v, installerSpec)
+			remotes := data.RemoteHosts
 
 			preflightSpec := &troubleshootv1beta2.HostPreflight{}
 
@@ -213,17 +204,7 @@ func newPreflightCmd(cli CLI) *cobra.Command {
 				return errors.Wrap(err, "decode installer spec")
 			}
 
-			remotes := append([]string{}, v.GetStringSlice("primary-host")...)
-			remotes = append(remotes, v.GetStringSlice("secondary-host")...)
-			data := installer.TemplateData{
-				Installer:      *installerSpec,
-				IsPrimary:      v.GetBool("is-primary"),
-				IsJoin:         v.GetBool("is-join"),
-				IsUpgrade:      v.GetBool("is-upgrade"),
-				PrimaryHosts:   v.GetStringSlice("primary-host"),
-				SecondaryHosts: v.GetStringSlice("secondary-host"),
-				RemoteHosts:    remotes,
-			}
+			data := templateDataFromFlags(v, installerSpec)
 
 			preflightSpec := &troubleshootv1beta2.Preflight{}
 
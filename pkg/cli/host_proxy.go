@@ -0,0 +1,260 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kurlkinds/client/kurlclientset"
+	kurlv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/replicatedhq/kurl/pkg/host"
+	"github.com/replicatedhq/kurl/pkg/installer"
+	"github.com/replicatedhq/kurl/pkg/k8sutil"
+)
+
+const hostProxyCheckCmdExample = `
+  # Check the proxy settings against the cluster configuration
+  $ kurl host proxy check
+
+  # Use the additional no proxy addresses of an installer spec file
+  $ kurl host proxy check --installer spec.yaml`
+
+const (
+	containerdProxyDropIn = "/etc/systemd/system/containerd.service.d/http-proxy.conf"
+	dockerProxyDropIn     = "/etc/systemd/system/docker.service.d/http-proxy.conf"
+)
+
+// proxyCheckReport is the output of the host proxy check command.
+type proxyCheckReport struct {
+	Required []string             `json:"required"`
+	Sources  []host.ProxySettings `json:"sources"`
+	Findings []host.ProxyFinding  `json:"findings"`
+}
+
+func newHostProxyCmd(_ CLI) *cobra.Command {
+	return &cobra.Command{
+		Use:   "proxy",
+		Short: "Perform operations on the kURL host proxy settings",
+	}
+}
+
+func newHostProxyCheckCmd(cli CLI) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "check",
+		Short:        "Checks the proxy settings of the host, the container runtime and kotsadm are consistent",
+		Example:      hostProxyCheckCmdExample,
+		SilenceUsage: true,
+		Args:         cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return cli.GetViper().BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			v := cli.GetViper()
+			output := v.GetString("output")
+			if output != "text" && output != "json" {
+				return errors.Errorf("invalid output %q, must be text or json", output)
+			}
+
+			var spec *kurlv1beta1.Installer
+			if path := v.GetString("installer"); path != "" {
+				data, err := afero.ReadFile(cli.GetFS(), path)
+				if err != nil {
+					return errors.Wrap(err, "read installer")
+				}
+				if spec, err = installer.DecodeSpec(data); err != nil {
+					return errors.Wrap(err, "decode installer")
+				}
+			}
+
+			var kcli kubernetes.Interface
+			var kurlcli kurlclientset.Interface
+			if k8sConfig, err := config.GetConfig(); err != nil {
+				cli.Logger().Printf("Unable to reach the cluster, only the host settings are checked: %s", err)
+			} else {
+				if kcli, err = kubernetes.NewForConfig(k8sConfig); err != nil {
+					return errors.Wrap(err, "create kubernetes client")
+				}
+				if kurlcli, err = kurlclientset.NewForConfig(k8sConfig); err != nil {
+					return errors.Wrap(err, "create kurl client")
+				}
+			}
+
+			report, err := checkHostProxy(cmd.Context(), cli.GetFS(), os.LookupEnv, kcli, kurlcli, spec, v.GetString("load-balancer-address"))
+			if err != nil {
+				return err
+			}
+
+			if output == "json" {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(report); err != nil {
+					return errors.Wrap(err, "encode report")
+				}
+			} else {
+				writeProxyCheckReport(cmd.OutOrStdout(), report)
+			}
+
+			for _, finding := range report.Findings {
+				if finding.Severity == host.ProxySeverityError {
+					return errors.New("inconsistent proxy settings found")
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().String("installer", "", "installer spec file used for the additional no proxy addresses, read from the cluster if not set")
+	cmd.Flags().String("load-balancer-address", "", "load balancer address, read from the cluster if not set")
+	cmd.Flags().StringP("output", "o", "text", "output format (text or json)")
+	return cmd
+}
+
+// checkHostProxy collects the proxy settings of the host environment, the container runtime drop-ins and the kotsadm
+// workload and compares them with the NO_PROXY entries the cluster needs. the cluster is optional, without it the
+// required entries are built from the provided installer spec only.
+func checkHostProxy(ctx context.Context, fs afero.Fs, lookupEnv func(string) (string, bool), kcli kubernetes.Interface, kurlcli kurlclientset.Interface, spec *kurlv1beta1.Installer, loadBalancer string) (*proxyCheckReport, error) {
+	network := host.ClusterNetwork{LoadBalancerAddress: loadBalancer}
+	sources := []host.ProxySettings{host.ProxySettingsFromEnv("host environment", lookupEnv)}
+	for _, dropIn := range []struct{ name, path string }{
+		{"containerd", containerdProxyDropIn},
+		{"docker", dockerProxyDropIn},
+	} {
+		data, err := afero.ReadFile(fs, dropIn.path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				sources = append(sources, host.ProxySettings{Source: dropIn.name})
+				continue
+			}
+			return nil, errors.Wrapf(err, "read %s", dropIn.path)
+		}
+		sources = append(sources, host.ParseSystemdProxyDropIn(dropIn.name, data))
+	}
+
+	if kcli != nil {
+		installerID, err := readClusterNetwork(ctx, kcli, &network)
+		if err != nil {
+			return nil, err
+		}
+		if spec == nil && installerID != "" && kurlcli != nil {
+			spec, err = kurlcli.ClusterV1beta1().Installers(metav1.NamespaceDefault).Get(ctx, installerID, metav1.GetOptions{})
+			if err != nil && !apierrors.IsNotFound(err) {
+				return nil, errors.Wrapf(err, "get installer %s", installerID)
+			}
+		}
+		kotsadm, err := kotsadmProxySettings(ctx, kcli)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, kotsadm)
+	}
+	if spec != nil && spec.Spec.Kurl != nil {
+		network.AdditionalNoProxy = spec.Spec.Kurl.AdditionalNoProxyAddresses
+	}
+
+	report := &proxyCheckReport{
+		Required: host.RequiredNoProxy(network),
+		Sources:  sources,
+	}
+	report.Findings = host.CheckEnvProxyCase("host environment", lookupEnv)
+	report.Findings = append(report.Findings, host.CheckProxySettings(report.Required, sources)...)
+	return report, nil
+}
+
+// readClusterNetwork reads the cidrs and load balancer from the kurl-config configmap and the node ips. returns the
+// installer id.
+func readClusterNetwork(ctx context.Context, kcli kubernetes.Interface, network *host.ClusterNetwork) (string, error) {
+	var installerID string
+	kurlConfig, err := kcli.CoreV1().ConfigMaps("kube-system").Get(ctx, "kurl-config", metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return "", errors.Wrap(err, "get kurl-config configmap")
+	} else if err == nil {
+		installerID = kurlConfig.Data["installer_id"]
+		network.PodCIDR = kurlConfig.Data["pod_cidr"]
+		network.ServiceCIDR = kurlConfig.Data["service_cidr"]
+		if network.LoadBalancerAddress == "" {
+			network.LoadBalancerAddress = kurlConfig.Data["kubernetes_api_address"]
+		}
+	}
+
+	nodes, err := kcli.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", errors.Wrap(err, "list nodes")
+	}
+	for _, node := range nodes.Items {
+		ip, err := k8sutil.NodeInternalIP(node)
+		if err != nil {
+			return "", errors.Wrapf(err, "get node %s ip", node.Name)
+		}
+		network.NodeIPs = append(network.NodeIPs, ip)
+	}
+	return installerID, nil
+}
+
+// kotsadmProxySettings reads the proxy variables of the kotsadm container, kotsadm is a statefulset since 1.46 and a
+// deployment before.
+func kotsadmProxySettings(ctx context.Context, kcli kubernetes.Interface) (host.ProxySettings, error) {
+	settings := host.ProxySettings{Source: "kotsadm"}
+	var podSpec *corev1.PodSpec
+	sts, err := kcli.AppsV1().StatefulSets(metav1.NamespaceDefault).Get(ctx, "kotsadm", metav1.GetOptions{})
+	if err == nil {
+		podSpec = &sts.Spec.Template.Spec
+	} else if !apierrors.IsNotFound(err) {
+		return settings, errors.Wrap(err, "get kotsadm statefulset")
+	} else {
+		deploy, err := kcli.AppsV1().Deployments(metav1.NamespaceDefault).Get(ctx, "kotsadm", metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return settings, nil
+		} else if err != nil {
+			return settings, errors.Wrap(err, "get kotsadm deployment")
+		}
+		podSpec = &deploy.Spec.Template.Spec
+	}
+
+	settings.Found = true
+	for _, container := range podSpec.Containers {
+		if container.Name != "kotsadm" {
+			continue
+		}
+		env := map[string]string{}
+		for _, e := range container.Env {
+			env[e.Name] = e.Value
+		}
+		settings = host.ProxySettingsFromEnv("kotsadm", func(key string) (string, bool) {
+			value, ok := env[key]
+			return value, ok
+		})
+	}
+	return settings, nil
+}
+
+func writeProxyCheckReport(w io.Writer, report *proxyCheckReport) {
+	fmt.Fprintf(w, "Required NO_PROXY entries: %s\n", strings.Join(report.Required, ","))
+	for _, source := range report.Sources {
+		switch {
+		case !source.Found:
+			fmt.Fprintf(w, "%s: not found\n", source.Source)
+		case source.HTTPProxy == "" && source.HTTPSProxy == "":
+			fmt.Fprintf(w, "%s: no proxy\n", source.Source)
+		default:
+			fmt.Fprintf(w, "%s: HTTP_PROXY=%s HTTPS_PROXY=%s NO_PROXY=%s\n", source.Source, source.HTTPProxy, source.HTTPSProxy, source.NoProxy)
+		}
+	}
+	if len(report.Findings) == 0 {
+		fmt.Fprintln(w, "No proxy problems found")
+		return
+	}
+	for _, finding := range report.Findings {
+		fmt.Fprintf(w, "%s: %s: %s\n", strings.ToUpper(string(finding.Severity)), finding.Source, finding.Message)
+	}
+}
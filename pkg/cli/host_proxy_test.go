@@ -0,0 +1,89 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	kurlfake "github.com/replicatedhq/kurlkinds/client/kurlclientset/fake"
+	kurlv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/replicatedhq/kurl/pkg/host"
+)
+
+func Test_checkHostProxy(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	require.NoError(t, afero.WriteFile(fs, containerdProxyDropIn, []byte(`# Generated by kURL
+[Service]
+Environment="HTTP_PROXY=http://proxy:3128" "HTTPS_PROXY=http://proxy:3128" "NO_PROXY=localhost,127.0.0.1,.svc,.local,kubernetes,10.32.0.0/20,10.96.0.0/22,10.0.0.5"
+`), 0644))
+
+	env := map[string]string{
+		"HTTP_PROXY":  "http://proxy:3128",
+		"HTTPS_PROXY": "http://proxy:3128",
+		"NO_PROXY":    "localhost,127.0.0.1,.svc,.cluster.local,kubernetes,10.32.0.0/20,10.96.0.0/22,10.0.0.0/24,registry.internal",
+	}
+	lookupEnv := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+
+	kcli := fake.NewClientset(
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kurl-config", Namespace: "kube-system"},
+			Data: map[string]string{
+				"installer_id":           "6abe39c",
+				"pod_cidr":               "10.32.0.0/20",
+				"service_cidr":           "10.96.0.0/22",
+				"kubernetes_api_address": "10.0.0.5:6443",
+			},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+			Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.5"}}},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-b"},
+			Status:     corev1.NodeStatus{Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.6"}}},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "kotsadm", Namespace: "default"},
+			Spec: appsv1.StatefulSetSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "kotsadm", Env: []corev1.EnvVar{
+					{Name: "HTTP_PROXY", Value: "http://proxy:3128"},
+					{Name: "HTTPS_PROXY", Value: "http://proxy:3128"},
+					{Name: "NO_PROXY", Value: "localhost,127.0.0.1,.svc,.cluster.local,kubernetes,10.32.0.0/20,10.96.0.0/22,10.0.0.5,10.0.0.6,registry.internal"},
+				}}},
+			}}},
+		},
+	)
+	kurlcli := kurlfake.NewSimpleClientset(&kurlv1beta1.Installer{
+		ObjectMeta: metav1.ObjectMeta{Name: "6abe39c", Namespace: "default"},
+		Spec: kurlv1beta1.InstallerSpec{
+			Kurl: &kurlv1beta1.Kurl{AdditionalNoProxyAddresses: []string{"registry.internal"}},
+		},
+	})
+
+	report, err := checkHostProxy(context.Background(), fs, lookupEnv, kcli, kurlcli, nil, "")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"localhost", "127.0.0.1", ".svc", ".cluster.local", "kubernetes",
+		"10.32.0.0/20", "10.96.0.0/22", "10.0.0.5", "10.0.0.6", "registry.internal",
+	}, report.Required)
+	assert.Equal(t, []host.ProxyFinding{
+		{Source: "containerd", Severity: host.ProxySeverityError, Message: "NO_PROXY is missing 10.0.0.6, registry.internal"},
+		{Source: "containerd", Severity: host.ProxySeverityWarning, Message: "NO_PROXY has .local not excluded by host environment"},
+	}, report.Findings)
+
+	var buf bytes.Buffer
+	writeProxyCheckReport(&buf, report)
+	assert.Contains(t, buf.String(), "docker: not found\n")
+	assert.Contains(t, buf.String(), "ERROR: containerd: NO_PROXY is missing 10.0.0.6, registry.internal\n")
+}
@@ -0,0 +1,107 @@
+package cli
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	kurlv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+	"github.com/spf13/afero"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+
+	"github.com/replicatedhq/kurl/pkg/installer"
+)
+
+const installerRenderTemplateCmdExample = `
+  # Render a host preflight spec for a joining secondary node
+  $ kurl installer render-template host-preflights.yaml spec.yaml --is-primary=false --is-join
+
+  # Print the data the template sees, installer spec from STDIN
+  $ kubectl get installer 6abe39c -oyaml | kurl installer render-template host-preflights.yaml - --show-data`
+
+func newInstallerCmd(cli CLI) *cobra.Command {
+	return &cobra.Command{
+		Use:   "installer",
+		Short: "Perform operations on kURL installer specs",
+		PersistentPreRunE: func(cmd *cobra.Command, _ []string) error {
+			return cli.GetViper().BindPFlags(cmd.PersistentFlags())
+		},
+	}
+}
+
+func newInstallerRenderTemplateCmd(cli CLI) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "render-template TEMPLATE_FILE [INSTALLER SPEC FILE|-]",
+		Short:        "Renders a kURL template, such as a host preflight spec, with the provided installer spec",
+		Example:      installerRenderTemplateCmdExample,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(2),
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			return cli.GetViper().BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v := cli.GetViper()
+
+			text, err := afero.ReadFile(cli.GetFS(), args[0])
+			if err != nil {
+				return errors.Wrapf(err, "read template file %s", args[0])
+			}
+			installerSpecData, err := retrieveInstallerSpecDataFromArg(cli.GetFS(), cmd.InOrStdin(), args[1])
+			if err != nil {
+				return errors.Wrap(err, "retrieve installer spec from arg")
+			}
+			installerSpec, err := installer.DecodeSpec(installerSpecData)
+			if err != nil {
+				return errors.Wrap(err, "decode installer spec")
+			}
+
+			if v.GetBool("apply-defaults") {
+				installer.ApplyDefaults(&installerSpec.Spec)
+			}
+			data := templateDataFromFlags(v, installerSpec)
+			if v.GetBool("show-data") {
+				// rendered through the same path as the template so nil add-ons show up as the template sees them.
+				out, err := installer.ExecuteTemplate("data", "{{kurl toPrettyJson . }}\n", data)
+				if err != nil {
+					return errors.Wrap(err, "render template data")
+				}
+				fmt.Fprint(cmd.ErrOrStderr(), string(out))
+			}
+
+			out, err := installer.ExecuteTemplate(args[0], string(text), data)
+			if err != nil {
+				return errors.Wrapf(err, "render %s", args[0])
+			}
+			_, err = cmd.OutOrStdout().Write(out)
+			return err
+		},
+	}
+	addTemplateDataFlags(cmd)
+	cmd.Flags().Bool("show-data", false, "print the template data as json to stderr")
+	cmd.Flags().Bool("apply-defaults", false, "set the kURL defaults on the add-on fields not set in the installer spec")
+	return cmd
+}
+
+// addTemplateDataFlags adds the flags read by templateDataFromFlags.
+func addTemplateDataFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("is-join", false, "set to true if this node is joining an existing cluster (non-primary implies join)")
+	cmd.Flags().Bool("is-primary", true, "set to true if this node is a primary")
+	cmd.Flags().Bool("is-upgrade", false, "set to true if this is an upgrade")
+	cmd.Flags().StringSlice("primary-host", nil, "host or IP of a control plane node running a Kubernetes API server and etcd peer")
+	cmd.Flags().StringSlice("secondary-host", nil, "host or IP of a secondary node running kubelet")
+}
+
+// templateDataFromFlags returns the data kURL templates are executed with.
+func templateDataFromFlags(v *viper.Viper, installerSpec *kurlv1beta1.Installer) installer.TemplateData {
+	remotes := append([]string{}, v.GetStringSlice("primary-host")...)
+	remotes = append(remotes, v.GetStringSlice("secondary-host")...)
+	return installer.TemplateData{
+		Installer:      *installerSpec,
+		IsPrimary:      v.GetBool("is-primary"),
+		IsJoin:         v.GetBool("is-join"),
+		IsUpgrade:      v.GetBool("is-upgrade"),
+		PrimaryHosts:   v.GetStringSlice("primary-host"),
+		SecondaryHosts: v.GetStringSlice("secondary-host"),
+		RemoteHosts:    remotes,
+	}
+}
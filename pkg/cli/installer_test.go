@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	mock_cli "github.com/replicatedhq/kurl/pkg/cli/mock"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewInstallerRenderTemplateCmd(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		args     []string
+		stdout   string
+		wantErr  string
+	}{
+		{
+			name:     "primary",
+			template: `version: {{kurl .Installer.Spec.Kubernetes.Version }} join: {{kurl .IsJoin }} rook: {{kurl addOnEnabled .Installer "rook" }}`,
+			stdout:   "version: 1.18.10 join: false rook: false",
+		},
+		{
+			name:     "secondary join",
+			template: `{{kurl if and .IsJoin (not .IsPrimary) }}{{kurl join "," .PrimaryHosts }}{{kurl end }}`,
+			args:     []string{"--is-primary=false", "--is-join", "--primary-host=10.0.0.1,10.0.0.2"},
+			stdout:   "10.0.0.1,10.0.0.2",
+		},
+		{
+			name:     "nested nil fields",
+			template: `proxy: {{kurl len .Installer.Spec.Kurl.AdditionalNoProxyAddresses }} cluster: {{kurl .Installer.Spec.Kubernetes.ClusterName }}`,
+			stdout:   "proxy: 0 cluster: ",
+		},
+		{
+			name:     "apply defaults",
+			template: `cluster: {{kurl .Installer.Spec.Kubernetes.ClusterName }} kotsadm: {{kurl .Installer.Spec.Kotsadm.UiBindPort }}`,
+			args:     []string{"--apply-defaults"},
+			stdout:   "cluster: kubernetes kotsadm: 0",
+		},
+		{
+			name:     "invalid template",
+			template: `{{kurl .Installer.Spec.Nope }}`,
+			wantErr:  "render /tmp/template.yaml",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockCtrl := gomock.NewController(t)
+			defer mockCtrl.Finish()
+
+			fs := afero.NewMemMapFs()
+			require.NoError(t, afero.WriteFile(fs, "/tmp/installer.yaml", []byte(installerYAML), 0666))
+			require.NoError(t, afero.WriteFile(fs, "/tmp/template.yaml", []byte(tt.template), 0666))
+
+			mockCLI := mock_cli.NewMockCLI(mockCtrl)
+			mockCLI.EXPECT().GetViper().Return(viper.New()).AnyTimes()
+			mockCLI.EXPECT().GetFS().Return(fs).AnyTimes()
+
+			cmd := newInstallerRenderTemplateCmd(mockCLI)
+			bOut, bErr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+			cmd.SetOut(bOut)
+			cmd.SetErr(bErr)
+			cmd.SetArgs(append([]string{"/tmp/template.yaml", "/tmp/installer.yaml"}, tt.args...))
+
+			err := cmd.Execute()
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.stdout, bOut.String())
+		})
+	}
+}
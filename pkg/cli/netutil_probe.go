@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/replicatedhq/kurl/pkg/netutils"
+)
+
+const (
+	probeModeLatency   = "latency"
+	probeModeMTU       = "mtu"
+	probeModeBandwidth = "bandwidth"
+)
+
+// nodeProbeResult is printed by the probe command and read back by nodes-connectivity.
+type nodeProbeResult struct {
+	Latency     *netutils.LatencyResult   `json:"latency,omitempty"`
+	MTU         *netutils.MTUResult       `json:"mtu,omitempty"`
+	Bandwidth   *netutils.BandwidthResult `json:"bandwidth,omitempty"`
+	OverlayMTUs map[string]int            `json:"overlayMTUs,omitempty"`
+}
+
+type netutilProbeOptions struct {
+	address  string
+	modes    []string
+	count    int
+	duration time.Duration
+	maxMTU   int
+	timeout  time.Duration
+}
+
+// newNetutilProbeServerCmd answers the probes sent by the probe command, it is run by the nodes-connectivity listeners.
+func newNetutilProbeServerCmd(_ CLI) *cobra.Command {
+	var port int32
+	cmd := &cobra.Command{
+		Use:    "probe-server",
+		Short:  "Answers latency, mtu and bandwidth probes on the provided tcp and udp port",
+		Hidden: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGTERM, syscall.SIGINT)
+			defer cancel()
+			return netutils.ServeProbes(ctx, net.JoinHostPort("", strconv.Itoa(int(port))))
+		},
+	}
+	cmd.Flags().Int32Var(&port, "port", 9797, "The tcp and udp port to listen on.")
+	return cmd
+}
+
+// newNetutilProbeCmd measures the latency, path mtu or bandwidth to a probe server, it is run by the nodes-connectivity
+// pinger.
+func newNetutilProbeCmd(_ CLI) *cobra.Command {
+	var opts netutilProbeOptions
+	cmd := &cobra.Command{
+		Use:    "probe",
+		Short:  "Measures the latency, path mtu or bandwidth to a probe server and prints the results as json",
+		Hidden: true,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			if opts.address == "" {
+				return fmt.Errorf("--address flag is required")
+			}
+			for _, mode := range opts.modes {
+				if mode != probeModeLatency && mode != probeModeMTU && mode != probeModeBandwidth {
+					return fmt.Errorf("invalid mode %q, must be one of latency, mtu or bandwidth", mode)
+				}
+			}
+			if opts.maxMTU < netutils.MinProbeMTU {
+				return fmt.Errorf("--max-mtu must be at least %d", netutils.MinProbeMTU)
+			}
+			cmd.SilenceUsage = true
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGTERM, syscall.SIGINT)
+			defer cancel()
+
+			var result nodeProbeResult
+			var err error
+			for _, mode := range opts.modes {
+				switch mode {
+				case probeModeLatency:
+					if result.Latency, err = netutils.MeasureLatency(ctx, opts.address, opts.count, opts.timeout); err != nil {
+						return fmt.Errorf("failed to measure latency: %w", err)
+					}
+				case probeModeMTU:
+					if result.MTU, err = netutils.DiscoverPathMTU(ctx, opts.address, opts.maxMTU, opts.timeout); err != nil {
+						return fmt.Errorf("failed to discover path mtu: %w", err)
+					}
+					if result.OverlayMTUs, err = netutils.OverlayInterfaceMTUs(); err != nil {
+						return fmt.Errorf("failed to read overlay interfaces: %w", err)
+					}
+				case probeModeBandwidth:
+					if result.Bandwidth, err = netutils.MeasureBandwidth(ctx, opts.address, opts.duration); err != nil {
+						return fmt.Errorf("failed to measure bandwidth: %w", err)
+					}
+				}
+			}
+			return json.NewEncoder(cmd.OutOrStdout()).Encode(result)
+		},
+	}
+	cmd.Flags().StringVar(&opts.address, "address", "", "The address of the probe server as HOST:PORT.")
+	cmd.Flags().StringSliceVar(&opts.modes, "mode", []string{probeModeLatency}, "The measurements to take (latency, mtu or bandwidth).")
+	cmd.Flags().IntVar(&opts.count, "count", 10, "The number of latency probes.")
+	cmd.Flags().DurationVar(&opts.duration, "duration", 5*time.Second, "How long to send data when measuring the bandwidth.")
+	cmd.Flags().IntVar(&opts.maxMTU, "max-mtu", 9000, "The largest mtu probed.")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", time.Second, "How long to wait for each probe answer.")
+	return cmd
+}
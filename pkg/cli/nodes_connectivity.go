@@ -4,9 +4,14 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net"
+	"os"
 	"os/signal"
+	"slices"
+	"sort"
 	"strings"
 	"syscall"
 	"time"
@@ -30,7 +35,9 @@ import (
 	"github.com/replicatedhq/plumber/v2"
 	"github.com/spf13/cobra"
 
+	"github.com/replicatedhq/kurl/pkg/installer"
 	"github.com/replicatedhq/kurl/pkg/k8sutil"
+	"github.com/replicatedhq/kurl/pkg/netutils"
 	"github.com/replicatedhq/kurl/pkg/static/nodes_connectivity"
 )
 
@@ -42,25 +49,86 @@ const (
 kurl netutil nodes-connectivity --port 6472 --proto tcp
 # Test if all nodes can reach all other nodes using udp in port 7788.
 kurl netutil nodes-connectivity --port 7788 --proto udp
+# Test multiple ports in one run and print the results as json.
+kurl netutil nodes-connectivity --ports 6443/tcp,8472/udp --output json
+# Test the ports used by weave, the kubelet and etcd.
+kurl netutil nodes-connectivity --profile weave,kubelet,etcd
+# Test the ports used by the components in the installer spec.
+kurl netutil nodes-connectivity --installer /var/lib/kurl/installer.yaml
+# Measure the latency, path mtu and bandwidth between all nodes.
+kurl netutil nodes-connectivity --mode latency,mtu,bandwidth
+# Test pod to pod, pod to service and dns traffic from all nodes.
+kurl netutil nodes-connectivity --pod-network
 `
 )
 
+const (
+	// connectivityModeReachability tests if the targets can be reached, the other modes are measurements.
+	connectivityModeReachability = "connectivity"
+	probeServerContainerName     = "probe-server"
+)
+
 type logLine struct {
 	message string
 	err     error
 }
 
 type nodeConnectivityOptions struct {
-	printf    func(string, ...interface{})
-	namespace string
-	proto     string
-	attempts  int
-	cliset    kubernetes.Interface
-	image     string
-	port      int32
-	cli       client.Client
-	wait      time.Duration
-	verbose   bool
+	printf     func(string, ...interface{})
+	namespace  string
+	proto      string
+	attempts   int
+	cliset     kubernetes.Interface
+	image      string
+	port       int32
+	ports      []string
+	profiles   []string
+	installer  string
+	output     string
+	targets    []connectivityTarget
+	cli        client.Client
+	verbose    bool
+	modes      []string
+	probe      nodeProbeOptions
+	podNetwork bool
+}
+
+// nodeProbeOptions configures the latency, mtu and bandwidth measurements.
+type nodeProbeOptions struct {
+	port                  int32
+	count                 int
+	duration              time.Duration
+	maxMTU                int
+	overlayMTU            int
+	encapsulationOverhead int
+}
+
+// probeModes returns the measurements requested, connectivity excluded.
+func (n nodeConnectivityOptions) probeModes() []string {
+	var modes []string
+	for _, mode := range n.modes {
+		if mode != connectivityModeReachability {
+			modes = append(modes, mode)
+		}
+	}
+	return modes
+}
+
+// listenerTargets returns the ports bound by the listeners daemonset.
+func (n nodeConnectivityOptions) listenerTargets() []connectivityTarget {
+	targets := n.targets
+	if len(n.probeModes()) > 0 {
+		targets = append(targets,
+			connectivityTarget{Port: n.probe.port, Protocol: string(corev1.ProtocolTCP)},
+			connectivityTarget{Port: n.probe.port, Protocol: string(corev1.ProtocolUDP)},
+		)
+	}
+	return targets
+}
+
+// testsReachability returns true if the connectivity of the targets has to be tested.
+func (n nodeConnectivityOptions) testsReachability() bool {
+	return len(n.probeModes()) < len(n.modes)
 }
 
 func (n nodeConnectivityOptions) debugf(format string, args ...interface{}) {
@@ -74,16 +142,51 @@ func newNetutilNodesConnectivity(_ CLI) *cobra.Command {
 	var opts nodeConnectivityOptions
 	cmd := &cobra.Command{
 		Use:     "nodes-connectivity",
-		Short:   "Tests if all nodes can reach all other nodes using the provided protocol in the provided port",
+		Short:   "Tests if all nodes can reach all other nodes using the provided protocols and ports",
 		Example: usageExamples,
 		PreRunE: func(cmd *cobra.Command, _ []string) error {
-			if opts.port == 0 {
-				return fmt.Errorf("--port flag is required")
-			}
 			opts.proto = strings.ToUpper(opts.proto)
 			if proto := corev1.Protocol(opts.proto); proto != corev1.ProtocolTCP && proto != corev1.ProtocolUDP {
 				return fmt.Errorf("--protocol must be either tcp or udp")
 			}
+			if opts.output != "text" && opts.output != "json" {
+				return fmt.Errorf("--output must be either text or json")
+			}
+			for _, mode := range opts.modes {
+				switch mode {
+				case connectivityModeReachability, probeModeLatency, probeModeMTU, probeModeBandwidth:
+				default:
+					return fmt.Errorf("invalid mode %q, must be one of connectivity, latency, mtu or bandwidth", mode)
+				}
+			}
+			if opts.probe.maxMTU < netutils.MinProbeMTU {
+				return fmt.Errorf("--max-mtu must be at least %d", netutils.MinProbeMTU)
+			}
+			ports := opts.ports
+			if opts.port != 0 {
+				ports = append([]string{fmt.Sprintf("%d/%s", opts.port, opts.proto)}, ports...)
+			}
+			profiles := opts.profiles
+			if opts.installer != "" {
+				data, err := os.ReadFile(opts.installer)
+				if err != nil {
+					return fmt.Errorf("failed to read installer: %w", err)
+				}
+				spec, err := installer.DecodeSpec(data)
+				if err != nil {
+					return fmt.Errorf("failed to decode installer: %w", err)
+				}
+				profiles = append(profiles, profilesFromInstaller(spec)...)
+			}
+			// the host network ports are optional when the pod network is tested.
+			hostPortsOptional := opts.podNetwork && len(ports) == 0 && len(profiles) == 0
+			if opts.testsReachability() && !hostPortsOptional {
+				targets, err := buildConnectivityTargets(ports, opts.proto, profiles)
+				if err != nil {
+					return err
+				}
+				opts.targets = targets
+			}
 			// now that all input args have been validated we can silence the usage print upon error.
 			cmd.SilenceUsage = true
 			cfg, err := config.GetConfig()
@@ -101,13 +204,20 @@ func newNetutilNodesConnectivity(_ CLI) *cobra.Command {
 			opts.cli = cli
 			opts.cliset = cliset
 			opts.printf = cmd.Printf
-			opts.wait = time.Second
-			if corev1.Protocol(opts.proto) == corev1.ProtocolUDP {
-				opts.wait = 5 * time.Second
+
+			// the ports of a running pod network can not be tested, explicitly requested profiles are rejected while
+			// the ones derived from the installer are skipped.
+			running, err := runningPodNetworkProfiles(cmd.Context(), cliset, profiles)
+			if err != nil {
+				return err
 			}
-			if corev1.Protocol(opts.proto) == corev1.ProtocolTCP {
-				opts.attempts = 1
+			for _, profile := range running {
+				if slices.Contains(opts.profiles, profile) {
+					return fmt.Errorf("profile %s can only be tested before %s is installed, its ports are in use", profile, profile)
+				}
+				opts.printf("Skipping profile %s, %s is running and its ports are in use.\n", profile, profile)
 			}
+			opts.targets = withoutProfiles(opts.targets, running)
 			return nil
 		},
 		RunE: func(cmd *cobra.Command, _ []string) error {
@@ -123,23 +233,96 @@ func newNetutilNodesConnectivity(_ CLI) *cobra.Command {
 				if err := deleteListeners(ctx, opts); err != nil {
 					opts.printf("Failed to delete DaemonSet listeners overlay: %s\n", err)
 				}
+				if opts.podNetwork {
+					opts.debugf("Deleting pod network listeners")
+					if err := deletePodNetworkListeners(ctx, opts); err != nil {
+						opts.printf("Failed to delete pod network listeners: %s\n", err)
+					}
+				}
 				opts.debugf("Deleting pinger job")
 				if err := deletePinger(ctx, opts); err != nil {
 					opts.printf("Failed to delete pinger job: %s\n", err)
 				}
 			}()
 
-			opts.printf("Testing intra nodes connectivity using port %d/%s.\n", opts.port, opts.proto)
+			if len(opts.targets) > 0 {
+				opts.printf("Testing intra nodes connectivity using ports %s.\n", targetsString(opts.targets))
+			}
+			if modes := opts.probeModes(); len(modes) > 0 {
+				opts.printf("Measuring %s between nodes using port %d.\n", strings.Join(modes, ", "), opts.probe.port)
+			}
+			if opts.podNetwork {
+				opts.printf("Testing pod to pod, pod to service and dns traffic on the pod network.\n")
+			}
 			opts.printf("Connection between all nodes will be attempted, this can take a while.\n")
-			if err := deployListenersDaemonset(ctx, opts); err != nil {
-				return fmt.Errorf("Failed to deploy listeners: %w", err)
+			if len(opts.listenerTargets()) > 0 {
+				if err := deployListenersDaemonset(ctx, opts); err != nil {
+					return fmt.Errorf("Failed to deploy listeners: %w", err)
+				}
+			}
+			if opts.podNetwork {
+				if err := deployPodNetworkListeners(ctx, opts); err != nil {
+					return fmt.Errorf("Failed to deploy pod network listeners: %w", err)
+				}
 			}
 
-			if err := testNodesConnectivity(ctx, opts); err != nil {
+			matrix, err := testNodesConnectivity(ctx, opts)
+			if err != nil {
 				return fmt.Errorf("Failed to test nodes connectivity: %w", err)
 			}
 
-			opts.printf("All nodes can reach all nodes using %s protocol in port %d.\n", opts.proto, opts.port)
+			if opts.output == "json" {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(matrix); err != nil {
+					return fmt.Errorf("failed to encode results: %w", err)
+				}
+			} else {
+				opts.printf("\n")
+				if err := matrix.writeText(cmd.OutOrStdout()); err != nil {
+					return fmt.Errorf("failed to print results: %w", err)
+				}
+			}
+
+			if errs := matrix.measurementErrors(); len(errs) > 0 {
+				opts.printf("\n")
+				for _, measurement := range errs {
+					opts.printf("Measurement from %s to %s failed: %s\n", measurement.Source, measurement.Destination, measurement.Error)
+				}
+				return fmt.Errorf("%d measurements between nodes failed", len(errs))
+			}
+
+			if failures := podNetworkFailures(matrix.PodNetwork); len(failures) > 0 {
+				opts.printf("\n")
+				count := 0
+				for _, layer := range []string{podNetworkLayerCNI, podNetworkLayerKubeProxy, podNetworkLayerDNS} {
+					if len(failures[layer]) == 0 {
+						continue
+					}
+					for _, failure := range failures[layer] {
+						opts.printf("Pod network %s check from %s to %s failed: %s\n", layer, failure.Source, failure.Target, failure.Error)
+					}
+					opts.printf("%s\n", podNetworkLayerHints[layer])
+					count += len(failures[layer])
+				}
+				return fmt.Errorf("%d pod network checks failed", count)
+			}
+
+			if failures := matrix.failures(); len(failures) > 0 {
+				opts.printf("\n")
+				for _, failure := range failures {
+					opts.printf("Attempt to connect from %s to %s on %d (%s) failed.\n", failure.Source, failure.Destination, failure.Port, failure.Protocol)
+				}
+				opts.printf("Please verify if the active network policies are not blocking the connection.\n")
+				return fmt.Errorf("%d connections between nodes failed", len(failures))
+			}
+
+			if len(opts.targets) > 0 {
+				opts.printf("All nodes can reach all nodes using ports %s.\n", targetsString(opts.targets))
+			}
+			if opts.podNetwork {
+				opts.printf("Pod to pod, pod to service and dns traffic work from all nodes.\n")
+			}
 			return nil
 		},
 	}
@@ -147,14 +330,26 @@ func newNetutilNodesConnectivity(_ CLI) *cobra.Command {
 	cmd.Flags().StringVar(&opts.namespace, "namespace", "default", "The namespace to use during the test.")
 	cmd.Flags().StringVar(&opts.image, "image", "replicated/kurl-util:latest", "The image to use for the test (image must contain bash, nc and echo).")
 	cmd.Flags().Int32Var(&opts.port, "port", 0, "The port to use for the test.")
+	cmd.Flags().StringSliceVar(&opts.ports, "ports", nil, "The ports to use for the test as PORT[/PROTOCOL], the protocol defaults to --proto.")
+	cmd.Flags().StringSliceVar(&opts.profiles, "profile", nil, fmt.Sprintf("The profiles to test, one of %s. The flannel and weave profiles can only be tested before the add-on is installed.", strings.Join(connectivityProfileNames(), ", ")))
+	cmd.Flags().StringVar(&opts.installer, "installer", "", "An installer spec to derive the profiles to test from.")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "text", "The output format (either text or json).")
 	cmd.Flags().IntVar(&opts.attempts, "udp-attempts", 5, "The number of connection attempts when using udp.")
 	cmd.Flags().BoolVar(&opts.verbose, "verbose", false, "Enable verbose output.")
+	cmd.Flags().StringSliceVar(&opts.modes, "mode", []string{connectivityModeReachability}, "The tests to run: connectivity, latency, mtu or bandwidth.")
+	cmd.Flags().Int32Var(&opts.probe.port, "probe-port", 9797, "The tcp and udp port used to measure latency, mtu and bandwidth.")
+	cmd.Flags().IntVar(&opts.probe.count, "latency-count", 10, "The number of probes sent to measure the latency.")
+	cmd.Flags().DurationVar(&opts.probe.duration, "bandwidth-duration", 5*time.Second, "How long to send data to measure the bandwidth.")
+	cmd.Flags().IntVar(&opts.probe.maxMTU, "max-mtu", 9000, "The largest mtu probed during path mtu discovery.")
+	cmd.Flags().IntVar(&opts.probe.overlayMTU, "overlay-mtu", 0, "The mtu of the overlay network, detected from the cni interfaces if not set.")
+	cmd.Flags().BoolVar(&opts.podNetwork, "pod-network", false, "Also test pod to pod, pod to service and dns traffic using pods on the pod network.")
+	cmd.Flags().IntVar(&opts.probe.encapsulationOverhead, "encapsulation-overhead", 50, "The bytes added by the overlay network to each packet (50 for vxlan).")
 	return cmd
 }
 
 // printDaemonsetStatus prints the status of the daemonset. this function also prints the pod statuses.
 func printDaemonsetStatus(ctx context.Context, opts nodeConnectivityOptions, ds *appsv1.DaemonSet) {
-	opts.printf("DaemonSet failed to deploy, that can possibly mean that port %d (%s) is in use.\n", opts.port, opts.proto)
+	opts.printf("DaemonSet failed to deploy, that can possibly mean that one of the ports %s is in use.\n", targetsString(opts.listenerTargets()))
 	buffer := bytes.NewBuffer([]byte("\n"))
 	table := &metav1.Table{}
 	request := opts.cliset.AppsV1().RESTClient().Get().
@@ -190,7 +385,7 @@ func printDaemonsetStatus(ctx context.Context, opts nodeConnectivityOptions, ds
 }
 
 // deployListenersDaemonset deploys a daemonset that will run a pod that listens for udp or tcp packets using the node's
-// network. port and protocol are read from the nodeConnectivityOptions.
+// network. the pod runs one listener container per target read from the nodeConnectivityOptions.
 func deployListenersDaemonset(ctx context.Context, opts nodeConnectivityOptions) error {
 	opts.printf("Deploying node connectivity listeners DaemonSet.\n")
 	options := []plumber.Option{
@@ -202,8 +397,10 @@ func deployListenersDaemonset(ctx context.Context, opts nodeConnectivityOptions)
 					return fmt.Errorf("failed to build tolerations: %w", err)
 				}
 				ds.Spec.Template.Spec.Tolerations = tolerations
-				ds.Spec.Template.Spec.Containers[0].Env = []corev1.EnvVar{
-					{Name: "PORT", Value: fmt.Sprintf("%d", opts.port)},
+				model := ds.Spec.Template.Spec.Containers[0]
+				ds.Spec.Template.Spec.Containers = listenerContainers(model, opts.targets)
+				if len(opts.probeModes()) > 0 {
+					ds.Spec.Template.Spec.Containers = append(ds.Spec.Template.Spec.Containers, probeServerContainer(model, opts.probe.port))
 				}
 			}
 			data, _ := yaml.Marshal(obj)
@@ -223,60 +420,101 @@ func deployListenersDaemonset(ctx context.Context, opts nodeConnectivityOptions)
 			return nil
 		}),
 	}
-	overlay := fmt.Sprintf("%s-listeners", strings.ToLower(opts.proto))
 	renderer := plumber.NewRenderer(opts.cli, nodes_connectivity.Static, options...)
-	if err := renderer.Apply(ctx, overlay); err != nil {
+	if err := renderer.Apply(ctx, "listeners"); err != nil {
 		return fmt.Errorf("failed to create listeners DaemonSet: %w", err)
 	}
 	opts.printf("Listeners DaemonSet deployed successfully.\n")
 	return nil
 }
 
-// attachToListenersPods attaches to all provided pod logs. returns a channel from where printed messages (or errors) can
-// be read.
+// listenerContainers returns a copy of the model container for each target.
+func listenerContainers(model corev1.Container, targets []connectivityTarget) []corev1.Container {
+	var containers []corev1.Container
+	for _, target := range targets {
+		container := *model.DeepCopy()
+		container.Name = target.containerName()
+		container.Args = []string{target.listenerCommand()}
+		container.Env = []corev1.EnvVar{
+			{Name: "PORT", Value: fmt.Sprintf("%d", target.Port)},
+		}
+		containers = append(containers, container)
+	}
+	return containers
+}
+
+// probeServerContainer returns a copy of the model container that answers the latency, mtu and bandwidth probes.
+func probeServerContainer(model corev1.Container, port int32) corev1.Container {
+	container := *model.DeepCopy()
+	container.Name = probeServerContainerName
+	container.Args = []string{"exec /usr/local/bin/kurl netutil probe-server --port $PORT"}
+	container.Env = []corev1.EnvVar{
+		{Name: "PORT", Value: fmt.Sprintf("%d", port)},
+	}
+	return container
+}
+
+func targetsString(targets []connectivityTarget) string {
+	var values []string
+	for _, target := range targets {
+		values = append(values, target.String())
+	}
+	return strings.Join(values, ", ")
+}
+
+// attachToListenersPods attaches to the logs of all listener containers in the provided pods. returns a channel from
+// where printed messages (or errors) can be read.
 func attachToListenersPods(ctx context.Context, opts nodeConnectivityOptions, pods []corev1.Pod) (<-chan logLine, error) {
 	var out = make(chan logLine)
 	for _, pod := range pods {
 		opts.printf("Attaching to pod %s\n", pod.Name)
-		errs := make(chan error)
-		go func(pod corev1.Pod) {
-			logopts := &corev1.PodLogOptions{Follow: true}
-			req := opts.cliset.CoreV1().Pods(opts.namespace).GetLogs(pod.Name, logopts)
-			stream, err := req.Stream(ctx)
-			if err != nil {
-				errs <- fmt.Errorf("failed to attach to pod %s: %w", pod.Name, err)
-				return
-			}
-			defer stream.Close()
-
-			errs <- nil
-			scanner := bufio.NewScanner(stream)
-			scanner.Split(bufio.ScanLines)
-			opts.debugf("Waiting for logs on pod %s\n", pod.Name)
-			for scanner.Scan() {
-				txt := scanner.Text()
-				opts.debugf("Pod %s log: %s\n", pod.Name, txt)
-				if _, err := uuid.Parse(txt); err != nil {
-					opts.debugf("Pod line is not a UUID\n")
-					out <- logLine{err: fmt.Errorf("invalid output found: %s", txt)}
-					continue
-				}
-				opts.debugf("Pod line is a UUID\n")
-				out <- logLine{message: txt}
+		for _, container := range pod.Spec.Containers {
+			if container.Name == probeServerContainerName {
+				continue
 			}
-			if err := scanner.Err(); err != nil {
-				opts.debugf("Error closing scanner on pod %s: %v\n", pod.Name, err)
-				out <- logLine{err: fmt.Errorf("failed to read logs for pod %s: %w", pod.Name, err)}
+			if err := attachToListenerContainer(ctx, opts, pod, container.Name, out); err != nil {
+				return nil, err
 			}
-		}(pod)
-		if err := <-errs; err != nil {
-			opts.debugf("Fail to attach to pod %s: %v\n", pod.Name, err)
-			return nil, err
 		}
 	}
 	return out, nil
 }
 
+// attachToListenerContainer follows the logs of a listener container in the background, sending them to out. returns
+// once the logs stream has been opened.
+func attachToListenerContainer(ctx context.Context, opts nodeConnectivityOptions, pod corev1.Pod, container string, out chan<- logLine) error {
+	logopts := &corev1.PodLogOptions{Follow: true, Container: container}
+	req := opts.cliset.CoreV1().Pods(opts.namespace).GetLogs(pod.Name, logopts)
+	stream, err := req.Stream(ctx)
+	if err != nil {
+		opts.debugf("Fail to attach to pod %s container %s: %v\n", pod.Name, container, err)
+		return fmt.Errorf("failed to attach to pod %s container %s: %w", pod.Name, container, err)
+	}
+
+	go func() {
+		defer stream.Close()
+		scanner := bufio.NewScanner(stream)
+		scanner.Split(bufio.ScanLines)
+		opts.debugf("Waiting for logs on pod %s container %s\n", pod.Name, container)
+		for scanner.Scan() {
+			txt := scanner.Text()
+			opts.debugf("Pod %s container %s log: %s\n", pod.Name, container, txt)
+			if _, err := uuid.Parse(txt); err != nil {
+				opts.debugf("Pod line is not a UUID\n")
+				out <- logLine{err: fmt.Errorf("invalid output found: %s", txt)}
+				continue
+			}
+			opts.debugf("Pod line is a UUID\n")
+			out <- logLine{message: txt}
+		}
+		if err := scanner.Err(); err != nil {
+			opts.debugf("Error closing scanner on pod %s: %v\n", pod.Name, err)
+			out <- logLine{err: fmt.Errorf("failed to read logs for pod %s: %w", pod.Name, err)}
+		}
+	}()
+	return nil
+}
+
 // kustomizeMutator returns a kustomize mutator that sets the namespace and image.
 func kustomizeMutator(opts nodeConnectivityOptions) plumber.KustomizeMutator {
 	image := types.Image{Name: "nodes-connectivity-image", NewName: opts.image}
@@ -316,29 +554,17 @@ func deletePinger(ctx context.Context, opts nodeConnectivityOptions) error {
 	return nil
 }
 
-// runPinger creates a job that inherit the provided pod affinity and tolerations. the job then attempts to send an
-// uuid (as string) through a network connection to the target ip and configured port/protocol. returns the sent uuid.
-// this function returns when the job has been finished. XXX this could also have been implemented by spawning a new
-// command inside the provided pod (instead of creating a new job).
-func runPinger(ctx context.Context, opts nodeConnectivityOptions, model corev1.Pod, targetIP string) (string, error) {
-	id := uuid.New().String()
+// applyPinger creates a job that inherit the provided pod affinity, the job pod is customized by the provided mutate
+// function. returns if the job succeeded once it has finished, the job is not deleted.
+func applyPinger(ctx context.Context, opts nodeConnectivityOptions, model corev1.Pod, timeout time.Duration, mutate func(*corev1.PodSpec)) (bool, error) {
+	var succeeded bool
 	options := []plumber.Option{
 		plumber.WithKustomizeMutator(kustomizeMutator(opts)),
 		plumber.WithObjectMutator(func(_ context.Context, obj client.Object) error {
 			if job, ok := obj.(*batchv1.Job); ok {
-				env := []corev1.EnvVar{
-					{Name: "NODEIP", Value: targetIP},
-					{Name: "NODEPORT", Value: fmt.Sprint(opts.port)},
-					{Name: "UUID", Value: id},
-				}
-				args := corev1.EnvVar{Name: "NCARGS", Value: "-w 2"}
-				if corev1.Protocol(opts.proto) == corev1.ProtocolUDP {
-					args = corev1.EnvVar{Name: "NCARGS", Value: "-uw 2"}
-				}
-				env = append(env, args)
 				job.Spec.Template.Spec.Affinity = model.Spec.Affinity
 				//job.Spec.Template.Spec.Tolerations = model.Spec.Tolerations
-				job.Spec.Template.Spec.Containers[0].Env = env
+				mutate(&job.Spec.Template.Spec)
 			}
 			data, _ := yaml.Marshal(obj)
 			opts.debugf("Creating object:\n%s", string(data))
@@ -347,7 +573,8 @@ func runPinger(ctx context.Context, opts nodeConnectivityOptions, model corev1.P
 		plumber.WithPostApplyAction(func(ctx context.Context, obj client.Object) error {
 			if job, ok := obj.(*batchv1.Job); ok {
 				opts.debugf("Waiting for job %s to finish\n", job.Name)
-				if _, err := k8sutil.WaitForJob(ctx, opts.cliset, job, time.Minute); err != nil {
+				var err error
+				if succeeded, err = k8sutil.WaitForJob(ctx, opts.cliset, job, timeout); err != nil {
 					return fmt.Errorf("failed to create job: %w", err)
 				}
 				opts.debugf("Job %s finished\n", job.Name)
@@ -357,16 +584,166 @@ func runPinger(ctx context.Context, opts nodeConnectivityOptions, model corev1.P
 	}
 	renderer := plumber.NewRenderer(opts.cli, nodes_connectivity.Static, options...)
 	if err := renderer.Apply(ctx, "pinger"); err != nil {
-		return "", fmt.Errorf("failed to apply pinger job: %w", err)
+		return false, fmt.Errorf("failed to apply pinger job: %w", err)
+	}
+	return succeeded, nil
+}
+
+// runPinger creates a job that inherit the provided pod affinity and tolerations. the job then attempts to send an
+// uuid (as string) through a network connection to the target ip and port/protocol. returns the sent uuid and if the
+// job succeeded, for tcp a successful job means the connection has been established. this function returns when the
+// job has been finished. XXX this could also have been implemented by spawning a new command inside the provided pod
+// (instead of creating a new job).
+func runPinger(ctx context.Context, opts nodeConnectivityOptions, model corev1.Pod, targetIP string, target connectivityTarget) (string, bool, error) {
+	id := uuid.New().String()
+	succeeded, err := applyPinger(ctx, opts, model, time.Minute, func(spec *corev1.PodSpec) {
+		container := &spec.Containers[0]
+		container.Env = []corev1.EnvVar{
+			{Name: "NODEIP", Value: targetIP},
+			{Name: "NODEPORT", Value: fmt.Sprint(target.Port)},
+			{Name: "UUID", Value: id},
+			{Name: "NCARGS", Value: "-w 2"},
+		}
+		if corev1.Protocol(target.Protocol) == corev1.ProtocolUDP {
+			container.Env[3].Value = "-uw 2"
+		}
+	})
+	if err != nil {
+		return "", false, err
+	}
+	if err := deletePinger(ctx, opts); err != nil {
+		return "", false, fmt.Errorf("failed to delete pinger job: %w", err)
+	}
+	return id, succeeded, nil
+}
+
+// runProbe creates a pinger job that measures the latency, mtu or bandwidth to the probe server running on the target
+// ip. returns the results printed by the job.
+func runProbe(ctx context.Context, opts nodeConnectivityOptions, model corev1.Pod, targetIP string) (*nodeProbeResult, error) {
+	address := net.JoinHostPort(targetIP, fmt.Sprint(opts.probe.port))
+	command := fmt.Sprintf(
+		"exec /usr/local/bin/kurl netutil probe --address %s --mode %s --count %d --duration %s --max-mtu %d",
+		address, strings.Join(opts.probeModes(), ","), opts.probe.count, opts.probe.duration, opts.probe.maxMTU,
+	)
+	timeout := 2*time.Minute + opts.probe.duration + time.Duration(opts.probe.count)*time.Second
+	logs, err := runPingerCommand(ctx, opts, model, timeout, func(spec *corev1.PodSpec) {
+		spec.Containers[0].Args = []string{command}
+	})
+	if err != nil {
+		return nil, err
+	}
+	var result nodeProbeResult
+	if err := json.Unmarshal(logs, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse probe results %q: %w", string(logs), err)
 	}
+	return &result, nil
+}
+
+// runPingerCommand creates a pinger job customized by the provided mutate function, waits for it to finish and deletes
+// it. returns the job logs, an error is returned if the job failed.
+func runPingerCommand(ctx context.Context, opts nodeConnectivityOptions, model corev1.Pod, timeout time.Duration, mutate func(*corev1.PodSpec)) ([]byte, error) {
+	succeeded, err := applyPinger(ctx, opts, model, timeout, mutate)
+	if err != nil {
+		return nil, err
+	}
+	logs, logsErr := pingerLogs(ctx, opts)
 	if err := deletePinger(ctx, opts); err != nil {
-		return "", fmt.Errorf("failed to delete pinger job: %w", err)
+		return nil, fmt.Errorf("failed to delete pinger job: %w", err)
+	}
+	if logsErr != nil {
+		return nil, logsErr
 	}
-	return id, nil
+	if !succeeded {
+		return nil, fmt.Errorf("pinger job failed: %s", strings.TrimSpace(string(logs)))
+	}
+	return logs, nil
 }
 
-// testNodesConnectivity tests the connectivity between the cluster nodes.
-func testNodesConnectivity(ctx context.Context, opts nodeConnectivityOptions) error {
+// pingerLogs returns the logs of the pinger job pod.
+func pingerLogs(ctx context.Context, opts nodeConnectivityOptions) ([]byte, error) {
+	pods, err := k8sutil.ListPodsBySelector(ctx, opts.cliset, opts.namespace, pingerSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pinger pods: %w", err)
+	}
+	if len(pods.Items) == 0 {
+		return nil, fmt.Errorf("pinger pod not found")
+	}
+	logopts := &corev1.PodLogOptions{Container: "nodes-connectivity-pinger"}
+	logs, err := opts.cliset.CoreV1().Pods(opts.namespace).GetLogs(pods.Items[0].Name, logopts).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pinger pod %s logs: %w", pods.Items[0].Name, err)
+	}
+	return logs, nil
+}
+
+// measureNodes measures the latency, mtu or bandwidth from every node to every other node. a failed measurement is
+// reported in the result instead of returning an error.
+func measureNodes(ctx context.Context, opts nodeConnectivityOptions, pods []corev1.Pod, nodes []corev1.Node) ([]nodeMeasurement, error) {
+	var measurements []nodeMeasurement
+	for _, node := range nodes {
+		dstIP, err := k8sutil.NodeInternalIP(node)
+		if err != nil {
+			return nil, fmt.Errorf("failed to determine node %s ip: %w", node.Name, err)
+		}
+		for _, pod := range pods {
+			src, dst := pod.Spec.NodeName, node.Name
+			if src == dst {
+				continue
+			}
+			opts.printf("Measuring %s from %s to %s\n", strings.Join(opts.probeModes(), ", "), src, dst)
+			measurement := nodeMeasurement{Source: src, Destination: dst}
+			result, err := runProbe(ctx, opts, pod, dstIP)
+			if err != nil {
+				if ctx.Err() != nil {
+					return nil, err
+				}
+				measurement.Error = err.Error()
+			} else {
+				measurement.nodeProbeResult = *result
+				measurement.Warnings = mtuWarnings(measurement, opts.probe.overlayMTU, opts.probe.encapsulationOverhead)
+			}
+			measurements = append(measurements, measurement)
+		}
+	}
+	sort.SliceStable(measurements, func(i, j int) bool {
+		if measurements[i].Source != measurements[j].Source {
+			return measurements[i].Source < measurements[j].Source
+		}
+		return measurements[i].Destination < measurements[j].Destination
+	})
+	return measurements, nil
+}
+
+// testNodesConnectivity tests the connectivity between the cluster nodes for all targets. returns the reachability
+// matrix, failing to reach a node is not an error.
+func testNodesConnectivity(ctx context.Context, opts nodeConnectivityOptions) (*connectivityMatrix, error) {
+	var nodes corev1.NodeList
+	if err := opts.cli.List(ctx, &nodes); err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	matrix := &connectivityMatrix{Targets: opts.targets}
+	for _, node := range nodes.Items {
+		matrix.Nodes = append(matrix.Nodes, node.Name)
+	}
+	sort.Strings(matrix.Nodes)
+
+	if len(opts.listenerTargets()) > 0 {
+		if err := testHostNetwork(ctx, opts, nodes.Items, matrix); err != nil {
+			return nil, err
+		}
+	}
+	if opts.podNetwork {
+		var err error
+		if matrix.PodNetwork, err = testPodNetwork(ctx, opts); err != nil {
+			return nil, err
+		}
+	}
+	return matrix, nil
+}
+
+// testHostNetwork tests the targets and takes the measurements using the listeners running on the host network,
+// results are stored in the provided matrix.
+func testHostNetwork(ctx context.Context, opts nodeConnectivityOptions, nodes []corev1.Node, matrix *connectivityMatrix) error {
 	pods, err := k8sutil.ListPodsBySelector(ctx, opts.cliset, opts.namespace, listenersSelector)
 	if err != nil {
 		return fmt.Errorf("failed to get listener pods: %w", err)
@@ -378,83 +755,91 @@ func testNodesConnectivity(ctx context.Context, opts nodeConnectivityOptions) er
 	if err != nil {
 		return fmt.Errorf("failed to attach to listeners: %w", err)
 	}
-	var nodes corev1.NodeList
-	if err := opts.cli.List(ctx, &nodes); err != nil {
-		return fmt.Errorf("failed to list nodes: %w", err)
+	if len(opts.probeModes()) > 0 {
+		if matrix.Measurements, err = measureNodes(ctx, opts, pods.Items, nodes); err != nil {
+			return err
+		}
 	}
-	for _, node := range nodes.Items {
-		if err := connectToNodeFromPods(ctx, opts, node, receiver); err != nil {
-			return fmt.Errorf("node %s: %w", node.Name, err)
+	for _, target := range opts.targets {
+		for _, node := range nodes {
+			results, err := connectToNodeFromPods(ctx, opts, pods.Items, node, target, receiver)
+			if err != nil {
+				return fmt.Errorf("node %s: %w", node.Name, err)
+			}
+			matrix.Results = append(matrix.Results, results...)
 		}
 	}
 	return nil
 }
 
-func readLogLine(ctx context.Context, opts nodeConnectivityOptions, receiver <-chan logLine) (string, error) {
-	select {
-	case line := <-receiver:
-		opts.debugf("Event received from listener pods: message: %s err: %v\n", line.message, line.err)
-		return line.message, line.err
-	case <-ctx.Done():
-		return "", fmt.Errorf("failed while waiting for logs: %w", ctx.Err())
-	case <-time.After(opts.wait):
-		opts.debugf("Timeout while waiting for logs\n")
-		return "", nil
+// waitForPacket reads the listeners logs until the provided id is received or the wait time is over. lines with other
+// ids, e.g. delayed udp packets of previous attempts, are ignored.
+func waitForPacket(ctx context.Context, opts nodeConnectivityOptions, receiver <-chan logLine, id string, wait time.Duration) (bool, error) {
+	timeout := time.After(wait)
+	for {
+		select {
+		case line := <-receiver:
+			opts.debugf("Event received from listener pods: message: %s err: %v\n", line.message, line.err)
+			if line.err != nil {
+				return false, line.err
+			}
+			opts.debugf("Received %s, expected %s\n", line.message, id)
+			if line.message == id {
+				return true, nil
+			}
+		case <-ctx.Done():
+			return false, fmt.Errorf("failed while waiting for logs: %w", ctx.Err())
+		case <-timeout:
+			opts.debugf("Timeout while waiting for logs\n")
+			return false, nil
+		}
 	}
 }
 
 // connectToNodeFromPods connects to the provided node by spawning pods in all other nodes and verifying they can reach
-// the destination ip address and port.
-func connectToNodeFromPods(ctx context.Context, opts nodeConnectivityOptions, node corev1.Node, receiver <-chan logLine) error {
-	pods, err := k8sutil.ListPodsBySelector(ctx, opts.cliset, opts.namespace, listenersSelector)
-	if err != nil {
-		return fmt.Errorf("failed to get listener pods: %w", err)
-	}
+// the destination ip address and target port.
+func connectToNodeFromPods(ctx context.Context, opts nodeConnectivityOptions, pods []corev1.Pod, node corev1.Node, target connectivityTarget, receiver <-chan logLine) ([]connectivityResult, error) {
 	dstIP, err := k8sutil.NodeInternalIP(node)
 	if err != nil {
-		return fmt.Errorf("failed to determine node %s ip: %w", node.Name, err)
+		return nil, fmt.Errorf("failed to determine node %s ip: %w", node.Name, err)
 	}
-	for _, pod := range pods.Items {
+	var results []connectivityResult
+	attempts := opts.attemptsFor(target)
+	for _, pod := range pods {
 		src, dst := pod.Spec.NodeName, node.Name
 		if src == dst {
 			continue
 		}
-		var success bool
-		for i := 1; i <= opts.attempts; i++ {
-			opts.printf("Testing connection from %s to %s (%d/%d)\n", src, dst, i, opts.attempts)
-			id, err := runPinger(ctx, opts, pod, dstIP)
+		result := connectivityResult{Source: src, Destination: dst, Port: target.Port, Protocol: target.Protocol}
+		for i := 1; i <= attempts; i++ {
+			result.Attempts = i
+			opts.printf("Testing connection from %s to %s on %s (%d/%d)\n", src, dst, target, i, attempts)
+			id, succeeded, err := runPinger(ctx, opts, pod, dstIP, target)
 			if err != nil {
-				return fmt.Errorf("failed to connect node %s from node %s: %w", src, dst, err)
+				return nil, fmt.Errorf("failed to connect node %s from node %s: %w", src, dst, err)
 			}
 			opts.debugf("Reading logs from listeners\n")
-			line, err := readLogLine(ctx, opts, receiver)
+			received, err := waitForPacket(ctx, opts, receiver, id, opts.waitFor(target))
 			if err != nil {
-				return fmt.Errorf("failed to read log line: %w", err)
+				return nil, fmt.Errorf("failed to read log line: %w", err)
 			}
-			opts.debugf("Received %s, expected %s\n", line, id)
-			if success = line == id; success {
+			tcpConnected := succeeded && corev1.Protocol(target.Protocol) == corev1.ProtocolTCP
+			if result.Reachable = received || tcpConnected; result.Reachable {
 				opts.printf("Success, packet received.\n")
 				break
 			}
 			opts.printf("Failed to connect from %s to %s\n", src, dst)
 		}
-		if success {
-			continue
-		}
-		opts.printf("\n")
-		opts.printf("Attempt to connect from %s to %s on %d (%s) failed.\n", src, dst, opts.port, opts.proto)
-		opts.printf("Please verify if the active network policies are not blocking the connection.\n")
-		return fmt.Errorf("failed to connect from %s to %s", src, dst)
+		results = append(results, result)
 	}
-	return nil
+	return results, nil
 }
 
-// deleteListeners deletes the listeners daemonset and service.
+// deleteListeners deletes the listeners daemonset.
 func deleteListeners(ctx context.Context, opts nodeConnectivityOptions) error {
 	opt := plumber.WithKustomizeMutator(kustomizeMutator(opts))
-	overlay := fmt.Sprintf("%s-listeners", strings.ToLower(opts.proto))
 	renderer := plumber.NewRenderer(opts.cli, nodes_connectivity.Static, opt)
-	if err := renderer.Delete(ctx, overlay); err != nil {
+	if err := renderer.Delete(ctx, "listeners"); err != nil {
 		return fmt.Errorf("failed to delete daemonset listeners overlay: %w", err)
 	}
 	return nil
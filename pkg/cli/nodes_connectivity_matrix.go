@@ -0,0 +1,379 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	kurlv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+)
+
+// connectivityTarget is a port and protocol tested between all nodes.
+type connectivityTarget struct {
+	Port     int32  `json:"port"`
+	Protocol string `json:"protocol"`
+	Profile  string `json:"profile,omitempty"`
+}
+
+func (t connectivityTarget) String() string {
+	return fmt.Sprintf("%d/%s", t.Port, strings.ToLower(t.Protocol))
+}
+
+// containerName returns the name of the listener container for the target.
+func (t connectivityTarget) containerName() string {
+	return fmt.Sprintf("listener-%s-%d", strings.ToLower(t.Protocol), t.Port)
+}
+
+// listenerCommand returns the command run by the listener container. tcp ports already in use on
+// the node (e.g. by the kubelet) are not bound again, the connection from the pinger is enough to
+// tell the port is reachable. udp ports already in use (found in /proc/net/udp) are not bound
+// either so the daemonset does not crash loop, but as udp has no connection they can not be tested
+// and are reported as failed. the pod network profiles are excluded once the add-on is running for
+// this reason, see runningPodNetworkProfiles.
+func (t connectivityTarget) listenerCommand() string {
+	if corev1.Protocol(t.Protocol) == corev1.ProtocolUDP {
+		return `if grep -qE "^ *[0-9]+: [0-9A-F]+:$(printf '%04X' $PORT) " /proc/net/udp /proc/net/udp6; then exec sleep infinity; fi; exec /usr/bin/nc -kulw 0 $PORT`
+	}
+	return "if /usr/bin/nc -z 127.0.0.1 $PORT; then exec sleep infinity; fi; exec /usr/bin/nc -kl $PORT"
+}
+
+// connectivityProfiles are the ports used by the kurl components, tested with --profile.
+var connectivityProfiles = map[string][]connectivityTarget{
+	"api-server": {{Port: 6443, Protocol: "TCP"}},
+	"etcd":       {{Port: 2379, Protocol: "TCP"}, {Port: 2380, Protocol: "TCP"}},
+	"flannel":    {{Port: 8472, Protocol: "UDP"}},
+	"kubelet":    {{Port: 10250, Protocol: "TCP"}},
+	"weave":      {{Port: 6783, Protocol: "TCP"}, {Port: 6783, Protocol: "UDP"}, {Port: 6784, Protocol: "UDP"}},
+}
+
+// podNetworkDaemonSets are the DaemonSets of the pod network add-ons tested by the profiles of the same name. the
+// ports of these profiles are bound on every node while the add-on is running, the profiles can only be tested before
+// the add-on is installed.
+var podNetworkDaemonSets = map[string]types.NamespacedName{
+	"flannel": {Namespace: "kube-flannel", Name: "kube-flannel-ds"},
+	"weave":   {Namespace: "kube-system", Name: "weave-net"},
+}
+
+// runningPodNetworkProfiles returns the pod network profiles among the provided ones whose add-on is running in the
+// cluster.
+func runningPodNetworkProfiles(ctx context.Context, cliset kubernetes.Interface, profiles []string) ([]string, error) {
+	var running []string
+	for _, profile := range profiles {
+		ds, ok := podNetworkDaemonSets[profile]
+		if !ok {
+			continue
+		}
+		_, err := cliset.AppsV1().DaemonSets(ds.Namespace).Get(ctx, ds.Name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get %s daemonset: %w", profile, err)
+		}
+		running = append(running, profile)
+	}
+	return running, nil
+}
+
+// withoutProfiles returns the targets that do not belong to any of the provided profiles.
+func withoutProfiles(targets []connectivityTarget, profiles []string) []connectivityTarget {
+	var result []connectivityTarget
+	for _, target := range targets {
+		if !slices.Contains(profiles, target.Profile) {
+			result = append(result, target)
+		}
+	}
+	return result
+}
+
+func connectivityProfileNames() []string {
+	names := make([]string, 0, len(connectivityProfiles))
+	for name := range connectivityProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// profilesFromInstaller returns the profiles for the components installed by the installer spec.
+func profilesFromInstaller(installer *kurlv1beta1.Installer) []string {
+	profiles := []string{"api-server", "etcd", "kubelet"}
+	if installer.Spec.Weave != nil && installer.Spec.Weave.Version != "" {
+		profiles = append(profiles, "weave")
+	}
+	if installer.Spec.Flannel != nil && installer.Spec.Flannel.Version != "" {
+		profiles = append(profiles, "flannel")
+	}
+	return profiles
+}
+
+// parseConnectivityTarget parses a PORT[/PROTOCOL] pair, defaultProto is used when the protocol is
+// omitted.
+func parseConnectivityTarget(value, defaultProto string) (connectivityTarget, error) {
+	port, proto, found := strings.Cut(value, "/")
+	if !found {
+		proto = defaultProto
+	}
+	proto = strings.ToUpper(proto)
+	if p := corev1.Protocol(proto); p != corev1.ProtocolTCP && p != corev1.ProtocolUDP {
+		return connectivityTarget{}, fmt.Errorf("invalid port %q: protocol must be either tcp or udp", value)
+	}
+	number, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || number == 0 {
+		return connectivityTarget{}, fmt.Errorf("invalid port %q: port must be a number between 1 and 65535", value)
+	}
+	return connectivityTarget{Port: int32(number), Protocol: proto}, nil
+}
+
+// buildConnectivityTargets returns the targets for the provided ports and profiles, removing
+// duplicates.
+func buildConnectivityTargets(ports []string, defaultProto string, profiles []string) ([]connectivityTarget, error) {
+	var targets []connectivityTarget
+	seen := map[string]bool{}
+	add := func(target connectivityTarget) {
+		if seen[target.String()] {
+			return
+		}
+		seen[target.String()] = true
+		targets = append(targets, target)
+	}
+
+	for _, port := range ports {
+		target, err := parseConnectivityTarget(port, defaultProto)
+		if err != nil {
+			return nil, err
+		}
+		add(target)
+	}
+
+	for _, profile := range profiles {
+		profileTargets, ok := connectivityProfiles[profile]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q, must be one of %s", profile, strings.Join(connectivityProfileNames(), ", "))
+		}
+		for _, target := range profileTargets {
+			target.Profile = profile
+			add(target)
+		}
+	}
+
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("at least one --port or --profile is required")
+	}
+	return targets, nil
+}
+
+// connectivityResult is the result of testing a target from a source node to a destination node.
+type connectivityResult struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Port        int32  `json:"port"`
+	Protocol    string `json:"protocol"`
+	Reachable   bool   `json:"reachable"`
+	Attempts    int    `json:"attempts"`
+}
+
+// nodeMeasurement holds the latency, mtu or bandwidth measured from a source node to a destination node.
+type nodeMeasurement struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	nodeProbeResult
+	Warnings []string `json:"warnings,omitempty"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// mtuWarnings returns a warning for each overlay interface whose mtu, once the encapsulation overhead is added, does
+// not fit in the path mtu between the nodes. packets that large are fragmented or, with the don't fragment bit set,
+// dropped. overlayMTU overrides the mtus of the interfaces found on the source node.
+func mtuWarnings(measurement nodeMeasurement, overlayMTU, overhead int) []string {
+	if measurement.MTU == nil {
+		return nil
+	}
+	mtus := measurement.OverlayMTUs
+	if overlayMTU > 0 {
+		mtus = map[string]int{"overlay": overlayMTU}
+	}
+	names := make([]string, 0, len(mtus))
+	for name := range mtus {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var warnings []string
+	pathMTU := measurement.MTU.PathMTU
+	for _, name := range names {
+		if mtus[name]+overhead <= pathMTU {
+			continue
+		}
+		warnings = append(warnings, fmt.Sprintf(
+			"%s mtu %d plus %d bytes of encapsulation exceeds the path mtu %d, the overlay mtu should be at most %d",
+			name, mtus[name], overhead, pathMTU, pathMTU-overhead,
+		))
+	}
+	return warnings
+}
+
+// connectivityMatrix holds the reachability of every target between every pair of nodes.
+type connectivityMatrix struct {
+	Nodes        []string             `json:"nodes"`
+	Targets      []connectivityTarget `json:"targets"`
+	Results      []connectivityResult `json:"results"`
+	Measurements []nodeMeasurement    `json:"measurements,omitempty"`
+	PodNetwork   []podNetworkResult   `json:"podNetwork,omitempty"`
+}
+
+// result returns the result for the target between src and dst.
+func (m connectivityMatrix) result(src, dst string, target connectivityTarget) (connectivityResult, bool) {
+	for _, result := range m.Results {
+		if result.Source == src && result.Destination == dst && result.Port == target.Port && result.Protocol == target.Protocol {
+			return result, true
+		}
+	}
+	return connectivityResult{}, false
+}
+
+// failures returns the results where the destination could not be reached.
+func (m connectivityMatrix) failures() []connectivityResult {
+	var failures []connectivityResult
+	for _, result := range m.Results {
+		if !result.Reachable {
+			failures = append(failures, result)
+		}
+	}
+	return failures
+}
+
+// measurementErrors returns the measurements that could not be taken.
+func (m connectivityMatrix) measurementErrors() []nodeMeasurement {
+	var errs []nodeMeasurement
+	for _, measurement := range m.Measurements {
+		if measurement.Error != "" {
+			errs = append(errs, measurement)
+		}
+	}
+	return errs
+}
+
+// writeText writes one table per target, rows are the source nodes and columns the destinations. measurements are
+// written afterwards with one row per pair of nodes, followed by the mtu warnings and the pod network checks.
+func (m connectivityMatrix) writeText(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	m.writeReachability(w)
+	if len(m.Measurements) > 0 {
+		if len(m.Targets) > 0 {
+			fmt.Fprintln(w)
+		}
+		m.writeMeasurements(w)
+	}
+	if len(m.PodNetwork) > 0 {
+		if len(m.Targets) > 0 || len(m.Measurements) > 0 {
+			fmt.Fprintln(w)
+		}
+		m.writePodNetwork(w)
+	}
+	return w.Flush()
+}
+
+func (m connectivityMatrix) writePodNetwork(w io.Writer) {
+	fmt.Fprintf(w, "pod network\n")
+	fmt.Fprintf(w, "FROM\tLAYER\tTARGET\tRESULT\n")
+	for _, result := range m.PodNetwork {
+		status := "ok"
+		if !result.Reachable {
+			status = "FAILED"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Source, result.Layer, result.Target, status)
+	}
+}
+
+func (m connectivityMatrix) writeReachability(w io.Writer) {
+	for i, target := range m.Targets {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		title := target.String()
+		if target.Profile != "" {
+			title = fmt.Sprintf("%s (%s)", title, target.Profile)
+		}
+		fmt.Fprintf(w, "%s\n", title)
+		fmt.Fprintf(w, "FROM \\ TO\t%s\n", strings.Join(m.Nodes, "\t"))
+		for _, src := range m.Nodes {
+			cells := make([]string, 0, len(m.Nodes))
+			for _, dst := range m.Nodes {
+				result, found := m.result(src, dst, target)
+				switch {
+				case !found:
+					cells = append(cells, "-")
+				case result.Reachable:
+					cells = append(cells, "ok")
+				default:
+					cells = append(cells, "FAILED")
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\n", src, strings.Join(cells, "\t"))
+		}
+	}
+}
+
+func (m connectivityMatrix) writeMeasurements(w io.Writer) {
+	fmt.Fprintf(w, "FROM\tTO\tLATENCY\tLOSS\tPATH MTU\tBANDWIDTH\n")
+	var warnings []string
+	for _, measurement := range m.Measurements {
+		latency, loss, mtu, bandwidth := "-", "-", "-", "-"
+		if measurement.Error != "" {
+			latency, loss, mtu, bandwidth = "ERROR", "ERROR", "ERROR", "ERROR"
+		}
+		if measurement.Latency != nil {
+			if measurement.Latency.Received > 0 {
+				latency = measurement.Latency.Avg.Round(10 * time.Microsecond).String()
+			}
+			loss = fmt.Sprintf("%.0f%%", measurement.Latency.Loss())
+		}
+		if measurement.MTU != nil {
+			mtu = strconv.Itoa(measurement.MTU.PathMTU)
+			if measurement.MTU.PathMTU >= measurement.MTU.MaxMTU {
+				mtu = ">=" + mtu
+			}
+		}
+		if measurement.Bandwidth != nil {
+			bandwidth = fmt.Sprintf("%.1f Mbit/s", measurement.Bandwidth.BitsPerSecond/1e6)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", measurement.Source, measurement.Destination, latency, loss, mtu, bandwidth)
+		for _, warning := range measurement.Warnings {
+			warnings = append(warnings, fmt.Sprintf("WARNING: %s to %s: %s", measurement.Source, measurement.Destination, warning))
+		}
+	}
+	if len(warnings) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	for _, warning := range warnings {
+		fmt.Fprintln(w, warning)
+	}
+}
+
+// attemptsFor returns the number of connection attempts for the target, only udp is retried.
+func (n nodeConnectivityOptions) attemptsFor(target connectivityTarget) int {
+	if corev1.Protocol(target.Protocol) == corev1.ProtocolUDP {
+		return n.attempts
+	}
+	return 1
+}
+
+// waitFor returns how long to wait for the listener to report the packet.
+func (n nodeConnectivityOptions) waitFor(target connectivityTarget) time.Duration {
+	if corev1.Protocol(target.Protocol) == corev1.ProtocolUDP {
+		return 5 * time.Second
+	}
+	return time.Second
+}
@@ -0,0 +1,243 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	kurlv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/replicatedhq/kurl/pkg/netutils"
+)
+
+func Test_buildConnectivityTargets(t *testing.T) {
+	for _, tt := range []struct {
+		name         string
+		ports        []string
+		defaultProto string
+		profiles     []string
+		want         []connectivityTarget
+		wantErr      string
+	}{
+		{
+			name:         "ports with and without protocol",
+			ports:        []string{"6443", "8472/udp", "6783/TCP"},
+			defaultProto: "TCP",
+			want: []connectivityTarget{
+				{Port: 6443, Protocol: "TCP"},
+				{Port: 8472, Protocol: "UDP"},
+				{Port: 6783, Protocol: "TCP"},
+			},
+		},
+		{
+			name:         "profiles remove duplicates",
+			ports:        []string{"6783/tcp"},
+			defaultProto: "TCP",
+			profiles:     []string{"weave", "kubelet", "weave"},
+			want: []connectivityTarget{
+				{Port: 6783, Protocol: "TCP"},
+				{Port: 6783, Protocol: "UDP", Profile: "weave"},
+				{Port: 6784, Protocol: "UDP", Profile: "weave"},
+				{Port: 10250, Protocol: "TCP", Profile: "kubelet"},
+			},
+		},
+		{
+			name:         "unknown profile",
+			defaultProto: "TCP",
+			profiles:     []string{"calico"},
+			wantErr:      `unknown profile "calico", must be one of api-server, etcd, flannel, kubelet, weave`,
+		},
+		{
+			name:         "invalid protocol",
+			ports:        []string{"6443/sctp"},
+			defaultProto: "TCP",
+			wantErr:      `invalid port "6443/sctp": protocol must be either tcp or udp`,
+		},
+		{
+			name:         "invalid port",
+			ports:        []string{"70000"},
+			defaultProto: "TCP",
+			wantErr:      `invalid port "70000": port must be a number between 1 and 65535`,
+		},
+		{
+			name:         "nothing to test",
+			defaultProto: "TCP",
+			wantErr:      "at least one --port or --profile is required",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildConnectivityTargets(tt.ports, tt.defaultProto, tt.profiles)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_profilesFromInstaller(t *testing.T) {
+	installer := &kurlv1beta1.Installer{
+		Spec: kurlv1beta1.InstallerSpec{
+			Flannel: &kurlv1beta1.Flannel{Version: "0.22.x"},
+			Weave:   &kurlv1beta1.Weave{},
+		},
+	}
+	assert.Equal(t, []string{"api-server", "etcd", "kubelet", "flannel"}, profilesFromInstaller(installer))
+}
+
+func Test_runningPodNetworkProfiles(t *testing.T) {
+	cliset := fake.NewClientset(&appsv1.DaemonSet{ObjectMeta: metav1.ObjectMeta{Name: "kube-flannel-ds", Namespace: "kube-flannel"}})
+	profiles := []string{"api-server", "flannel", "weave"}
+
+	running, err := runningPodNetworkProfiles(context.Background(), cliset, profiles)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"flannel"}, running)
+
+	targets, err := buildConnectivityTargets([]string{"8472/udp"}, "tcp", profiles)
+	require.NoError(t, err)
+	assert.Equal(t, []connectivityTarget{
+		{Port: 8472, Protocol: "UDP"},
+		{Port: 6443, Protocol: "TCP", Profile: "api-server"},
+		{Port: 6783, Protocol: "TCP", Profile: "weave"},
+		{Port: 6783, Protocol: "UDP", Profile: "weave"},
+		{Port: 6784, Protocol: "UDP", Profile: "weave"},
+	}, withoutProfiles(targets, running))
+}
+
+func Test_connectivityMatrix(t *testing.T) {
+	tcp := connectivityTarget{Port: 6443, Protocol: "TCP", Profile: "api-server"}
+	udp := connectivityTarget{Port: 8472, Protocol: "UDP"}
+	matrix := connectivityMatrix{
+		Nodes:   []string{"node-a", "node-b"},
+		Targets: []connectivityTarget{tcp, udp},
+		Results: []connectivityResult{
+			{Source: "node-a", Destination: "node-b", Port: 6443, Protocol: "TCP", Reachable: true, Attempts: 1},
+			{Source: "node-b", Destination: "node-a", Port: 6443, Protocol: "TCP", Reachable: true, Attempts: 1},
+			{Source: "node-a", Destination: "node-b", Port: 8472, Protocol: "UDP", Reachable: false, Attempts: 5},
+			{Source: "node-b", Destination: "node-a", Port: 8472, Protocol: "UDP", Reachable: true, Attempts: 2},
+		},
+	}
+
+	assert.Equal(t, []connectivityResult{matrix.Results[2]}, matrix.failures())
+
+	var buf bytes.Buffer
+	require.NoError(t, matrix.writeText(&buf))
+	assert.Equal(t, `6443/tcp (api-server)
+FROM \ TO  node-a  node-b
+node-a     -       ok
+node-b     ok      -
+
+8472/udp
+FROM \ TO  node-a  node-b
+node-a     -       FAILED
+node-b     ok      -
+`, buf.String())
+}
+
+func Test_listenerContainers(t *testing.T) {
+	targets := []connectivityTarget{{Port: 6443, Protocol: "TCP"}, {Port: 8472, Protocol: "UDP"}}
+	opts := nodeConnectivityOptions{attempts: 5}
+	assert.Equal(t, 1, opts.attemptsFor(targets[0]))
+	assert.Equal(t, 5, opts.attemptsFor(targets[1]))
+
+	model := corev1.Container{Name: "nodes-connectivity-listener", Image: "nodes-connectivity-image", Command: []string{"/bin/bash", "-c"}}
+	containers := listenerContainers(model, targets)
+	require.Len(t, containers, 2)
+	assert.Equal(t, "listener-tcp-6443", containers[0].Name)
+	assert.Equal(t, "nodes-connectivity-image", containers[0].Image)
+	assert.Equal(t, "6443", containers[0].Env[0].Value)
+	assert.Equal(t, "listener-udp-8472", containers[1].Name)
+	assert.Contains(t, containers[1].Args[0], "/proc/net/udp")
+	assert.True(t, strings.HasSuffix(containers[1].Args[0], "exec /usr/bin/nc -kulw 0 $PORT"))
+	assert.Equal(t, "nodes-connectivity-listener", model.Name)
+}
+
+func Test_mtuWarnings(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		measurement nodeMeasurement
+		overlayMTU  int
+		want        []string
+	}{
+		{
+			name:        "mtu not measured",
+			measurement: nodeMeasurement{nodeProbeResult: nodeProbeResult{OverlayMTUs: map[string]int{"weave": 1376}}},
+		},
+		{
+			name: "overlay fits in the path mtu",
+			measurement: nodeMeasurement{nodeProbeResult: nodeProbeResult{
+				MTU:         &netutils.MTUResult{PathMTU: 1500, MaxMTU: 9000},
+				OverlayMTUs: map[string]int{"flannel.1": 1450},
+			}},
+		},
+		{
+			name: "overlay larger than the path mtu",
+			measurement: nodeMeasurement{nodeProbeResult: nodeProbeResult{
+				MTU:         &netutils.MTUResult{PathMTU: 1400, MaxMTU: 9000},
+				OverlayMTUs: map[string]int{"vxlan-6784": 1376, "weave": 1376, "flannel.1": 1450},
+			}},
+			want: []string{
+				"flannel.1 mtu 1450 plus 50 bytes of encapsulation exceeds the path mtu 1400, the overlay mtu should be at most 1350",
+				"vxlan-6784 mtu 1376 plus 50 bytes of encapsulation exceeds the path mtu 1400, the overlay mtu should be at most 1350",
+				"weave mtu 1376 plus 50 bytes of encapsulation exceeds the path mtu 1400, the overlay mtu should be at most 1350",
+			},
+		},
+		{
+			name: "overlay mtu override",
+			measurement: nodeMeasurement{nodeProbeResult: nodeProbeResult{
+				MTU:         &netutils.MTUResult{PathMTU: 1500, MaxMTU: 9000},
+				OverlayMTUs: map[string]int{"flannel.1": 1450},
+			}},
+			overlayMTU: 1500,
+			want:       []string{"overlay mtu 1500 plus 50 bytes of encapsulation exceeds the path mtu 1500, the overlay mtu should be at most 1450"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, mtuWarnings(tt.measurement, tt.overlayMTU, 50))
+		})
+	}
+}
+
+func Test_connectivityMatrixMeasurements(t *testing.T) {
+	matrix := connectivityMatrix{
+		Nodes: []string{"node-a", "node-b"},
+		Measurements: []nodeMeasurement{
+			{
+				Source:      "node-a",
+				Destination: "node-b",
+				nodeProbeResult: nodeProbeResult{
+					Latency:   &netutils.LatencyResult{Sent: 10, Received: 9, Avg: 512345 * time.Nanosecond},
+					MTU:       &netutils.MTUResult{PathMTU: 1400, MaxMTU: 9000},
+					Bandwidth: &netutils.BandwidthResult{BitsPerSecond: 941.26e6},
+				},
+				Warnings: []string{"flannel.1 mtu 1450 plus 50 bytes of encapsulation exceeds the path mtu 1400, the overlay mtu should be at most 1350"},
+			},
+			{
+				Source:      "node-b",
+				Destination: "node-a",
+				Error:       "no answer to 576 bytes probes from 10.0.0.1:9797",
+			},
+		},
+	}
+
+	assert.Equal(t, []nodeMeasurement{matrix.Measurements[1]}, matrix.measurementErrors())
+
+	var buf bytes.Buffer
+	require.NoError(t, matrix.writeText(&buf))
+	assert.Equal(t, `FROM    TO      LATENCY  LOSS   PATH MTU  BANDWIDTH
+node-a  node-b  510µs    10%    1400      941.3 Mbit/s
+node-b  node-a  ERROR    ERROR  ERROR     ERROR
+
+WARNING: node-a to node-b: flannel.1 mtu 1450 plus 50 bytes of encapsulation exceeds the path mtu 1400, the overlay mtu should be at most 1350
+`, buf.String())
+}
@@ -0,0 +1,266 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/replicatedhq/plumber/v2"
+	"github.com/spf13/cobra"
+
+	"github.com/replicatedhq/kurl/pkg/k8sutil"
+	"github.com/replicatedhq/kurl/pkg/static/nodes_connectivity"
+)
+
+const (
+	podListenersSelector = "name=nodes-connectivity-pod-listener"
+	podListenerService   = "nodes-connectivity-pod-listener"
+	podListenerPort      = 8080
+
+	// the layers tested by the pod network checks, a failure in one of them points to the component at fault.
+	podNetworkLayerCNI       = "cni"
+	podNetworkLayerKubeProxy = "kube-proxy"
+	podNetworkLayerDNS       = "dns"
+)
+
+// podNetworkLayerHints explains what a failure in each layer means.
+var podNetworkLayerHints = map[string]string{
+	podNetworkLayerCNI:       "Pods cannot reach pods on other nodes, verify the CNI plugin pods and that the overlay network ports are open between the nodes.",
+	podNetworkLayerKubeProxy: "Pods cannot reach the Service ClusterIP, verify the kube-proxy pods and their iptables or ipvs rules.",
+	podNetworkLayerDNS:       "Pods cannot resolve Service names, verify the CoreDNS pods and the kube-dns Service.",
+}
+
+// podNetworkResult is the result of a pod network check from a pod running on the source node.
+type podNetworkResult struct {
+	Source    string `json:"source"`
+	Layer     string `json:"layer"`
+	Target    string `json:"target"`
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error,omitempty"`
+}
+
+type podNetworkCheckOptions struct {
+	pods    []string
+	service string
+	dns     string
+	timeout time.Duration
+}
+
+// newNetutilPodNetworkCheckCmd tests the pod network from inside a pod and prints the results as json, it is run by the
+// nodes-connectivity pinger when --pod-network is provided.
+func newNetutilPodNetworkCheckCmd(_ CLI) *cobra.Command {
+	var opts podNetworkCheckOptions
+	cmd := &cobra.Command{
+		Use:    "pod-network-check",
+		Short:  "Tests pod to pod, pod to service and dns traffic and prints the results as json",
+		Hidden: true,
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			for _, pod := range opts.pods {
+				if _, _, found := strings.Cut(pod, "="); !found {
+					return fmt.Errorf("invalid pod %q, must be NODE=HOST:PORT", pod)
+				}
+			}
+			cmd.SilenceUsage = true
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGTERM, syscall.SIGINT)
+			defer cancel()
+			results := checkPodNetwork(ctx, opts)
+			return json.NewEncoder(cmd.OutOrStdout()).Encode(results)
+		},
+	}
+	cmd.Flags().StringSliceVar(&opts.pods, "pods", nil, "The pods to connect to as NODE=HOST:PORT.")
+	cmd.Flags().StringVar(&opts.service, "service", "", "The service to connect to as HOST:PORT.")
+	cmd.Flags().StringVar(&opts.dns, "dns", "", "The name to resolve, it must resolve to the service ip.")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", 2*time.Second, "How long to wait for each connection.")
+	return cmd
+}
+
+// checkPodNetwork connects to every pod and to the service, then resolves the dns name. the source of the results is
+// left empty, it is set by the caller.
+func checkPodNetwork(ctx context.Context, opts podNetworkCheckOptions) []podNetworkResult {
+	var results []podNetworkResult
+	for _, pod := range opts.pods {
+		node, address, _ := strings.Cut(pod, "=")
+		result := podNetworkResult{Layer: podNetworkLayerCNI, Target: node}
+		if err := dialTCP(ctx, address, opts.timeout); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Reachable = true
+		}
+		results = append(results, result)
+	}
+
+	if opts.service != "" {
+		result := podNetworkResult{Layer: podNetworkLayerKubeProxy, Target: opts.service}
+		if err := dialTCP(ctx, opts.service, opts.timeout); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Reachable = true
+		}
+		results = append(results, result)
+	}
+
+	if opts.dns != "" {
+		result := podNetworkResult{Layer: podNetworkLayerDNS, Target: opts.dns}
+		if err := resolveServiceName(ctx, opts.dns, opts.service, opts.timeout); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Reachable = true
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func dialTCP(ctx context.Context, address string, timeout time.Duration) error {
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// resolveServiceName resolves the name and verifies it points to the host of the service address, if provided.
+func resolveServiceName(ctx context.Context, name, service string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupHost(ctx, name)
+	if err != nil {
+		return err
+	}
+	if service == "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(service)
+	if err != nil {
+		return fmt.Errorf("invalid service address %q: %w", service, err)
+	}
+	for _, addr := range addrs {
+		if addr == host {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s resolved to %s instead of the service ip %s", name, strings.Join(addrs, ", "), host)
+}
+
+// podNetworkFailures returns the failed pod network checks grouped by layer.
+func podNetworkFailures(results []podNetworkResult) map[string][]podNetworkResult {
+	failures := map[string][]podNetworkResult{}
+	for _, result := range results {
+		if !result.Reachable {
+			failures[result.Layer] = append(failures[result.Layer], result)
+		}
+	}
+	return failures
+}
+
+// deployPodNetworkListeners deploys a daemonset that runs a listener pod on the pod network of every node and a
+// service in front of them.
+func deployPodNetworkListeners(ctx context.Context, opts nodeConnectivityOptions) error {
+	opts.printf("Deploying pod network listeners DaemonSet.\n")
+	options := []plumber.Option{
+		plumber.WithKustomizeMutator(kustomizeMutator(opts)),
+		plumber.WithObjectMutator(func(ctx context.Context, obj client.Object) error {
+			if ds, ok := obj.(*appsv1.DaemonSet); ok {
+				tolerations, err := k8sutil.TolerationsForAllNodes(ctx, opts.cliset)
+				if err != nil {
+					return fmt.Errorf("failed to build tolerations: %w", err)
+				}
+				ds.Spec.Template.Spec.Tolerations = tolerations
+			}
+			return nil
+		}),
+		plumber.WithPostApplyAction(func(ctx context.Context, obj client.Object) error {
+			if ds, ok := obj.(*appsv1.DaemonSet); ok {
+				opts.debugf("Waiting for DaemonSet %s to rollout\n", ds.Name)
+				if err := k8sutil.WaitForDaemonsetRollout(ctx, opts.cliset, ds, 2*time.Minute); err != nil {
+					return fmt.Errorf("failed to wait for DaemonSet rollout: %w", err)
+				}
+			}
+			return nil
+		}),
+	}
+	renderer := plumber.NewRenderer(opts.cli, nodes_connectivity.Static, options...)
+	if err := renderer.Apply(ctx, "pod-network"); err != nil {
+		return fmt.Errorf("failed to create pod network listeners: %w", err)
+	}
+	opts.printf("Pod network listeners DaemonSet deployed successfully.\n")
+	return nil
+}
+
+// deletePodNetworkListeners deletes the pod network listeners daemonset and service.
+func deletePodNetworkListeners(ctx context.Context, opts nodeConnectivityOptions) error {
+	opt := plumber.WithKustomizeMutator(kustomizeMutator(opts))
+	renderer := plumber.NewRenderer(opts.cli, nodes_connectivity.Static, opt)
+	if err := renderer.Delete(ctx, "pod-network"); err != nil {
+		return fmt.Errorf("failed to delete pod network overlay: %w", err)
+	}
+	return nil
+}
+
+// testPodNetwork runs the pod network checks from a pod on every node. the pinger job runs on the pod network, next to
+// the listener pod of the source node.
+func testPodNetwork(ctx context.Context, opts nodeConnectivityOptions) ([]podNetworkResult, error) {
+	pods, err := k8sutil.ListPodsBySelector(ctx, opts.cliset, opts.namespace, podListenersSelector)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod network listener pods: %w", err)
+	}
+	var service corev1.Service
+	key := client.ObjectKey{Namespace: opts.namespace, Name: podListenerService}
+	if err := opts.cli.Get(ctx, key, &service); err != nil {
+		return nil, fmt.Errorf("failed to get pod network listener service: %w", err)
+	}
+	serviceAddress := net.JoinHostPort(service.Spec.ClusterIP, fmt.Sprint(podListenerPort))
+	dnsName := fmt.Sprintf("%s.%s.svc", podListenerService, opts.namespace)
+
+	var results []podNetworkResult
+	for _, src := range pods.Items {
+		var targets []string
+		for _, dst := range pods.Items {
+			if dst.Spec.NodeName == src.Spec.NodeName {
+				continue
+			}
+			address := net.JoinHostPort(dst.Status.PodIP, fmt.Sprint(podListenerPort))
+			targets = append(targets, fmt.Sprintf("%s=%s", dst.Spec.NodeName, address))
+		}
+		command := fmt.Sprintf("exec /usr/local/bin/kurl netutil pod-network-check --service %s --dns %s", serviceAddress, dnsName)
+		if len(targets) > 0 {
+			command = fmt.Sprintf("%s --pods %s", command, strings.Join(targets, ","))
+		}
+
+		opts.printf("Testing pod network from %s\n", src.Spec.NodeName)
+		logs, err := runPingerCommand(ctx, opts, src, 2*time.Minute, func(spec *corev1.PodSpec) {
+			spec.HostNetwork = false
+			spec.DNSPolicy = corev1.DNSClusterFirst
+			spec.Containers[0].Args = []string{command}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to test pod network from node %s: %w", src.Spec.NodeName, err)
+		}
+		var nodeResults []podNetworkResult
+		if err := json.Unmarshal(logs, &nodeResults); err != nil {
+			return nil, fmt.Errorf("failed to parse pod network results %q: %w", string(logs), err)
+		}
+		for _, result := range nodeResults {
+			result.Source = src.Spec.NodeName
+			results = append(results, result)
+		}
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Source < results[j].Source
+	})
+	return results, nil
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_checkPodNetwork(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closedAddress := closed.Addr().String()
+	closed.Close()
+
+	results := checkPodNetwork(context.Background(), podNetworkCheckOptions{
+		pods:    []string{"node-b=" + listener.Addr().String(), "node-c=" + closedAddress},
+		service: listener.Addr().String(),
+		dns:     "localhost",
+		timeout: time.Second,
+	})
+	require.Len(t, results, 4)
+
+	assert.Equal(t, podNetworkResult{Layer: podNetworkLayerCNI, Target: "node-b", Reachable: true}, results[0])
+	assert.Equal(t, podNetworkLayerCNI, results[1].Layer)
+	assert.Equal(t, "node-c", results[1].Target)
+	assert.False(t, results[1].Reachable)
+	assert.Contains(t, results[1].Error, "connection refused")
+	assert.Equal(t, podNetworkResult{Layer: podNetworkLayerKubeProxy, Target: listener.Addr().String(), Reachable: true}, results[2])
+	assert.Equal(t, podNetworkResult{Layer: podNetworkLayerDNS, Target: "localhost", Reachable: true}, results[3])
+
+	results = checkPodNetwork(context.Background(), podNetworkCheckOptions{
+		service: "10.96.0.20:8080",
+		dns:     "localhost",
+		timeout: 100 * time.Millisecond,
+	})
+	require.Len(t, results, 2)
+	assert.Equal(t, podNetworkLayerDNS, results[1].Layer)
+	assert.Contains(t, results[1].Error, "instead of the service ip 10.96.0.20")
+}
+
+func Test_podNetworkFailures(t *testing.T) {
+	matrix := connectivityMatrix{
+		Nodes: []string{"node-a", "node-b"},
+		PodNetwork: []podNetworkResult{
+			{Source: "node-a", Layer: podNetworkLayerCNI, Target: "node-b", Reachable: true},
+			{Source: "node-a", Layer: podNetworkLayerKubeProxy, Target: "10.96.0.20:8080", Reachable: true},
+			{Source: "node-a", Layer: podNetworkLayerDNS, Target: "nodes-connectivity-pod-listener.default.svc", Reachable: false, Error: "i/o timeout"},
+			{Source: "node-b", Layer: podNetworkLayerCNI, Target: "node-a", Reachable: true},
+		},
+	}
+
+	failures := podNetworkFailures(matrix.PodNetwork)
+	assert.Equal(t, map[string][]podNetworkResult{podNetworkLayerDNS: {matrix.PodNetwork[2]}}, failures)
+
+	var buf bytes.Buffer
+	require.NoError(t, matrix.writeText(&buf))
+	assert.Equal(t, `pod network
+FROM    LAYER       TARGET                                       RESULT
+node-a  cni         node-b                                       ok
+node-a  kube-proxy  10.96.0.20:8080                              ok
+node-a  dns         nodes-connectivity-pod-listener.default.svc  FAILED
+node-b  cni         node-a                                       ok
+`, buf.String())
+}
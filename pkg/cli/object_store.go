@@ -1,11 +1,10 @@
 package cli
 
 import (
-	"context"
 	"fmt"
-	"log"
+	"os/signal"
+	"syscall"
 
-	"github.com/minio/minio-go"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -33,18 +32,24 @@ func newSyncObjectStoreCmdDeprecated(cli CLI) *cobra.Command {
 	return cmd
 }
 
-func newSyncObjectStoreCmd(_ CLI) *cobra.Command {
-	var srcHost string
-	var srcAccessKeyID string
-	var srcAccessKeySecret string
+func newSyncObjectStoreCmd(cli CLI) *cobra.Command {
+	var src objectStoreEndpoint
+	var dst objectStoreEndpoint
+	var renameBuckets map[string]string
 
-	var dstHost string
-	var dstAccessKeyID string
-	var dstAccessKeySecret string
+	opts := objectStoreSyncOptions{}
 
 	syncObjectStoreCmd := &cobra.Command{
 		Use:   "sync",
 		Short: "Copies buckets and objects from one object store to another",
+		Long: "Copies buckets and objects from one object store to another. Objects are copied in parallel and objects\n" +
+			"already present in the destination are skipped (see --compare). When --checkpoint_file is provided every\n" +
+			"synced object is recorded in the file so an interrupted sync can be resumed by running the same command again.\n" +
+			"Buckets can be filtered with --include_bucket and --exclude_bucket glob patterns and renamed in the\n" +
+			"destination with --rename_bucket. All flags can also be set through KURL_ prefixed environment variables.",
+		Example: "  kurl object-store sync --source_host=10.96.0.10 --dest_host=s3.example.com --dest_tls --dest_ca_cert=/etc/ssl/ca.pem \\\n" +
+			"    --include_bucket='velero*' --rename_bucket=velero=velero-backups",
+		SilenceUsage: true,
 		PreRunE: func(cmd *cobra.Command, _ []string) error {
 			v := viper.New()
 			v.SetEnvPrefix("KURL")
@@ -60,95 +65,66 @@ func newSyncObjectStoreCmd(_ CLI) *cobra.Command {
 			)
 			return nil
 		},
-		Run: func(_ *cobra.Command, _ []string) {
-			src, err := minio.New(
-				srcHost,
-				srcAccessKeyID,
-				srcAccessKeySecret,
-				false,
-			)
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			srcClient, err := src.client()
 			if err != nil {
-				log.Panic(err)
+				return fmt.Errorf("failed to create source client: %w", err)
 			}
 
-			dst, err := minio.New(
-				dstHost,
-				dstAccessKeyID,
-				dstAccessKeySecret,
-				false,
-			)
+			dstClient, err := dst.client()
 			if err != nil {
-				log.Panic(err)
+				return fmt.Errorf("failed to create destination client: %w", err)
 			}
 
-			ctx := context.Background()
+			opts.RenameBuckets = renameBuckets
+			opts.Location = dst.Region
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGTERM, syscall.SIGINT)
+			defer cancel()
 
-			srcBuckets, err := src.ListBuckets()
+			fmt.Printf("Syncing buckets from %s to %s\n", src.Host, dst.Host)
+			syncer, err := newObjectStoreSyncer(minioObjectStore{srcClient}, minioObjectStore{dstClient}, opts, cli.Logger())
 			if err != nil {
-				log.Fatalf("Failed to list buckets in %s: %v", srcHost, err)
+				return err
 			}
 
-			for _, srcBucket := range srcBuckets {
-				fmt.Printf("Syncing %s from %s to %s\n", srcBucket.Name, srcHost, dstHost)
-
-				count, err := syncBucket(ctx, src, dst, srcBucket.Name)
-				if err != nil {
-					log.Fatal(err)
-				}
-
-				fmt.Printf("Successfully synced %d objects in bucket %s from %s to %s\n", count, srcBucket.Name, srcHost, dstHost)
+			report, err := syncer.Sync(ctx)
+			if report != nil {
+				report.Print(cli.Stdout())
+			}
+			if err != nil {
+				return err
 			}
 
-			fmt.Printf("Successfully synced %d buckets from %s to %s\n", len(srcBuckets), srcHost, dstHost)
+			fmt.Printf("Successfully synced %d buckets from %s to %s\n", report.Buckets, src.Host, dst.Host)
+			return nil
 		},
 	}
 
-	syncObjectStoreCmd.Flags().StringVar(&srcHost, "source_host", "", "Hostname of the source object store")
-	syncObjectStoreCmd.Flags().StringVar(&srcAccessKeyID, "source_access_key_id", "", "Access key ID for the source object store")
-	syncObjectStoreCmd.Flags().StringVar(&srcAccessKeySecret, "source_access_key_secret", "", "Access key secret for the source object store")
+	addObjectStoreEndpointFlags(syncObjectStoreCmd.Flags(), &src, "source", "source")
+	addObjectStoreEndpointFlags(syncObjectStoreCmd.Flags(), &dst, "dest", "destination")
 
-	syncObjectStoreCmd.Flags().StringVar(&dstHost, "dest_host", "", "Hostname of the destination object store")
-	syncObjectStoreCmd.Flags().StringVar(&dstAccessKeyID, "dest_access_key_id", "", "Access key ID for the destination object store")
-	syncObjectStoreCmd.Flags().StringVar(&dstAccessKeySecret, "dest_access_key_secret", "", "Access key secret for the destination object store")
+	syncObjectStoreCmd.Flags().IntVar(&opts.Workers, "workers", 4, "Number of objects copied in parallel")
+	syncObjectStoreCmd.Flags().StringVar(&opts.Compare, "compare", objectStoreCompareETag, fmt.Sprintf("How to detect objects already present in the destination: %s, %s or %s", objectStoreCompareETag, objectStoreCompareSizeMtime, objectStoreCompareNone))
+	syncObjectStoreCmd.Flags().StringVar(&opts.CheckpointFile, "checkpoint_file", "", "File used to record synced objects, allowing an interrupted sync to be resumed")
+	syncObjectStoreCmd.Flags().BoolVar(&opts.DeleteExtraneous, "delete_extraneous", false, "Delete objects in the destination that do not exist in the source (mirror mode)")
+	syncObjectStoreCmd.Flags().BoolVar(&opts.VerifyChecksum, "verify_checksum", false, "Read back every copied object and compare its sha256 checksum with the source")
+	syncObjectStoreCmd.Flags().StringSliceVar(&opts.IncludeBuckets, "include_bucket", nil, "Only sync buckets matching these glob patterns")
+	syncObjectStoreCmd.Flags().StringSliceVar(&opts.ExcludeBuckets, "exclude_bucket", nil, "Do not sync buckets matching these glob patterns")
+	syncObjectStoreCmd.Flags().StringToStringVar(&renameBuckets, "rename_bucket", nil, "Rename buckets in the destination, in the form source=destination")
 
 	return syncObjectStoreCmd
 }
 
-func syncBucket(ctx context.Context, src *minio.Client, dst *minio.Client, bucket string) (int, error) {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-
-	count := 0
-
-	exists, err := dst.BucketExists(bucket)
-	if err != nil {
-		return count, fmt.Errorf("Failed to check if bucket %q exists in destination: %w", bucket, err)
-	}
-	if !exists {
-		if err := dst.MakeBucket(bucket, ""); err != nil {
-			return count, fmt.Errorf("Failed to make bucket %q in destination: %w", bucket, err)
-		}
-	}
-
-	srcObjectInfoChan := src.ListObjects(bucket, "", true, ctx.Done())
-
-	for srcObjectInfo := range srcObjectInfoChan {
-		srcObject, err := src.GetObject(bucket, srcObjectInfo.Key, minio.GetObjectOptions{})
-		if err != nil {
-			return count, fmt.Errorf("Get %s from source: %w", srcObjectInfo.Key, err)
-		}
-
-		_, err = dst.PutObject(bucket, srcObjectInfo.Key, srcObject, srcObjectInfo.Size, minio.PutObjectOptions{
-			ContentType:     srcObjectInfo.ContentType,
-			ContentEncoding: srcObjectInfo.Metadata.Get("Content-Encoding"),
-		})
-		srcObject.Close()
-		if err != nil {
-			return count, fmt.Errorf("Failed to copy object %s to destination: %w", srcObjectInfo.Key, err)
-		}
-
-		count++
-	}
-
-	return count, nil
+// addObjectStoreEndpointFlags registers the connection flags for an object store, all prefixed
+// with prefix (e.g. source_host).
+func addObjectStoreEndpointFlags(flags *pflag.FlagSet, endpoint *objectStoreEndpoint, prefix, description string) {
+	flags.StringVar(&endpoint.Host, prefix+"_host", "", fmt.Sprintf("Hostname of the %s object store", description))
+	flags.StringVar(&endpoint.AccessKeyID, prefix+"_access_key_id", "", fmt.Sprintf("Access key ID for the %s object store", description))
+	flags.StringVar(&endpoint.AccessKeySecret, prefix+"_access_key_secret", "", fmt.Sprintf("Access key secret for the %s object store", description))
+	flags.StringVar(&endpoint.Region, prefix+"_region", "", fmt.Sprintf("Region of the %s object store", description))
+	flags.BoolVar(&endpoint.TLS, prefix+"_tls", false, fmt.Sprintf("Connect to the %s object store using TLS", description))
+	flags.StringVar(&endpoint.CACertFile, prefix+"_ca_cert", "", fmt.Sprintf("PEM encoded CA bundle used to verify the %s object store certificate", description))
+	flags.BoolVar(&endpoint.InsecureSkipVerify, prefix+"_insecure_skip_verify", false, fmt.Sprintf("Do not verify the %s object store certificate (lab use only)", description))
+	flags.BoolVar(&endpoint.PathStyle, prefix+"_path_style", false, fmt.Sprintf("Use path style requests for the %s object store instead of virtual host style", description))
 }
@@ -0,0 +1,604 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/minio/minio-go"
+	"github.com/minio/minio-go/pkg/credentials"
+)
+
+const (
+	// objectStoreCompareETag skips objects whose ETag and size match in both object stores. multipart
+	// ETags are not comparable between different object store implementations so for them the
+	// size-mtime comparison is used instead.
+	objectStoreCompareETag = "etag"
+	// objectStoreCompareSizeMtime skips objects with the same size in both object stores if the
+	// destination object is not older than the source object.
+	objectStoreCompareSizeMtime = "size-mtime"
+	// objectStoreCompareNone copies all objects.
+	objectStoreCompareNone = "none"
+)
+
+// objectStore is the subset of the object store api used when syncing buckets.
+type objectStore interface {
+	ListBuckets() ([]minio.BucketInfo, error)
+	BucketExists(bucketName string) (bool, error)
+	MakeBucket(bucketName string, location string) error
+	ListObjects(bucketName, objectPrefix string, recursive bool, doneCh <-chan struct{}) <-chan minio.ObjectInfo
+	StatObject(bucketName, objectName string, opts minio.StatObjectOptions) (minio.ObjectInfo, error)
+	PutObjectWithContext(ctx context.Context, bucketName, objectName string, reader io.Reader, objectSize int64, opts minio.PutObjectOptions) (int64, error)
+	RemoveObject(bucketName, objectName string) error
+	OpenObject(ctx context.Context, bucketName, objectName string) (io.ReadCloser, error)
+}
+
+// minioObjectStore implements objectStore on top of a minio client.
+type minioObjectStore struct {
+	*minio.Client
+}
+
+// OpenObject returns a reader for the object content.
+func (m minioObjectStore) OpenObject(ctx context.Context, bucketName, objectName string) (io.ReadCloser, error) {
+	return m.GetObjectWithContext(ctx, bucketName, objectName, minio.GetObjectOptions{})
+}
+
+// objectStoreEndpoint holds the connection settings for an object store.
+type objectStoreEndpoint struct {
+	Host               string
+	AccessKeyID        string
+	AccessKeySecret    string
+	Region             string
+	TLS                bool
+	CACertFile         string
+	InsecureSkipVerify bool
+	PathStyle          bool
+}
+
+// client returns a minio client for the endpoint. when TLS is enabled the system cert pool is
+// extended with the certificates in CACertFile, if any.
+func (e objectStoreEndpoint) client() (*minio.Client, error) {
+	lookup := minio.BucketLookupAuto
+	if e.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.NewWithOptions(e.Host, &minio.Options{
+		Creds:        credentials.NewStaticV4(e.AccessKeyID, e.AccessKeySecret, ""),
+		Secure:       e.TLS,
+		Region:       e.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.TLS {
+		if e.CACertFile != "" || e.InsecureSkipVerify {
+			return nil, fmt.Errorf("tls must be enabled to use a ca certificate or to skip verification")
+		}
+		return client, nil
+	}
+
+	tlsConfig, err := e.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	transport := minio.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	client.SetCustomTransport(transport)
+	return client, nil
+}
+
+func (e objectStoreEndpoint) tlsConfig() (*tls.Config, error) {
+	// nolint:gosec // skipping verification is an explicit opt in for lab environments
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: e.InsecureSkipVerify,
+	}
+	if e.CACertFile == "" {
+		return config, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	data, err := os.ReadFile(e.CACertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca certificate: %w", err)
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", e.CACertFile)
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+// objectStoreSyncOptions holds the settings used when syncing two object stores.
+type objectStoreSyncOptions struct {
+	Workers          int
+	Compare          string
+	CheckpointFile   string
+	DeleteExtraneous bool
+	VerifyChecksum   bool
+	// IncludeBuckets and ExcludeBuckets are lists of glob patterns (see path.Match) matched
+	// against the source bucket names. if IncludeBuckets is empty all buckets are included.
+	IncludeBuckets []string
+	ExcludeBuckets []string
+	// RenameBuckets maps source bucket names to the bucket names used in the destination.
+	RenameBuckets map[string]string
+	// Location is the region where missing buckets are created in the destination.
+	Location string
+}
+
+func (o objectStoreSyncOptions) validate() error {
+	if o.Workers < 1 {
+		return fmt.Errorf("workers must be greater than zero")
+	}
+	switch o.Compare {
+	case objectStoreCompareETag, objectStoreCompareSizeMtime, objectStoreCompareNone:
+	default:
+		return fmt.Errorf("invalid compare mode %q, expected %s, %s or %s", o.Compare, objectStoreCompareETag, objectStoreCompareSizeMtime, objectStoreCompareNone)
+	}
+	for _, pattern := range append(append([]string{}, o.IncludeBuckets...), o.ExcludeBuckets...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid bucket pattern %q: %w", pattern, err)
+		}
+	}
+	targets := map[string]string{}
+	for from, to := range o.RenameBuckets {
+		if from == "" || to == "" {
+			return fmt.Errorf("invalid bucket rename %q=%q", from, to)
+		}
+		if other, ok := targets[to]; ok {
+			return fmt.Errorf("buckets %s and %s are both renamed to %s", other, from, to)
+		}
+		targets[to] = from
+	}
+	return nil
+}
+
+// includesBucket returns true if the bucket matches the include patterns and does not match
+// any of the exclude patterns.
+func (o objectStoreSyncOptions) includesBucket(bucket string) bool {
+	for _, pattern := range o.ExcludeBuckets {
+		if ok, _ := path.Match(pattern, bucket); ok {
+			return false
+		}
+	}
+	if len(o.IncludeBuckets) == 0 {
+		return true
+	}
+	for _, pattern := range o.IncludeBuckets {
+		if ok, _ := path.Match(pattern, bucket); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// destinationBucket returns the name of the bucket in the destination object store.
+func (o objectStoreSyncOptions) destinationBucket(bucket string) string {
+	if to, ok := o.RenameBuckets[bucket]; ok {
+		return to
+	}
+	return bucket
+}
+
+// objectStoreSyncFailure describes an object that could not be synced.
+type objectStoreSyncFailure struct {
+	Bucket string
+	Key    string
+	Err    error
+}
+
+// objectStoreSyncReport summarizes the outcome of a sync.
+type objectStoreSyncReport struct {
+	Buckets int
+	Copied  int
+	Skipped int
+	Deleted int
+	Bytes   int64
+	Failed  []objectStoreSyncFailure
+}
+
+// Print writes the report as a table followed by the list of failed objects.
+func (r *objectStoreSyncReport) Print(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 2, 2, 1, ' ', 0)
+	fmt.Fprintf(tw, "Buckets\tCopied\tSkipped\tDeleted\tFailed\tBytes copied\n")
+	fmt.Fprintf(tw, "%d\t%d\t%d\t%d\t%d\t%d\n", r.Buckets, r.Copied, r.Skipped, r.Deleted, len(r.Failed), r.Bytes)
+	tw.Flush()
+
+	for _, failure := range r.Failed {
+		fmt.Fprintf(w, "Failed to sync %s/%s: %v\n", failure.Bucket, failure.Key, failure.Err)
+	}
+}
+
+// objectStoreCheckpoint records the objects already synced so an interrupted sync can be resumed.
+// the checkpoint file is a list of json documents, one per line, appended as objects are synced.
+// entries are keyed on the destination bucket so a renamed bucket is synced again.
+type objectStoreCheckpoint struct {
+	mtx    sync.Mutex
+	file   *os.File
+	synced map[string]string
+}
+
+// objectStoreCheckpointEntry is a synced object, Bucket is the destination bucket.
+type objectStoreCheckpointEntry struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+	ETag   string `json:"etag"`
+}
+
+// openObjectStoreCheckpoint loads the provided checkpoint file, creating it if it does not exist.
+// an empty path returns a checkpoint that is not persisted.
+func openObjectStoreCheckpoint(path string) (*objectStoreCheckpoint, error) {
+	checkpoint := &objectStoreCheckpoint{synced: map[string]string{}}
+	if path == "" {
+		return checkpoint, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint file: %w", err)
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var entry objectStoreCheckpointEntry
+		// a partially written line is expected if the previous sync was killed.
+		if err := json.Unmarshal(line, &entry); err != nil {
+			continue
+		}
+		checkpoint.synced[checkpoint.id(entry.Bucket, entry.Key)] = entry.ETag
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read checkpoint file: %w", err)
+	}
+
+	checkpoint.file = file
+	return checkpoint, nil
+}
+
+func (c *objectStoreCheckpoint) id(bucket, key string) string {
+	return bucket + "/" + key
+}
+
+// Synced returns true if the object has been synced with the provided ETag.
+func (c *objectStoreCheckpoint) Synced(bucket, key, etag string) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	synced, ok := c.synced[c.id(bucket, key)]
+	return ok && synced == etag
+}
+
+// Record marks the object as synced.
+func (c *objectStoreCheckpoint) Record(bucket, key, etag string) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.synced[c.id(bucket, key)] = etag
+	if c.file == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(objectStoreCheckpointEntry{Bucket: bucket, Key: key, ETag: etag})
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint entry: %w", err)
+	}
+	if _, err := c.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write checkpoint file: %w", err)
+	}
+	return nil
+}
+
+// Close closes the underlying checkpoint file.
+func (c *objectStoreCheckpoint) Close() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+// objectStoreSyncer copies buckets and objects from one object store to another.
+type objectStoreSyncer struct {
+	src        objectStore
+	dst        objectStore
+	opts       objectStoreSyncOptions
+	checkpoint *objectStoreCheckpoint
+	log        *log.Logger
+
+	mtx    sync.Mutex
+	report objectStoreSyncReport
+}
+
+func newObjectStoreSyncer(src, dst objectStore, opts objectStoreSyncOptions, logger *log.Logger) (*objectStoreSyncer, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	checkpoint, err := openObjectStoreCheckpoint(opts.CheckpointFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &objectStoreSyncer{
+		src:        src,
+		dst:        dst,
+		opts:       opts,
+		checkpoint: checkpoint,
+		log:        logger,
+	}, nil
+}
+
+// Sync copies all buckets in the source object store to the destination object store. errors
+// syncing individual objects do not interrupt the sync, they are collected in the report and
+// an error is returned at the end.
+func (s *objectStoreSyncer) Sync(ctx context.Context) (*objectStoreSyncReport, error) {
+	defer s.checkpoint.Close()
+
+	buckets, err := s.src.ListBuckets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source buckets: %w", err)
+	}
+
+	for _, bucket := range buckets {
+		if !s.opts.includesBucket(bucket.Name) {
+			s.log.Printf("Skipping bucket %s", bucket.Name)
+			continue
+		}
+		if to := s.opts.destinationBucket(bucket.Name); to != bucket.Name {
+			s.log.Printf("Syncing bucket %s to %s", bucket.Name, to)
+		} else {
+			s.log.Printf("Syncing bucket %s", bucket.Name)
+		}
+		if err := s.syncBucket(ctx, bucket.Name); err != nil {
+			return &s.report, fmt.Errorf("failed to sync bucket %s: %w", bucket.Name, err)
+		}
+		s.report.Buckets++
+	}
+
+	if len(s.report.Failed) > 0 {
+		return &s.report, fmt.Errorf("failed to sync %d objects", len(s.report.Failed))
+	}
+	return &s.report, nil
+}
+
+// syncBucket copies all objects in the bucket using a pool of workers. when requested objects
+// that only exist in the destination are deleted once all objects have been copied. bucket is
+// always the source bucket name, the destination name is resolved through the rename options.
+func (s *objectStoreSyncer) syncBucket(ctx context.Context, bucket string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	exists, err := s.dst.BucketExists(s.opts.destinationBucket(bucket))
+	if err != nil {
+		return fmt.Errorf("failed to check if bucket exists in destination: %w", err)
+	}
+	if !exists {
+		if err := s.dst.MakeBucket(s.opts.destinationBucket(bucket), s.opts.Location); err != nil {
+			return fmt.Errorf("failed to make bucket in destination: %w", err)
+		}
+	}
+
+	objects := make(chan minio.ObjectInfo)
+	var wg sync.WaitGroup
+	for i := 0; i < s.opts.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for object := range objects {
+				s.syncObject(ctx, bucket, object)
+			}
+		}()
+	}
+
+	srcKeys := map[string]bool{}
+	var listErr error
+	for object := range s.src.ListObjects(bucket, "", true, ctx.Done()) {
+		if object.Err != nil {
+			listErr = fmt.Errorf("failed to list source objects: %w", object.Err)
+			break
+		}
+		srcKeys[object.Key] = true
+		select {
+		case objects <- object:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(objects)
+	wg.Wait()
+
+	if listErr != nil {
+		return listErr
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if !s.opts.DeleteExtraneous {
+		return nil
+	}
+
+	// we only delete objects once we know the source has been fully listed and copied.
+	if s.bucketHasFailures(bucket) {
+		s.log.Printf("Not deleting extraneous objects from bucket %s as some objects failed to sync", bucket)
+		return nil
+	}
+	return s.deleteExtraneous(ctx, bucket, srcKeys)
+}
+
+func (s *objectStoreSyncer) bucketHasFailures(bucket string) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, failure := range s.report.Failed {
+		if failure.Bucket == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteExtraneous removes from the destination bucket all objects not present in srcKeys.
+func (s *objectStoreSyncer) deleteExtraneous(ctx context.Context, bucket string, srcKeys map[string]bool) error {
+	for object := range s.dst.ListObjects(s.opts.destinationBucket(bucket), "", true, ctx.Done()) {
+		if object.Err != nil {
+			return fmt.Errorf("failed to list destination objects: %w", object.Err)
+		}
+		if srcKeys[object.Key] {
+			continue
+		}
+		if err := s.dst.RemoveObject(s.opts.destinationBucket(bucket), object.Key); err != nil {
+			s.fail(bucket, object.Key, fmt.Errorf("failed to delete extraneous object: %w", err))
+			continue
+		}
+		s.mtx.Lock()
+		s.report.Deleted++
+		s.mtx.Unlock()
+	}
+	return nil
+}
+
+// syncObject copies a single object unless it has already been synced.
+func (s *objectStoreSyncer) syncObject(ctx context.Context, bucket string, object minio.ObjectInfo) {
+	dstBucket := s.opts.destinationBucket(bucket)
+	if s.checkpoint.Synced(dstBucket, object.Key, object.ETag) {
+		s.skip()
+		return
+	}
+
+	same, err := s.isSame(bucket, object)
+	if err != nil {
+		s.fail(bucket, object.Key, err)
+		return
+	}
+	if same {
+		s.skip()
+		if err := s.checkpoint.Record(dstBucket, object.Key, object.ETag); err != nil {
+			s.log.Printf("Failed to record %s/%s in checkpoint: %v", dstBucket, object.Key, err)
+		}
+		return
+	}
+
+	if err := s.copyObject(ctx, bucket, object); err != nil {
+		s.fail(bucket, object.Key, err)
+		return
+	}
+
+	s.mtx.Lock()
+	s.report.Copied++
+	s.report.Bytes += object.Size
+	s.mtx.Unlock()
+
+	if err := s.checkpoint.Record(dstBucket, object.Key, object.ETag); err != nil {
+		s.log.Printf("Failed to record %s/%s in checkpoint: %v", dstBucket, object.Key, err)
+	}
+}
+
+// isSame compares the source object against the destination object according to the compare
+// mode. returns false if the object does not exist in the destination.
+func (s *objectStoreSyncer) isSame(bucket string, src minio.ObjectInfo) (bool, error) {
+	if s.opts.Compare == objectStoreCompareNone {
+		return false, nil
+	}
+
+	dst, err := s.dst.StatObject(s.opts.destinationBucket(bucket), src.Key, minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to stat destination object: %w", err)
+	}
+
+	if dst.Size != src.Size {
+		return false, nil
+	}
+
+	multipart := strings.Contains(src.ETag, "-") || strings.Contains(dst.ETag, "-")
+	if s.opts.Compare == objectStoreCompareETag && !multipart {
+		return strings.Trim(dst.ETag, `"`) == strings.Trim(src.ETag, `"`), nil
+	}
+	return !dst.LastModified.Before(src.LastModified), nil
+}
+
+// copyObject copies the object from the source to the destination. when checksum verification
+// is enabled the destination object is read back and its sha256 compared against the sha256 of
+// the data read from the source.
+func (s *objectStoreSyncer) copyObject(ctx context.Context, bucket string, object minio.ObjectInfo) error {
+	reader, err := s.src.OpenObject(ctx, bucket, object.Key)
+	if err != nil {
+		return fmt.Errorf("failed to get object from source: %w", err)
+	}
+	defer reader.Close()
+
+	srcHash := sha256.New()
+	var body io.Reader = reader
+	if s.opts.VerifyChecksum {
+		body = io.TeeReader(reader, srcHash)
+	}
+
+	var contentEncoding string
+	if object.Metadata != nil {
+		contentEncoding = object.Metadata.Get("Content-Encoding")
+	}
+	if _, err := s.dst.PutObjectWithContext(ctx, s.opts.destinationBucket(bucket), object.Key, body, object.Size, minio.PutObjectOptions{
+		ContentType:     object.ContentType,
+		ContentEncoding: contentEncoding,
+	}); err != nil {
+		return fmt.Errorf("failed to copy object to destination: %w", err)
+	}
+
+	if !s.opts.VerifyChecksum {
+		return nil
+	}
+
+	written, err := s.dst.OpenObject(ctx, s.opts.destinationBucket(bucket), object.Key)
+	if err != nil {
+		return fmt.Errorf("failed to get object from destination: %w", err)
+	}
+	defer written.Close()
+
+	dstHash := sha256.New()
+	if _, err := io.Copy(dstHash, written); err != nil {
+		return fmt.Errorf("failed to read object from destination: %w", err)
+	}
+	if !bytes.Equal(srcHash.Sum(nil), dstHash.Sum(nil)) {
+		return errors.New("checksum mismatch between source and destination")
+	}
+	return nil
+}
+
+func (s *objectStoreSyncer) skip() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.report.Skipped++
+}
+
+func (s *objectStoreSyncer) fail(bucket, key string, err error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.log.Printf("Failed to sync %s/%s: %v", bucket, key, err)
+	s.report.Failed = append(s.report.Failed, objectStoreSyncFailure{Bucket: bucket, Key: key, Err: err})
+}
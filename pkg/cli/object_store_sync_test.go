@@ -0,0 +1,496 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memObject struct {
+	data     []byte
+	etag     string
+	modified time.Time
+}
+
+// memObjectStore is an in memory objectStore implementation.
+type memObjectStore struct {
+	mtx     sync.Mutex
+	buckets map[string]map[string]memObject
+	now     time.Time
+	puts    int
+	putErr  map[string]error
+	corrupt bool
+}
+
+func newMemObjectStore() *memObjectStore {
+	return &memObjectStore{
+		buckets: map[string]map[string]memObject{},
+		now:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		putErr:  map[string]error{},
+	}
+}
+
+func (m *memObjectStore) add(bucket, key, data string) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if _, ok := m.buckets[bucket]; !ok {
+		m.buckets[bucket] = map[string]memObject{}
+	}
+	sum := md5.Sum([]byte(data))
+	m.now = m.now.Add(time.Second)
+	m.buckets[bucket][key] = memObject{data: []byte(data), etag: hex.EncodeToString(sum[:]), modified: m.now}
+}
+
+func (m *memObjectStore) keys(bucket string) []string {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var keys []string
+	for key := range m.buckets[bucket] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (m *memObjectStore) ListBuckets() ([]minio.BucketInfo, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	var result []minio.BucketInfo
+	for name := range m.buckets {
+		result = append(result, minio.BucketInfo{Name: name})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result, nil
+}
+
+func (m *memObjectStore) BucketExists(bucketName string) (bool, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	_, ok := m.buckets[bucketName]
+	return ok, nil
+}
+
+func (m *memObjectStore) MakeBucket(bucketName string, _ string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.buckets[bucketName] = map[string]memObject{}
+	return nil
+}
+
+func (m *memObjectStore) ListObjects(bucketName, _ string, _ bool, _ <-chan struct{}) <-chan minio.ObjectInfo {
+	ch := make(chan minio.ObjectInfo)
+	go func() {
+		defer close(ch)
+		for _, key := range m.keys(bucketName) {
+			info, err := m.StatObject(bucketName, key, minio.StatObjectOptions{})
+			if err != nil {
+				continue
+			}
+			ch <- info
+		}
+	}()
+	return ch
+}
+
+func (m *memObjectStore) StatObject(bucketName, objectName string, _ minio.StatObjectOptions) (minio.ObjectInfo, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	obj, ok := m.buckets[bucketName][objectName]
+	if !ok {
+		return minio.ObjectInfo{}, minio.ErrorResponse{Code: "NoSuchKey"}
+	}
+	return minio.ObjectInfo{
+		Key:          objectName,
+		ETag:         obj.etag,
+		Size:         int64(len(obj.data)),
+		LastModified: obj.modified,
+	}, nil
+}
+
+func (m *memObjectStore) PutObjectWithContext(_ context.Context, bucketName, objectName string, reader io.Reader, _ int64, _ minio.PutObjectOptions) (int64, error) {
+	if err := m.putErr[objectName]; err != nil {
+		return 0, err
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, err
+	}
+	if m.corrupt {
+		data = append(data, '!')
+	}
+	m.add(bucketName, objectName, string(data))
+	m.mtx.Lock()
+	m.puts++
+	m.mtx.Unlock()
+	return int64(len(data)), nil
+}
+
+func (m *memObjectStore) RemoveObject(bucketName, objectName string) error {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	delete(m.buckets[bucketName], objectName)
+	return nil
+}
+
+func (m *memObjectStore) OpenObject(_ context.Context, bucketName, objectName string) (io.ReadCloser, error) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	obj, ok := m.buckets[bucketName][objectName]
+	if !ok {
+		return nil, minio.ErrorResponse{Code: "NoSuchKey"}
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+func defaultSyncOptions() objectStoreSyncOptions {
+	return objectStoreSyncOptions{Workers: 3, Compare: objectStoreCompareETag}
+}
+
+func Test_objectStoreSyncer_Sync(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+
+	src := newMemObjectStore()
+	for i := 0; i < 20; i++ {
+		src.add("registry", fmt.Sprintf("blob-%02d", i), fmt.Sprintf("content %d", i))
+	}
+	src.add("velero", "backup.tar.gz", "backup")
+
+	dst := newMemObjectStore()
+	dst.add("velero", "extraneous", "old")
+
+	syncer, err := newObjectStoreSyncer(src, dst, defaultSyncOptions(), logger)
+	require.NoError(t, err)
+	report, err := syncer.Sync(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, objectStoreSyncReport{Buckets: 2, Copied: 21, Bytes: 196}, *report)
+	assert.Equal(t, src.keys("registry"), dst.keys("registry"))
+	assert.Equal(t, []string{"backup.tar.gz", "extraneous"}, dst.keys("velero"))
+
+	// a second run skips everything as the etags match.
+	syncer, err = newObjectStoreSyncer(src, dst, defaultSyncOptions(), logger)
+	require.NoError(t, err)
+	report, err = syncer.Sync(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, objectStoreSyncReport{Buckets: 2, Skipped: 21}, *report)
+
+	// changed objects are copied again and mirror mode deletes the extraneous object.
+	src.add("velero", "backup.tar.gz", "new backup")
+	opts := defaultSyncOptions()
+	opts.DeleteExtraneous = true
+	syncer, err = newObjectStoreSyncer(src, dst, opts, logger)
+	require.NoError(t, err)
+	report, err = syncer.Sync(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, objectStoreSyncReport{Buckets: 2, Copied: 1, Skipped: 20, Deleted: 1, Bytes: 10}, *report)
+	assert.Equal(t, []string{"backup.tar.gz"}, dst.keys("velero"))
+}
+
+func Test_objectStoreSyncer_Failures(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+
+	src := newMemObjectStore()
+	src.add("bucket", "a", "a")
+	src.add("bucket", "b", "b")
+	src.add("bucket", "c", "c")
+
+	dst := newMemObjectStore()
+	dst.add("bucket", "extraneous", "x")
+	dst.putErr["b"] = errors.New("disk full")
+
+	opts := defaultSyncOptions()
+	opts.DeleteExtraneous = true
+	syncer, err := newObjectStoreSyncer(src, dst, opts, logger)
+	require.NoError(t, err)
+	report, err := syncer.Sync(context.Background())
+	assert.EqualError(t, err, "failed to sync 1 objects")
+	assert.Equal(t, 2, report.Copied)
+	require.Len(t, report.Failed, 1)
+	assert.Equal(t, "b", report.Failed[0].Key)
+	assert.ErrorContains(t, report.Failed[0].Err, "disk full")
+	// extraneous objects are kept if anything failed.
+	assert.Equal(t, []string{"a", "c", "extraneous"}, dst.keys("bucket"))
+
+	output := bytes.NewBuffer(nil)
+	report.Print(output)
+	assert.Contains(t, output.String(), "Failed to sync bucket/b: failed to copy object to destination: disk full")
+}
+
+func Test_objectStoreSyncer_Checkpoint(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint")
+
+	src := newMemObjectStore()
+	src.add("bucket", "a", "a")
+	src.add("bucket", "b", "b")
+
+	dst := newMemObjectStore()
+	dst.putErr["b"] = errors.New("network error")
+
+	opts := defaultSyncOptions()
+	opts.CheckpointFile = checkpoint
+	opts.Compare = objectStoreCompareNone
+	syncer, err := newObjectStoreSyncer(src, dst, opts, logger)
+	require.NoError(t, err)
+	_, err = syncer.Sync(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, 1, dst.puts)
+
+	// resuming only copies the object that failed even though compare mode is none.
+	delete(dst.putErr, "b")
+	syncer, err = newObjectStoreSyncer(src, dst, opts, logger)
+	require.NoError(t, err)
+	report, err := syncer.Sync(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, objectStoreSyncReport{Buckets: 1, Copied: 1, Skipped: 1, Bytes: 1}, *report)
+	assert.Equal(t, 2, dst.puts)
+
+	// objects synced to another destination bucket are copied to the renamed bucket.
+	opts.RenameBuckets = map[string]string{"bucket": "renamed"}
+	syncer, err = newObjectStoreSyncer(src, dst, opts, logger)
+	require.NoError(t, err)
+	report, err = syncer.Sync(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, objectStoreSyncReport{Buckets: 1, Copied: 2, Bytes: 2}, *report)
+	assert.Equal(t, []string{"a", "b"}, dst.keys("renamed"))
+}
+
+func Test_objectStoreSyncer_isSame(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	dst := newMemObjectStore()
+	dst.add("bucket", "key", "data")
+	dstInfo, err := dst.StatObject("bucket", "key", minio.StatObjectOptions{})
+	require.NoError(t, err)
+
+	for _, tt := range []struct {
+		name    string
+		compare string
+		src     minio.ObjectInfo
+		same    bool
+	}{
+		{
+			name:    "etag matches",
+			compare: objectStoreCompareETag,
+			src:     minio.ObjectInfo{Key: "key", Size: 4, ETag: `"` + dstInfo.ETag + `"`},
+			same:    true,
+		},
+		{
+			name:    "etag differs",
+			compare: objectStoreCompareETag,
+			src:     minio.ObjectInfo{Key: "key", Size: 4, ETag: "other"},
+		},
+		{
+			name:    "multipart etag falls back to size and mtime",
+			compare: objectStoreCompareETag,
+			src:     minio.ObjectInfo{Key: "key", Size: 4, ETag: "abc-2", LastModified: dstInfo.LastModified.Add(-time.Hour)},
+			same:    true,
+		},
+		{
+			name:    "size differs",
+			compare: objectStoreCompareSizeMtime,
+			src:     minio.ObjectInfo{Key: "key", Size: 5},
+		},
+		{
+			name:    "source is newer",
+			compare: objectStoreCompareSizeMtime,
+			src:     minio.ObjectInfo{Key: "key", Size: 4, LastModified: dstInfo.LastModified.Add(time.Hour)},
+		},
+		{
+			name:    "missing in destination",
+			compare: objectStoreCompareSizeMtime,
+			src:     minio.ObjectInfo{Key: "missing", Size: 4},
+		},
+		{
+			name:    "compare none",
+			compare: objectStoreCompareNone,
+			src:     minio.ObjectInfo{Key: "key", Size: 4, ETag: dstInfo.ETag},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := defaultSyncOptions()
+			opts.Compare = tt.compare
+			syncer, err := newObjectStoreSyncer(newMemObjectStore(), dst, opts, logger)
+			require.NoError(t, err)
+			same, err := syncer.isSame("bucket", tt.src)
+			require.NoError(t, err)
+			assert.Equal(t, tt.same, same)
+		})
+	}
+}
+
+func Test_objectStoreSyncer_VerifyChecksum(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+
+	src := newMemObjectStore()
+	src.add("bucket", "a", "a")
+
+	dst := newMemObjectStore()
+	dst.corrupt = true
+
+	opts := defaultSyncOptions()
+	opts.VerifyChecksum = true
+	syncer, err := newObjectStoreSyncer(src, dst, opts, logger)
+	require.NoError(t, err)
+	report, err := syncer.Sync(context.Background())
+	require.Error(t, err)
+	require.Len(t, report.Failed, 1)
+	assert.EqualError(t, report.Failed[0].Err, "checksum mismatch between source and destination")
+
+	dst.corrupt = false
+	syncer, err = newObjectStoreSyncer(src, dst, opts, logger)
+	require.NoError(t, err)
+	_, err = syncer.Sync(context.Background())
+	require.NoError(t, err)
+}
+
+func Test_objectStoreSyncOptions_validate(t *testing.T) {
+	assert.EqualError(t, objectStoreSyncOptions{Workers: 0, Compare: objectStoreCompareETag}.validate(), "workers must be greater than zero")
+	assert.ErrorContains(t, objectStoreSyncOptions{Workers: 1, Compare: "md5"}.validate(), `invalid compare mode "md5"`)
+	assert.ErrorContains(t, objectStoreSyncOptions{Workers: 1, Compare: objectStoreCompareETag, ExcludeBuckets: []string{"["}}.validate(), `invalid bucket pattern "["`)
+	assert.EqualError(t, objectStoreSyncOptions{Workers: 1, Compare: objectStoreCompareETag, RenameBuckets: map[string]string{"a": ""}}.validate(), `invalid bucket rename "a"=""`)
+	assert.ErrorContains(t, objectStoreSyncOptions{Workers: 1, Compare: objectStoreCompareETag, RenameBuckets: map[string]string{"a": "c", "b": "c"}}.validate(), "are both renamed to c")
+	assert.NoError(t, objectStoreSyncOptions{Workers: 1, Compare: objectStoreCompareSizeMtime}.validate())
+}
+
+func Test_objectStoreSyncer_BucketFilters(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+
+	src := newMemObjectStore()
+	src.add("velero", "backup", "backup")
+	src.add("velero-restic", "snapshot", "snapshot")
+	src.add("registry", "blob", "blob")
+	src.add("kotsadm", "archive", "archive")
+
+	dst := newMemObjectStore()
+
+	opts := defaultSyncOptions()
+	opts.IncludeBuckets = []string{"velero*", "registry"}
+	opts.ExcludeBuckets = []string{"*-restic"}
+	opts.RenameBuckets = map[string]string{"velero": "velero-backups"}
+	opts.DeleteExtraneous = true
+	syncer, err := newObjectStoreSyncer(src, dst, opts, logger)
+	require.NoError(t, err)
+	report, err := syncer.Sync(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, objectStoreSyncReport{Buckets: 2, Copied: 2, Bytes: 10}, *report)
+
+	buckets, err := dst.ListBuckets()
+	require.NoError(t, err)
+	var names []string
+	for _, bucket := range buckets {
+		names = append(names, bucket.Name)
+	}
+	assert.Equal(t, []string{"registry", "velero-backups"}, names)
+	assert.Equal(t, []string{"backup"}, dst.keys("velero-backups"))
+
+	// renamed buckets are compared against the renamed destination bucket.
+	syncer, err = newObjectStoreSyncer(src, dst, opts, logger)
+	require.NoError(t, err)
+	report, err = syncer.Sync(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, objectStoreSyncReport{Buckets: 2, Skipped: 2}, *report)
+}
+
+func Test_objectStoreEndpoint_client(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/" {
+			w.WriteHeader(http.StatusNotImplemented)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = io.WriteString(w, `<?xml version="1.0" encoding="UTF-8"?>
+<ListAllMyBucketsResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+<Owner><ID>minio</ID><DisplayName>minio</DisplayName></Owner>
+<Buckets><Bucket><Name>velero</Name><CreationDate>2024-01-01T00:00:00.000Z</CreationDate></Bucket></Buckets>
+</ListAllMyBucketsResult>`)
+	}))
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	require.NoError(t, os.WriteFile(caFile, ca, 0600))
+	invalidCAFile := filepath.Join(t.TempDir(), "invalid.pem")
+	require.NoError(t, os.WriteFile(invalidCAFile, []byte("not a certificate"), 0600))
+
+	for _, tt := range []struct {
+		name      string
+		endpoint  objectStoreEndpoint
+		clientErr string
+		listErr   bool
+	}{
+		{
+			name:     "custom ca",
+			endpoint: objectStoreEndpoint{Host: host, TLS: true, CACertFile: caFile, Region: "us-east-1", PathStyle: true},
+		},
+		{
+			name:     "skip verification",
+			endpoint: objectStoreEndpoint{Host: host, TLS: true, InsecureSkipVerify: true},
+		},
+		{
+			name:     "unknown authority",
+			endpoint: objectStoreEndpoint{Host: host, TLS: true},
+			listErr:  true,
+		},
+		{
+			name:     "plain http against tls server",
+			endpoint: objectStoreEndpoint{Host: host},
+			listErr:  true,
+		},
+		{
+			name:      "invalid ca bundle",
+			endpoint:  objectStoreEndpoint{Host: host, TLS: true, CACertFile: invalidCAFile},
+			clientErr: "no certificates found",
+		},
+		{
+			name:      "ca without tls",
+			endpoint:  objectStoreEndpoint{Host: host, CACertFile: caFile},
+			clientErr: "tls must be enabled",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := tt.endpoint.client()
+			if tt.clientErr != "" {
+				assert.ErrorContains(t, err, tt.clientErr)
+				return
+			}
+			require.NoError(t, err)
+
+			buckets, err := client.ListBuckets()
+			if tt.listErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, buckets, 1)
+			assert.Equal(t, "velero", buckets[0].Name)
+		})
+	}
+}
+
+func Test_newSyncObjectStoreCmd_FlagNames(t *testing.T) {
+	cmd := newSyncObjectStoreCmd(nil)
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		assert.NotContains(t, f.Name, "-", "flags of this command use underscores")
+	})
+	assert.NotNil(t, cmd.Flags().Lookup("rename_bucket"))
+}
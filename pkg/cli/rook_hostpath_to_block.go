@@ -7,19 +7,29 @@ import (
 )
 
 func NewHostpathToBlockCmd(_ CLI) *cobra.Command {
+	var opts rook.HostpathToOsdOptions
 	cmd := &cobra.Command{
 		Use:   "hostpath-to-block",
 		Short: "Migrates rook hostpath data to block device volumes, changing the rook cluster config if needed",
+		Long: `Migrates rook hostpath data to block device volumes, changing the rook cluster config if needed.
+
+Hostpath OSDs are removed one at a time. The migration can be limited to some nodes with --nodes and be run again
+to resume an interrupted migration or to migrate the remaining nodes, so that it can be spread over several
+maintenance windows.`,
 		RunE: func(cmd *cobra.Command, _ []string) error {
 			k8sConfig := config.GetConfigOrDie()
 
 			rook.InitWriter(cmd.OutOrStdout())
 
-			err := rook.HostpathToOsd(cmd.Context(), k8sConfig)
+			err := rook.HostpathToOsd(cmd.Context(), k8sConfig, opts)
 			return err
 		},
 		SilenceUsage: true,
 	}
 
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "Print the hostpath OSDs that would be removed without changing the cluster.")
+	cmd.Flags().StringSliceVar(&opts.Nodes, "nodes", nil, "Only migrate the hostpath OSDs on these nodes, by name or IP. All nodes if not set.")
+	cmd.Flags().DurationVar(&opts.PauseBetweenRemovals, "pause-between-removals", 0, "The time to wait between OSD removals to let recovery I/O settle.")
+
 	return cmd
 }
@@ -1,18 +1,227 @@
 package cli
 
 import (
-	"github.com/replicatedhq/kurl/pkg/version"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/replicatedhq/kurlkinds/client/kurlclientset"
 	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+
+	"github.com/replicatedhq/kurl/pkg/installer"
+	"github.com/replicatedhq/kurl/pkg/rook"
+	"github.com/replicatedhq/kurl/pkg/version"
 )
 
+// versionReport is the json output of the version command, Cluster is only set with --cluster.
+type versionReport struct {
+	version.Info
+	Cluster *clusterVersions `json:"cluster,omitempty"`
+}
+
+// clusterVersions are the versions of the components installed in the cluster, empty when not installed.
+type clusterVersions struct {
+	Kubernetes        string         `json:"kubernetes"`
+	Kurl              string         `json:"kurl,omitempty"`
+	Containerd        []string       `json:"containerd,omitempty"`
+	Rook              []string       `json:"rook,omitempty"`
+	Ceph              []string       `json:"ceph,omitempty"`
+	Longhorn          string         `json:"longhorn,omitempty"`
+	OpenEBS           string         `json:"openebs,omitempty"`
+	EKCO              string         `json:"ekco,omitempty"`
+	InstallerID       string         `json:"installerID,omitempty"`
+	InstallerSpecHash string         `json:"installerSpecHash,omitempty"`
+	Nodes             []nodeVersions `json:"nodes"`
+}
+
+type nodeVersions struct {
+	Name             string `json:"name"`
+	Kubelet          string `json:"kubelet"`
+	ContainerRuntime string `json:"containerRuntime"`
+}
+
 func newVersionCmd(_ CLI) *cobra.Command {
+	var output string
+	var cluster bool
 	cmd := &cobra.Command{
 		Use:   "version",
 		Short: "Prints the kURL version",
+		PreRunE: func(cmd *cobra.Command, _ []string) error {
+			if output != "text" && output != "json" {
+				return fmt.Errorf("invalid output %q, must be text or json", output)
+			}
+			cmd.SilenceUsage = true
+			return nil
+		},
 		RunE: func(cmd *cobra.Command, _ []string) error {
+			report := versionReport{Info: version.Get()}
+			if cluster {
+				k8sConfig, err := config.GetConfig()
+				if err != nil {
+					return fmt.Errorf("failed to get kubernetes config: %w", err)
+				}
+				kcli, err := kubernetes.NewForConfig(k8sConfig)
+				if err != nil {
+					return fmt.Errorf("failed to create kubernetes client: %w", err)
+				}
+				kurlcli, err := kurlclientset.NewForConfig(k8sConfig)
+				if err != nil {
+					return fmt.Errorf("failed to create kurl client: %w", err)
+				}
+				if report.Cluster, err = getClusterVersions(cmd.Context(), kcli, kurlcli); err != nil {
+					return err
+				}
+			}
+
+			if output == "json" {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(report); err != nil {
+					return fmt.Errorf("failed to encode version: %w", err)
+				}
+				return nil
+			}
 			version.Fprint(cmd.OutOrStdout())
+			if report.Cluster != nil {
+				writeClusterVersions(cmd.OutOrStdout(), report.Cluster)
+			}
 			return nil
 		},
 	}
+	cmd.Flags().StringVarP(&output, "output", "o", "text", "The output format (text or json).")
+	cmd.Flags().BoolVar(&cluster, "cluster", false, "Also print the versions of the components installed in the cluster.")
 	return cmd
 }
+
+// getClusterVersions reads the versions of kubernetes, the container runtime and the add-ons from the cluster, and
+// hashes the installer spec currently applied.
+func getClusterVersions(ctx context.Context, kcli kubernetes.Interface, kurlcli kurlclientset.Interface) (*clusterVersions, error) {
+	versions := &clusterVersions{}
+	serverVersion, err := kcli.Discovery().ServerVersion()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get kubernetes version: %w", err)
+	}
+	versions.Kubernetes = serverVersion.GitVersion
+
+	nodes, err := kcli.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+	containerd := map[string]bool{}
+	for _, node := range nodes.Items {
+		info := node.Status.NodeInfo
+		versions.Nodes = append(versions.Nodes, nodeVersions{
+			Name:             node.Name,
+			Kubelet:          info.KubeletVersion,
+			ContainerRuntime: info.ContainerRuntimeVersion,
+		})
+		if v, ok := strings.CutPrefix(info.ContainerRuntimeVersion, "containerd://"); ok && !containerd[v] {
+			containerd[v] = true
+			versions.Containerd = append(versions.Containerd, v)
+		}
+	}
+	sort.Strings(versions.Containerd)
+
+	if versions.Rook, err = rook.RookOrCephVersions(ctx, kcli, "rook-version"); err != nil {
+		return nil, err
+	}
+	if versions.Ceph, err = rook.RookOrCephVersions(ctx, kcli, "ceph-version"); err != nil {
+		return nil, err
+	}
+
+	if versions.OpenEBS, err = deploymentImageVersion(ctx, kcli, "openebs", "openebs-localpv-provisioner"); err != nil {
+		return nil, err
+	}
+	if versions.EKCO, err = deploymentImageVersion(ctx, kcli, ekcoNamespace, ekcoDeploymentName); err != nil {
+		return nil, err
+	}
+	longhorn, err := kcli.AppsV1().DaemonSets(longhornNamespace).Get(ctx, "longhorn-manager", metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get longhorn-manager daemonset: %w", err)
+	} else if err == nil {
+		versions.Longhorn = containersImageVersion(longhorn.Spec.Template.Spec.Containers)
+	}
+
+	kurlConfig, err := kcli.CoreV1().ConfigMaps("kube-system").Get(ctx, "kurl-config", metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get kurl-config configmap: %w", err)
+	} else if err == nil {
+		versions.InstallerID = kurlConfig.Data["installer_id"]
+	}
+	currentConfig, err := kcli.CoreV1().ConfigMaps("kurl").Get(ctx, "kurl-current-config", metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return nil, fmt.Errorf("failed to get kurl-current-config configmap: %w", err)
+	} else if err == nil {
+		versions.Kurl = currentConfig.Data["kurl-version"]
+	}
+
+	if versions.InstallerID != "" {
+		spec, err := kurlcli.ClusterV1beta1().Installers(metav1.NamespaceDefault).Get(ctx, versions.InstallerID, metav1.GetOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("failed to get installer %s: %w", versions.InstallerID, err)
+		} else if err == nil {
+			if versions.InstallerSpecHash, err = installer.SpecHash(spec); err != nil {
+				return nil, fmt.Errorf("failed to hash installer %s: %w", versions.InstallerID, err)
+			}
+		}
+	}
+	return versions, nil
+}
+
+// deploymentImageVersion returns the image tag of the first container of a deployment, empty if the deployment does not
+// exist.
+func deploymentImageVersion(ctx context.Context, kcli kubernetes.Interface, namespace, name string) (string, error) {
+	deploy, err := kcli.AppsV1().Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	} else if err != nil {
+		return "", fmt.Errorf("failed to get %s deployment: %w", name, err)
+	}
+	return containersImageVersion(deploy.Spec.Template.Spec.Containers), nil
+}
+
+// containersImageVersion returns the tag, without the "v" prefix, of the first container image.
+func containersImageVersion(containers []corev1.Container) string {
+	if len(containers) == 0 {
+		return ""
+	}
+	image := containers[0].Image
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	i := strings.LastIndex(image, ":")
+	if i < 0 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+	return strings.TrimPrefix(image[i+1:], "v")
+}
+
+func writeClusterVersions(w io.Writer, versions *clusterVersions) {
+	for _, line := range []struct {
+		key   string
+		value string
+	}{
+		{"kubernetes", versions.Kubernetes},
+		{"kurl", versions.Kurl},
+		{"containerd", strings.Join(versions.Containerd, ",")},
+		{"rook", strings.Join(versions.Rook, ",")},
+		{"ceph", strings.Join(versions.Ceph, ",")},
+		{"longhorn", versions.Longhorn},
+		{"openebs", versions.OpenEBS},
+		{"ekco", versions.EKCO},
+		{"installer", versions.InstallerID},
+		{"installer_spec_hash", versions.InstallerSpecHash},
+	} {
+		if line.value != "" {
+			fmt.Fprintf(w, "%s=%s\n", line.key, line.value)
+		}
+	}
+}
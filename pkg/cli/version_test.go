@@ -0,0 +1,108 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	kurlfake "github.com/replicatedhq/kurlkinds/client/kurlclientset/fake"
+	kurlv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sversion "k8s.io/apimachinery/pkg/version"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/replicatedhq/kurl/pkg/installer"
+)
+
+func Test_getClusterVersions(t *testing.T) {
+	deployment := func(namespace, name, image string, labels map[string]string) *appsv1.Deployment {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: name, Image: image}},
+			}}},
+		}
+	}
+	node := func(name, runtime string) *corev1.Node {
+		return &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Status: corev1.NodeStatus{NodeInfo: corev1.NodeSystemInfo{
+				KubeletVersion:          "v1.27.3",
+				ContainerRuntimeVersion: runtime,
+			}},
+		}
+	}
+
+	kcli := fake.NewClientset(
+		node("node-a", "containerd://1.6.21"),
+		node("node-b", "containerd://1.6.22"),
+		deployment("rook-ceph", "rook-ceph-mgr-a", "quay.io/ceph/ceph:v17.2.6", map[string]string{"rook_cluster": "rook-ceph", "rook-version": "v1.12.8", "ceph-version": "17.2.6-0"}),
+		deployment("openebs", "openebs-localpv-provisioner", "openebs/provisioner-localpv:3.5.0", nil),
+		deployment("kurl", "ekc-operator", "replicated/ekco:v0.28.0@sha256:0123", nil),
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kurl-config", Namespace: "kube-system"},
+			Data:       map[string]string{"installer_id": "6abe39c"},
+		},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "kurl-current-config", Namespace: "kurl"},
+			Data:       map[string]string{"kurl-version": "v2024.01.02-0"},
+		},
+	)
+	kcli.Discovery().(*fakediscovery.FakeDiscovery).FakedServerVersion = &k8sversion.Info{GitVersion: "v1.27.3"}
+	spec := &kurlv1beta1.Installer{
+		ObjectMeta: metav1.ObjectMeta{Name: "6abe39c", Namespace: "default"},
+		Spec: kurlv1beta1.InstallerSpec{
+			Kubernetes: &kurlv1beta1.Kubernetes{Version: "1.27.x"},
+		},
+	}
+	kurlcli := kurlfake.NewSimpleClientset(spec)
+	specHash, err := installer.SpecHash(spec)
+	require.NoError(t, err)
+
+	versions, err := getClusterVersions(context.Background(), kcli, kurlcli)
+	require.NoError(t, err)
+	assert.Equal(t, &clusterVersions{
+		Kubernetes:        "v1.27.3",
+		Kurl:              "v2024.01.02-0",
+		Containerd:        []string{"1.6.21", "1.6.22"},
+		Rook:              []string{"1.12.8"},
+		Ceph:              []string{"17.2.6-0"},
+		OpenEBS:           "3.5.0",
+		EKCO:              "0.28.0",
+		InstallerID:       "6abe39c",
+		InstallerSpecHash: specHash,
+		Nodes: []nodeVersions{
+			{Name: "node-a", Kubelet: "v1.27.3", ContainerRuntime: "containerd://1.6.21"},
+			{Name: "node-b", Kubelet: "v1.27.3", ContainerRuntime: "containerd://1.6.22"},
+		},
+	}, versions)
+
+	var out bytes.Buffer
+	writeClusterVersions(&out, versions)
+	assert.Equal(t, `kubernetes=v1.27.3
+kurl=v2024.01.02-0
+containerd=1.6.21,1.6.22
+rook=1.12.8
+ceph=17.2.6-0
+openebs=3.5.0
+ekco=0.28.0
+installer=6abe39c
+installer_spec_hash=`+specHash+"\n", out.String())
+}
+
+func Test_containersImageVersion(t *testing.T) {
+	tests := map[string]string{
+		"replicated/ekco:v0.28.0":                                   "0.28.0",
+		"registry.local:5000/longhorn-manager":                      "",
+		"registry.local:5000/openebs/provisioner:3.5.0@sha256:0123": "3.5.0",
+	}
+	for image, want := range tests {
+		assert.Equal(t, want, containersImageVersion([]corev1.Container{{Image: image}}), image)
+	}
+	assert.Equal(t, "", containersImageVersion(nil))
+}
@@ -5,20 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"slices"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/distribution/reference"
-	"github.com/google/uuid"
 	"github.com/replicatedhq/kurl/pkg/k8sutil"
-	"golang.org/x/sync/errgroup"
-	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/utils/ptr"
 )
 
 const (
@@ -30,9 +27,12 @@ const (
 	// DefaultNodeImagesJobTimeout is the default timeout for the node images job
 	// This timeout must be greater than 0
 	DefaultNodeImagesJobTimeout = 120 * time.Second
+
+	nodeImagesContainerName = "node-images"
 )
 
-// NodeImagesJobOptions are options for the node images job
+// NodeImagesJobOptions are options for the node images job. TargetNode and ExcludeNodes are matched
+// against the kubernetes.io/hostname label of the nodes.
 type NodeImagesJobOptions struct {
 	JobNamespace string
 	JobImage     string
@@ -44,74 +44,90 @@ type NodeImagesJobOptions struct {
 }
 
 // nodeImagesJobRunner is used for testing
-type nodeImagesJobRunner func(context.Context, kubernetes.Interface, *log.Logger, corev1.Node, NodeImagesJobOptions) ([]corev1.ContainerImage, error)
+type nodeImagesJobRunner func(context.Context, kubernetes.Interface, *log.Logger, k8sutil.NodeJobOptions) ([]k8sutil.NodeJobResult, error)
 
 // NodeImages returns a map of node names to maps of images present on that node.
 // It will use node.Status.Images if it is likely comprehensive, otherwise it will fallback to run
-// a job on all nodes if not.
+// a job on the node.
 func NodeImages(ctx context.Context, client kubernetes.Interface, logger *log.Logger, opts NodeImagesJobOptions) (map[string]map[string]struct{}, error) {
-	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	selector, err := nodeImagesNodeSelector(opts)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: selector})
 	if err != nil {
 		return nil, fmt.Errorf("unable to list nodes: %w", err)
 	}
 
 	nodeImages := map[string]map[string]struct{}{}
-
-	g := errgroup.Group{}
+	for _, node := range nodes.Items {
+		nodeImages[node.Name] = imageNames(node.Status.Images)
+	}
 
 	if opts.nodeImagesJobRunner == nil {
-		opts.nodeImagesJobRunner = runNodeImagesJob
+		opts.nodeImagesJobRunner = k8sutil.RunNodeJobs
 	}
-
-	for _, n := range nodes.Items {
-		node := n
-		if opts.TargetNode != "" && node.Name != opts.TargetNode {
-			continue
-		}
-		if slices.Contains(opts.ExcludeNodes, node.Name) {
+	jobOpts := nodeImagesJobOptions(opts)
+	jobOpts.NodeSelector = selector
+	results, err := opts.nodeImagesJobRunner(ctx, client, logger, jobOpts)
+	if err != nil {
+		// best effort
+		logger.Printf("Failed to run node images jobs: %s", err)
+		return nodeImages, nil
+	}
+	for _, result := range results {
+		images, err := parseNodeImagesJobResult(result)
+		if err != nil {
+			// best effort
+			logger.Printf("Failed to run node images job on node %s: %s", result.Node, err)
 			continue
 		}
-		thisNodeImages := map[string]struct{}{}
-		for _, image := range node.Status.Images {
-			for _, name := range image.Names {
-				ref, _ := reference.ParseDockerRef(name)
-				if ref != nil {
-					name = ref.String()
-				}
-				thisNodeImages[name] = struct{}{}
-			}
+		nodeImages[result.Node] = imageNames(images)
+	}
+
+	return nodeImages, nil
+}
+
+// nodeImagesNodeSelector returns the label selector of the nodes matching the TargetNode and ExcludeNodes options.
+func nodeImagesNodeSelector(opts NodeImagesJobOptions) (string, error) {
+	selector := labels.NewSelector()
+	if opts.TargetNode != "" {
+		req, err := labels.NewRequirement(corev1.LabelHostname, selection.Equals, []string{opts.TargetNode})
+		if err != nil {
+			return "", fmt.Errorf("invalid target node %q: %w", opts.TargetNode, err)
 		}
-		nodeImages[node.Name] = thisNodeImages
-		// 50 is the default value for max images per node. If the length is equal it is likely
-		// that the node has more than 50 images.
-		if len(node.Status.Images) == 0 || len(node.Status.Images) == 50 {
-			g.Go(func() error {
-				images, err := opts.nodeImagesJobRunner(ctx, client, logger, node, opts)
-				if err != nil {
-					return fmt.Errorf("failed to run job on node %s: %w", node.Name, err)
-				}
-				thisNodeImages := map[string]struct{}{}
-				for _, image := range images {
-					for _, name := range image.Names {
-						ref, _ := reference.ParseDockerRef(name)
-						if ref != nil {
-							name = ref.String()
-						}
-						thisNodeImages[name] = struct{}{}
-					}
-				}
-				nodeImages[node.Name] = thisNodeImages
-				return nil
-			})
+		selector = selector.Add(*req)
+	}
+	if len(opts.ExcludeNodes) > 0 {
+		req, err := labels.NewRequirement(corev1.LabelHostname, selection.NotIn, opts.ExcludeNodes)
+		if err != nil {
+			return "", fmt.Errorf("invalid exclude nodes %q: %w", opts.ExcludeNodes, err)
 		}
+		selector = selector.Add(*req)
 	}
+	return selector.String(), nil
+}
 
-	if err := g.Wait(); err != nil {
-		// best effort
-		logger.Printf("Failed to run node images jobs: %s", err)
-	}
+// nodeImagesIncomplete returns true if node.Status.Images is likely not comprehensive.
+func nodeImagesIncomplete(node corev1.Node) bool {
+	// 50 is the default value for max images per node. If the length is equal it is likely
+	// that the node has more than 50 images.
+	return len(node.Status.Images) == 0 || len(node.Status.Images) == 50
+}
 
-	return nodeImages, nil
+// imageNames returns the set of the canonical names of the provided images.
+func imageNames(images []corev1.ContainerImage) map[string]struct{} {
+	names := map[string]struct{}{}
+	for _, image := range images {
+		for _, name := range image.Names {
+			ref, _ := reference.ParseDockerRef(name)
+			if ref != nil {
+				name = ref.String()
+			}
+			names[name] = struct{}{}
+		}
+	}
+	return names
 }
 
 // NodesMissingImages returns the list of nodes missing any one of the images in the provided list
@@ -148,17 +164,12 @@ func NodesMissingImages(ctx context.Context, client kubernetes.Interface, logger
 	return missingNodesList, nil
 }
 
-func runNodeImagesJob(ctx context.Context, client kubernetes.Interface, logger *log.Logger, node corev1.Node, opts NodeImagesJobOptions) ([]corev1.ContainerImage, error) {
-	job := buildNodeImagesJob(ctx, opts.JobNamespace, opts.JobImage, node)
-	timeout := opts.Timeout
-	if timeout == 0 {
-		timeout = DefaultNodeImagesJobTimeout
+// parseNodeImagesJobResult returns the images listed by the node images job.
+func parseNodeImagesJobResult(result k8sutil.NodeJobResult) ([]corev1.ContainerImage, error) {
+	if result.Err != nil {
+		return nil, result.Err
 	}
-	logs, _, err := k8sutil.RunJob(ctx, client, logger, job, timeout)
-	if err != nil {
-		return nil, err
-	}
-	containerLogs, ok := logs[job.Spec.Template.Spec.Containers[0].Name]
+	containerLogs, ok := result.Logs[nodeImagesContainerName]
 	if !ok {
 		return nil, fmt.Errorf("failed to find container logs")
 	}
@@ -171,7 +182,7 @@ func runNodeImagesJob(ctx context.Context, client kubernetes.Interface, logger *
 			Size        string   `json:"size"`
 		} `json:"images"`
 	}{}
-	err = json.Unmarshal(containerLogs, &criImages)
+	err := json.Unmarshal(containerLogs, &criImages)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal images: %w", err)
 	}
@@ -189,28 +200,38 @@ func runNodeImagesJob(ctx context.Context, client kubernetes.Interface, logger *
 	return images, nil
 }
 
-func buildNodeImagesJob(_ context.Context, jobNamespace string, jobImage string, node corev1.Node) *batchv1.Job {
+// nodeImagesJobOptions returns the jobs listing the images present on the nodes through the cri socket. the jobs only
+// run on the nodes whose status images are likely not comprehensive.
+func nodeImagesJobOptions(opts NodeImagesJobOptions) k8sutil.NodeJobOptions {
+	jobNamespace := opts.JobNamespace
 	if jobNamespace == "" {
 		jobNamespace = DefaultNodeImagesJobNamespace
 	}
+	jobImage := opts.JobImage
 	if jobImage == "" {
 		jobImage = DefaultNodeImagesJobImage
 	}
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultNodeImagesJobTimeout
+	}
 
-	schedRules := &corev1.NodeSelector{
-		NodeSelectorTerms: []corev1.NodeSelectorTerm{
-			{
-				MatchExpressions: []corev1.NodeSelectorRequirement{
-					{
-						Key:      "kubernetes.io/hostname",
-						Operator: corev1.NodeSelectorOperator("In"),
-						Values:   []string{node.Name},
-					},
-				},
-			},
+	return k8sutil.NodeJobOptions{
+		Namespace:  jobNamespace,
+		NamePrefix: "node-images",
+		Labels: map[string]string{
+			"app": "kurl-job-node-images",
+		},
+		Timeout:    timeout,
+		NodeFilter: nodeImagesIncomplete,
+		NodePodTemplate: func(node corev1.Node) corev1.PodTemplateSpec {
+			return nodeImagesPodTemplate(jobImage, node)
 		},
 	}
+}
 
+// nodeImagesPodTemplate returns the pod listing the images present on the node through its cri socket.
+func nodeImagesPodTemplate(jobImage string, node corev1.Node) corev1.PodTemplateSpec {
 	typeSocket := corev1.HostPathSocket
 	criSocket := getCRISocketFilePath(node)
 	criSocketVolume := corev1.Volume{
@@ -229,51 +250,24 @@ func buildNodeImagesJob(_ context.Context, jobNamespace string, jobImage string,
 	}
 
 	command := getNodeImagesCommand(criSocket)
-	podSpec := corev1.PodSpec{
-		RestartPolicy: corev1.RestartPolicyNever,
-		Affinity: &corev1.Affinity{
-			NodeAffinity: &corev1.NodeAffinity{
-				RequiredDuringSchedulingIgnoredDuringExecution: schedRules,
+	return corev1.PodTemplateSpec{
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				criSocketVolume,
 			},
-		},
-		Volumes: []corev1.Volume{
-			criSocketVolume,
-		},
-		Containers: []corev1.Container{
-			{
-				Name:    "node-images",
-				Image:   jobImage,
-				Command: []string{command[0]},
-				Args:    command[1:],
-				VolumeMounts: []corev1.VolumeMount{
-					criSocketVolumeMount,
+			Containers: []corev1.Container{
+				{
+					Name:    nodeImagesContainerName,
+					Image:   jobImage,
+					Command: []string{command[0]},
+					Args:    command[1:],
+					VolumeMounts: []corev1.VolumeMount{
+						criSocketVolumeMount,
+					},
 				},
 			},
 		},
 	}
-
-	tmp := uuid.New().String()[:5]
-	jobName := fmt.Sprintf("node-images-%s-%s", node.Name, tmp)
-	if len(jobName) > 63 {
-		jobName = jobName[0:31] + jobName[len(jobName)-32:]
-	}
-
-	return &batchv1.Job{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      jobName,
-			Namespace: jobNamespace,
-			Labels: map[string]string{
-				"app": "kurl-job-node-images",
-			},
-		},
-		Spec: batchv1.JobSpec{
-			BackoffLimit:          ptr.To(int32(1)),
-			ActiveDeadlineSeconds: ptr.To(int64(120)),
-			Template: corev1.PodTemplateSpec{
-				Spec: podSpec,
-			},
-		},
-	}
 }
 
 // NOTE: this only works for unix sockets
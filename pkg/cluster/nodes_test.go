@@ -7,9 +7,11 @@ import (
 	"log"
 	"testing"
 
+	"github.com/replicatedhq/kurl/pkg/k8sutil"
 	"github.com/replicatedhq/kurl/pkg/rook/testfiles"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/fake"
@@ -68,10 +70,13 @@ func TestNodesMissingImages(t *testing.T) {
 			resources: runtimeFromNodesJSON(testfiles.UpgradedNode),
 			images:    []string{"docker.io/library/doesnotexist:latest"},
 			nodeImagesOpts: NodeImagesJobOptions{
-				nodeImagesJobRunner: func(_ context.Context, _ kubernetes.Interface, _ *log.Logger, _ corev1.Node, _ NodeImagesJobOptions) ([]corev1.ContainerImage, error) {
-					return []corev1.ContainerImage{
+				nodeImagesJobRunner: func(_ context.Context, _ kubernetes.Interface, _ *log.Logger, _ k8sutil.NodeJobOptions) ([]k8sutil.NodeJobResult, error) {
+					return []k8sutil.NodeJobResult{
 						{
-							Names: []string{"doesnotexist"},
+							Node: "laverya-rook-kubernetes-upgrade",
+							Logs: map[string][]byte{
+								"node-images": []byte(`{"images":[{"repoTags":["doesnotexist"],"size":"1"}]}`),
+							},
 						},
 					}, nil
 				},
@@ -83,10 +88,13 @@ func TestNodesMissingImages(t *testing.T) {
 			resources: runtimeFromNodesJSON(testfiles.UpgradedNode),
 			images:    []string{"registry.k8s.io/kube-state-metrics/kube-state-metrics:v2.5.0"},
 			nodeImagesOpts: NodeImagesJobOptions{
-				nodeImagesJobRunner: func(_ context.Context, _ kubernetes.Interface, _ *log.Logger, _ corev1.Node, _ NodeImagesJobOptions) ([]corev1.ContainerImage, error) {
-					return []corev1.ContainerImage{
+				nodeImagesJobRunner: func(_ context.Context, _ kubernetes.Interface, _ *log.Logger, _ k8sutil.NodeJobOptions) ([]k8sutil.NodeJobResult, error) {
+					return []k8sutil.NodeJobResult{
 						{
-							Names: []string{"doesnotexist"},
+							Node: "laverya-rook-kubernetes-upgrade",
+							Logs: map[string][]byte{
+								"node-images": []byte(`{"images":[{"repoTags":["doesnotexist"],"size":"1"}]}`),
+							},
 						},
 					}, nil
 				},
@@ -106,3 +114,76 @@ func TestNodesMissingImages(t *testing.T) {
 		})
 	}
 }
+
+func TestNodeImagesJobOptions(t *testing.T) {
+	nodes := []runtime.Object{
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{corev1.LabelHostname: "node-a"}},
+			Status:     corev1.NodeStatus{Images: []corev1.ContainerImage{{Names: []string{"docker.io/library/a:1"}}}},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-b", Labels: map[string]string{corev1.LabelHostname: "node-b"}},
+			Status:     corev1.NodeStatus{Images: []corev1.ContainerImage{{Names: []string{"docker.io/library/b:1"}}}},
+		},
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-c", Labels: map[string]string{corev1.LabelHostname: "node-c"}},
+		},
+	}
+	tests := []struct {
+		name         string
+		opts         NodeImagesJobOptions
+		wantSelector string
+		wantImages   map[string]map[string]struct{}
+	}{
+		{
+			name:       "all nodes",
+			wantImages: map[string]map[string]struct{}{"node-a": {"docker.io/library/a:1": {}}, "node-b": {"docker.io/library/b:1": {}}, "node-c": {"docker.io/library/c:1": {}}},
+		},
+		{
+			name:         "target node",
+			opts:         NodeImagesJobOptions{TargetNode: "node-b"},
+			wantSelector: "kubernetes.io/hostname=node-b",
+			wantImages:   map[string]map[string]struct{}{"node-b": {"docker.io/library/b:1": {}}},
+		},
+		{
+			name:         "exclude nodes",
+			opts:         NodeImagesJobOptions{ExcludeNodes: []string{"node-a", "node-b"}},
+			wantSelector: "kubernetes.io/hostname notin (node-a,node-b)",
+			wantImages:   map[string]map[string]struct{}{"node-c": {"docker.io/library/c:1": {}}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			clientset := fake.NewClientset(nodes...)
+			logger := log.New(io.Discard, "", 0)
+
+			tt.opts.nodeImagesJobRunner = func(ctx context.Context, client kubernetes.Interface, _ *log.Logger, opts k8sutil.NodeJobOptions) ([]k8sutil.NodeJobResult, error) {
+				req.Equal(tt.wantSelector, opts.NodeSelector)
+				nodes, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: opts.NodeSelector})
+				req.NoError(err)
+				var results []k8sutil.NodeJobResult
+				for _, node := range nodes.Items {
+					if !opts.NodeFilter(node) {
+						continue
+					}
+					// only node-c has no images in its status
+					req.Equal("node-c", node.Name)
+					container := opts.NodePodTemplate(node).Spec.Containers[0]
+					req.Equal(DefaultNodeImagesJobImage, container.Image)
+					results = append(results, k8sutil.NodeJobResult{
+						Node: node.Name,
+						Logs: map[string][]byte{
+							container.Name: []byte(`{"images":[{"repoTags":["c:1"],"size":"1"}]}`),
+						},
+					})
+				}
+				return results, nil
+			}
+
+			gotImages, err := NodeImages(context.Background(), clientset, logger, tt.opts)
+			req.NoError(err)
+			req.Equal(tt.wantImages, gotImages)
+		})
+	}
+}
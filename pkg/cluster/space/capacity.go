@@ -0,0 +1,91 @@
+package clusterspace
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	rookcli "github.com/rook/rook/pkg/client/clientset/versioned"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	OpenEBSLocalProvisioner = "openebs.io/local"
+	RookRBDProvisioner      = "rook-ceph.rbd.csi.ceph.com"
+	RookCephFSProvisioner   = "rook-ceph.cephfs.csi.ceph.com"
+	LonghornProvisioner     = "driver.longhorn.io"
+)
+
+// NodeCapacity holds the free, used and reserved bytes for a storage class in a single node.
+type NodeCapacity struct {
+	Free     int64 `json:"free"`
+	Used     int64 `json:"used"`
+	Reserved int64 `json:"reserved"`
+}
+
+// Capacity holds the capacity of a storage class. Nodes is only populated by providers whose
+// storage is bound to a node (e.g. OpenEBS local volumes and Longhorn disks). The totals take
+// replication into account, i.e. Free is the amount of data that can still be written to new
+// volumes using the storage class.
+type Capacity struct {
+	Nodes    map[string]NodeCapacity `json:"nodes,omitempty"`
+	Free     int64                   `json:"free"`
+	Used     int64                   `json:"used"`
+	Reserved int64                   `json:"reserved"`
+}
+
+// CapacityProvider is implemented by all storage backends capable of reporting their capacity.
+type CapacityProvider interface {
+	Capacity(ctx context.Context) (*Capacity, error)
+}
+
+// CapacityProviderOptions holds the clients and settings used when creating a CapacityProvider.
+// Only the clients needed by the storage class provisioner must be provided.
+type CapacityProviderOptions struct {
+	KubeClient     kubernetes.Interface
+	RookClient     rookcli.Interface
+	LonghornClient client.Client
+	Logger         *log.Logger
+	OpenEBSImage   string
+	StorageClass   string
+}
+
+// NewCapacityProvider returns a CapacityProvider for the provided storage class. the provider is
+// chosen based on the storage class provisioner.
+func NewCapacityProvider(ctx context.Context, opts CapacityProviderOptions) (CapacityProvider, error) {
+	if opts.KubeClient == nil {
+		return nil, fmt.Errorf("no kubernetes client provided")
+	}
+
+	sc, err := opts.KubeClient.StorageV1().StorageClasses().Get(ctx, opts.StorageClass, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get storage class %s: %w", opts.StorageClass, err)
+	}
+
+	var provider CapacityProvider
+	switch sc.Provisioner {
+	case OpenEBSLocalProvisioner:
+		if provider, err = NewOpenEBSFreeDiskSpaceGetter(opts.KubeClient, opts.Logger, opts.OpenEBSImage, sc.Name); err != nil {
+			return nil, fmt.Errorf("failed to create openebs capacity provider: %w", err)
+		}
+	case RookRBDProvisioner, RookCephFSProvisioner:
+		if opts.RookClient == nil {
+			return nil, fmt.Errorf("no rook client provided")
+		}
+		if provider, err = NewRookFreeDiskSpaceGetter(opts.KubeClient, opts.RookClient, sc.Name); err != nil {
+			return nil, fmt.Errorf("failed to create rook capacity provider: %w", err)
+		}
+	case LonghornProvisioner:
+		if opts.LonghornClient == nil {
+			return nil, fmt.Errorf("no longhorn client provided")
+		}
+		if provider, err = NewLonghornFreeDiskSpaceGetter(opts.KubeClient, opts.LonghornClient, sc.Name); err != nil {
+			return nil, fmt.Errorf("failed to create longhorn capacity provider: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("provisioner %q is not supported", sc.Provisioner)
+	}
+	return provider, nil
+}
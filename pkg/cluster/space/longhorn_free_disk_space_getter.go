@@ -0,0 +1,111 @@
+package clusterspace
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	lhv1b1 "github.com/longhorn/longhorn-manager/k8s/pkg/apis/longhorn/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	longhornNamespace       = "longhorn-system"
+	longhornDefaultReplicas = 3
+)
+
+type LonghornFreeDiskSpaceGetter struct {
+	kcli   kubernetes.Interface
+	lcli   client.Client
+	scname string
+}
+
+// replicas returns the number of replicas configured in the destination storage class. if the
+// storage class does not specify it we fallback to the longhorn default.
+func (l *LonghornFreeDiskSpaceGetter) replicas(ctx context.Context) (int64, error) {
+	sc, err := l.kcli.StorageV1().StorageClasses().Get(ctx, l.scname, metav1.GetOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get storage class %s: %w", l.scname, err)
+	}
+
+	value, ok := sc.Parameters["numberOfReplicas"]
+	if !ok {
+		return longhornDefaultReplicas, nil
+	}
+
+	replicas, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse storage class %s number of replicas: %w", l.scname, err)
+	}
+
+	// this should never happen but we better this than a division by zero.
+	if replicas <= 0 {
+		return 0, fmt.Errorf("invalid number of replicas in storage class %s: %d", l.scname, replicas)
+	}
+	return replicas, nil
+}
+
+// nodeCapacity sums up the capacity of all disks in a longhorn node. disks (or nodes) where
+// scheduling has been disabled do not contribute to the free space.
+func (l *LonghornFreeDiskSpaceGetter) nodeCapacity(node lhv1b1.Node) NodeCapacity {
+	var result NodeCapacity
+	for name, status := range node.Status.DiskStatus {
+		if status == nil {
+			continue
+		}
+
+		spec := node.Spec.Disks[name]
+		result.Reserved += spec.StorageReserved
+		result.Used += status.StorageMaximum - status.StorageAvailable
+		if !node.Spec.AllowScheduling || !spec.AllowScheduling {
+			continue
+		}
+
+		if free := status.StorageAvailable - spec.StorageReserved; free > 0 {
+			result.Free += free
+		}
+	}
+	return result
+}
+
+// Capacity returns the free, used and reserved space for all longhorn nodes. the total free and
+// used space are divided by the number of replicas configured in the storage class.
+func (l *LonghornFreeDiskSpaceGetter) Capacity(ctx context.Context) (*Capacity, error) {
+	replicas, err := l.replicas(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get longhorn replicas: %w", err)
+	}
+
+	var nodes lhv1b1.NodeList
+	if err := l.lcli.List(ctx, &nodes, client.InNamespace(longhornNamespace)); err != nil {
+		return nil, fmt.Errorf("failed to list longhorn nodes: %w", err)
+	}
+
+	result := &Capacity{Nodes: map[string]NodeCapacity{}}
+	for _, node := range nodes.Items {
+		capacity := l.nodeCapacity(node)
+		result.Nodes[node.Name] = capacity
+		result.Free += capacity.Free
+		result.Used += capacity.Used
+		result.Reserved += capacity.Reserved
+	}
+
+	result.Free /= replicas
+	result.Used /= replicas
+	return result, nil
+}
+
+// NewLonghornFreeDiskSpaceGetter returns a disk free getter for longhorn storage provisioner. the
+// provided controller runtime client must have the longhorn v1beta1 scheme registered.
+func NewLonghornFreeDiskSpaceGetter(kcli kubernetes.Interface, lcli client.Client, scname string) (*LonghornFreeDiskSpaceGetter, error) {
+	if scname == "" {
+		return nil, fmt.Errorf("empty storage class")
+	}
+	return &LonghornFreeDiskSpaceGetter{
+		kcli:   kcli,
+		lcli:   lcli,
+		scname: scname,
+	}, nil
+}
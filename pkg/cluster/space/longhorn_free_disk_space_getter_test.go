@@ -0,0 +1,169 @@
+package clusterspace
+
+import (
+	"context"
+	"testing"
+
+	lhv1b1 "github.com/longhorn/longhorn-manager/k8s/pkg/apis/longhorn/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func longhornNode(name string, allowScheduling bool, disks map[string]int64) *lhv1b1.Node {
+	node := &lhv1b1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: longhornNamespace,
+		},
+		Spec: lhv1b1.NodeSpec{
+			AllowScheduling: allowScheduling,
+			Disks:           map[string]lhv1b1.DiskSpec{},
+		},
+		Status: lhv1b1.NodeStatus{
+			DiskStatus: map[string]*lhv1b1.DiskStatus{},
+		},
+	}
+	for disk, available := range disks {
+		node.Spec.Disks[disk] = lhv1b1.DiskSpec{
+			AllowScheduling: true,
+			StorageReserved: 10,
+		}
+		node.Status.DiskStatus[disk] = &lhv1b1.DiskStatus{
+			StorageMaximum:   1000,
+			StorageAvailable: available,
+		}
+	}
+	return node
+}
+
+func TestLonghornFreeDiskSpaceGetter_Capacity(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		params   map[string]string
+		nodes    []client.Object
+		expected *Capacity
+		err      string
+	}{
+		{
+			name:   "should divide totals by the storage class number of replicas",
+			params: map[string]string{"numberOfReplicas": "2"},
+			nodes: []client.Object{
+				longhornNode("node0", true, map[string]int64{"disk0": 510}),
+				longhornNode("node1", true, map[string]int64{"disk0": 110, "disk1": 210}),
+			},
+			expected: &Capacity{
+				Nodes: map[string]NodeCapacity{
+					"node0": {Free: 500, Used: 490, Reserved: 10},
+					"node1": {Free: 300, Used: 1680, Reserved: 20},
+				},
+				Free:     400,
+				Used:     1085,
+				Reserved: 30,
+			},
+		},
+		{
+			name: "should use longhorn default replicas if not set in the storage class",
+			nodes: []client.Object{
+				longhornNode("node0", true, map[string]int64{"disk0": 310}),
+			},
+			expected: &Capacity{
+				Nodes: map[string]NodeCapacity{
+					"node0": {Free: 300, Used: 690, Reserved: 10},
+				},
+				Free:     100,
+				Used:     230,
+				Reserved: 10,
+			},
+		},
+		{
+			name:   "should not count free space in unschedulable nodes",
+			params: map[string]string{"numberOfReplicas": "1"},
+			nodes: []client.Object{
+				longhornNode("node0", false, map[string]int64{"disk0": 510}),
+				longhornNode("node1", true, map[string]int64{"disk0": 5}),
+			},
+			expected: &Capacity{
+				Nodes: map[string]NodeCapacity{
+					"node0": {Free: 0, Used: 490, Reserved: 10},
+					"node1": {Free: 0, Used: 995, Reserved: 10},
+				},
+				Free:     0,
+				Used:     1485,
+				Reserved: 20,
+			},
+		},
+		{
+			name:   "should fail with invalid number of replicas",
+			params: map[string]string{"numberOfReplicas": "0"},
+			err:    "invalid number of replicas in storage class longhorn: 0",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			kcli := fake.NewSimpleClientset(&storagev1.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: "longhorn"},
+				Provisioner: LonghornProvisioner,
+				Parameters:  tt.params,
+			})
+
+			scheme := runtime.NewScheme()
+			lhv1b1.AddToScheme(scheme)
+			lcli := crfake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.nodes...).Build()
+
+			provider, err := NewCapacityProvider(context.Background(), CapacityProviderOptions{
+				KubeClient:     kcli,
+				LonghornClient: lcli,
+				StorageClass:   "longhorn",
+			})
+			require.NoError(t, err)
+
+			capacity, err := provider.Capacity(context.Background())
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, capacity)
+		})
+	}
+}
+
+func TestNewCapacityProvider(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		provisioner string
+		opts        CapacityProviderOptions
+		err         string
+	}{
+		{
+			name:        "should fail for rook without a rook client",
+			provisioner: RookRBDProvisioner,
+			err:         "no rook client provided",
+		},
+		{
+			name:        "should fail for openebs without an image",
+			provisioner: OpenEBSLocalProvisioner,
+			err:         "failed to create openebs capacity provider: empty image",
+		},
+		{
+			name:        "should fail for unknown provisioners",
+			provisioner: "kubernetes.io/no-provisioner",
+			err:         `provisioner "kubernetes.io/no-provisioner" is not supported`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.KubeClient = fake.NewSimpleClientset(&storagev1.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: "test"},
+				Provisioner: tt.provisioner,
+			})
+			tt.opts.StorageClass = "test"
+			_, err := NewCapacityProvider(context.Background(), tt.opts)
+			assert.ErrorContains(t, err, tt.err)
+		})
+	}
+}
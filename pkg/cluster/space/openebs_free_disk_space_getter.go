@@ -14,7 +14,6 @@ import (
 	"github.com/google/uuid"
 	"github.com/replicatedhq/kurl/pkg/k8sutil"
 	"gopkg.in/yaml.v2"
-	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -23,6 +22,9 @@ import (
 	"k8s.io/utils/ptr"
 )
 
+// openEBSJobTimeout is the time given to the job reading the openebs volume free space on a node.
+const openEBSJobTimeout = 5 * time.Minute
+
 type OpenEBSFreeDiskSpaceGetter struct {
 	kcli            kubernetes.Interface
 	deletePVTimeout time.Duration
@@ -41,7 +43,7 @@ type OpenEBSVolume struct {
 
 // OpenEBSVolumes attempts to gather the free and used disk space for the openebs volume in
 // all nodes in the cluster. this function creates a temporary pod in each of the nodes of
-// the cluster, the pod runs a "df" command and we parse its output.
+// the cluster, the pods run at the same time and we parse the output of their "df" command.
 func (o *OpenEBSFreeDiskSpaceGetter) OpenEBSVolumes(ctx context.Context) (map[string]OpenEBSVolume, error) {
 	nodes, err := o.kcli.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
 	if err != nil {
@@ -61,9 +63,9 @@ func (o *OpenEBSFreeDiskSpaceGetter) OpenEBSVolumes(ctx context.Context) (map[st
 		}
 	}()
 
-	result := map[string]OpenEBSVolume{}
+	// claims holds the name of the temporary pvc created for each node.
+	claims := map[string]string{}
 	for _, node := range nodes.Items {
-		o.log.Printf("Analyzing free space on node %s", node.Name)
 		if err := o.nodeIsSchedulable(node); err != nil {
 			return nil, fmt.Errorf("failed to assess node %s: %w", node.Name, err)
 		}
@@ -75,21 +77,28 @@ func (o *OpenEBSFreeDiskSpaceGetter) OpenEBSVolumes(ctx context.Context) (map[st
 			return nil, fmt.Errorf("failed to create temporary pvc: %w", err)
 		}
 		tmpPVCs = append(tmpPVCs, pvc.DeepCopy())
+		claims[node.Name] = pvc.Name
+	}
 
-		job := o.buildJob(ctx, node.Name, basePath, pvc.Name)
-		out, status, err := k8sutil.RunJob(ctx, o.kcli, o.log, job, 5*time.Minute)
-		if err != nil {
+	o.log.Printf("Analyzing free space on %d nodes", len(claims))
+	results, err := k8sutil.RunNodeJobs(ctx, o.kcli, o.log, o.jobOptions(basePath, claims))
+	if err != nil {
+		return nil, err
+	}
+
+	result := map[string]OpenEBSVolume{}
+	for _, res := range results {
+		node, out, status := res.Node, res.Logs, res.States
+		if res.Err != nil {
 			o.logContainersState(out, status)
-			return nil, fmt.Errorf(
-				"failed to run job %s/%s on node %s: %w", job.Namespace, job.Name, node.Name, err,
-			)
+			return nil, res.Err
 		}
 
 		free, used, err := o.parseDFContainerOutput(out["df"])
 		if err != nil {
 			o.logContainersState(out, status)
 			return nil, fmt.Errorf(
-				"failed to parse node %s df output: %w", node.Name, err,
+				"failed to parse node %s df output: %w", node, err,
 			)
 		}
 
@@ -97,7 +106,7 @@ func (o *OpenEBSFreeDiskSpaceGetter) OpenEBSVolumes(ctx context.Context) (map[st
 		if err != nil {
 			o.logContainersState(out, status)
 			return nil, fmt.Errorf(
-				"failed to parse node %s fstab output: %w", node.Name, err,
+				"failed to parse node %s fstab output: %w", node, err,
 			)
 		}
 
@@ -109,7 +118,7 @@ func (o *OpenEBSFreeDiskSpaceGetter) OpenEBSVolumes(ctx context.Context) (map[st
 			}
 		}
 
-		result[node.Name] = OpenEBSVolume{
+		result[node] = OpenEBSVolume{
 			Free:       free,
 			Used:       used,
 			RootVolume: rootVolume,
@@ -118,6 +127,26 @@ func (o *OpenEBSFreeDiskSpaceGetter) OpenEBSVolumes(ctx context.Context) (map[st
 	return result, nil
 }
 
+// Capacity returns the free and used space for the openebs volume in all nodes in the cluster.
+// the totals are the sum of all nodes as openebs local volumes are not replicated.
+func (o *OpenEBSFreeDiskSpaceGetter) Capacity(ctx context.Context) (*Capacity, error) {
+	volumes, err := o.OpenEBSVolumes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Capacity{Nodes: map[string]NodeCapacity{}}
+	for node, volume := range volumes {
+		result.Nodes[node] = NodeCapacity{
+			Free: volume.Free,
+			Used: volume.Used,
+		}
+		result.Free += volume.Free
+		result.Used += volume.Used
+	}
+	return result, nil
+}
+
 // basePath inspects the destination storage class and checks what is the openebs base path
 // configured for the storage.
 func (o *OpenEBSFreeDiskSpaceGetter) basePath(ctx context.Context) (string, error) {
@@ -197,36 +226,35 @@ func (o *OpenEBSFreeDiskSpaceGetter) buildTmpPVC(node string) *corev1.Persistent
 	}
 }
 
-// buildJob returns a job scheduled to run in provided node. this job runs a pod with two
-// containers, one to capture the disk size and the other to capture the content of the
-// node fstab. timeout for the job is 2 minutes as in some cases we need to pull the image
-// and then it takes longer to boostrap the job pod. this job also mounts the provided temp
-// pvc, this is done to make sure that the openebs has created the base path inside the node
-// (it only creates it when some kind of allocation already happened in the node).
-func (o *OpenEBSFreeDiskSpaceGetter) buildJob(_ context.Context, node, basePath, tmpPVC string) *batchv1.Job {
-	schedRules := &corev1.NodeSelector{
-		NodeSelectorTerms: []corev1.NodeSelectorTerm{
-			{
-				MatchExpressions: []corev1.NodeSelectorRequirement{
-					{
-						Key:      "kubernetes.io/hostname",
-						Operator: corev1.NodeSelectorOperator("In"),
-						Values:   []string{node},
-					},
-				},
-			},
+// jobOptions returns the jobs to run on the nodes present in the claims map. each job runs a
+// pod with two containers, one to capture the disk size and the other to capture the content
+// of the node fstab. timeout for the jobs is 5 minutes as in some cases we need to pull the
+// image and then it takes longer to boostrap the job pod. each job also mounts the temp pvc
+// of its node, this is done to make sure that the openebs has created the base path inside
+// the node (it only creates it when some kind of allocation already happened in the node).
+func (o *OpenEBSFreeDiskSpaceGetter) jobOptions(basePath string, claims map[string]string) k8sutil.NodeJobOptions {
+	return k8sutil.NodeJobOptions{
+		Namespace:  "default",
+		NamePrefix: "disk-free",
+		Labels: map[string]string{
+			"app": "kurl-job-openebs-disk-free",
+		},
+		NodeFilter: func(node corev1.Node) bool {
+			_, ok := claims[node.Name]
+			return ok
+		},
+		NodePodTemplate: func(node corev1.Node) corev1.PodTemplateSpec {
+			return corev1.PodTemplateSpec{Spec: o.podSpec(basePath, claims[node.Name])}
 		},
+		Timeout: openEBSJobTimeout,
 	}
+}
 
+// podSpec returns the spec of the job pod mounting the base path and the provided temp pvc.
+func (o *OpenEBSFreeDiskSpaceGetter) podSpec(basePath, tmpPVC string) corev1.PodSpec {
 	typeDir := corev1.HostPathDirectory
 	typeFile := corev1.HostPathFile
-	podSpec := corev1.PodSpec{
-		RestartPolicy: corev1.RestartPolicyNever,
-		Affinity: &corev1.Affinity{
-			NodeAffinity: &corev1.NodeAffinity{
-				RequiredDuringSchedulingIgnoredDuringExecution: schedRules,
-			},
-		},
+	return corev1.PodSpec{
 		Volumes: []corev1.Volume{
 			{
 				Name: "openebs",
@@ -289,29 +317,6 @@ func (o *OpenEBSFreeDiskSpaceGetter) buildJob(_ context.Context, node, basePath,
 			},
 		},
 	}
-
-	tmp := uuid.New().String()[:5]
-	jobName := fmt.Sprintf("disk-free-%s-%s", node, tmp)
-	if len(jobName) > 63 {
-		jobName = jobName[0:31] + jobName[len(jobName)-32:]
-	}
-
-	return &batchv1.Job{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      jobName,
-			Namespace: "default",
-			Labels: map[string]string{
-				"app": "kurl-job-openebs-disk-free",
-			},
-		},
-		Spec: batchv1.JobSpec{
-			BackoffLimit:          ptr.To(int32(1)),
-			ActiveDeadlineSeconds: ptr.To(int64(120)),
-			Template: corev1.PodTemplateSpec{
-				Spec: podSpec,
-			},
-		},
-	}
 }
 
 // deleteTmpPVCs deletes the provided pvcs from the default namespace and waits until all their
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/replicatedhq/kurl/pkg/k8sutil"
 	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
@@ -727,7 +728,13 @@ func Test_basePath(t *testing.T) {
 func Test_buildJob(t *testing.T) {
 	nname := "this-is-a-very-long-node-name-this-will-extrapolate-the-limit"
 	ochecker := OpenEBSFreeDiskSpaceGetter{image: "myimage:latest"}
-	job := ochecker.buildJob(context.Background(), nname, "/var/local", "tmppvc")
+	node := corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: nname},
+		Spec: corev1.NodeSpec{
+			Taints: []corev1.Taint{{Key: "node-role.kubernetes.io/control-plane", Effect: corev1.TaintEffectNoSchedule}},
+		},
+	}
+	job := k8sutil.BuildNodeJob(node, ochecker.jobOptions("/var/local", map[string]string{nname: "tmppvc"}))
 
 	// check that the job name is within boundaries
 	if len(job.Name) > 63 {
@@ -745,6 +752,11 @@ func Test_buildJob(t *testing.T) {
 		t.Errorf("node has not be set to be scheduled in the node")
 	}
 
+	// check that the job tolerates the node taints
+	if len(job.Spec.Template.Spec.Tolerations) != 1 || job.Spec.Template.Spec.Tolerations[0].Key != "node-role.kubernetes.io/control-plane" {
+		t.Errorf("node taints not tolerated: %v", job.Spec.Template.Spec.Tolerations)
+	}
+
 	// assure that the temp pvc is among the volumes
 	var mountName string
 	for _, vol := range job.Spec.Template.Spec.Volumes {
@@ -9,6 +9,10 @@ import (
 	"k8s.io/client-go/kubernetes"
 )
 
+const (
+	namespace = "rook-ceph"
+)
+
 type RookFreeDiskSpaceGetter struct {
 	kcli   kubernetes.Interface
 	rcli   rookcli.Interface
@@ -43,33 +47,45 @@ func (r *RookFreeDiskSpaceGetter) getPoolAndClusterNames(ctx context.Context) (s
 
 // GetFreeSpace attempts to get the ceph free space. returns the number of available bytes.
 func (r *RookFreeDiskSpaceGetter) GetFreeSpace(ctx context.Context) (int64, error) {
+	capacity, err := r.Capacity(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return capacity.Free, nil
+}
+
+// Capacity returns the ceph free and used space. both values are divided by the number of
+// replicas configured for the storage class pool. ceph does not report per node capacity.
+func (r *RookFreeDiskSpaceGetter) Capacity(ctx context.Context) (*Capacity, error) {
 	pname, cname, err := r.getPoolAndClusterNames(ctx)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get ceph pool: %w", err)
+		return nil, fmt.Errorf("failed to get ceph pool: %w", err)
 	}
 
 	pool, err := r.rcli.CephV1().CephBlockPools(namespace).Get(ctx, pname, metav1.GetOptions{})
 	if err != nil {
-		return 0, fmt.Errorf("failed to get pool %s: %w", pname, err)
+		return nil, fmt.Errorf("failed to get pool %s: %w", pname, err)
 	}
 
 	// this should never happen but we better this than a division by zero.
 	if pool.Spec.Replicated.Size == 0 {
-		return 0, fmt.Errorf("pool replica size is zeroed")
+		return nil, fmt.Errorf("pool replica size is zeroed")
 	}
 
 	cluster, err := r.rcli.CephV1().CephClusters(namespace).Get(ctx, cname, metav1.GetOptions{})
 	if err != nil {
-		return 0, fmt.Errorf("failed to get ceph cluster %s: %w", cname, err)
+		return nil, fmt.Errorf("failed to get ceph cluster %s: %w", cname, err)
 	}
 
 	if cluster.Status.CephStatus == nil {
-		return 0, fmt.Errorf("failed to read ceph status (nil)")
+		return nil, fmt.Errorf("failed to read ceph status (nil)")
 	}
 
-	availint64 := int64(cluster.Status.CephStatus.Capacity.AvailableBytes)
 	replicasint64 := int64(pool.Spec.Replicated.Size)
-	return availint64 / replicasint64, nil
+	return &Capacity{
+		Free: int64(cluster.Status.CephStatus.Capacity.AvailableBytes) / replicasint64,
+		Used: int64(cluster.Status.CephStatus.Capacity.UsedBytes) / replicasint64,
+	}, nil
 }
 
 // NewRookFreeDiskSpaceGetter returns a disk free getter for rook storage provisioner.
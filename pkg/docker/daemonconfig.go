@@ -0,0 +1,419 @@
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// DaemonAction is what needs to be done for dockerd to pick up a daemon.json change.
+type DaemonAction string
+
+const (
+	// DaemonActionNone means daemon.json did not change.
+	DaemonActionNone DaemonAction = "none"
+	// DaemonActionReload means all changed keys are applied by sending SIGHUP to dockerd.
+	DaemonActionReload DaemonAction = "reload"
+	// DaemonActionRestart means at least one changed key is only read when dockerd starts.
+	DaemonActionRestart DaemonAction = "restart"
+)
+
+// DaemonConfigChange is the result of ApplyDaemonConfig.
+type DaemonConfigChange struct {
+	// ChangedKeys are the top level keys that were added or modified, sorted.
+	ChangedKeys []string
+	// BackupPath is the copy of the previous daemon.json, empty if there was no previous file or
+	// nothing changed.
+	BackupPath string
+	// Action is what needs to be done for dockerd to pick up the change.
+	Action DaemonAction
+	// Warnings are keys dockerd may not know about and problems found in the existing file.
+	Warnings []string
+}
+
+type daemonKeyType string
+
+const (
+	daemonKeyString     daemonKeyType = "string"
+	daemonKeyBool       daemonKeyType = "boolean"
+	daemonKeyNumber     daemonKeyType = "number"
+	daemonKeyStringList daemonKeyType = "list of strings"
+	daemonKeyObjectList daemonKeyType = "list of objects"
+	daemonKeyObject     daemonKeyType = "object"
+	daemonKeyStringMap  daemonKeyType = "object of strings"
+)
+
+// daemonKeys are the daemon.json keys documented in the dockerd reference.
+var daemonKeys = map[string]daemonKeyType{
+	"allow-nondistributable-artifacts": daemonKeyStringList,
+	"authorization-plugins":            daemonKeyStringList,
+	"bip":                              daemonKeyString,
+	"bridge":                           daemonKeyString,
+	"builder":                          daemonKeyObject,
+	"cgroup-parent":                    daemonKeyString,
+	"containerd":                       daemonKeyString,
+	"containerd-namespace":             daemonKeyString,
+	"containerd-plugin-namespace":      daemonKeyString,
+	"data-root":                        daemonKeyString,
+	"debug":                            daemonKeyBool,
+	"default-address-pools":            daemonKeyObjectList,
+	"default-cgroupns-mode":            daemonKeyString,
+	"default-gateway":                  daemonKeyString,
+	"default-gateway-v6":               daemonKeyString,
+	"default-ipc-mode":                 daemonKeyString,
+	"default-runtime":                  daemonKeyString,
+	"default-shm-size":                 daemonKeyString,
+	"default-ulimits":                  daemonKeyObject,
+	"dns":                              daemonKeyStringList,
+	"dns-opts":                         daemonKeyStringList,
+	"dns-search":                       daemonKeyStringList,
+	"exec-opts":                        daemonKeyStringList,
+	"exec-root":                        daemonKeyString,
+	"experimental":                     daemonKeyBool,
+	"features":                         daemonKeyObject,
+	"fixed-cidr":                       daemonKeyString,
+	"fixed-cidr-v6":                    daemonKeyString,
+	"group":                            daemonKeyString,
+	"hosts":                            daemonKeyStringList,
+	"icc":                              daemonKeyBool,
+	"init":                             daemonKeyBool,
+	"init-path":                        daemonKeyString,
+	"insecure-registries":              daemonKeyStringList,
+	"ip":                               daemonKeyString,
+	"ip-forward":                       daemonKeyBool,
+	"ip-masq":                          daemonKeyBool,
+	"ip6tables":                        daemonKeyBool,
+	"iptables":                         daemonKeyBool,
+	"ipv6":                             daemonKeyBool,
+	"labels":                           daemonKeyStringList,
+	"live-restore":                     daemonKeyBool,
+	"log-driver":                       daemonKeyString,
+	"log-format":                       daemonKeyString,
+	"log-level":                        daemonKeyString,
+	"log-opts":                         daemonKeyStringMap,
+	"max-concurrent-downloads":         daemonKeyNumber,
+	"max-concurrent-uploads":           daemonKeyNumber,
+	"max-download-attempts":            daemonKeyNumber,
+	"metrics-addr":                     daemonKeyString,
+	"mtu":                              daemonKeyNumber,
+	"no-new-privileges":                daemonKeyBool,
+	"node-generic-resources":           daemonKeyStringList,
+	"oom-score-adjust":                 daemonKeyNumber,
+	"pidfile":                          daemonKeyString,
+	"proxies":                          daemonKeyStringMap,
+	"raw-logs":                         daemonKeyBool,
+	"registry-mirrors":                 daemonKeyStringList,
+	"runtimes":                         daemonKeyObject,
+	"seccomp-profile":                  daemonKeyString,
+	"selinux-enabled":                  daemonKeyBool,
+	"shutdown-timeout":                 daemonKeyNumber,
+	"storage-driver":                   daemonKeyString,
+	"storage-opts":                     daemonKeyStringList,
+	"tls":                              daemonKeyBool,
+	"tlscacert":                        daemonKeyString,
+	"tlscert":                          daemonKeyString,
+	"tlskey":                           daemonKeyString,
+	"tlsverify":                        daemonKeyBool,
+	"userland-proxy":                   daemonKeyBool,
+	"userland-proxy-path":              daemonKeyString,
+	"userns-remap":                     daemonKeyString,
+}
+
+// reloadableDaemonKeys are the keys dockerd applies on SIGHUP, see
+// https://docs.docker.com/engine/reference/commandline/dockerd/#configuration-reload-behavior
+var reloadableDaemonKeys = map[string]bool{
+	"allow-nondistributable-artifacts": true,
+	"authorization-plugins":            true,
+	"builder":                          true,
+	"debug":                            true,
+	"default-runtime":                  true,
+	"default-shm-size":                 true,
+	"features":                         true,
+	"insecure-registries":              true,
+	"labels":                           true,
+	"live-restore":                     true,
+	"max-concurrent-downloads":         true,
+	"max-concurrent-uploads":           true,
+	"max-download-attempts":            true,
+	"registry-mirrors":                 true,
+	"runtimes":                         true,
+	"shutdown-timeout":                 true,
+}
+
+// MergeDaemonConfig merges two daemon.json documents. Objects are merged recursively, any other
+// value in newData replaces the value in oldData.
+func MergeDaemonConfig(oldData []byte, newData []byte) ([]byte, error) {
+	oldData = bytes.TrimSpace(oldData)
+	newData = bytes.TrimSpace(newData)
+
+	if len(oldData) == 0 && len(newData) == 0 {
+		return nil, nil
+	}
+
+	if len(oldData) == 0 {
+		return newData, nil
+	}
+
+	if len(newData) == 0 {
+		return oldData, nil
+	}
+
+	oldConfig := make(map[string]interface{})
+	if err := json.Unmarshal(oldData, &oldConfig); err != nil {
+		return nil, errors.Wrap(err, "failed to parse existing config")
+	}
+
+	newConfig := make(map[string]interface{})
+	if err := json.Unmarshal(newData, &newConfig); err != nil {
+		return nil, errors.Wrap(err, "failed to parse new config")
+	}
+
+	mergedConfig := mergeJSONMaps(oldConfig, newConfig)
+
+	mergedData, err := json.MarshalIndent(mergedConfig, "", "  ")
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal merged config")
+	}
+
+	return mergedData, nil
+}
+
+func mergeJSONMaps(oldConfig map[string]interface{}, newConfig map[string]interface{}) map[string]interface{} {
+	mergedConfig := make(map[string]interface{})
+	for key, oldVal := range oldConfig {
+		mergedConfig[key] = oldVal
+	}
+
+	for key, newVal := range newConfig {
+		oldValMap, isOldMap := mergedConfig[key].(map[string]interface{})
+		newValMap, isNewMap := newVal.(map[string]interface{})
+		if isOldMap && isNewMap {
+			mergedConfig[key] = mergeJSONMaps(oldValMap, newValMap)
+			continue
+		}
+		mergedConfig[key] = newVal
+	}
+	return mergedConfig
+}
+
+// ValidateDaemonConfig returns an error if data is not a json object or if a known key has the
+// wrong type. Unknown keys are returned as warnings as they may be supported by a newer dockerd.
+func ValidateDaemonConfig(data []byte) ([]string, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	config := make(map[string]interface{})
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, errors.Wrap(err, "failed to parse config")
+	}
+
+	var warnings []string
+	for _, key := range sortedKeys(config) {
+		keyType, ok := daemonKeys[key]
+		if !ok {
+			warnings = append(warnings, fmt.Sprintf("unknown key %q", key))
+			continue
+		}
+		if !isDaemonKeyType(keyType, config[key]) {
+			return nil, errors.Errorf("invalid value for %q: expected %s", key, keyType)
+		}
+	}
+	return warnings, nil
+}
+
+func isDaemonKeyType(keyType daemonKeyType, value interface{}) bool {
+	switch keyType {
+	case daemonKeyString:
+		_, ok := value.(string)
+		return ok
+	case daemonKeyBool:
+		_, ok := value.(bool)
+		return ok
+	case daemonKeyNumber:
+		_, ok := value.(float64)
+		return ok
+	case daemonKeyObject:
+		_, ok := value.(map[string]interface{})
+		return ok
+	case daemonKeyStringMap:
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		for _, v := range m {
+			if _, ok := v.(string); !ok {
+				return false
+			}
+		}
+		return true
+	case daemonKeyStringList, daemonKeyObjectList:
+		list, ok := value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, item := range list {
+			itemType := daemonKeyString
+			if keyType == daemonKeyObjectList {
+				itemType = daemonKeyObject
+			}
+			if !isDaemonKeyType(itemType, item) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// ApplyDaemonConfig merges config on top of the daemon.json at path, keeping the settings of the
+// existing file that config does not set. The previous file is copied to path.bak and the
+// permissions and ownership of the file are preserved. The file is not touched if config is empty
+// or the merge does not change any setting. Only config is validated, problems with the existing
+// file are returned as warnings.
+func ApplyDaemonConfig(path string, config []byte) (*DaemonConfigChange, error) {
+	change := &DaemonConfigChange{Action: DaemonActionNone}
+
+	config = bytes.TrimSpace(config)
+	if len(config) == 0 {
+		return change, nil
+	}
+
+	warnings, err := ValidateDaemonConfig(config)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid docker daemon config")
+	}
+	change.Warnings = warnings
+
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrapf(err, "failed to read %s", path)
+	}
+	exists := err == nil
+
+	// the settings of the existing file belong to the host, problems with them are only reported
+	existingWarnings, err := ValidateDaemonConfig(existing)
+	if err != nil {
+		existingWarnings = []string{err.Error()}
+	}
+	for _, warning := range existingWarnings {
+		change.Warnings = append(change.Warnings, fmt.Sprintf("existing config: %s", warning))
+	}
+
+	merged, err := MergeDaemonConfig(existing, config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to merge %s", path)
+	}
+
+	change.ChangedKeys, err = changedDaemonKeys(existing, merged)
+	if err != nil {
+		return nil, err
+	}
+	if len(change.ChangedKeys) == 0 {
+		return change, nil
+	}
+
+	change.Action = DaemonActionReload
+	for _, key := range change.ChangedKeys {
+		if !reloadableDaemonKeys[key] {
+			change.Action = DaemonActionRestart
+			break
+		}
+	}
+
+	mode := os.FileMode(0644)
+	uid, gid := -1, -1
+	if exists {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to stat %s", path)
+		}
+		mode = info.Mode().Perm()
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+			uid, gid = int(stat.Uid), int(stat.Gid)
+		}
+
+		change.BackupPath = path + ".bak"
+		if err := writeFileAtomic(change.BackupPath, existing, mode, uid, gid); err != nil {
+			return nil, errors.Wrap(err, "failed to write backup")
+		}
+	} else if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrapf(err, "failed to create directory for %s", path)
+	}
+
+	if err := writeFileAtomic(path, append(merged, '\n'), mode, uid, gid); err != nil {
+		return nil, errors.Wrapf(err, "failed to write %s", path)
+	}
+
+	return change, nil
+}
+
+// changedDaemonKeys returns the top level keys of newData that are not in oldData or have a
+// different value.
+func changedDaemonKeys(oldData, newData []byte) ([]string, error) {
+	oldConfig := make(map[string]interface{})
+	if len(bytes.TrimSpace(oldData)) > 0 {
+		if err := json.Unmarshal(oldData, &oldConfig); err != nil {
+			return nil, errors.Wrap(err, "failed to parse existing config")
+		}
+	}
+
+	newConfig := make(map[string]interface{})
+	if err := json.Unmarshal(newData, &newConfig); err != nil {
+		return nil, errors.Wrap(err, "failed to parse new config")
+	}
+
+	var changed []string
+	for _, key := range sortedKeys(newConfig) {
+		if oldVal, ok := oldConfig[key]; !ok || !reflect.DeepEqual(oldVal, newConfig[key]) {
+			changed = append(changed, key)
+		}
+	}
+	return changed, nil
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory and renames it to path.
+// uid and gid are ignored if negative.
+func writeFileAtomic(path string, data []byte, mode os.FileMode, uid, gid int) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "failed to create temporary file")
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return errors.Wrap(err, "failed to write temporary file")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "failed to close temporary file")
+	}
+
+	if err := os.Chmod(f.Name(), mode); err != nil {
+		return errors.Wrap(err, "failed to set permissions")
+	}
+	if uid >= 0 && gid >= 0 {
+		if err := os.Chown(f.Name(), uid, gid); err != nil {
+			return errors.Wrap(err, "failed to set ownership")
+		}
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,191 @@
+package docker
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateDaemonConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       string
+		wantWarnings []string
+		wantErr      string
+	}{
+		{
+			name:   "empty",
+			config: "",
+		},
+		{
+			name:   "valid",
+			config: `{"log-driver": "json-file", "log-opts": {"max-size": "10m"}, "registry-mirrors": ["https://mirror.example.com"], "debug": true, "mtu": 1450, "default-address-pools": [{"base": "172.80.0.0/16", "size": 24}]}`,
+		},
+		{
+			name:         "unknown keys are warnings",
+			config:       `{"log-driver": "json-file", "some-future-option": true}`,
+			wantWarnings: []string{`unknown key "some-future-option"`},
+		},
+		{
+			name:    "log-opts values must be strings",
+			config:  `{"log-opts": {"max-file": 3}}`,
+			wantErr: `invalid value for "log-opts": expected object of strings`,
+		},
+		{
+			name:    "list of strings",
+			config:  `{"registry-mirrors": "https://mirror.example.com"}`,
+			wantErr: `invalid value for "registry-mirrors": expected list of strings`,
+		},
+		{
+			name:    "boolean",
+			config:  `{"live-restore": "true"}`,
+			wantErr: `invalid value for "live-restore": expected boolean`,
+		},
+		{
+			name:    "not an object",
+			config:  `["debug"]`,
+			wantErr: "failed to parse config: json: cannot unmarshal array into Go value of type map[string]interface {}",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			warnings, err := ValidateDaemonConfig([]byte(tt.config))
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantWarnings, warnings)
+		})
+	}
+}
+
+func TestApplyDaemonConfig(t *testing.T) {
+	tests := []struct {
+		name         string
+		existing     string
+		config       string
+		wantChanged  []string
+		wantAction   DaemonAction
+		wantConfig   string
+		wantWarnings []string
+		wantErr      string
+	}{
+		{
+			name:       "empty config does not touch the file",
+			existing:   `{"log-driver": "journald"}`,
+			config:     "",
+			wantAction: DaemonActionNone,
+			wantConfig: `{"log-driver": "journald"}`,
+		},
+		{
+			name:        "host settings are kept",
+			existing:    `{"log-driver": "journald", "registry-mirrors": ["https://mirror.example.com"]}`,
+			config:      `{"exec-opts": ["native.cgroupdriver=systemd"]}`,
+			wantChanged: []string{"exec-opts"},
+			wantAction:  DaemonActionRestart,
+			wantConfig: `{
+  "exec-opts": [
+    "native.cgroupdriver=systemd"
+  ],
+  "log-driver": "journald",
+  "registry-mirrors": [
+    "https://mirror.example.com"
+  ]
+}
+`,
+		},
+		{
+			name:        "reloadable keys",
+			existing:    `{"log-driver": "journald"}`,
+			config:      `{"insecure-registries": ["10.96.0.10:443"], "debug": true}`,
+			wantChanged: []string{"debug", "insecure-registries"},
+			wantAction:  DaemonActionReload,
+			wantConfig: `{
+  "debug": true,
+  "insecure-registries": [
+    "10.96.0.10:443"
+  ],
+  "log-driver": "journald"
+}
+`,
+		},
+		{
+			name:       "unchanged settings",
+			existing:   `{"log-driver": "journald", "log-opts": {"max-size": "10m"}}`,
+			config:     `{"log-opts": {"max-size": "10m"}}`,
+			wantAction: DaemonActionNone,
+			wantConfig: `{"log-driver": "journald", "log-opts": {"max-size": "10m"}}`,
+		},
+		{
+			name:        "no existing file",
+			config:      `{"log-driver": "json-file"}`,
+			wantChanged: []string{"log-driver"},
+			wantAction:  DaemonActionRestart,
+			wantConfig:  "{\"log-driver\": \"json-file\"}\n",
+		},
+		{
+			name:        "invalid existing settings are only warned about",
+			existing:    `{"log-driver": ["journald"]}`,
+			config:      `{"debug": true, "some-future-option": true}`,
+			wantChanged: []string{"debug", "some-future-option"},
+			wantAction:  DaemonActionRestart,
+			wantConfig: `{
+  "debug": true,
+  "log-driver": [
+    "journald"
+  ],
+  "some-future-option": true
+}
+`,
+			wantWarnings: []string{
+				`unknown key "some-future-option"`,
+				`existing config: invalid value for "log-driver": expected string`,
+			},
+		},
+		{
+			name:     "invalid config",
+			existing: `{"log-driver": "journald"}`,
+			config:   `{"log-driver": ["json-file"]}`,
+			wantErr:  `invalid docker daemon config: invalid value for "log-driver": expected string`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "daemon.json")
+			if tt.existing != "" {
+				require.NoError(t, os.WriteFile(path, []byte(tt.existing), 0600))
+			}
+
+			change, err := ApplyDaemonConfig(path, []byte(tt.config))
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantChanged, change.ChangedKeys)
+			assert.Equal(t, tt.wantAction, change.Action)
+			assert.Equal(t, tt.wantWarnings, change.Warnings)
+
+			got, err := os.ReadFile(path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantConfig, string(got))
+
+			if len(tt.wantChanged) == 0 || tt.existing == "" {
+				assert.Empty(t, change.BackupPath)
+				return
+			}
+
+			backup, err := os.ReadFile(change.BackupPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.existing, string(backup))
+
+			info, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+		})
+	}
+}
@@ -0,0 +1,302 @@
+// Package ekco implements a client for the EKCO operator storage migration api.
+package ekco
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	retryablehttp "github.com/hashicorp/go-retryablehttp"
+	"gopkg.in/yaml.v2"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// MigrationStatus is the status of the storage migration as reported by EKCO.
+type MigrationStatus string
+
+const (
+	MigrationStatusFailed    MigrationStatus = "failed"
+	MigrationStatusCompleted MigrationStatus = "completed"
+)
+
+// MigrationReadyStatus represents the status of the migration readiness check, includes
+// a reason, the total number of nodes in the cluster and the required number of nodes needed to start the migration.
+type MigrationReadyStatus struct {
+	Ready  bool   `json:"ready"`
+	Reason string `json:"reason"`
+}
+
+// ClusterReadyStatus represents the status of the cluster readiness check, namely whether
+// the total number of nodes in the cluster exceed or meet the minimum required number of nodes
+// for the migration to start.
+type ClusterReadyStatus struct {
+	MigrationReadyStatus
+	NrNodes         int `json:"nrNodes"`
+	RequiredNrNodes int `json:"requiredNrNodes"`
+}
+
+// AuthTokenFunc returns the token used to authenticate the requests that change the migration state.
+type AuthTokenFunc func(ctx context.Context) (string, error)
+
+// Client talks to the EKCO operator storage migration api. read requests are retried, requests that change the
+// migration state are not.
+type Client struct {
+	baseURL   *url.URL
+	client    *http.Client
+	retry     *http.Client
+	authToken AuthTokenFunc
+	retryMax  int
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithTLSConfig sets the tls configuration used when the address is https.
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Client) {
+		c.client.Transport.(*http.Transport).TLSClientConfig = config
+	}
+}
+
+// WithAuthToken sets a static authentication token.
+func WithAuthToken(token string) Option {
+	return func(c *Client) {
+		c.authToken = func(context.Context) (string, error) { return token, nil }
+	}
+}
+
+// WithAuthTokenFunc sets a function called to get the authentication token when it is first needed.
+func WithAuthTokenFunc(fn AuthTokenFunc) Option {
+	return func(c *Client) {
+		c.authToken = fn
+	}
+}
+
+// WithRetryMax sets the number of retries of the read requests.
+func WithRetryMax(retryMax int) Option {
+	return func(c *Client) {
+		c.retryMax = retryMax
+	}
+}
+
+// NewClient returns a client for the EKCO operator at address. the address is either HOST:PORT, reached over http,
+// or a http or https url.
+func NewClient(address string, opts ...Option) (*Client, error) {
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	baseURL, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ekco address %q: %w", address, err)
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid ekco address %q: scheme must be http or https", address)
+	}
+
+	c := &Client{
+		baseURL:  baseURL,
+		client:   &http.Client{Transport: http.DefaultTransport.(*http.Transport).Clone()},
+		retryMax: 5,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	retryClient := retryablehttp.NewClient()
+	retryClient.Logger = nil
+	retryClient.RetryMax = c.retryMax
+	retryClient.RetryWaitMin = 500 * time.Millisecond
+	retryClient.HTTPClient = c.client
+	c.retry = retryClient.StandardClient()
+	return c, nil
+}
+
+// MigrationStatus returns the status of the storage migration.
+func (c *Client) MigrationStatus(ctx context.Context) (MigrationStatus, error) {
+	body, err := c.get(ctx, "/storagemigration/status")
+	if err != nil {
+		return "", fmt.Errorf("failed to get migration status: %w", err)
+	}
+	return MigrationStatus(strings.TrimSpace(string(body))), nil
+}
+
+// ClusterReady returns if the cluster has enough nodes for the storage migration.
+func (c *Client) ClusterReady(ctx context.Context) (*ClusterReadyStatus, error) {
+	body, err := c.get(ctx, "/storagemigration/cluster-ready")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ekco cluster ready status: %w", err)
+	}
+	var status ClusterReadyStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ekco cluster ready status: %w", err)
+	}
+	return &status, nil
+}
+
+// MigrationReady returns if EKCO is ready to start the storage migration.
+func (c *Client) MigrationReady(ctx context.Context) (*MigrationReadyStatus, error) {
+	body, err := c.get(ctx, "/storagemigration/ready")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ekco status: %w", err)
+	}
+	var status MigrationReadyStatus
+	if err := json.Unmarshal(body, &status); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ekco status: %w", err)
+	}
+	return &status, nil
+}
+
+// MigrationLogs returns the logs of the storage migration.
+func (c *Client) MigrationLogs(ctx context.Context) (string, error) {
+	body, err := c.get(ctx, "/storagemigration/logs")
+	if err != nil {
+		return "", fmt.Errorf("failed to get migration logs: %w", err)
+	}
+	return string(body), nil
+}
+
+// ApproveMigration tells EKCO to start the storage migration.
+func (c *Client) ApproveMigration(ctx context.Context) error {
+	if c.authToken == nil {
+		return fmt.Errorf("authentication token missing")
+	}
+	token, err := c.authToken(ctx)
+	if err != nil {
+		return fmt.Errorf("authentication token missing: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url("/storagemigration/approve", nil), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to approve storage migration: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to approve storage migration (%d): %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
+
+// FollowMigrationLogs writes the storage migration logs to w as they are produced, until the context is done. the
+// logs are requested with follow=true and copied while the response streams. EKCO versions that do not stream return
+// the logs at once, they are requested again every interval and only the new lines are written.
+func (c *Client) FollowMigrationLogs(ctx context.Context, w io.Writer, interval time.Duration) error {
+	var written int64
+	for {
+		n, err := c.copyLogs(ctx, w, written)
+		written += n
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// copyLogs copies the logs to w skipping the first skip bytes, already written by previous requests. returns the
+// number of bytes written.
+func (c *Client) copyLogs(ctx context.Context, w io.Writer, skip int64) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/storagemigration/logs", url.Values{"follow": {"true"}}), nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.retry.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get migration logs: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, fmt.Errorf("failed to get migration logs (%d): %s", resp.StatusCode, string(body))
+	}
+	if _, err := io.CopyN(io.Discard, resp.Body, skip); err != nil {
+		// the logs are shorter than what has been written, e.g. ekco restarted.
+		return 0, nil
+	}
+	n, err := io.Copy(flushWriter{w}, resp.Body)
+	if err != nil && ctx.Err() == nil {
+		return n, fmt.Errorf("failed to read migration logs: %w", err)
+	}
+	return n, nil
+}
+
+// get sends a get request to the path and returns the response body, an error is returned for non 200 responses.
+func (c *Client) get(ctx context.Context, path string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url(path, nil), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := c.retry.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status (%d): %s", resp.StatusCode, string(bytes.TrimSpace(body)))
+	}
+	return body, nil
+}
+
+func (c *Client) url(path string, query url.Values) string {
+	u := *c.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// flushWriter writes line by line so followed logs show up as soon as a line is complete.
+type flushWriter struct {
+	w io.Writer
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(interface{ Flush() error }); ok && err == nil {
+		err = flusher.Flush()
+	}
+	return n, err
+}
+
+// AuthTokenFromCluster reads the storage migration authentication token from the ekco-config configmap in the kurl
+// namespace.
+func AuthTokenFromCluster(clientSet kubernetes.Interface) AuthTokenFunc {
+	return func(ctx context.Context) (string, error) {
+		ekcoConfig, err := clientSet.CoreV1().ConfigMaps("kurl").Get(ctx, "ekco-config", metav1.GetOptions{})
+		if err != nil {
+			return "", fmt.Errorf("failed to get ekco-config configmap in kurl namespace: %w", err)
+		}
+
+		var authConfig struct {
+			AuthToken string `yaml:"storage_migration_auth_token"`
+		}
+		if err := yaml.Unmarshal([]byte(ekcoConfig.Data["config.yaml"]), &authConfig); err != nil {
+			return "", fmt.Errorf("failed to parse storage migration authentication token from YAML config: %w", err)
+		}
+		return authConfig.AuthToken, nil
+	}
+}
@@ -0,0 +1,206 @@
+package ekco
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeEkco stands in for the ekco operator storage migration api.
+type fakeEkco struct {
+	mu       sync.Mutex
+	status   string
+	logs     []string
+	stream   bool
+	token    string
+	approved bool
+}
+
+func (f *fakeEkco) appendLog(line string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logs = append(f.logs, line)
+}
+
+func (f *fakeEkco) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/storagemigration/status":
+		fmt.Fprint(w, f.status)
+	case "/storagemigration/cluster-ready":
+		fmt.Fprint(w, `{"ready":false,"reason":"not enough nodes","nrNodes":1,"requiredNrNodes":3}`)
+	case "/storagemigration/ready":
+		fmt.Fprint(w, `{"ready":true,"reason":"ready"}`)
+	case "/storagemigration/approve":
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer "+f.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "invalid token")
+			return
+		}
+		f.approved = true
+	case "/storagemigration/logs":
+		fmt.Fprint(w, strings.Join(f.logs, ""))
+		if !f.stream || r.URL.Query().Get("follow") != "true" {
+			return
+		}
+		written := len(f.logs)
+		w.(http.Flusher).Flush()
+		for r.Context().Err() == nil && f.status != string(MigrationStatusCompleted) {
+			f.mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			f.mu.Lock()
+			fmt.Fprint(w, strings.Join(f.logs[written:], ""))
+			written = len(f.logs)
+			w.(http.Flusher).Flush()
+		}
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestNewClient(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    string
+		wantErr bool
+	}{
+		{name: "host and port", address: "10.0.0.1:31880", want: "http://10.0.0.1:31880/storagemigration/status"},
+		{name: "https url", address: "https://ekco.kurl.svc:8443", want: "https://ekco.kurl.svc:8443/storagemigration/status"},
+		{name: "url with path", address: "http://10.0.0.1/ekco/", want: "http://10.0.0.1/ekco/storagemigration/status"},
+		{name: "invalid scheme", address: "ftp://10.0.0.1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := NewClient(tt.address)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, client.url("/storagemigration/status", nil))
+		})
+	}
+}
+
+func TestClient(t *testing.T) {
+	ekco := &fakeEkco{status: "running", token: "secret"}
+	server := httptest.NewUnstartedServer(ekco)
+	// the untrusted client below fails the handshake on purpose.
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+
+	tlsConfig := server.Client().Transport.(*http.Transport).TLSClientConfig
+	client, err := NewClient(server.URL, WithTLSConfig(tlsConfig), WithAuthToken("secret"))
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	status, err := client.MigrationStatus(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, MigrationStatus("running"), status)
+
+	clusterReady, err := client.ClusterReady(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, &ClusterReadyStatus{
+		MigrationReadyStatus: MigrationReadyStatus{Ready: false, Reason: "not enough nodes"},
+		NrNodes:              1,
+		RequiredNrNodes:      3,
+	}, clusterReady)
+
+	migrationReady, err := client.MigrationReady(ctx)
+	require.NoError(t, err)
+	assert.True(t, migrationReady.Ready)
+
+	require.NoError(t, client.ApproveMigration(ctx))
+	assert.True(t, ekco.approved)
+
+	badToken, err := NewClient(server.URL, WithTLSConfig(tlsConfig), WithAuthToken("wrong"))
+	require.NoError(t, err)
+	assert.ErrorContains(t, badToken.ApproveMigration(ctx), "invalid token")
+
+	untrusted, err := NewClient(server.URL, WithRetryMax(0))
+	require.NoError(t, err)
+	_, err = untrusted.MigrationStatus(ctx)
+	assert.Error(t, err)
+}
+
+func TestClient_FollowMigrationLogs(t *testing.T) {
+	for _, stream := range []bool{true, false} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) {
+			ekco := &fakeEkco{status: "running", stream: stream, logs: []string{"scaling down\n"}}
+			server := httptest.NewServer(ekco)
+			defer server.Close()
+
+			client, err := NewClient(server.URL)
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var buf syncBuffer
+			done := make(chan error)
+			go func() {
+				done <- client.FollowMigrationLogs(ctx, &buf, 10*time.Millisecond)
+			}()
+
+			require.Eventually(t, func() bool { return buf.String() == "scaling down\n" }, 5*time.Second, 10*time.Millisecond)
+			ekco.appendLog("migrating pvc-1\n")
+			ekco.appendLog("migrating pvc-2\n")
+			require.Eventually(t, func() bool {
+				return buf.String() == "scaling down\nmigrating pvc-1\nmigrating pvc-2\n"
+			}, 5*time.Second, 10*time.Millisecond)
+
+			cancel()
+			assert.NoError(t, <-done)
+		})
+	}
+}
+
+func TestAuthTokenFromCluster(t *testing.T) {
+	clientSet := fake.NewClientset(&corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "ekco-config", Namespace: "kurl"},
+		Data:       map[string]string{"config.yaml": "storage_migration_auth_token: secret\n"},
+	})
+	token, err := AuthTokenFromCluster(clientSet)(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "secret", token)
+
+	_, err = AuthTokenFromCluster(fake.NewClientset())(context.Background())
+	assert.Error(t, err)
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (s *syncBuffer) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.Write(p)
+}
+
+func (s *syncBuffer) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
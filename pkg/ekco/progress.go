@@ -0,0 +1,320 @@
+package ekco
+
+import (
+	"bytes"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EventType is the kind of change reported by the ProgressTracker.
+type EventType string
+
+const (
+	EventStatus  EventType = "status"
+	EventPhase   EventType = "phase"
+	EventVolume  EventType = "volume"
+	EventWarning EventType = "warning"
+)
+
+// VolumeState is the migration state of a single volume.
+type VolumeState string
+
+const (
+	VolumePending   VolumeState = "pending"
+	VolumeMigrating VolumeState = "migrating"
+	VolumeDone      VolumeState = "done"
+)
+
+// recentLogLines is the number of log lines kept by the tracker to explain failures.
+const recentLogLines = 20
+
+var (
+	phaseRegexp          = regexp.MustCompile(`(?i)^migrating data from "?([\w.-]+)"?(?: storage ?class)? to "?([\w.-]+)"?`)
+	copyPhaseRegexp      = regexp.MustCompile(`^Copying data from (\S+) PVCs to (\S+) PVCs`)
+	foundRegexp          = regexp.MustCompile(`^Found (\d+) matching PVCs to migrate`)
+	volumeRowRegexp      = regexp.MustCompile(`^(\S+)\s+(\S+)\s+(pvc-\S+)`)
+	volumeStartRegexp    = regexp.MustCompile(`^Copying data from (\S+) \(\S+\) to \S+ in (\S+)`)
+	volumeFinishedRegexp = regexp.MustCompile(`^finished migrating PVC (\S+)`)
+	warningRegexp        = regexp.MustCompile(`(?i)^warn(?:ing)?:?\s+(.*)`)
+)
+
+// Phase is a step of the storage migration, moving the volumes from one storage provider to another.
+type Phase struct {
+	Number           int    `json:"number"`
+	Source           string `json:"source"`
+	Destination      string `json:"destination"`
+	SourceClass      string `json:"sourceClass"`
+	DestinationClass string `json:"destinationClass"`
+}
+
+// String returns the phase as "OpenEBS → Rook".
+func (p Phase) String() string {
+	return p.Source + " → " + p.Destination
+}
+
+// VolumeProgress is the migration state of a persistent volume claim.
+type VolumeProgress struct {
+	Namespace string        `json:"namespace"`
+	Name      string        `json:"name"`
+	State     VolumeState   `json:"state"`
+	Duration  time.Duration `json:"duration,omitempty"`
+
+	started time.Time
+}
+
+// ID returns the volume as NAMESPACE/NAME.
+func (v VolumeProgress) ID() string {
+	return v.Namespace + "/" + v.Name
+}
+
+// Progress is the state of the storage migration as parsed from the EKCO status and logs.
+type Progress struct {
+	Status            MigrationStatus  `json:"status"`
+	Phase             *Phase           `json:"phase,omitempty"`
+	Volumes           []VolumeProgress `json:"volumes,omitempty"`
+	VolumesDone       int              `json:"volumesDone"`
+	VolumesRemaining  int              `json:"volumesRemaining"`
+	EstimatedTimeLeft time.Duration    `json:"estimatedTimeLeft,omitempty"`
+	Warnings          []string         `json:"warnings,omitempty"`
+}
+
+// Event is a change of the migration progress. Message holds the warning or the volume that changed.
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     EventType `json:"type"`
+	Message  string    `json:"message,omitempty"`
+	Progress Progress  `json:"progress"`
+}
+
+// ProgressTracker parses the EKCO migration logs, written to it line by line, and the migration status into phases and
+// per volume progress. onEvent is called for every change, with the tracker lock held.
+type ProgressTracker struct {
+	mu       sync.Mutex
+	now      func() time.Time
+	onEvent  func(Event)
+	progress Progress
+	partial  []byte
+	recent   []string
+	inTable  bool
+	// found is the number of volumes pvmigrate announced for the current phase.
+	found int
+}
+
+// NewProgressTracker returns a tracker calling onEvent for every change of the migration progress.
+func NewProgressTracker(onEvent func(Event)) *ProgressTracker {
+	return &ProgressTracker{now: time.Now, onEvent: onEvent}
+}
+
+// Write parses the complete lines of p, the remainder is kept until the next write.
+func (t *ProgressTracker) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.parseLine(strings.TrimRight(string(t.partial[:i]), "\r"))
+		t.partial = t.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// SetStatus records the migration status reported by EKCO.
+func (t *ProgressTracker) SetStatus(status MigrationStatus) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if status == t.progress.Status {
+		return
+	}
+	t.progress.Status = status
+	t.emit(EventStatus, string(status))
+}
+
+// Progress returns a copy of the current progress.
+func (t *ProgressTracker) Progress() Progress {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshot()
+}
+
+// RecentLogs returns the last log lines written to the tracker.
+func (t *ProgressTracker) RecentLogs() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.recent...)
+}
+
+func (t *ProgressTracker) parseLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		t.inTable = false
+		return
+	}
+	t.recent = append(t.recent, line)
+	if len(t.recent) > recentLogLines {
+		t.recent = t.recent[1:]
+	}
+
+	if m := phaseRegexp.FindStringSubmatch(line); m != nil {
+		t.startPhase(m[1], m[2])
+		return
+	}
+	if m := copyPhaseRegexp.FindStringSubmatch(line); m != nil {
+		t.startPhase(m[1], m[2])
+		return
+	}
+	if m := foundRegexp.FindStringSubmatch(line); m != nil {
+		if t.progress.Phase == nil {
+			t.startPhase("", "")
+		}
+		t.inTable = true
+		if n, err := strconv.Atoi(m[1]); err == nil {
+			t.found = n
+		}
+		return
+	}
+	if t.inTable {
+		if m := volumeRowRegexp.FindStringSubmatch(line); m != nil {
+			t.volume(m[1], m[2])
+			return
+		}
+	}
+	if m := volumeStartRegexp.FindStringSubmatch(line); m != nil {
+		volume := t.volume(m[2], m[1])
+		volume.State = VolumeMigrating
+		volume.started = t.now()
+		t.emit(EventVolume, volume.ID())
+		return
+	}
+	if m := volumeFinishedRegexp.FindStringSubmatch(line); m != nil {
+		volume := t.migratingVolume(m[1])
+		volume.State = VolumeDone
+		if !volume.started.IsZero() {
+			volume.Duration = t.now().Sub(volume.started)
+		}
+		t.emit(EventVolume, volume.ID())
+		return
+	}
+	if m := warningRegexp.FindStringSubmatch(line); m != nil {
+		t.progress.Warnings = append(t.progress.Warnings, m[1])
+		t.emit(EventWarning, m[1])
+	}
+}
+
+// startPhase starts a new phase unless the storage classes are the ones of the current phase, pvmigrate announces
+// them more than once.
+func (t *ProgressTracker) startPhase(sourceClass, destinationClass string) {
+	current := t.progress.Phase
+	if current != nil && (current.SourceClass == sourceClass || sourceClass == "") && current.DestinationClass == destinationClass {
+		return
+	}
+	if current != nil && current.SourceClass == "" && current.DestinationClass == "" {
+		// the volumes were listed before the storage classes were known.
+		current.SourceClass, current.Source = sourceClass, StorageProvider(sourceClass)
+		current.DestinationClass, current.Destination = destinationClass, StorageProvider(destinationClass)
+		t.emit(EventPhase, current.String())
+		return
+	}
+
+	number := 1
+	if current != nil {
+		number = current.Number + 1
+	}
+	t.progress.Phase = &Phase{
+		Number:           number,
+		Source:           StorageProvider(sourceClass),
+		Destination:      StorageProvider(destinationClass),
+		SourceClass:      sourceClass,
+		DestinationClass: destinationClass,
+	}
+	t.progress.Volumes = nil
+	t.found = 0
+	t.inTable = false
+	if sourceClass != "" || destinationClass != "" {
+		t.emit(EventPhase, t.progress.Phase.String())
+	}
+}
+
+// volume returns the volume of the current phase, adding it as pending if unknown.
+func (t *ProgressTracker) volume(namespace, name string) *VolumeProgress {
+	for i := range t.progress.Volumes {
+		if t.progress.Volumes[i].Namespace == namespace && t.progress.Volumes[i].Name == name {
+			return &t.progress.Volumes[i]
+		}
+	}
+	t.progress.Volumes = append(t.progress.Volumes, VolumeProgress{Namespace: namespace, Name: name, State: VolumePending})
+	return &t.progress.Volumes[len(t.progress.Volumes)-1]
+}
+
+// migratingVolume returns the volume being migrated with the provided name, pvmigrate does not log the namespace when
+// a volume finishes.
+func (t *ProgressTracker) migratingVolume(name string) *VolumeProgress {
+	for i := range t.progress.Volumes {
+		if t.progress.Volumes[i].Name == name && t.progress.Volumes[i].State == VolumeMigrating {
+			return &t.progress.Volumes[i]
+		}
+	}
+	return t.volume("", name)
+}
+
+func (t *ProgressTracker) emit(eventType EventType, message string) {
+	if t.onEvent == nil {
+		return
+	}
+	t.onEvent(Event{Time: t.now(), Type: eventType, Message: message, Progress: t.snapshot()})
+}
+
+// snapshot returns a copy of the progress with the volume counts and the estimated time left computed from the
+// average duration of the volumes already migrated.
+func (t *ProgressTracker) snapshot() Progress {
+	progress := t.progress
+	if progress.Phase != nil {
+		phase := *progress.Phase
+		progress.Phase = &phase
+	}
+	progress.Volumes = append([]VolumeProgress{}, t.progress.Volumes...)
+	progress.Warnings = append([]string{}, t.progress.Warnings...)
+
+	var total, elapsed time.Duration
+	done := 0
+	for _, volume := range progress.Volumes {
+		switch volume.State {
+		case VolumeDone:
+			done++
+			total += volume.Duration
+		case VolumeMigrating:
+			elapsed += t.now().Sub(volume.started)
+		}
+	}
+	progress.VolumesDone = done
+	progress.VolumesRemaining = max(t.found, len(progress.Volumes)) - done
+	progress.EstimatedTimeLeft = 0
+	if done > 0 && total > 0 {
+		left := total/time.Duration(done)*time.Duration(progress.VolumesRemaining) - elapsed
+		if left > 0 {
+			progress.EstimatedTimeLeft = left.Round(time.Second)
+		}
+	}
+	return progress
+}
+
+// StorageProvider returns the name of the storage provider of a kURL storage class.
+func StorageProvider(storageClass string) string {
+	name := strings.ToLower(storageClass)
+	switch {
+	case name == "":
+		return "unknown"
+	case strings.Contains(name, "openebs"), name == "scaling", name == "local":
+		return "OpenEBS"
+	case strings.Contains(name, "rook"), strings.Contains(name, "ceph"), name == "distributed", name == "default":
+		return "Rook"
+	case strings.Contains(name, "longhorn"):
+		return "Longhorn"
+	}
+	return storageClass
+}
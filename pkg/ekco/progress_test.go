@@ -0,0 +1,88 @@
+package ekco
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const pvmigrateLogs = `Migrating data from "scaling" storage class to "distributed"
+
+Found 2 matching PVCs to migrate across 1 namespaces:
+namespace: pvc:        pv:                                       size:
+default    www-web-0   pvc-0a1b2c3d-0000-0000-0000-000000000000 1Gi
+default    www-web-1   pvc-0a1b2c3d-0000-0000-0000-000000000001 1Gi
+
+Creating new PVCs to migrate data to using the distributed StorageClass
+created new PVC www-web-0-pvcmigrate with size 1Gi in default
+created new PVC www-web-1-pvcmigrate with size 1Gi in default
+
+Copying data from scaling PVCs to distributed PVCs
+Copying data from www-web-0 (pvc-0a1b2c3d-0000-0000-0000-000000000000) to www-web-0-pvcmigrate in default
+`
+
+func TestProgressTracker(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	var events []Event
+	tracker := NewProgressTracker(func(event Event) { events = append(events, event) })
+	tracker.now = func() time.Time { return now }
+
+	tracker.SetStatus("running")
+	_, err := tracker.Write([]byte(pvmigrateLogs))
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Minute)
+	_, err = tracker.Write([]byte("finished migrating PVC www-web-0\nCopying data from www-web-1 (pvc-0a1b2c3d-0000-0000-0000-000000000001) "))
+	require.NoError(t, err)
+	_, err = tracker.Write([]byte("to www-web-1-pvcmigrate in default\nWARNING: pod migrate-www-web-1 restarted\n"))
+	require.NoError(t, err)
+	now = now.Add(30 * time.Second)
+
+	var types []EventType
+	var messages []string
+	for _, event := range events {
+		types = append(types, event.Type)
+		messages = append(messages, event.Message)
+	}
+	assert.Equal(t, []EventType{EventStatus, EventPhase, EventVolume, EventVolume, EventVolume, EventWarning}, types)
+	assert.Equal(t, []string{"running", "OpenEBS → Rook", "default/www-web-0", "default/www-web-0", "default/www-web-1", "pod migrate-www-web-1 restarted"}, messages)
+
+	progress := tracker.Progress()
+	assert.Equal(t, MigrationStatus("running"), progress.Status)
+	assert.Equal(t, &Phase{Number: 1, Source: "OpenEBS", Destination: "Rook", SourceClass: "scaling", DestinationClass: "distributed"}, progress.Phase)
+	assert.Equal(t, 1, progress.VolumesDone)
+	assert.Equal(t, 1, progress.VolumesRemaining)
+	assert.Equal(t, []string{"pod migrate-www-web-1 restarted"}, progress.Warnings)
+	require.Len(t, progress.Volumes, 2)
+	assert.Equal(t, VolumeDone, progress.Volumes[0].State)
+	assert.Equal(t, 2*time.Minute, progress.Volumes[0].Duration)
+	assert.Equal(t, VolumeMigrating, progress.Volumes[1].State)
+	// 2 minutes per volume, the second started 30 seconds ago.
+	assert.Equal(t, 90*time.Second, progress.EstimatedTimeLeft)
+
+	// a second phase resets the volumes.
+	_, err = tracker.Write([]byte("Migrating data from \"longhorn\" storage class to \"distributed\"\n"))
+	require.NoError(t, err)
+	progress = tracker.Progress()
+	assert.Equal(t, &Phase{Number: 2, Source: "Longhorn", Destination: "Rook", SourceClass: "longhorn", DestinationClass: "distributed"}, progress.Phase)
+	assert.Empty(t, progress.Volumes)
+	assert.Equal(t, 0, progress.VolumesRemaining)
+	assert.Contains(t, tracker.RecentLogs(), "WARNING: pod migrate-www-web-1 restarted")
+}
+
+func TestStorageProvider(t *testing.T) {
+	tests := map[string]string{
+		"":                "unknown",
+		"scaling":         "OpenEBS",
+		"openebs-localpv": "OpenEBS",
+		"distributed":     "Rook",
+		"rook-cephfs":     "Rook",
+		"longhorn":        "Longhorn",
+		"gp2":             "gp2",
+	}
+	for storageClass, want := range tests {
+		assert.Equal(t, want, StorageProvider(storageClass), storageClass)
+	}
+}
@@ -0,0 +1,406 @@
+package host
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// Info is the inventory of a host, as reported by kurl host info.
+type Info struct {
+	Hostname         string            `json:"hostname"`
+	ProtectedID      string            `json:"protectedID"`
+	OS               OSInfo            `json:"os"`
+	Kernel           KernelInfo        `json:"kernel"`
+	CPU              CPUInfo           `json:"cpu"`
+	Memory           MemoryInfo        `json:"memory"`
+	Swap             SwapInfo          `json:"swap"`
+	BlockDevices     []BlockDevice     `json:"blockDevices"`
+	ContainerRuntime *ContainerRuntime `json:"containerRuntime,omitempty"`
+	Kubelet          KubeletInfo       `json:"kubelet"`
+	SELinux          SELinuxInfo       `json:"selinux"`
+	Firewalld        FirewalldInfo     `json:"firewalld"`
+	// Errors are the parts of the inventory that could not be collected, the rest of the inventory is still valid.
+	Errors []string `json:"errors,omitempty"`
+}
+
+// OSInfo is read from /etc/os-release.
+type OSInfo struct {
+	ID         string `json:"id"`
+	VersionID  string `json:"versionID"`
+	PrettyName string `json:"prettyName"`
+}
+
+type KernelInfo struct {
+	Release      string `json:"release"`
+	Architecture string `json:"architecture"`
+}
+
+type CPUInfo struct {
+	Model string `json:"model"`
+	Count int    `json:"count"`
+}
+
+type MemoryInfo struct {
+	TotalBytes     uint64 `json:"totalBytes"`
+	AvailableBytes uint64 `json:"availableBytes"`
+}
+
+type SwapInfo struct {
+	Enabled    bool   `json:"enabled"`
+	TotalBytes uint64 `json:"totalBytes"`
+}
+
+// BlockDevice is a disk or a partition, Filesystem and Mountpoint are empty when unknown or not mounted.
+type BlockDevice struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	SizeBytes  uint64 `json:"sizeBytes"`
+	ReadOnly   bool   `json:"readOnly"`
+	Filesystem string `json:"filesystem,omitempty"`
+	Mountpoint string `json:"mountpoint,omitempty"`
+}
+
+type ContainerRuntime struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type KubeletInfo struct {
+	Installed bool   `json:"installed"`
+	Version   string `json:"version,omitempty"`
+	Active    bool   `json:"active"`
+}
+
+// SELinuxInfo holds the current SELinux mode and the mode configured in /etc/selinux/config, they differ until the
+// next reboot.
+type SELinuxInfo struct {
+	Mode           string `json:"mode"`
+	ConfiguredMode string `json:"configuredMode,omitempty"`
+}
+
+type FirewalldInfo struct {
+	Installed bool `json:"installed"`
+	Active    bool `json:"active"`
+}
+
+// InfoCollector collects the host inventory. the fields are replaced in tests.
+type InfoCollector struct {
+	FS afero.Fs
+	// Run executes a command and returns its standard output, exec.ErrNotFound is returned when the command is not
+	// installed.
+	Run         func(ctx context.Context, name string, args ...string) ([]byte, error)
+	Hostname    func() (string, error)
+	ProtectedID func() (string, error)
+}
+
+// NewInfoCollector returns a collector for the current host.
+func NewInfoCollector() *InfoCollector {
+	return &InfoCollector{
+		FS:          afero.NewOsFs(),
+		Run:         runCommand,
+		Hostname:    GetHostname,
+		ProtectedID: ProtectedID,
+	}
+}
+
+func runCommand(ctx context.Context, name string, args ...string) ([]byte, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return nil, err
+	}
+	return exec.CommandContext(ctx, path, args...).Output()
+}
+
+// Collect returns the host inventory. parts that fail are reported in Info.Errors.
+func (c *InfoCollector) Collect(ctx context.Context) *Info {
+	info := &Info{Kernel: KernelInfo{Architecture: runtime.GOARCH}}
+	record := func(part string, err error) {
+		if err != nil {
+			info.Errors = append(info.Errors, fmt.Sprintf("%s: %s", part, err))
+		}
+	}
+
+	var err error
+	info.Hostname, err = c.Hostname()
+	record("hostname", err)
+	info.ProtectedID, err = c.ProtectedID()
+	record("protected id", err)
+	info.OS, err = c.osInfo()
+	record("os", err)
+	info.Kernel.Release, err = c.readTrimmed("/proc/sys/kernel/osrelease")
+	record("kernel", err)
+	info.CPU, err = c.cpuInfo()
+	record("cpu", err)
+	info.Memory, info.Swap, err = c.memoryInfo()
+	record("memory", err)
+	info.BlockDevices, err = c.blockDevices()
+	record("block devices", err)
+	info.ContainerRuntime, err = c.containerRuntime(ctx)
+	record("container runtime", err)
+	info.Kubelet, err = c.kubeletInfo(ctx)
+	record("kubelet", err)
+	info.SELinux, err = c.selinuxInfo()
+	record("selinux", err)
+	info.Firewalld = FirewalldInfo{
+		Installed: c.installed(ctx, "firewall-cmd", "--version"),
+		Active:    c.serviceActive(ctx, "firewalld"),
+	}
+	return info
+}
+
+func (c *InfoCollector) readTrimmed(path string) (string, error) {
+	data, err := afero.ReadFile(c.FS, path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (c *InfoCollector) osInfo() (OSInfo, error) {
+	data, err := afero.ReadFile(c.FS, "/etc/os-release")
+	if err != nil {
+		return OSInfo{}, err
+	}
+	values := parseKeyValues(data, "=")
+	return OSInfo{ID: values["ID"], VersionID: values["VERSION_ID"], PrettyName: values["PRETTY_NAME"]}, nil
+}
+
+func (c *InfoCollector) cpuInfo() (CPUInfo, error) {
+	data, err := afero.ReadFile(c.FS, "/proc/cpuinfo")
+	if err != nil {
+		return CPUInfo{}, err
+	}
+	var cpu CPUInfo
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "processor":
+			cpu.Count++
+		case "model name":
+			if cpu.Model == "" {
+				cpu.Model = strings.TrimSpace(value)
+			}
+		}
+	}
+	return cpu, nil
+}
+
+// memoryInfo reads /proc/meminfo, swap is enabled when /proc/swaps lists a device or file.
+func (c *InfoCollector) memoryInfo() (MemoryInfo, SwapInfo, error) {
+	data, err := afero.ReadFile(c.FS, "/proc/meminfo")
+	if err != nil {
+		return MemoryInfo{}, SwapInfo{}, err
+	}
+	values := parseKeyValues(data, ":")
+	kibibytes := func(key string) uint64 {
+		n, _ := strconv.ParseUint(strings.TrimSuffix(values[key], " kB"), 10, 64)
+		return n * 1024
+	}
+	memory := MemoryInfo{TotalBytes: kibibytes("MemTotal"), AvailableBytes: kibibytes("MemAvailable")}
+	swap := SwapInfo{TotalBytes: kibibytes("SwapTotal")}
+
+	swaps, err := afero.ReadFile(c.FS, "/proc/swaps")
+	if err != nil {
+		return memory, swap, err
+	}
+	lines := strings.Split(strings.TrimSpace(string(swaps)), "\n")
+	swap.Enabled = len(lines) > 1
+	return memory, swap, nil
+}
+
+// blockDevices lists the disks in /sys/block and their partitions. the filesystem is read from the udev database and
+// the mountpoint from /proc/mounts. ram and empty loop devices are skipped.
+func (c *InfoCollector) blockDevices() ([]BlockDevice, error) {
+	disks, err := afero.ReadDir(c.FS, "/sys/block")
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := c.mounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []BlockDevice
+	for _, disk := range disks {
+		if strings.HasPrefix(disk.Name(), "ram") {
+			continue
+		}
+		dir := filepath.Join("/sys/block", disk.Name())
+		device := c.blockDevice(dir, disk.Name(), "disk", mounts)
+		if strings.HasPrefix(disk.Name(), "loop") && device.SizeBytes == 0 {
+			continue
+		}
+		devices = append(devices, device)
+
+		entries, err := afero.ReadDir(c.FS, dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if ok, _ := afero.Exists(c.FS, filepath.Join(dir, entry.Name(), "partition")); ok {
+				devices = append(devices, c.blockDevice(filepath.Join(dir, entry.Name()), entry.Name(), "part", mounts))
+			}
+		}
+	}
+	return devices, nil
+}
+
+func (c *InfoCollector) blockDevice(dir, name, deviceType string, mounts map[string]mount) BlockDevice {
+	device := BlockDevice{Name: name, Type: deviceType}
+	if size, err := c.readTrimmed(filepath.Join(dir, "size")); err == nil {
+		sectors, _ := strconv.ParseUint(size, 10, 64)
+		device.SizeBytes = sectors * 512
+	}
+	if ro, err := c.readTrimmed(filepath.Join(dir, "ro")); err == nil {
+		device.ReadOnly = ro == "1"
+	}
+	if dev, err := c.readTrimmed(filepath.Join(dir, "dev")); err == nil {
+		if data, err := afero.ReadFile(c.FS, filepath.Join("/run/udev/data", "b"+dev)); err == nil {
+			device.Filesystem = parseKeyValues(data, "=")["E:ID_FS_TYPE"]
+		}
+	}
+	if m, ok := mounts["/dev/"+name]; ok {
+		device.Mountpoint = m.mountpoint
+		if device.Filesystem == "" {
+			device.Filesystem = m.filesystem
+		}
+	}
+	return device
+}
+
+type mount struct {
+	mountpoint string
+	filesystem string
+}
+
+// mounts returns the first mountpoint of each device in /proc/mounts.
+func (c *InfoCollector) mounts() (map[string]mount, error) {
+	data, err := afero.ReadFile(c.FS, "/proc/mounts")
+	if err != nil {
+		return nil, err
+	}
+	mounts := map[string]mount{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || !strings.HasPrefix(fields[0], "/dev/") {
+			continue
+		}
+		if _, ok := mounts[fields[0]]; !ok {
+			mounts[fields[0]] = mount{mountpoint: fields[1], filesystem: fields[2]}
+		}
+	}
+	return mounts, nil
+}
+
+// containerRuntime returns docker when its daemon answers, kURL runs containerd through docker in that case, or
+// containerd. the docker error is only reported when containerd is not installed either.
+func (c *InfoCollector) containerRuntime(ctx context.Context) (*ContainerRuntime, error) {
+	out, dockerErr := c.Run(ctx, "docker", "version", "--format", "{{.Server.Version}}")
+	if dockerErr == nil {
+		return &ContainerRuntime{Name: "docker", Version: strings.TrimSpace(string(out))}, nil
+	}
+
+	// containerd containerd.io 1.6.21 3dce8eb055cbb6872793272b4f20ed16117344f8
+	out, err := c.Run(ctx, "containerd", "--version")
+	if errors.Is(err, exec.ErrNotFound) {
+		if errors.Is(dockerErr, exec.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get docker version: %w", dockerErr)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to get containerd version: %w", err)
+	}
+	containerd := &ContainerRuntime{Name: "containerd"}
+	if fields := strings.Fields(string(out)); len(fields) >= 3 {
+		containerd.Version = strings.TrimPrefix(fields[2], "v")
+	}
+	return containerd, nil
+}
+
+func (c *InfoCollector) kubeletInfo(ctx context.Context) (KubeletInfo, error) {
+	// Kubernetes v1.27.3
+	out, err := c.Run(ctx, "kubelet", "--version")
+	if errors.Is(err, exec.ErrNotFound) {
+		return KubeletInfo{}, nil
+	} else if err != nil {
+		return KubeletInfo{Installed: true}, fmt.Errorf("failed to get kubelet version: %w", err)
+	}
+	kubelet := KubeletInfo{Installed: true, Active: c.serviceActive(ctx, "kubelet")}
+	if fields := strings.Fields(string(out)); len(fields) >= 2 {
+		kubelet.Version = fields[1]
+	}
+	return kubelet, nil
+}
+
+// selinuxInfo reads the mode from selinuxfs, SELinux is disabled when it is not mounted.
+func (c *InfoCollector) selinuxInfo() (SELinuxInfo, error) {
+	selinux := SELinuxInfo{Mode: "disabled"}
+	enforce, err := c.readTrimmed("/sys/fs/selinux/enforce")
+	if err == nil {
+		selinux.Mode = "permissive"
+		if enforce == "1" {
+			selinux.Mode = "enforcing"
+		}
+	} else if !os.IsNotExist(err) {
+		return selinux, err
+	}
+
+	config, err := afero.ReadFile(c.FS, "/etc/selinux/config")
+	if err == nil {
+		selinux.ConfiguredMode = parseKeyValues(config, "=")["SELINUX"]
+	} else if !os.IsNotExist(err) {
+		return selinux, err
+	}
+	return selinux, nil
+}
+
+func (c *InfoCollector) installed(ctx context.Context, name string, args ...string) bool {
+	_, err := c.Run(ctx, name, args...)
+	return !errors.Is(err, exec.ErrNotFound)
+}
+
+// serviceActive returns true if systemd reports the service as active.
+func (c *InfoCollector) serviceActive(ctx context.Context, service string) bool {
+	out, err := c.Run(ctx, "systemctl", "is-active", service)
+	return err == nil && strings.TrimSpace(string(out)) == "active"
+}
+
+// parseKeyValues parses lines of KEY<sep>VALUE, values are unquoted and comments skipped.
+func parseKeyValues(data []byte, sep string) map[string]string {
+	values := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, sep)
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		values[strings.TrimSpace(key)] = value
+	}
+	return values
+}
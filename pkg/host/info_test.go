@@ -0,0 +1,162 @@
+package host
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInfoCollector_Collect(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	files := map[string]string{
+		"/etc/os-release":               "NAME=\"Rocky Linux\"\nID=\"rocky\"\nVERSION_ID=\"9.2\"\nPRETTY_NAME=\"Rocky Linux 9.2 (Blue Onyx)\"\n",
+		"/proc/sys/kernel/osrelease":    "5.14.0-284.11.1.el9_2.x86_64\n",
+		"/proc/cpuinfo":                 "processor\t: 0\nmodel name\t: Intel(R) Xeon(R) CPU @ 2.20GHz\n\nprocessor\t: 1\nmodel name\t: Intel(R) Xeon(R) CPU @ 2.20GHz\n",
+		"/proc/meminfo":                 "MemTotal:        8000000 kB\nMemFree:         1000000 kB\nMemAvailable:    6000000 kB\nSwapTotal:       2097148 kB\n",
+		"/proc/swaps":                   "Filename\tType\tSize\tUsed\tPriority\n/dev/dm-1\tpartition\t2097148\t0\t-2\n",
+		"/proc/mounts":                  "/dev/sda1 /boot xfs rw 0 0\n/dev/sda1 /mnt/boot xfs rw 0 0\nproc /proc proc rw 0 0\n",
+		"/sys/block/sda/size":           "209715200\n",
+		"/sys/block/sda/ro":             "0\n",
+		"/sys/block/sda/dev":            "8:0\n",
+		"/sys/block/sda/sda1/size":      "2097152\n",
+		"/sys/block/sda/sda1/ro":        "0\n",
+		"/sys/block/sda/sda1/dev":       "8:1\n",
+		"/sys/block/sda/sda1/partition": "1\n",
+		"/sys/block/sdb/size":           "104857600\n",
+		"/sys/block/sdb/ro":             "0\n",
+		"/sys/block/sdb/dev":            "8:16\n",
+		"/sys/block/loop0/size":         "0\n",
+		"/sys/block/ram0/size":          "8192\n",
+		"/run/udev/data/b8:1":           "S:disk/by-uuid/1234\nE:ID_FS_TYPE=xfs\n",
+		"/run/udev/data/b8:16":          "E:ID_FS_TYPE=ceph_bluestore\n",
+		"/sys/fs/selinux/enforce":       "0\n",
+		"/etc/selinux/config":           "# comment\nSELINUX=enforcing\nSELINUXTYPE=targeted\n",
+	}
+	for path, content := range files {
+		require.NoError(t, afero.WriteFile(fs, path, []byte(content), 0644))
+	}
+
+	commands := map[string]string{
+		"containerd --version":          "containerd containerd.io 1.6.21 3dce8eb055cbb6872793272b4f20ed16117344f8\n",
+		"kubelet --version":             "Kubernetes v1.27.3\n",
+		"systemctl is-active kubelet":   "active\n",
+		"firewall-cmd --version":        "1.2.1\n",
+		"systemctl is-active firewalld": "inactive\n",
+	}
+	collector := &InfoCollector{
+		FS: fs,
+		Run: func(_ context.Context, name string, args ...string) ([]byte, error) {
+			out, ok := commands[strings.Join(append([]string{name}, args...), " ")]
+			if !ok {
+				return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+			}
+			if strings.TrimSpace(out) == "inactive" {
+				return []byte(out), fmt.Errorf("exit status 3")
+			}
+			return []byte(out), nil
+		},
+		Hostname:    func() (string, error) { return "node-a", nil },
+		ProtectedID: func() (string, error) { return "", fmt.Errorf("no machine id") },
+	}
+
+	info := collector.Collect(context.Background())
+	assert.Equal(t, &Info{
+		Hostname: "node-a",
+		OS:       OSInfo{ID: "rocky", VersionID: "9.2", PrettyName: "Rocky Linux 9.2 (Blue Onyx)"},
+		Kernel:   KernelInfo{Release: "5.14.0-284.11.1.el9_2.x86_64", Architecture: runtime.GOARCH},
+		CPU:      CPUInfo{Model: "Intel(R) Xeon(R) CPU @ 2.20GHz", Count: 2},
+		Memory:   MemoryInfo{TotalBytes: 8000000 * 1024, AvailableBytes: 6000000 * 1024},
+		Swap:     SwapInfo{Enabled: true, TotalBytes: 2097148 * 1024},
+		BlockDevices: []BlockDevice{
+			{Name: "sda", Type: "disk", SizeBytes: 209715200 * 512},
+			{Name: "sda1", Type: "part", SizeBytes: 2097152 * 512, Filesystem: "xfs", Mountpoint: "/boot"},
+			{Name: "sdb", Type: "disk", SizeBytes: 104857600 * 512, Filesystem: "ceph_bluestore"},
+		},
+		ContainerRuntime: &ContainerRuntime{Name: "containerd", Version: "1.6.21"},
+		Kubelet:          KubeletInfo{Installed: true, Version: "v1.27.3", Active: true},
+		SELinux:          SELinuxInfo{Mode: "permissive", ConfiguredMode: "enforcing"},
+		Firewalld:        FirewalldInfo{Installed: true, Active: false},
+		Errors:           []string{"protected id: no machine id"},
+	}, info)
+}
+
+func TestInfoCollector_Collect_missing(t *testing.T) {
+	collector := &InfoCollector{
+		FS: afero.NewMemMapFs(),
+		Run: func(_ context.Context, name string, _ ...string) ([]byte, error) {
+			return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+		},
+		Hostname:    func() (string, error) { return "node-a", nil },
+		ProtectedID: func() (string, error) { return "id", nil },
+	}
+
+	info := collector.Collect(context.Background())
+	assert.Nil(t, info.ContainerRuntime)
+	assert.Equal(t, KubeletInfo{}, info.Kubelet)
+	assert.Equal(t, SELinuxInfo{Mode: "disabled"}, info.SELinux)
+	assert.Equal(t, FirewalldInfo{}, info.Firewalld)
+	assert.Len(t, info.Errors, 5)
+}
+
+func TestInfoCollector_containerRuntime(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands map[string]string
+		want     *ContainerRuntime
+		wantErr  string
+	}{
+		{
+			name: "docker",
+			commands: map[string]string{
+				"docker version --format {{.Server.Version}}": "20.10.17\n",
+				"containerd --version":                        "containerd containerd.io 1.6.21 3dce8eb055cbb6872793272b4f20ed16117344f8\n",
+			},
+			want: &ContainerRuntime{Name: "docker", Version: "20.10.17"},
+		},
+		{
+			name: "docker daemon not running",
+			commands: map[string]string{
+				"docker version --format {{.Server.Version}}": "",
+				"containerd --version":                        "containerd containerd.io 1.6.21 3dce8eb055cbb6872793272b4f20ed16117344f8\n",
+			},
+			want: &ContainerRuntime{Name: "containerd", Version: "1.6.21"},
+		},
+		{
+			name: "docker daemon not running without containerd",
+			commands: map[string]string{
+				"docker version --format {{.Server.Version}}": "",
+			},
+			wantErr: "failed to get docker version: exit status 1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			collector := &InfoCollector{
+				Run: func(_ context.Context, name string, args ...string) ([]byte, error) {
+					out, ok := tt.commands[strings.Join(append([]string{name}, args...), " ")]
+					if !ok {
+						return nil, &exec.Error{Name: name, Err: exec.ErrNotFound}
+					}
+					if out == "" {
+						return nil, fmt.Errorf("exit status 1")
+					}
+					return []byte(out), nil
+				},
+			}
+			got, err := collector.containerRuntime(context.Background())
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,304 @@
+package host
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// defaultNoProxy are the addresses every kURL cluster needs to reach without going through the proxy.
+var defaultNoProxy = []string{"localhost", "127.0.0.1", ".svc", ".cluster.local", "kubernetes"}
+
+// ProxySeverity tells if a proxy finding breaks the cluster or may only be unexpected.
+type ProxySeverity string
+
+const (
+	ProxySeverityError   ProxySeverity = "error"
+	ProxySeverityWarning ProxySeverity = "warning"
+)
+
+// ClusterNetwork holds the addresses the cluster components reach without proxy.
+type ClusterNetwork struct {
+	PodCIDR             string
+	ServiceCIDR         string
+	NodeIPs             []string
+	LoadBalancerAddress string
+	// AdditionalNoProxy are the kurl.additionalNoProxyAddresses of the installer spec.
+	AdditionalNoProxy []string
+}
+
+// ProxySettings is the proxy configuration found in one place, e.g. the host environment or the containerd systemd
+// drop-in.
+type ProxySettings struct {
+	Source     string `json:"source"`
+	Found      bool   `json:"found"`
+	HTTPProxy  string `json:"httpProxy,omitempty"`
+	HTTPSProxy string `json:"httpsProxy,omitempty"`
+	NoProxy    string `json:"noProxy,omitempty"`
+}
+
+// usesProxy returns true if the settings route any traffic through a proxy.
+func (p ProxySettings) usesProxy() bool {
+	return p.HTTPProxy != "" || p.HTTPSProxy != ""
+}
+
+// ProxyFinding is a missing or contradicting proxy setting.
+type ProxyFinding struct {
+	Source   string        `json:"source"`
+	Severity ProxySeverity `json:"severity"`
+	Message  string        `json:"message"`
+}
+
+// RequiredNoProxy returns the NO_PROXY entries the cluster needs: the defaults, the pod and service cidrs, the node
+// ips, the load balancer host and the additional addresses of the installer spec.
+func RequiredNoProxy(network ClusterNetwork) []string {
+	entries := append([]string{}, defaultNoProxy...)
+	entries = append(entries, network.PodCIDR, network.ServiceCIDR)
+	entries = append(entries, network.NodeIPs...)
+	if network.LoadBalancerAddress != "" {
+		host := network.LoadBalancerAddress
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		entries = append(entries, host)
+	}
+	entries = append(entries, network.AdditionalNoProxy...)
+	return uniqueNoProxy(entries)
+}
+
+// ParseNoProxy splits a NO_PROXY value, removing empty and duplicated entries.
+func ParseNoProxy(value string) []string {
+	return uniqueNoProxy(strings.Split(value, ","))
+}
+
+func uniqueNoProxy(entries []string) []string {
+	var result []string
+	seen := map[string]bool{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || seen[entry] {
+			continue
+		}
+		seen[entry] = true
+		result = append(result, entry)
+	}
+	return result
+}
+
+// NoProxyCovers returns true if the entry is excluded from the proxy by the NO_PROXY list. ips are covered by cidrs
+// containing them, cidrs by larger cidrs, and hosts by domain suffixes (".example.com" or "example.com").
+func NoProxyCovers(list []string, entry string) bool {
+	entryIP := net.ParseIP(entry)
+	_, entryNet, _ := net.ParseCIDR(entry)
+	for _, item := range list {
+		if item == "*" || item == entry {
+			return true
+		}
+		if _, itemNet, err := net.ParseCIDR(item); err == nil {
+			if entryIP != nil && itemNet.Contains(entryIP) {
+				return true
+			}
+			if entryNet != nil && itemNet.Contains(entryNet.IP) {
+				entryOnes, _ := entryNet.Mask.Size()
+				itemOnes, _ := itemNet.Mask.Size()
+				if itemOnes <= entryOnes {
+					return true
+				}
+			}
+			continue
+		}
+		if entryIP != nil || entryNet != nil {
+			continue
+		}
+		suffix, domain := strings.TrimPrefix(item, "."), strings.TrimPrefix(entry, ".")
+		if domain == suffix || strings.HasSuffix(domain, "."+suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseSystemdProxyDropIn reads the proxy variables from the Environment lines of a systemd drop-in, e.g.
+// /etc/systemd/system/containerd.service.d/http-proxy.conf.
+func ParseSystemdProxyDropIn(source string, data []byte) ProxySettings {
+	settings := ProxySettings{Source: source, Found: true}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		value, ok := strings.CutPrefix(line, "Environment=")
+		if !ok {
+			continue
+		}
+		for _, assignment := range splitSystemdEnvironment(value) {
+			key, val, _ := strings.Cut(assignment, "=")
+			settings.set(key, val)
+		}
+	}
+	return settings
+}
+
+// ProxySettingsFromEnv returns the proxy settings found in the environment, lookup is usually os.LookupEnv. upper
+// case variables take precedence over lower case ones.
+func ProxySettingsFromEnv(source string, lookup func(string) (string, bool)) ProxySettings {
+	settings := ProxySettings{Source: source, Found: true}
+	for _, key := range []string{"http_proxy", "https_proxy", "no_proxy", "HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+		if value, ok := lookup(key); ok && value != "" {
+			settings.set(key, value)
+		}
+	}
+	return settings
+}
+
+func (p *ProxySettings) set(key, value string) {
+	switch strings.ToUpper(key) {
+	case "HTTP_PROXY":
+		p.HTTPProxy = value
+	case "HTTPS_PROXY":
+		p.HTTPSProxy = value
+	case "NO_PROXY":
+		p.NoProxy = value
+	}
+}
+
+// splitSystemdEnvironment splits the value of an Environment line into its assignments, honoring double quotes.
+func splitSystemdEnvironment(value string) []string {
+	var assignments []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range value {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if current.Len() > 0 {
+				assignments = append(assignments, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		assignments = append(assignments, current.String())
+	}
+	return assignments
+}
+
+// CheckProxySettings compares the proxy settings found in all sources. sources that route traffic through a proxy must
+// exclude all required entries, and all sources must agree on the proxy addresses. sources not found are skipped.
+func CheckProxySettings(required []string, sources []ProxySettings) []ProxyFinding {
+	var findings []ProxyFinding
+	var found []ProxySettings
+	for _, source := range sources {
+		if source.Found {
+			found = append(found, source)
+		}
+	}
+
+	var reference *ProxySettings
+	for i, source := range found {
+		if source.usesProxy() {
+			reference = &found[i]
+			break
+		}
+	}
+	if reference == nil {
+		return nil
+	}
+
+	for _, source := range found {
+		if !source.usesProxy() {
+			findings = append(findings, ProxyFinding{
+				Source:   source.Source,
+				Severity: ProxySeverityWarning,
+				Message:  fmt.Sprintf("no proxy configured while %s uses %s", reference.Source, reference.proxyAddress()),
+			})
+			continue
+		}
+
+		if source.HTTPProxy != reference.HTTPProxy {
+			findings = append(findings, contradiction(source, *reference, "HTTP_PROXY", source.HTTPProxy, reference.HTTPProxy))
+		}
+		if source.HTTPSProxy != reference.HTTPSProxy {
+			findings = append(findings, contradiction(source, *reference, "HTTPS_PROXY", source.HTTPSProxy, reference.HTTPSProxy))
+		}
+
+		noProxy := ParseNoProxy(source.NoProxy)
+		var missing []string
+		for _, entry := range required {
+			if !NoProxyCovers(noProxy, entry) {
+				missing = append(missing, entry)
+			}
+		}
+		if len(missing) > 0 {
+			findings = append(findings, ProxyFinding{
+				Source:   source.Source,
+				Severity: ProxySeverityError,
+				Message:  fmt.Sprintf("NO_PROXY is missing %s", strings.Join(missing, ", ")),
+			})
+		}
+
+		if extra := extraNoProxy(noProxy, ParseNoProxy(reference.NoProxy)); source.Source != reference.Source && len(extra) > 0 {
+			findings = append(findings, ProxyFinding{
+				Source:   source.Source,
+				Severity: ProxySeverityWarning,
+				Message:  fmt.Sprintf("NO_PROXY has %s not excluded by %s", strings.Join(extra, ", "), reference.Source),
+			})
+		}
+	}
+	return findings
+}
+
+func (p ProxySettings) proxyAddress() string {
+	if p.HTTPSProxy != "" {
+		return p.HTTPSProxy
+	}
+	return p.HTTPProxy
+}
+
+func contradiction(source, reference ProxySettings, key, value, expected string) ProxyFinding {
+	if value == "" {
+		value = "<unset>"
+	}
+	if expected == "" {
+		expected = "<unset>"
+	}
+	return ProxyFinding{
+		Source:   source.Source,
+		Severity: ProxySeverityError,
+		Message:  fmt.Sprintf("%s is %s but %s in %s", key, value, expected, reference.Source),
+	}
+}
+
+// extraNoProxy returns the entries of list not covered by reference, sorted.
+func extraNoProxy(list, reference []string) []string {
+	var extra []string
+	for _, entry := range list {
+		if !NoProxyCovers(reference, entry) {
+			extra = append(extra, entry)
+		}
+	}
+	sort.Strings(extra)
+	return extra
+}
+
+// CheckEnvProxyCase returns a finding for each proxy variable whose upper and lower case values differ, programs do
+// not agree on which one takes precedence.
+func CheckEnvProxyCase(source string, lookup func(string) (string, bool)) []ProxyFinding {
+	var findings []ProxyFinding
+	for _, key := range []string{"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY"} {
+		upper, _ := lookup(key)
+		lower, _ := lookup(strings.ToLower(key))
+		if upper != "" && lower != "" && upper != lower {
+			findings = append(findings, ProxyFinding{
+				Source:   source,
+				Severity: ProxySeverityError,
+				Message:  fmt.Sprintf("%s is %s but %s is %s", key, upper, strings.ToLower(key), lower),
+			})
+		}
+	}
+	return findings
+}
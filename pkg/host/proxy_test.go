@@ -0,0 +1,122 @@
+package host
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNoProxyCovers(t *testing.T) {
+	list := []string{"localhost", ".svc", "cluster.local", "10.96.0.0/12", "10.0.0.5", "10.32.0.0/20"}
+	tests := []struct {
+		entry string
+		want  bool
+	}{
+		{entry: "localhost", want: true},
+		{entry: ".svc", want: true},
+		{entry: "kotsadm.default.svc", want: true},
+		{entry: ".cluster.local", want: true},
+		{entry: "svc", want: true},
+		{entry: "kubernetes", want: false},
+		{entry: "10.96.0.1", want: true},
+		{entry: "10.0.0.5", want: true},
+		{entry: "10.0.0.6", want: false},
+		{entry: "10.96.0.0/16", want: true},
+		{entry: "10.32.0.0/16", want: false},
+		{entry: "example.com", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entry, func(t *testing.T) {
+			assert.Equal(t, tt.want, NoProxyCovers(list, tt.entry))
+		})
+	}
+	assert.True(t, NoProxyCovers([]string{"*"}, "example.com"))
+}
+
+func TestRequiredNoProxy(t *testing.T) {
+	got := RequiredNoProxy(ClusterNetwork{
+		PodCIDR:             "10.32.0.0/20",
+		ServiceCIDR:         "10.96.0.0/22",
+		NodeIPs:             []string{"10.0.0.5", "10.0.0.6"},
+		LoadBalancerAddress: "10.0.0.100:6443",
+		AdditionalNoProxy:   []string{"registry.internal", "10.0.0.5"},
+	})
+	assert.Equal(t, []string{
+		"localhost", "127.0.0.1", ".svc", ".cluster.local", "kubernetes",
+		"10.32.0.0/20", "10.96.0.0/22", "10.0.0.5", "10.0.0.6", "10.0.0.100", "registry.internal",
+	}, got)
+}
+
+func TestParseSystemdProxyDropIn(t *testing.T) {
+	data := []byte(`# Generated by kURL
+[Service]
+Environment="HTTP_PROXY=http://proxy:3128" "HTTPS_PROXY=http://proxy:3129" "NO_PROXY=localhost,.svc"
+`)
+	assert.Equal(t, ProxySettings{
+		Source:     "containerd",
+		Found:      true,
+		HTTPProxy:  "http://proxy:3128",
+		HTTPSProxy: "http://proxy:3129",
+		NoProxy:    "localhost,.svc",
+	}, ParseSystemdProxyDropIn("containerd", data))
+}
+
+func TestCheckProxySettings(t *testing.T) {
+	required := []string{"localhost", ".svc", "10.96.0.0/22", "10.0.0.5"}
+	tests := []struct {
+		name    string
+		sources []ProxySettings
+		want    []ProxyFinding
+	}{
+		{
+			name: "no proxy anywhere",
+			sources: []ProxySettings{
+				{Source: "host environment", Found: true},
+				{Source: "containerd"},
+			},
+		},
+		{
+			name: "consistent",
+			sources: []ProxySettings{
+				{Source: "host environment", Found: true, HTTPProxy: "http://proxy:3128", HTTPSProxy: "http://proxy:3128", NoProxy: "localhost,.svc,10.96.0.0/16,10.0.0.0/24"},
+				{Source: "containerd", Found: true, HTTPProxy: "http://proxy:3128", HTTPSProxy: "http://proxy:3128", NoProxy: "localhost,.svc,10.96.0.0/16,10.0.0.5"},
+				{Source: "docker"},
+			},
+		},
+		{
+			name: "missing and contradicting entries",
+			sources: []ProxySettings{
+				{Source: "host environment", Found: true, HTTPProxy: "http://proxy:3128", HTTPSProxy: "http://proxy:3128", NoProxy: "localhost,.svc,10.96.0.0/22,10.0.0.5"},
+				{Source: "containerd", Found: true, HTTPProxy: "http://proxy:3128", HTTPSProxy: "http://other:3128", NoProxy: "localhost,10.0.0.5,registry.internal"},
+				{Source: "kotsadm", Found: true},
+			},
+			want: []ProxyFinding{
+				{Source: "containerd", Severity: ProxySeverityError, Message: "HTTPS_PROXY is http://other:3128 but http://proxy:3128 in host environment"},
+				{Source: "containerd", Severity: ProxySeverityError, Message: "NO_PROXY is missing .svc, 10.96.0.0/22"},
+				{Source: "containerd", Severity: ProxySeverityWarning, Message: "NO_PROXY has registry.internal not excluded by host environment"},
+				{Source: "kotsadm", Severity: ProxySeverityWarning, Message: "no proxy configured while host environment uses http://proxy:3128"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, CheckProxySettings(required, tt.sources))
+		})
+	}
+}
+
+func TestCheckEnvProxyCase(t *testing.T) {
+	env := map[string]string{
+		"HTTP_PROXY": "http://proxy:3128",
+		"http_proxy": "http://proxy:3128",
+		"NO_PROXY":   "localhost,.svc",
+		"no_proxy":   "localhost",
+	}
+	lookup := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+	assert.Equal(t, []ProxyFinding{
+		{Source: "host environment", Severity: ProxySeverityError, Message: "NO_PROXY is localhost,.svc but no_proxy is localhost"},
+	}, CheckEnvProxyCase("host environment", lookup))
+}
@@ -0,0 +1,118 @@
+package installer
+
+import (
+	"reflect"
+
+	clusterv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+)
+
+// defaultSpec holds the documented kURL defaults of the add-on fields, the values the install scripts use when the
+// field is not set in the installer spec. fields defaulting to their zero value are left out.
+var defaultSpec = clusterv1beta1.InstallerSpec{
+	Kubernetes: &clusterv1beta1.Kubernetes{
+		ClusterName: "kubernetes",
+	},
+	Contour: &clusterv1beta1.Contour{
+		TLSMinimumProtocolVersion: "1.2",
+		HTTPPort:                  80,
+		HTTPSPort:                 443,
+	},
+	Rook: &clusterv1beta1.Rook{
+		StorageClassName: "default",
+	},
+	Kotsadm: &clusterv1beta1.Kotsadm{
+		UiBindPort: 8800,
+	},
+	Velero: &clusterv1beta1.Velero{
+		Namespace:   "velero",
+		LocalBucket: "velero",
+	},
+	Minio: &clusterv1beta1.Minio{
+		Namespace: "minio",
+		ClaimSize: "10Gi",
+	},
+	OpenEBS: &clusterv1beta1.OpenEBS{
+		Namespace:               "openebs",
+		LocalPVStorageClassName: "openebs-localpv",
+	},
+	Ekco: &clusterv1beta1.Ekco{
+		NodeUnreachableToleration: "5m",
+		MinReadyMasterNodeCount:   2,
+		EnvoyPodsNotReadyDuration: "5m",
+	},
+	Longhorn: &clusterv1beta1.Longhorn{
+		UiBindPort:                        30880,
+		StorageOverProvisioningPercentage: 200,
+	},
+}
+
+// ApplyDefaults sets the documented kURL defaults on the fields left empty in the add-ons of the spec. add-ons that are
+// not in the spec are not added.
+func ApplyDefaults(spec *clusterv1beta1.InstallerSpec) {
+	defaults := reflect.ValueOf(defaultSpec)
+	addOns := reflect.ValueOf(spec).Elem()
+	for i := 0; i < defaults.NumField(); i++ {
+		def, addOn := defaults.Field(i), addOns.Field(i)
+		if def.Kind() != reflect.Ptr || def.IsNil() || addOn.IsNil() {
+			continue
+		}
+		def, addOn = def.Elem(), addOn.Elem()
+		for j := 0; j < def.NumField(); j++ {
+			if field := addOn.Field(j); field.CanSet() && field.IsZero() && !def.Field(j).IsZero() {
+				field.Set(def.Field(j))
+			}
+		}
+	}
+}
+
+// ZeroNilFields walks the struct v points to and replaces, at any depth, nil pointers to structs with zero structs and
+// nil slices and maps with empty ones, so templates can access nested fields without guards. pointers to scalars are
+// left nil as nil often means unset, e.g. a *bool defaulting to true. a pointer to a struct that would recurse into its
+// own type is left nil.
+func ZeroNilFields(v interface{}) {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return
+	}
+	zeroNilFields(value.Elem(), map[reflect.Type]bool{})
+}
+
+// zeroNilFields fills the value in place, path holds the struct types being filled to break type cycles.
+func zeroNilFields(value reflect.Value, path map[reflect.Type]bool) {
+	switch value.Kind() { // nolint:exhaustive // scalars have nothing to fill
+	case reflect.Ptr:
+		elem := value.Type().Elem()
+		if value.IsNil() {
+			if elem.Kind() != reflect.Struct || path[elem] || !value.CanSet() {
+				return
+			}
+			value.Set(reflect.New(elem))
+		}
+		zeroNilFields(value.Elem(), path)
+	case reflect.Struct:
+		if path[value.Type()] {
+			return
+		}
+		path[value.Type()] = true
+		defer delete(path, value.Type())
+		for i := 0; i < value.NumField(); i++ {
+			if value.Type().Field(i).IsExported() {
+				zeroNilFields(value.Field(i), path)
+			}
+		}
+	case reflect.Slice:
+		if value.IsNil() {
+			if value.CanSet() {
+				value.Set(reflect.MakeSlice(value.Type(), 0, 0))
+			}
+			return
+		}
+		for i := 0; i < value.Len(); i++ {
+			zeroNilFields(value.Index(i), path)
+		}
+	case reflect.Map:
+		if value.IsNil() && value.CanSet() {
+			value.Set(reflect.MakeMap(value.Type()))
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package installer
+
+import (
+	"testing"
+
+	clusterv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestZeroNilFields(t *testing.T) {
+	type C struct {
+		D *string
+	}
+	type B struct {
+		C *C
+	}
+	type A struct {
+		B *B
+	}
+	type Node struct {
+		Name string
+		Next *Node
+	}
+	scp := "scalar_ptr"
+
+	type testStruct struct {
+		Scalar    string
+		ScalarPtr *string
+		NilPtr    *bool
+		A         A
+		AP        *A
+		APN       *A
+		Slice     []B
+		NilSlice  []string
+		NilMap    map[string]string
+		Node      *Node
+		private   *A
+	}
+	input := testStruct{
+		Scalar:    "scalar",
+		ScalarPtr: &scp,
+		A:         A{B: &B{C: &C{D: &scp}}},
+		AP:        &A{B: &B{C: &C{D: &scp}}},
+		APN:       nil,
+		Slice:     []B{{}},
+	}
+	want := testStruct{
+		Scalar:    "scalar",
+		ScalarPtr: &scp,
+		A:         A{B: &B{C: &C{D: &scp}}},
+		AP:        &A{B: &B{C: &C{D: &scp}}},
+		APN:       &A{B: &B{C: &C{}}},
+		Slice:     []B{{C: &C{}}},
+		NilSlice:  []string{},
+		NilMap:    map[string]string{},
+		Node:      &Node{},
+	}
+
+	ZeroNilFields(&input)
+	assert.Equal(t, want, input)
+}
+
+func TestApplyDefaults(t *testing.T) {
+	tests := []struct {
+		name string
+		spec clusterv1beta1.InstallerSpec
+		want clusterv1beta1.InstallerSpec
+	}{
+		{
+			name: "add-ons not in the spec are not added",
+			spec: clusterv1beta1.InstallerSpec{},
+			want: clusterv1beta1.InstallerSpec{},
+		},
+		{
+			name: "empty fields are defaulted",
+			spec: clusterv1beta1.InstallerSpec{
+				Kubernetes: &clusterv1beta1.Kubernetes{Version: "1.27.x"},
+				Contour:    &clusterv1beta1.Contour{Version: "latest", HTTPPort: 8080},
+				Minio:      &clusterv1beta1.Minio{Version: "latest", Namespace: "storage"},
+			},
+			want: clusterv1beta1.InstallerSpec{
+				Kubernetes: &clusterv1beta1.Kubernetes{Version: "1.27.x", ClusterName: "kubernetes"},
+				Contour:    &clusterv1beta1.Contour{Version: "latest", TLSMinimumProtocolVersion: "1.2", HTTPPort: 8080, HTTPSPort: 443},
+				Minio:      &clusterv1beta1.Minio{Version: "latest", Namespace: "storage", ClaimSize: "10Gi"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ApplyDefaults(&tt.spec)
+			assert.Equal(t, tt.want, tt.spec)
+		})
+	}
+}
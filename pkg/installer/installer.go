@@ -1,6 +1,10 @@
 package installer
 
 import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+
 	"github.com/pkg/errors"
 	kurlclientsetscheme "github.com/replicatedhq/kurlkinds/client/kurlclientset/scheme"
 	clusterv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
@@ -30,3 +34,13 @@ func DecodeSpec(data []byte) (*clusterv1beta1.Installer, error) {
 	}
 	return spec, nil
 }
+
+// SpecHash returns the sha256 of the json encoded installer spec, it changes whenever the spec applied to the cluster
+// changes.
+func SpecHash(installer *clusterv1beta1.Installer) (string, error) {
+	data, err := json.Marshal(installer.Spec)
+	if err != nil {
+		return "", errors.Wrap(err, "marshal spec")
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(data)), nil
+}
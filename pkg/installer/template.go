@@ -2,10 +2,8 @@ package installer
 
 import (
 	"bytes"
-	"reflect"
 	"text/template"
 
-	"github.com/Masterminds/sprig"
 	"github.com/pkg/errors"
 	clusterv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
 )
@@ -23,8 +21,10 @@ type TemplateData struct {
 
 // ExecuteTemplate runs go templates to determine what preflights need to be run etc
 func ExecuteTemplate(name, text string, data TemplateData) ([]byte, error) {
-	zeroNilStructFields(&data.Installer.Spec)
-	t, err := template.New(name).Funcs(sprig.TxtFuncMap()).Delims("{{kurl", "}}").Parse(text)
+	// the spec is copied as filling the nil fields must not change the caller's installer.
+	data.Installer = *data.Installer.DeepCopy()
+	ZeroNilFields(&data.Installer.Spec)
+	t, err := template.New(name).Funcs(FuncMap()).Delims("{{kurl", "}}").Parse(text)
 	if err != nil {
 		return nil, errors.Wrap(err, "parse")
 	}
@@ -32,26 +32,3 @@ func ExecuteTemplate(name, text string, data TemplateData) ([]byte, error) {
 	err = t.Execute(b, data)
 	return b.Bytes(), errors.Wrap(err, "execute")
 }
-
-func zeroNilStructFields(v interface{}) {
-	valueOf := reflect.ValueOf(v)
-	typeOf := reflect.TypeOf(v)
-	if valueOf.Kind() != reflect.Ptr || valueOf.IsNil() {
-		return
-	}
-	if valueOf.Elem().Kind() != reflect.Struct {
-		return
-	}
-	for i := 0; i < typeOf.Elem().NumField(); i++ {
-		switch typeOf.Elem().Field(i).Type.Kind() { // nolint:exhaustive // we only care about pointers here
-		case reflect.Ptr:
-			if !valueOf.Elem().Field(i).IsNil() {
-				continue
-			}
-			ptr := reflect.New(valueOf.Elem().Field(i).Type())
-			p2 := ptr.Elem()
-			ptr.Elem().Set(reflect.New(p2.Type().Elem()))
-			valueOf.Elem().Field(i).Set(p2)
-		}
-	}
-}
@@ -0,0 +1,153 @@
+package installer
+
+import (
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig"
+	"github.com/pkg/errors"
+	clusterv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+)
+
+// FuncMap returns the functions available to kURL templates, sprig's and the kURL specific ones below.
+func FuncMap() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	funcs["addOnEnabled"] = addOnEnabled
+	funcs["addOnVersion"] = addOnVersion
+	funcs["versionCompare"] = versionCompare
+	funcs["versionAtLeast"] = versionAtLeast
+	funcs["versionLessThan"] = versionLessThan
+	funcs["cidrContains"] = cidrContains
+	funcs["cidrOverlap"] = cidrOverlap
+	return funcs
+}
+
+// addOnVersion returns the version of the add-on named as in the installer spec yaml, e.g. "rook" or "openebs". the
+// name is case insensitive. returns an empty string when the add-on is not in the spec.
+func addOnVersion(installer clusterv1beta1.Installer, name string) (string, error) {
+	spec := reflect.ValueOf(installer.Spec)
+	for i := 0; i < spec.NumField(); i++ {
+		field := spec.Type().Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !strings.EqualFold(tag, name) {
+			continue
+		}
+		addOn := spec.Field(i)
+		if addOn.Kind() == reflect.Ptr {
+			if addOn.IsNil() {
+				return "", nil
+			}
+			addOn = addOn.Elem()
+		}
+		if addOn.Kind() != reflect.Struct {
+			return "", nil
+		}
+		if version := addOn.FieldByName("Version"); version.IsValid() && version.Kind() == reflect.String {
+			return version.String(), nil
+		}
+		return "", nil
+	}
+	return "", errors.Errorf("unknown add-on %q", name)
+}
+
+// addOnEnabled returns true if the add-on has a version in the installer spec.
+func addOnEnabled(installer clusterv1beta1.Installer, name string) (bool, error) {
+	version, err := addOnVersion(installer, name)
+	return version != "", err
+}
+
+// versionCompare returns -1, 0 or 1 when a is lower, equal or greater than b. add-on versions are accepted: a leading v
+// and the suffix after "-" are ignored, "x" is greater than any number and "latest" is greater than any version.
+func versionCompare(a, b string) (int, error) {
+	av, err := parseAddOnVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	bv, err := parseAddOnVersion(b)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(av) || i < len(bv); i++ {
+		var x, y int
+		if i < len(av) {
+			x = av[i]
+		}
+		if i < len(bv) {
+			y = bv[i]
+		}
+		switch {
+		case x < y:
+			return -1, nil
+		case x > y:
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// versionAtLeast returns true if version is greater than or equal to minimum.
+func versionAtLeast(version, minimum string) (bool, error) {
+	c, err := versionCompare(version, minimum)
+	return c >= 0, err
+}
+
+// versionLessThan returns true if version is lower than maximum.
+func versionLessThan(version, maximum string) (bool, error) {
+	c, err := versionCompare(version, maximum)
+	return c < 0, err
+}
+
+func parseAddOnVersion(version string) ([]int, error) {
+	if version == "latest" {
+		return []int{math.MaxInt}, nil
+	}
+	main, _, _ := strings.Cut(strings.TrimPrefix(version, "v"), "-")
+	var parts []int
+	for _, part := range strings.Split(main, ".") {
+		if part == "x" {
+			parts = append(parts, math.MaxInt)
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return nil, errors.Errorf("invalid version %q", version)
+		}
+		parts = append(parts, n)
+	}
+	return parts, nil
+}
+
+// cidrContains returns true if the address, an ip or a cidr, is inside the cidr.
+func cidrContains(cidr, address string) (bool, error) {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return false, errors.Wrapf(err, "parse cidr %q", cidr)
+	}
+	if ip := net.ParseIP(address); ip != nil {
+		return network.Contains(ip), nil
+	}
+	_, inner, err := net.ParseCIDR(address)
+	if err != nil {
+		return false, errors.Errorf("invalid ip or cidr %q", address)
+	}
+	networkOnes, _ := network.Mask.Size()
+	innerOnes, _ := inner.Mask.Size()
+	return network.Contains(inner.IP) && networkOnes <= innerOnes, nil
+}
+
+// cidrOverlap returns true if the two cidrs share at least one address.
+func cidrOverlap(a, b string) (bool, error) {
+	_, an, err := net.ParseCIDR(a)
+	if err != nil {
+		return false, errors.Wrapf(err, "parse cidr %q", a)
+	}
+	_, bn, err := net.ParseCIDR(b)
+	if err != nil {
+		return false, errors.Wrapf(err, "parse cidr %q", b)
+	}
+	return an.Contains(bn.IP) || bn.Contains(an.IP), nil
+}
@@ -0,0 +1,115 @@
+package installer
+
+import (
+	"testing"
+
+	clusterv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_versionCompare(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		want    int
+		wantErr bool
+	}{
+		{a: "1.12.8", b: "1.12.8", want: 0},
+		{a: "v1.12.8", b: "1.12.10", want: -1},
+		{a: "1.12.x", b: "1.12.10", want: 1},
+		{a: "1.12", b: "1.12.0", want: 0},
+		{a: "1.0.4-14.2.21", b: "1.0.4", want: 0},
+		{a: "latest", b: "3.10.0", want: 1},
+		{a: "3.10.0", b: "latest", want: -1},
+		{a: "1.a.0", b: "1.0.0", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" "+tt.b, func(t *testing.T) {
+			got, err := versionCompare(tt.a, tt.b)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_cidrContains(t *testing.T) {
+	tests := []struct {
+		cidr, address string
+		want          bool
+		wantErr       bool
+	}{
+		{cidr: "10.96.0.0/22", address: "10.96.3.255", want: true},
+		{cidr: "10.96.0.0/22", address: "10.96.4.0", want: false},
+		{cidr: "10.0.0.0/8", address: "10.32.0.0/20", want: true},
+		{cidr: "10.32.0.0/20", address: "10.0.0.0/8", want: false},
+		{cidr: "10.0.0.0", address: "10.0.0.1", wantErr: true},
+		{cidr: "10.0.0.0/8", address: "node-a", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cidr+" "+tt.address, func(t *testing.T) {
+			got, err := cidrContains(tt.cidr, tt.address)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	overlap, err := cidrOverlap("10.32.0.0/20", "10.0.0.0/8")
+	require.NoError(t, err)
+	assert.True(t, overlap)
+	overlap, err = cidrOverlap("10.32.0.0/20", "10.96.0.0/22")
+	require.NoError(t, err)
+	assert.False(t, overlap)
+}
+
+func TestExecuteTemplate_funcs(t *testing.T) {
+	data := TemplateData{Installer: clusterv1beta1.Installer{Spec: clusterv1beta1.InstallerSpec{
+		Kubernetes: &clusterv1beta1.Kubernetes{Version: "1.27.x", ServiceCIDR: "10.96.0.0/22"},
+		Rook:       &clusterv1beta1.Rook{Version: "1.12.8"},
+	}}}
+	tests := []struct {
+		name    string
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "add-on enabled",
+			text: `{{kurl addOnEnabled .Installer "rook" }} {{kurl addOnEnabled .Installer "openEBS" }} {{kurl addOnEnabled .Installer "openebs" }}`,
+			want: "true false false",
+		},
+		{
+			name: "add-on version",
+			text: `{{kurl addOnVersion .Installer "rook" }} {{kurl if versionAtLeast (addOnVersion .Installer "kubernetes") "1.26.0" }}new{{kurl end }}`,
+			want: "1.12.8 new",
+		},
+		{
+			name: "cidr",
+			text: `{{kurl cidrContains .Installer.Spec.Kubernetes.ServiceCIDR "10.96.0.1" }}`,
+			want: "true",
+		},
+		{
+			name:    "unknown add-on",
+			text:    `{{kurl addOnEnabled .Installer "nope" }}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ExecuteTemplate(tt.name, tt.text, data)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
@@ -3,48 +3,23 @@ package installer
 import (
 	"testing"
 
+	clusterv1beta1 "github.com/replicatedhq/kurlkinds/pkg/apis/cluster/v1beta1"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
-func Test_zeroNilStructFields(t *testing.T) {
-	type C struct {
-		D *string
-	}
-	type B struct {
-		C *C
-	}
-	type A struct {
-		B *B
-	}
-	scp := "scalar_ptr"
-
-	input := struct {
-		Scalar    string
-		ScalarPtr *string
-		A         A
-		AP        *A
-		APN       *A
-	}{
-		Scalar:    "scalar",
-		ScalarPtr: &scp,
-		A:         A{B: &B{C: &C{D: &scp}}},
-		AP:        &A{B: &B{C: &C{D: &scp}}},
-		APN:       nil,
-	}
-	want := struct {
-		Scalar    string
-		ScalarPtr *string
-		A         A
-		AP        *A
-		APN       *A
-	}{
-		Scalar:    "scalar",
-		ScalarPtr: &scp,
-		A:         A{B: &B{C: &C{D: &scp}}},
-		AP:        &A{B: &B{C: &C{D: &scp}}},
-		APN:       &A{},
+func TestExecuteTemplate(t *testing.T) {
+	data := TemplateData{
+		Installer: clusterv1beta1.Installer{
+			Spec: clusterv1beta1.InstallerSpec{
+				Kurl: &clusterv1beta1.Kurl{},
+			},
+		},
 	}
 
-	zeroNilStructFields(&input)
-	assert.Equal(t, want, input)
+	out, err := ExecuteTemplate("test", `{{kurl len .Installer.Spec.Kurl.AdditionalNoProxyAddresses }} {{kurl .Installer.Spec.Kubernetes.ClusterName | default "none" }} {{kurl .Installer.Spec.Rook.IsBlockStorageEnabled }}`, data)
+	require.NoError(t, err)
+	assert.Equal(t, "0 none false", string(out))
+	assert.Nil(t, data.Installer.Spec.Kubernetes)
+	assert.Nil(t, data.Installer.Spec.Kurl.AdditionalNoProxyAddresses, "the caller's spec must not change")
 }
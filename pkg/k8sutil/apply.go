@@ -4,35 +4,223 @@ import (
 	"bytes"
 	"context"
 	"embed"
-	"os/exec"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
 
 	"github.com/replicatedhq/plumber/v2"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/yaml"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	kustomizetypes "sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/api/krusty"
 )
 
-func KubectlApply(ctx context.Context, cli client.Client, resources embed.FS, overlay string, opts ...plumber.Option) error {
-	options := append([]plumber.Option{
-		plumber.WithKustomizeMutator(
-			func(_ context.Context, k *kustomizetypes.Kustomization) error {
-				newLabel := kustomizetypes.Label{
-					Pairs: AppendKurlLabels(nil),
-				}
-				k.Labels = append(k.Labels, newLabel)
-				return nil
-			},
-		),
-	}, opts...)
-
-	err := plumber.NewRenderer(cli, resources, options...).Apply(
-		ctx, overlay,
-	)
-	return err
-}
-
-func KubectlDelete(ctx context.Context, b []byte, args ...string) ([]byte, error) {
-	args = append([]string{"delete", "-f", "-"}, args...)
-	cmd := exec.CommandContext(ctx, "kubectl", args...)
-	cmd.Stdin = bytes.NewReader(b)
-	return cmd.CombinedOutput()
+// FieldManager is the field manager of the objects applied server side by kURL.
+const FieldManager = "kurl"
+
+// RenderKustomize renders the overlay of the kustomize directory in resources, the mutators are run on the files
+// before rendering.
+func RenderKustomize(ctx context.Context, resources embed.FS, overlay string, mutators ...plumber.FSMutator) ([]*unstructured.Unstructured, error) {
+	fsys, err := plumber.LoadFS(resources)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load kustomize files: %w", err)
+	}
+	for _, mutator := range mutators {
+		if err := mutator(ctx, fsys); err != nil {
+			return nil, fmt.Errorf("failed to mutate kustomize files: %w", err)
+		}
+	}
+
+	res, err := krusty.MakeKustomizer(krusty.MakeDefaultOptions()).Run(fsys, path.Join("kustomize", overlay))
+	if err != nil {
+		return nil, fmt.Errorf("failed to run kustomize: %w", err)
+	}
+	b, err := res.AsYaml()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode kustomize output: %w", err)
+	}
+	return DecodeManifests(b)
+}
+
+// ApplyKustomize renders the overlay of the kustomize directory in resources and applies the objects with
+// ApplyManifests.
+func ApplyKustomize(ctx context.Context, cli client.Client, resources embed.FS, overlay string, opts ApplyOptions, mutators ...plumber.FSMutator) error {
+	objs, err := RenderKustomize(ctx, resources, overlay, mutators...)
+	if err != nil {
+		return err
+	}
+	return ApplyManifests(ctx, cli, objs, opts)
+}
+
+// DecodeManifests decodes the objects of a multi document yaml or json stream, empty documents are skipped.
+func DecodeManifests(b []byte) ([]*unstructured.Unstructured, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(b), 4096)
+	var objs []*unstructured.Unstructured
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); errors.Is(err, io.EOF) {
+			return objs, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		if obj.GetKind() == "" || obj.GetAPIVersion() == "" {
+			return nil, fmt.Errorf("manifest %q is missing apiVersion or kind", obj.GetName())
+		}
+		objs = append(objs, obj)
+	}
+}
+
+// ApplyOptions are options for ApplyManifests.
+type ApplyOptions struct {
+	// Namespace is set on the namespaced objects without one.
+	Namespace string
+	// ApplySet is the name of the set of objects, set as the LabelKeyKurlshApplySet label. required to prune.
+	ApplySet string
+	// Prune deletes the objects of the apply set that are not in the applied objects.
+	Prune bool
+	// PruneTypes are the kinds of objects pruned in addition to the kinds of the applied objects, e.g. kinds that
+	// are not rendered anymore.
+	PruneTypes []schema.GroupVersionKind
+}
+
+// ApplyManifests applies the objects server side with the kURL field manager, taking ownership of the conflicting
+// fields. the objects are labeled with the kURL labels and the apply set, when pruning the objects of the apply set
+// that are not applied anymore are deleted once all the objects have been applied.
+func ApplyManifests(ctx context.Context, cli client.Client, objs []*unstructured.Unstructured, opts ApplyOptions) error {
+	if opts.Prune && opts.ApplySet == "" {
+		return fmt.Errorf("an apply set is required to prune")
+	}
+
+	for _, obj := range objs {
+		obj = obj.DeepCopy()
+		if err := setDefaultNamespace(cli, obj, opts.Namespace); err != nil {
+			return err
+		}
+		labels := AppendKurlLabels(obj.GetLabels())
+		if opts.ApplySet != "" {
+			labels[LabelKeyKurlshApplySet] = opts.ApplySet
+		}
+		obj.SetLabels(labels)
+		// server side apply rejects objects carrying a resource version or managed fields.
+		obj.SetResourceVersion("")
+		obj.SetManagedFields(nil)
+
+		err := cli.Apply(ctx, client.ApplyConfigurationFromUnstructured(obj), client.FieldOwner(FieldManager), client.ForceOwnership)
+		if err != nil {
+			return fmt.Errorf("failed to apply %s: %w", objectRef(obj), err)
+		}
+	}
+
+	if !opts.Prune {
+		return nil
+	}
+	types := append([]schema.GroupVersionKind{}, opts.PruneTypes...)
+	for _, obj := range objs {
+		types = append(types, obj.GroupVersionKind())
+	}
+	return Prune(ctx, cli, opts.ApplySet, objs, types, opts.Namespace)
+}
+
+// Prune deletes the objects of the kinds provided labeled as managed by kURL and part of the apply set, except the
+// objects to keep. kinds unknown to the cluster are skipped.
+func Prune(ctx context.Context, cli client.Client, applySet string, keep []*unstructured.Unstructured, types []schema.GroupVersionKind, namespace string) error {
+	kept := map[string]bool{}
+	for _, obj := range keep {
+		obj = obj.DeepCopy()
+		if err := setDefaultNamespace(cli, obj, namespace); err != nil {
+			return err
+		}
+		kept[objectRef(obj)] = true
+	}
+
+	seen := map[schema.GroupVersionKind]bool{}
+	for _, gvk := range types {
+		if seen[gvk] {
+			continue
+		}
+		seen[gvk] = true
+
+		list := &unstructured.UnstructuredList{}
+		list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		selector := client.MatchingLabels{LabelKeyKurlshManaged: "true", LabelKeyKurlshApplySet: applySet}
+		if err := cli.List(ctx, list, selector); meta.IsNoMatchError(err) {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("failed to list %s: %w", gvk.Kind, err)
+		}
+		for i := range list.Items {
+			obj := &list.Items[i]
+			obj.SetGroupVersionKind(gvk)
+			if kept[objectRef(obj)] {
+				continue
+			}
+			if err := deleteObject(ctx, cli, obj); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteManifests deletes the objects, objects that do not exist are ignored. dependents, e.g. the pods of a job, are
+// deleted by the garbage collector.
+func DeleteManifests(ctx context.Context, cli client.Client, objs []*unstructured.Unstructured, namespace string) error {
+	var errs []error
+	for _, obj := range objs {
+		obj = obj.DeepCopy()
+		if err := setDefaultNamespace(cli, obj, namespace); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := deleteObject(ctx, cli, obj); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func deleteObject(ctx context.Context, cli client.Client, obj *unstructured.Unstructured) error {
+	err := cli.Delete(ctx, obj, client.PropagationPolicy(metav1.DeletePropagationBackground))
+	if err != nil && !apierrors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return fmt.Errorf("failed to delete %s: %w", objectRef(obj), err)
+	}
+	return nil
+}
+
+// setDefaultNamespace sets the namespace on the object if it is namespaced and has none.
+func setDefaultNamespace(cli client.Client, obj *unstructured.Unstructured, namespace string) error {
+	if obj.GetNamespace() != "" || namespace == "" {
+		return nil
+	}
+	namespaced, err := cli.IsObjectNamespaced(obj)
+	if err != nil {
+		return fmt.Errorf("failed to get %s scope: %w", objectRef(obj), err)
+	}
+	if namespaced {
+		obj.SetNamespace(namespace)
+	}
+	return nil
+}
+
+// objectRef returns a reference to the object such as apps/Deployment kurl/ekc-operator.
+func objectRef(obj *unstructured.Unstructured) string {
+	gvk := obj.GroupVersionKind()
+	kind := gvk.Kind
+	if gvk.Group != "" {
+		kind = gvk.Group + "/" + kind
+	}
+	name := obj.GetName()
+	if obj.GetNamespace() != "" {
+		name = obj.GetNamespace() + "/" + name
+	}
+	return strings.Join([]string{kind, name}, " ")
 }
@@ -0,0 +1,120 @@
+package k8sutil
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testManifests = `---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  key: value
+---
+# an empty document
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: account
+  namespace: other
+`
+
+// testRESTMapper maps the kinds used in the tests, the fake client does not map any kind by default.
+func testRESTMapper() meta.RESTMapper {
+	mapper := meta.NewDefaultRESTMapper(nil)
+	for _, kind := range []string{"ConfigMap", "Secret", "ServiceAccount"} {
+		mapper.Add(corev1.SchemeGroupVersion.WithKind(kind), meta.RESTScopeNamespace)
+	}
+	return mapper
+}
+
+func TestDecodeManifests(t *testing.T) {
+	objs, err := DecodeManifests([]byte(testManifests))
+	require.NoError(t, err)
+	require.Len(t, objs, 2)
+	assert.Equal(t, "ConfigMap", objs[0].GetKind())
+	assert.Equal(t, "config", objs[0].GetName())
+	assert.Equal(t, "other", objs[1].GetNamespace())
+
+	_, err = DecodeManifests([]byte("metadata:\n  name: config\n"))
+	assert.EqualError(t, err, `manifest "config" is missing apiVersion or kind`)
+}
+
+func TestApplyManifests(t *testing.T) {
+	applySetLabels := func(set string) map[string]string {
+		return map[string]string{LabelKeyKurlshManaged: "true", LabelKeyKurlshApplySet: set}
+	}
+	cli := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithRESTMapper(testRESTMapper()).WithObjects(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "kurl"}, Data: map[string]string{"key": "old"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "stale", Namespace: "kurl", Labels: applySetLabels("test")}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other-set", Namespace: "kurl", Labels: applySetLabels("other")}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unmanaged", Namespace: "kurl"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "stale-secret", Namespace: "kurl", Labels: applySetLabels("test")}},
+	).Build()
+
+	objs, err := DecodeManifests([]byte(testManifests))
+	require.NoError(t, err)
+	err = ApplyManifests(context.Background(), cli, objs, ApplyOptions{
+		Namespace:  "kurl",
+		ApplySet:   "test",
+		Prune:      true,
+		PruneTypes: []schema.GroupVersionKind{corev1.SchemeGroupVersion.WithKind("Secret")},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, objs[0].GetNamespace(), "the objects must not be changed")
+
+	var config corev1.ConfigMap
+	require.NoError(t, cli.Get(context.Background(), client.ObjectKey{Namespace: "kurl", Name: "config"}, &config))
+	assert.Equal(t, "value", config.Data["key"])
+	assert.Equal(t, "test", config.Labels[LabelKeyKurlshApplySet])
+	assert.Equal(t, "true", config.Labels[LabelKeyKurlshManaged])
+	require.NoError(t, cli.Get(context.Background(), client.ObjectKey{Namespace: "other", Name: "account"}, &corev1.ServiceAccount{}))
+
+	for _, tt := range []struct {
+		obj    client.Object
+		exists bool
+	}{
+		{&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "stale", Namespace: "kurl"}}, false},
+		{&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "stale-secret", Namespace: "kurl"}}, false},
+		{&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "other-set", Namespace: "kurl"}}, true},
+		{&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "unmanaged", Namespace: "kurl"}}, true},
+	} {
+		err := cli.Get(context.Background(), client.ObjectKeyFromObject(tt.obj), tt.obj)
+		if tt.exists {
+			assert.NoError(t, err, tt.obj.GetName())
+		} else {
+			assert.True(t, apierrors.IsNotFound(err), "%s must be pruned: %v", tt.obj.GetName(), err)
+		}
+	}
+
+	err = ApplyManifests(context.Background(), cli, objs, ApplyOptions{Prune: true})
+	assert.EqualError(t, err, "an apply set is required to prune")
+}
+
+func TestDeleteManifests(t *testing.T) {
+	cli := fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithRESTMapper(testRESTMapper()).WithObjects(
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "kurl"}},
+	).Build()
+
+	objs, err := DecodeManifests([]byte(testManifests))
+	require.NoError(t, err)
+	// the service account does not exist and is ignored.
+	require.NoError(t, DeleteManifests(context.Background(), cli, objs, "kurl"))
+
+	err = cli.Get(context.Background(), client.ObjectKey{Namespace: "kurl", Name: "config"}, &corev1.ConfigMap{})
+	assert.True(t, apierrors.IsNotFound(err), err)
+}
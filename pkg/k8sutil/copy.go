@@ -0,0 +1,145 @@
+package k8sutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// CopyToPod copies the local file or directory srcPath into the destDir directory of the pod container, the way
+// "kubectl cp" does: the files are streamed as a tar archive to the tar binary of the container, which must exist.
+// the command and streams of opts are set by CopyToPod.
+func CopyToPod(ctx context.Context, opts ExecOptions, srcPath, destDir string) error {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeTar(writer, srcPath))
+	}()
+	defer reader.Close()
+
+	var stderr bytes.Buffer
+	opts.Command = []string{"tar", "-xmf", "-", "-C", destDir}
+	opts.In, opts.Out, opts.Err = reader, io.Discard, &stderr
+	exitCode, err := ExecStream(ctx, opts)
+	if err != nil {
+		return errors.Wrapf(err, "copy %s to %s/%s:%s", srcPath, opts.Namespace, opts.PodName, destDir)
+	} else if exitCode != 0 {
+		return errors.Errorf("copy %s to %s/%s:%s: tar exited with code %d: %s", srcPath, opts.Namespace, opts.PodName, destDir, exitCode, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// CopyFromPod copies the file or directory srcPath of the pod container into the local destDir directory, the
+// container must have the tar binary. only regular files and directories are copied, entries escaping destDir are
+// rejected. the command and streams of opts are set by CopyFromPod.
+func CopyFromPod(ctx context.Context, opts ExecOptions, srcPath, destDir string) error {
+	reader, writer := io.Pipe()
+	var stderr bytes.Buffer
+	opts.Command = []string{"tar", "-cf", "-", "-C", path.Dir(srcPath), path.Base(srcPath)}
+	opts.In, opts.Out, opts.Err = nil, writer, &stderr
+
+	result := make(chan error, 1)
+	go func() {
+		result <- extractTar(reader, destDir)
+		// drain what is left so the exec stream does not block.
+		_, _ = io.Copy(io.Discard, reader)
+	}()
+
+	exitCode, err := ExecStream(ctx, opts)
+	writer.Close()
+	extractErr := <-result
+	if err != nil {
+		return errors.Wrapf(err, "copy %s/%s:%s to %s", opts.Namespace, opts.PodName, srcPath, destDir)
+	} else if exitCode != 0 {
+		return errors.Errorf("copy %s/%s:%s to %s: tar exited with code %d: %s", opts.Namespace, opts.PodName, srcPath, destDir, exitCode, strings.TrimSpace(stderr.String()))
+	}
+	return errors.Wrapf(extractErr, "extract %s/%s:%s to %s", opts.Namespace, opts.PodName, srcPath, destDir)
+}
+
+// writeTar writes the file or directory at srcPath as a tar archive, the entries are relative to the parent of
+// srcPath.
+func writeTar(w io.Writer, srcPath string) error {
+	tw := tar.NewWriter(w)
+	base := filepath.Dir(filepath.Clean(srcPath))
+	err := filepath.Walk(srcPath, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return errors.Wrapf(err, "create tar header for %s", file)
+		}
+		name, err := filepath.Rel(base, file)
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(header); err != nil {
+			return errors.Wrapf(err, "write tar header for %s", file)
+		}
+		if info.IsDir() {
+			return nil
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return errors.Wrapf(err, "write %s to tar", file)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// extractTar extracts the regular files and directories of the tar archive into destDir.
+func extractTar(r io.Reader, destDir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "read tar")
+		}
+
+		name := filepath.FromSlash(path.Clean(header.Name))
+		if path.IsAbs(header.Name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return errors.Errorf("tar entry %q is outside of the destination", header.Name)
+		}
+		dest := filepath.Join(destDir, name)
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(dest, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return errors.Wrapf(err, "write %s", dest)
+			}
+		}
+	}
+}
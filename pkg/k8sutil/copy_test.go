@@ -0,0 +1,101 @@
+package k8sutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+)
+
+// stubExecutor runs the tar commands of the copy functions against a local directory standing for the container
+// filesystem.
+type stubExecutor struct {
+	root    string
+	command []string
+}
+
+func (s *stubExecutor) Stream(options remotecommand.StreamOptions) error {
+	return s.StreamWithContext(context.Background(), options)
+}
+
+func (s *stubExecutor) StreamWithContext(_ context.Context, options remotecommand.StreamOptions) error {
+	switch {
+	case len(s.command) == 5 && s.command[1] == "-xmf":
+		return extractTar(options.Stdin, filepath.Join(s.root, s.command[4]))
+	case len(s.command) == 6 && s.command[1] == "-cf":
+		src := filepath.Join(s.root, s.command[4], s.command[5])
+		if _, err := os.Stat(src); err != nil {
+			fmt.Fprintf(options.Stderr, "tar: %s: No such file or directory\n", s.command[5])
+			return exec.CodeExitError{Err: err, Code: 2}
+		}
+		return writeTar(options.Stdout, src)
+	}
+	return fmt.Errorf("unexpected command %v", s.command)
+}
+
+func stubExecOptions(root string) ExecOptions {
+	return ExecOptions{
+		CoreClient: fake.NewClientset().CoreV1(),
+		StreamOptions: StreamOptions{
+			Namespace:     "default",
+			PodName:       "pod",
+			ContainerName: "container",
+		},
+		Executor: func(opts ExecOptions) (remotecommand.Executor, error) {
+			return &stubExecutor{root: root, command: opts.Command}, nil
+		},
+	}
+}
+
+func TestCopyToPodAndBack(t *testing.T) {
+	local, pod := t.TempDir(), t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(local, "bin", "sub"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "bin", "pvmigrate"), []byte("binary"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(local, "bin", "sub", "config"), []byte("config"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(pod, "usr", "local"), 0755))
+
+	opts := stubExecOptions(pod)
+	require.NoError(t, CopyToPod(context.Background(), opts, filepath.Join(local, "bin"), "/usr/local"))
+
+	b, err := os.ReadFile(filepath.Join(pod, "usr", "local", "bin", "pvmigrate"))
+	require.NoError(t, err)
+	assert.Equal(t, "binary", string(b))
+	info, err := os.Stat(filepath.Join(pod, "usr", "local", "bin", "pvmigrate"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	dest := t.TempDir()
+	require.NoError(t, CopyFromPod(context.Background(), opts, "/usr/local/bin/sub/config", dest))
+	b, err = os.ReadFile(filepath.Join(dest, "config"))
+	require.NoError(t, err)
+	assert.Equal(t, "config", string(b))
+
+	err = CopyFromPod(context.Background(), opts, "/var/log/missing.log", dest)
+	assert.EqualError(t, err, "copy default/pod:/var/log/missing.log to "+dest+": tar exited with code 2: tar: missing.log: No such file or directory")
+}
+
+func Test_extractTar(t *testing.T) {
+	for _, name := range []string{"../escape", "/etc/passwd", "dir/../../escape"} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: 1, Typeflag: tar.TypeReg}))
+			_, err := io.WriteString(tw, "x")
+			require.NoError(t, err)
+			require.NoError(t, tw.Close())
+
+			err = extractTar(&buf, t.TempDir())
+			assert.ErrorContains(t, err, "is outside of the destination")
+		})
+	}
+}
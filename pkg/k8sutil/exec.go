@@ -23,8 +23,14 @@ type ExecOptions struct {
 
 	CoreClient corev1client.CoreV1Interface
 	Config     *restclient.Config
+
+	// Executor creates the executor running the command, an SPDY executor when not set.
+	Executor ExecutorFunc
 }
 
+// ExecutorFunc returns the executor running the command of the provided options in the pod container.
+type ExecutorFunc func(opts ExecOptions) (remotecommand.Executor, error)
+
 type StreamOptions struct {
 	Namespace     string
 	PodName       string
@@ -39,24 +45,12 @@ type StreamOptions struct {
 // and error. The error will be non-nil if exit code is not 0.
 func ExecContainer(ctx context.Context, opts ExecOptions, terminalSizeQueue remotecommand.TerminalSizeQueue) (int, error) {
 	// TODO: handle tty, build TerminalSizeQueue from StreamOpts.In?
-	// TODO: ctx
-
-	req := opts.CoreClient.RESTClient().Post().
-		Resource("pods").
-		Name(opts.PodName).
-		Namespace(opts.Namespace).
-		SubResource("exec").
-		Param("container", opts.ContainerName)
-	req.VersionedParams(&corev1.PodExecOptions{
-		Container: opts.ContainerName,
-		Command:   opts.Command,
-		Stdin:     opts.In != nil,
-		Stdout:    opts.Out != nil,
-		Stderr:    opts.Err != nil,
-		TTY:       opts.TTY,
-	}, runtime.NewParameterCodec(scheme.Scheme))
 
-	executor, err := remotecommand.NewSPDYExecutor(opts.Config, "POST", req.URL())
+	newExecutor := opts.Executor
+	if newExecutor == nil {
+		newExecutor = spdyExecutor
+	}
+	executor, err := newExecutor(opts)
 	if err != nil {
 		return 0, errors.Wrap(err, "create exec")
 	}
@@ -78,6 +72,35 @@ func ExecContainer(ctx context.Context, opts ExecOptions, terminalSizeQueue remo
 	return 0, nil
 }
 
+// spdyExecutor returns an SPDY executor running the command through the pod exec subresource.
+func spdyExecutor(opts ExecOptions) (remotecommand.Executor, error) {
+	req := opts.CoreClient.RESTClient().Post().
+		Resource("pods").
+		Name(opts.PodName).
+		Namespace(opts.Namespace).
+		SubResource("exec").
+		Param("container", opts.ContainerName)
+	req.VersionedParams(&corev1.PodExecOptions{
+		Container: opts.ContainerName,
+		Command:   opts.Command,
+		Stdin:     opts.In != nil,
+		Stdout:    opts.Out != nil,
+		Stderr:    opts.Err != nil,
+		TTY:       opts.TTY,
+	}, runtime.NewParameterCodec(scheme.Scheme))
+	return remotecommand.NewSPDYExecutor(opts.Config, "POST", req.URL())
+}
+
+// ExecStream runs the command in the container streaming opts.In to its stdin and its stdout and stderr to opts.Out
+// and opts.Err. returns the exit code of the command, a non-zero exit code is not considered an error.
+func ExecStream(ctx context.Context, opts ExecOptions) (int, error) {
+	exitCode, err := ExecContainer(ctx, opts, nil)
+	if exitCode != 0 {
+		err = nil
+	}
+	return exitCode, err
+}
+
 // SyncExec returns exitcode, stdout, stderr. A non-zero exit code from the command is not considered an error.
 func SyncExec(coreClient corev1client.CoreV1Interface, clientConfig *restclient.Config, ns, pod, container string, command ...string) (int, string, string, error) {
 	var stdout bytes.Buffer
@@ -28,13 +28,17 @@ func WaitForJob(ctx context.Context, cli kubernetes.Interface, job *batchv1.Job,
 			return false, nil
 		case gotJob.Status.Succeeded > 0:
 			return true, nil
-		default:
-			time.Sleep(time.Second)
 		}
 
 		if time.Now().After(endAt) {
 			return false, fmt.Errorf("timeout waiting for job to finish")
 		}
+
+		select {
+		case <-ctx.Done():
+			return false, fmt.Errorf("failed waiting for job: %w", ctx.Err())
+		case <-time.After(time.Second):
+		}
 	}
 }
 
@@ -9,6 +9,8 @@ const (
 	LabelKeyKurlshManaged = "kurl.sh/managed"
 	// LabelKeyKurlshVersion is the metadata label key for kurl.sh version
 	LabelKeyKurlshVersion = "kurl.sh/version"
+	// LabelKeyKurlshApplySet is the metadata label key for the set of objects applied together, used to prune them
+	LabelKeyKurlshApplySet = "kurl.sh/apply-set"
 )
 
 // AppendKurlLabels appends kurl.sh managed labels to the given map
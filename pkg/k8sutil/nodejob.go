@@ -0,0 +1,159 @@
+package k8sutil
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/sync/errgroup"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/ptr"
+)
+
+// DefaultNodeJobTimeout is the time a node job is given to finish when NodeJobOptions.Timeout is not set.
+const DefaultNodeJobTimeout = 2 * time.Minute
+
+// NodeJobOptions describes a job to run on one or more nodes.
+type NodeJobOptions struct {
+	// Namespace is the namespace the jobs are created in.
+	Namespace string
+	// NamePrefix is the prefix of the job names, the node name and a random suffix are appended to it.
+	NamePrefix string
+	// Labels are added to the jobs.
+	Labels map[string]string
+	// PodTemplate is the template of the job pods. the restart policy defaults to Never, an affinity to the node and
+	// tolerations for the node taints are added.
+	PodTemplate corev1.PodTemplateSpec
+	// Timeout is the time each job is given to finish, DefaultNodeJobTimeout when not set.
+	Timeout time.Duration
+	// NodeSelector is the label selector of the nodes RunNodeJobs runs the job on, all nodes when empty.
+	NodeSelector string
+	// NodeFilter, when set, restricts the nodes selected by NodeSelector to the ones it returns true for.
+	NodeFilter func(corev1.Node) bool
+	// NodePodTemplate, when set, returns the pod template of the job of a node in place of PodTemplate.
+	NodePodTemplate func(corev1.Node) corev1.PodTemplateSpec
+	// Parallelism is the maximum number of jobs RunNodeJobs runs at once, no limit when not set.
+	Parallelism int
+}
+
+// NodeJobResult is the outcome of a job run on a node. Logs and States are indexed by container name and may be set
+// even when the job failed.
+type NodeJobResult struct {
+	Node   string
+	Logs   map[string][]byte
+	States map[string]corev1.ContainerState
+	Err    error
+}
+
+// RunNodeJobs runs the job on each node selected by opts.NodeSelector and opts.NodeFilter, at most opts.Parallelism at
+// a time, and waits for all of them to finish. a failed job does not stop the others, its error is in the result of the node. the jobs
+// are deleted once finished, also when the context is canceled. results are in the order the nodes are listed.
+func RunNodeJobs(ctx context.Context, cli kubernetes.Interface, logger *log.Logger, opts NodeJobOptions) ([]NodeJobResult, error) {
+	nodes, err := cli.CoreV1().Nodes().List(ctx, metav1.ListOptions{LabelSelector: opts.NodeSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nodes: %w", err)
+	}
+
+	var selected []corev1.Node
+	for _, node := range nodes.Items {
+		if opts.NodeFilter == nil || opts.NodeFilter(node) {
+			selected = append(selected, node)
+		}
+	}
+
+	results := make([]NodeJobResult, len(selected))
+	g := errgroup.Group{}
+	if opts.Parallelism > 0 {
+		g.SetLimit(opts.Parallelism)
+	}
+	for i, node := range selected {
+		g.Go(func() error {
+			result := NodeJobResult{Node: node.Name, Err: ctx.Err()}
+			if result.Err == nil {
+				result, _ = RunNodeJob(ctx, cli, logger, node, opts)
+			}
+			results[i] = result
+			return nil
+		})
+	}
+	_ = g.Wait()
+	return results, nil
+}
+
+// RunNodeJob runs the job on the provided node and waits until it finishes or the timeout is reached. the returned
+// error is also set in the result.
+func RunNodeJob(ctx context.Context, cli kubernetes.Interface, logger *log.Logger, node corev1.Node, opts NodeJobOptions) (NodeJobResult, error) {
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultNodeJobTimeout
+	}
+	result := NodeJobResult{Node: node.Name}
+	job := BuildNodeJob(node, opts)
+	result.Logs, result.States, result.Err = RunJob(ctx, cli, logger, job, timeout)
+	if result.Err != nil {
+		result.Err = fmt.Errorf("failed to run job %s/%s on node %s: %w", job.Namespace, job.Name, node.Name, result.Err)
+	}
+	return result, result.Err
+}
+
+// BuildNodeJob returns the job described by opts scheduled to run on the provided node.
+func BuildNodeJob(node corev1.Node, opts NodeJobOptions) *batchv1.Job {
+	timeout := opts.Timeout
+	if timeout == 0 {
+		timeout = DefaultNodeJobTimeout
+	}
+
+	template := *opts.PodTemplate.DeepCopy()
+	if opts.NodePodTemplate != nil {
+		template = opts.NodePodTemplate(node)
+	}
+	if template.Spec.RestartPolicy == "" {
+		template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	}
+	if template.Spec.Affinity == nil {
+		template.Spec.Affinity = &corev1.Affinity{}
+	}
+	template.Spec.Affinity.NodeAffinity = &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{
+					MatchExpressions: []corev1.NodeSelectorRequirement{
+						{
+							Key:      corev1.LabelHostname,
+							Operator: corev1.NodeSelectorOpIn,
+							Values:   []string{node.Name},
+						},
+					},
+				},
+			},
+		},
+	}
+	template.Spec.Tolerations = append(template.Spec.Tolerations, TolerationsForNode(node)...)
+
+	jobName := fmt.Sprintf("%s-%s-%s", opts.NamePrefix, node.Name, uuid.New().String()[:5])
+	if len(jobName) > 63 {
+		jobName = jobName[0:31] + jobName[len(jobName)-32:]
+	}
+
+	labels := map[string]string{}
+	for k, v := range opts.Labels {
+		labels[k] = v
+	}
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      jobName,
+			Namespace: opts.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          ptr.To(int32(1)),
+			ActiveDeadlineSeconds: ptr.To(int64(timeout.Seconds())),
+			Template:              template,
+		},
+	}
+}
@@ -0,0 +1,116 @@
+package k8sutil
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestRunNodeJobs(t *testing.T) {
+	nodes := []runtime.Object{
+		&corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{Name: "node-a", Labels: map[string]string{"role": "worker"}},
+			Spec: corev1.NodeSpec{
+				Taints: []corev1.Taint{{Key: "dedicated", Value: "storage", Effect: corev1.TaintEffectNoSchedule}},
+			},
+		},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-b", Labels: map[string]string{"role": "worker"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-c", Labels: map[string]string{"role": "control-plane"}}},
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-d", Labels: map[string]string{"role": "worker"}}},
+	}
+	cli := fake.NewClientset(nodes...)
+
+	// the fake client does not run jobs, the reactor completes them and creates their pod. jobs on node-b fail.
+	var created []*batchv1.Job
+	cli.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		created = append(created, job.DeepCopy())
+		node := job.Spec.Template.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions[0].Values[0]
+		job.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": job.Name}}
+		if node == "node-b" {
+			job.Status.Failed = 1
+		} else {
+			job.Status.Succeeded = 1
+		}
+		pod := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: job.Name, Namespace: job.Namespace, Labels: job.Spec.Selector.MatchLabels},
+			Spec:       job.Spec.Template.Spec,
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{
+				{Name: "main", State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{ExitCode: int32(job.Status.Failed)}}},
+			}},
+		}
+		return false, nil, cli.Tracker().Add(pod)
+	})
+
+	results, err := RunNodeJobs(context.Background(), cli, log.New(io.Discard, "", 0), NodeJobOptions{
+		Namespace:    "kurl",
+		NamePrefix:   "test",
+		Labels:       map[string]string{"app": "test"},
+		NodeSelector: "role=worker",
+		NodeFilter: func(node corev1.Node) bool {
+			return node.Name != "node-d"
+		},
+		NodePodTemplate: func(node corev1.Node) corev1.PodTemplateSpec {
+			return corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "main", Image: "busybox:" + node.Name}}},
+			}
+		},
+		Parallelism: 1,
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+
+	assert.Equal(t, "node-a", results[0].Node)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, map[string][]byte{"main": []byte("fake logs")}, results[0].Logs)
+	assert.Equal(t, int32(0), results[0].States["main"].Terminated.ExitCode)
+
+	assert.Equal(t, "node-b", results[1].Node)
+	assert.ErrorContains(t, results[1].Err, "on node node-b: job failed to execute")
+	assert.Equal(t, int32(1), results[1].States["main"].Terminated.ExitCode)
+
+	require.Len(t, created, 2)
+	for _, job := range created {
+		assert.True(t, strings.HasPrefix(job.Name, "test-node-"), job.Name)
+		assert.Equal(t, "test", job.Labels["app"])
+		assert.Equal(t, corev1.RestartPolicyNever, job.Spec.Template.Spec.RestartPolicy)
+	}
+	assert.Equal(t, "busybox:node-a", created[0].Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, "busybox:node-b", created[1].Spec.Template.Spec.Containers[0].Image)
+	assert.Equal(t, []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists, Effect: corev1.TaintEffectNoSchedule}}, created[0].Spec.Template.Spec.Tolerations)
+	assert.Empty(t, created[1].Spec.Template.Spec.Tolerations)
+
+	jobs, err := cli.BatchV1().Jobs("kurl").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, jobs.Items, "jobs must be deleted")
+}
+
+func TestRunNodeJobsCanceled(t *testing.T) {
+	cli := fake.NewClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-a"}})
+	ctx, cancel := context.WithCancel(context.Background())
+	// the job never finishes, the context is canceled once it is created.
+	cli.PrependReactor("create", "jobs", func(k8stesting.Action) (bool, runtime.Object, error) {
+		cancel()
+		return false, nil, nil
+	})
+
+	results, err := RunNodeJobs(ctx, cli, log.New(io.Discard, "", 0), NodeJobOptions{Namespace: "kurl", NamePrefix: "test"})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.ErrorIs(t, results[0].Err, context.Canceled)
+
+	jobs, err := cli.BatchV1().Jobs("kurl").List(context.Background(), metav1.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, jobs.Items, "jobs must be deleted")
+}
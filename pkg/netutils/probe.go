@@ -0,0 +1,389 @@
+package netutils
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	probeLatency byte = 'L'
+	probeMTU     byte = 'M'
+	probeAck     byte = 'A'
+
+	// probeHeaderSize is the size of the probe type and sequence number at the start of every udp probe.
+	probeHeaderSize = 9
+)
+
+// MinProbeMTU is the smallest mtu probed, all ipv4 hosts must accept it. DiscoverPathMTU rejects a
+// smaller maximum.
+const MinProbeMTU = 576
+
+// overlayInterfaces are the interfaces created by the cni plugins supported by kurl.
+var overlayInterfaces = []string{"flannel.1", "weave", "vxlan-6784", "antrea-gw0"}
+
+// LatencyResult is the round trip time of udp probes between two hosts.
+type LatencyResult struct {
+	Sent     int           `json:"sent"`
+	Received int           `json:"received"`
+	Min      time.Duration `json:"min"`
+	Avg      time.Duration `json:"avg"`
+	Max      time.Duration `json:"max"`
+}
+
+// Loss returns the percentage of probes without an answer.
+func (r LatencyResult) Loss() float64 {
+	if r.Sent == 0 {
+		return 0
+	}
+	return float64(r.Sent-r.Received) * 100 / float64(r.Sent)
+}
+
+// MTUResult is the largest packet, ip header included, that reached the remote host without being
+// fragmented.
+type MTUResult struct {
+	PathMTU int `json:"pathMTU"`
+	// MaxMTU is the largest size probed.
+	MaxMTU int `json:"maxMTU"`
+}
+
+// BandwidthResult is the throughput of a tcp stream between two hosts.
+type BandwidthResult struct {
+	Bytes         int64         `json:"bytes"`
+	Duration      time.Duration `json:"duration"`
+	BitsPerSecond float64       `json:"bitsPerSecond"`
+}
+
+// ServeProbes answers the probes sent by MeasureLatency and DiscoverPathMTU on udp and receives the
+// streams sent by MeasureBandwidth on tcp, both on the provided address. returns when the context
+// is done or one of the listeners fails.
+func ServeProbes(ctx context.Context, address string) error {
+	packetConn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on udp %s: %w", address, err)
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		packetConn.Close()
+		return fmt.Errorf("failed to listen on tcp %s: %w", address, err)
+	}
+
+	var once sync.Once
+	closeAll := func() {
+		once.Do(func() {
+			packetConn.Close()
+			listener.Close()
+		})
+	}
+	go func() {
+		<-ctx.Done()
+		closeAll()
+	}()
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- serveUDPProbes(packetConn)
+	}()
+	go func() {
+		errs <- serveTCPProbes(listener)
+	}()
+
+	err = <-errs
+	closeAll()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func serveUDPProbes(conn net.PacketConn) error {
+	buf := make([]byte, 65536)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return fmt.Errorf("failed to read udp probe: %w", err)
+		}
+		if n < probeHeaderSize {
+			continue
+		}
+		switch buf[0] {
+		case probeLatency:
+			_, err = conn.WriteTo(buf[:n], addr)
+		case probeMTU:
+			// only a small ack is sent back, the return path may have a different mtu.
+			ack := make([]byte, probeHeaderSize+4)
+			ack[0] = probeAck
+			copy(ack[1:probeHeaderSize], buf[1:probeHeaderSize])
+			binary.BigEndian.PutUint32(ack[probeHeaderSize:], uint32(n))
+			_, err = conn.WriteTo(ack, addr)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to answer udp probe: %w", err)
+		}
+	}
+}
+
+func serveTCPProbes(listener net.Listener) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return fmt.Errorf("failed to accept tcp probe: %w", err)
+		}
+		go func() {
+			defer conn.Close()
+			received, _ := io.Copy(io.Discard, conn)
+			fmt.Fprintf(conn, "%d\n", received)
+		}()
+	}
+}
+
+// MeasureLatency sends count udp probes to the address, waiting up to timeout for each answer.
+func MeasureLatency(ctx context.Context, address string, count int, timeout time.Duration) (*LatencyResult, error) {
+	conn, err := dialProbe(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	result := &LatencyResult{}
+	var total time.Duration
+	packet := make([]byte, 64)
+	for seq := uint64(1); seq <= uint64(count); seq++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		result.Sent++
+		start := time.Now()
+		if err := sendProbe(conn, probeLatency, seq, packet); err != nil {
+			return nil, err
+		}
+		if _, ok, err := readProbeAnswer(conn, probeLatency, seq, start.Add(timeout)); err != nil {
+			return nil, err
+		} else if !ok {
+			continue
+		}
+
+		rtt := time.Since(start)
+		result.Received++
+		total += rtt
+		if result.Min == 0 || rtt < result.Min {
+			result.Min = rtt
+		}
+		if rtt > result.Max {
+			result.Max = rtt
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if result.Received > 0 {
+		result.Avg = total / time.Duration(result.Received)
+	}
+	return result, nil
+}
+
+// DiscoverPathMTU finds the largest packet up to maxMTU that reaches the address with the don't
+// fragment bit set. sizes include the ip and udp headers so they can be compared with interface
+// mtus.
+func DiscoverPathMTU(ctx context.Context, address string, maxMTU int, timeout time.Duration) (*MTUResult, error) {
+	if maxMTU < MinProbeMTU {
+		return nil, fmt.Errorf("max mtu %d is smaller than the minimum probe size %d", maxMTU, MinProbeMTU)
+	}
+
+	conn, err := dialProbe(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := setDontFragment(conn); err != nil {
+		return nil, fmt.Errorf("failed to set the don't fragment bit: %w", err)
+	}
+
+	headers := 28 // ipv4 and udp headers
+	if remote, ok := conn.RemoteAddr().(*net.UDPAddr); ok && remote.IP.To4() == nil {
+		headers = 48
+	}
+
+	var seq uint64
+	probe := func(size int) (bool, error) {
+		packet := make([]byte, size-headers)
+		for attempt := 0; attempt < 3; attempt++ {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			seq++
+			if err := sendProbe(conn, probeMTU, seq, packet); err != nil {
+				if isMessageTooLong(err) {
+					// larger than the mtu of the local interface or a cached path mtu.
+					return false, nil
+				}
+				return false, err
+			}
+			if _, ok, err := readProbeAnswer(conn, probeMTU, seq, time.Now().Add(timeout)); err != nil {
+				return false, err
+			} else if ok {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	ok, err := probe(MinProbeMTU)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("no answer to %d bytes probes from %s", MinProbeMTU, address)
+	}
+
+	low, high := MinProbeMTU, maxMTU
+	for low < high {
+		size := (low + high + 1) / 2
+		ok, err := probe(size)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			low = size
+		} else {
+			high = size - 1
+		}
+	}
+	return &MTUResult{PathMTU: low, MaxMTU: maxMTU}, nil
+}
+
+// MeasureBandwidth sends a tcp stream to the address during the provided duration. the throughput is
+// computed with the number of bytes the remote host received.
+func MeasureBandwidth(ctx context.Context, address string, duration time.Duration) (*BandwidthResult, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	defer conn.Close()
+
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil, fmt.Errorf("unexpected connection type %T", conn)
+	}
+
+	chunk := make([]byte, 128*1024)
+	start := time.Now()
+	deadline := start.Add(duration)
+	if err := conn.SetWriteDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("failed to set write deadline: %w", err)
+	}
+	for time.Now().Before(deadline) && ctx.Err() == nil {
+		if _, err := conn.Write(chunk); err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				break
+			}
+			return nil, fmt.Errorf("failed to send data: %w", err)
+		}
+	}
+	if err := tcpConn.CloseWrite(); err != nil {
+		return nil, fmt.Errorf("failed to close connection: %w", err)
+	}
+
+	if err := conn.SetReadDeadline(time.Now().Add(duration + 30*time.Second)); err != nil {
+		return nil, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the number of bytes received: %w", err)
+	}
+	elapsed := time.Since(start)
+	received, err := strconv.ParseInt(strings.TrimSpace(line), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of bytes received %q: %w", line, err)
+	}
+
+	return &BandwidthResult{
+		Bytes:         received,
+		Duration:      elapsed,
+		BitsPerSecond: float64(received*8) / elapsed.Seconds(),
+	}, nil
+}
+
+// OverlayInterfaceMTUs returns the mtu of the overlay network interfaces found on the host.
+func OverlayInterfaceMTUs() (map[string]int, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+	mtus := map[string]int{}
+	for _, iface := range ifaces {
+		for _, name := range overlayInterfaces {
+			if iface.Name == name {
+				mtus[iface.Name] = iface.MTU
+			}
+		}
+	}
+	return mtus, nil
+}
+
+func dialProbe(ctx context.Context, address string) (*net.UDPConn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+	return conn.(*net.UDPConn), nil
+}
+
+func sendProbe(conn *net.UDPConn, kind byte, seq uint64, packet []byte) error {
+	packet[0] = kind
+	binary.BigEndian.PutUint64(packet[1:probeHeaderSize], seq)
+	if _, err := conn.Write(packet); err != nil {
+		return fmt.Errorf("failed to send probe: %w", err)
+	}
+	return nil
+}
+
+// readProbeAnswer waits until the deadline for the answer to the probe with the provided sequence
+// number. answers to previous probes are discarded.
+func readProbeAnswer(conn *net.UDPConn, kind byte, seq uint64, deadline time.Time) ([]byte, bool, error) {
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return nil, false, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+	expected := probeLatency
+	if kind == probeMTU {
+		expected = probeAck
+	}
+	buf := make([]byte, 65536)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				return nil, false, nil
+			}
+			if isConnectionRefused(err) {
+				// an icmp port unreachable from a previous probe, the next read waits for the answer.
+				continue
+			}
+			return nil, false, fmt.Errorf("failed to read probe answer: %w", err)
+		}
+		if n < probeHeaderSize || buf[0] != expected || binary.BigEndian.Uint64(buf[1:probeHeaderSize]) != seq {
+			continue
+		}
+		return buf[:n], true, nil
+	}
+}
+
+func isMessageTooLong(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE)
+}
+
+func isConnectionRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package netutils
+
+import (
+	"errors"
+	"net"
+)
+
+func setDontFragment(_ *net.UDPConn) error {
+	return errors.New("not supported on this platform")
+}
@@ -0,0 +1,81 @@
+package netutils
+
+import (
+	"context"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func startProbeServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.Addr().String()
+	require.NoError(t, listener.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- ServeProbes(ctx, address)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		assert.NoError(t, <-done)
+	})
+	// wait for the listeners.
+	require.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	return address
+}
+
+func TestProbes(t *testing.T) {
+	address := startProbeServer(t)
+	ctx := context.Background()
+
+	latency, err := MeasureLatency(ctx, address, 3, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 3, latency.Sent)
+	assert.Equal(t, 3, latency.Received)
+	assert.Equal(t, float64(0), latency.Loss())
+	assert.True(t, latency.Min <= latency.Avg && latency.Avg <= latency.Max)
+
+	bandwidth, err := MeasureBandwidth(ctx, address, 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Greater(t, bandwidth.Bytes, int64(0))
+	assert.Greater(t, bandwidth.BitsPerSecond, float64(0))
+
+	if runtime.GOOS != "linux" {
+		t.Skip("path mtu discovery is only supported on linux")
+	}
+	// the loopback mtu is larger than the probed sizes.
+	mtu, err := DiscoverPathMTU(ctx, address, 1500, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, &MTUResult{PathMTU: 1500, MaxMTU: 1500}, mtu)
+
+	// the path mtu can not be reported below the minimum probe size.
+	_, err = DiscoverPathMTU(ctx, address, 500, time.Second)
+	assert.EqualError(t, err, "max mtu 500 is smaller than the minimum probe size 576")
+}
+
+func TestProbesWithoutServer(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := listener.LocalAddr().String()
+	require.NoError(t, listener.Close())
+
+	latency, err := MeasureLatency(context.Background(), address, 2, 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 0, latency.Received)
+	assert.Equal(t, float64(100), latency.Loss())
+}
@@ -15,7 +15,6 @@ import (
 	"github.com/pkg/errors"
 	"github.com/replicatedhq/kurl/pkg/k8sutil"
 	"github.com/replicatedhq/kurl/pkg/rook/static/flexmigrator"
-	"github.com/replicatedhq/plumber/v2"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -31,6 +30,7 @@ const (
 	rookCephMigratorDeploymentName          = "rook-ceph-migrator"
 	rookCephMigratorPodContainerName        = "rook-ceph-migrator"
 	rookCephMigratorDeploymentLabelSelector = "app=rook-ceph-migrator"
+	flexMigratorApplySet                    = "rook-flex-migrator"
 
 	desiredScaleAnnotation = "kurl.sh/rook-flexvolume-to-csi-desired-scale"
 )
@@ -81,11 +81,6 @@ func FlexvolumeToCSI(ctx context.Context, logger *log.Logger, clientset kubernet
 		return err
 	}
 
-	_, err = exec.LookPath("kubectl")
-	if err != nil {
-		return errors.Wrap(err, "which kubectl")
-	}
-
 	cli, err := client.New(clientConfig, client.Options{})
 	if err != nil {
 		return errors.Wrap(err, "create kubernetes controller-runtime client")
@@ -98,12 +93,8 @@ func FlexvolumeToCSI(ctx context.Context, logger *log.Logger, clientset kubernet
 	}
 	defer func() {
 		logger.Println("Deleting flex migrator ...")
-		out, err := deleteFlexMigrator(context.Background())
-		if err != nil {
+		if err := deleteFlexMigrator(context.Background(), cli); err != nil {
 			logger.Printf("Failed to delete flex migrator resources: %v", err)
-			if out != "" {
-				logger.Printf("err: %s", out)
-			}
 		} else {
 			logger.Println("Deleted flex migrator")
 		}
@@ -213,27 +204,33 @@ func runBinPVMigrator(ctx context.Context, w io.Writer, clientset kubernetes.Int
 }
 
 func runFlexMigrator(ctx context.Context, cli client.Client, opts FlexvolumeToCSIOpts) error {
-	options := []plumber.Option{
-		plumber.WithFSMutator(func(_ context.Context, fs filesys.FileSystem) error {
-			return generateFlexMigratorPatch(fs, opts)
-		}),
+	mutator := func(_ context.Context, fs filesys.FileSystem) error {
+		return generateFlexMigratorPatch(fs, opts)
 	}
 
-	err := k8sutil.KubectlApply(ctx, cli, flexmigrator.FS, "overlays/kurl", options...)
+	// the manifests set the namespace of their objects.
+	applyOpts := k8sutil.ApplyOptions{
+		ApplySet: flexMigratorApplySet,
+		Prune:    true,
+	}
+	err := k8sutil.ApplyKustomize(ctx, cli, flexmigrator.FS, "overlays/kurl", applyOpts, mutator)
 	if err != nil {
-		return errors.Wrap(err, "kubectl apply flex migrator")
+		return errors.Wrap(err, "apply flex migrator")
 	}
 
 	return nil
 }
 
-func deleteFlexMigrator(ctx context.Context) (string, error) {
+func deleteFlexMigrator(ctx context.Context, cli client.Client) error {
 	b, err := fs.ReadFile(flexmigrator.FS, "kustomize/base/flex-migrator.yaml")
 	if err != nil {
-		return "", errors.Wrap(err, "read flex migrator kustomize")
+		return errors.Wrap(err, "read flex migrator kustomize")
+	}
+	objs, err := k8sutil.DecodeManifests(b)
+	if err != nil {
+		return errors.Wrap(err, "decode flex migrator manifests")
 	}
-	out, err := k8sutil.KubectlDelete(ctx, b, "--ignore-not-found=true")
-	return strings.TrimSpace(string(out)), errors.Wrap(err, "kubectl delete flex migrator")
+	return errors.Wrap(k8sutil.DeleteManifests(ctx, cli, objs, rookCephNamespace), "delete flex migrator")
 }
 
 func waitForFlexMigratorPod(ctx context.Context, clientset kubernetes.Interface) (*corev1.Pod, error) {
@@ -6,11 +6,13 @@ import (
 	"sort"
 	"testing"
 
+	"github.com/replicatedhq/kurl/pkg/k8sutil"
 	"github.com/replicatedhq/kurl/pkg/rook/testfiles"
 	"github.com/stretchr/testify/require"
 	"gotest.tools/assert"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer/json"
@@ -174,15 +176,31 @@ func Test_runFlexMigrator(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			cli := fakeclient.NewClientBuilder().Build()
+			// a service account applied by a previous version of the migrator must be pruned.
+			stale := &corev1.ServiceAccount{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "rook-ceph-old-migrator",
+					Namespace: "rook-ceph",
+					Labels: map[string]string{
+						k8sutil.LabelKeyKurlshManaged:  "true",
+						k8sutil.LabelKeyKurlshApplySet: flexMigratorApplySet,
+					},
+				},
+			}
+			cli := fakeclient.NewClientBuilder().WithObjects(stale).Build()
 
 			err := runFlexMigrator(context.Background(), cli, tt.opts)
 			require.NoError(t, err)
 
+			err = cli.Get(context.Background(), client.ObjectKeyFromObject(stale), &corev1.ServiceAccount{})
+			require.True(t, k8sErrors.IsNotFound(err), err)
+
 			obj := &appsv1.Deployment{}
 			err = cli.Get(context.Background(), client.ObjectKey{Namespace: "rook-ceph", Name: "rook-ceph-migrator"}, obj)
 			require.NoError(t, err)
 
+			assert.Equal(t, flexMigratorApplySet, obj.Labels[k8sutil.LabelKeyKurlshApplySet])
+
 			assert.Equal(t, tt.opts.NodeName, obj.Spec.Template.Spec.NodeName)
 			assert.Equal(t, tt.opts.PVMigratorBinPath, obj.Spec.Template.Spec.Volumes[0].HostPath.Path)
 			assert.Equal(t, tt.opts.CephMigratorImage, obj.Spec.Template.Spec.Containers[0].Image)
@@ -3,11 +3,15 @@ package rook
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/replicatedhq/kurl/pkg/k8sutil"
 	cephv1 "github.com/rook/rook/pkg/client/clientset/versioned/typed/ceph.rook.io/v1"
+	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
@@ -16,10 +20,29 @@ import (
 
 var loopSleep = time.Second * 1
 
-func HostpathToOsd(ctx context.Context, config *rest.Config) error {
+// HostpathToOsdOptions control which hostpath OSDs are migrated and how fast.
+type HostpathToOsdOptions struct {
+	// DryRun prints the migration plan without changing the cluster.
+	DryRun bool
+	// Nodes limits the migration to the hostpath OSDs on these nodes, by node name or IP. All nodes are migrated if
+	// empty.
+	Nodes []string
+	// PauseBetweenRemovals is the time to wait after removing an OSD before starting on the next one, to let recovery
+	// I/O settle.
+	PauseBetweenRemovals time.Duration
+}
+
+// HostpathToOsd adds block device OSDs and removes the hostpath OSDs of the selected nodes one at a time. the plan is
+// built from the hostpath OSDs that remain in the cluster, so running it again resumes an interrupted migration,
+// and a migration can be spread over several runs with different nodes.
+func HostpathToOsd(ctx context.Context, config *rest.Config, opts HostpathToOsdOptions) error {
 	client := kubernetes.NewForConfigOrDie(config)
 	cephClient := cephv1.NewForConfigOrDie(config)
 
+	if opts.DryRun {
+		return printHostpathToOsdPlan(ctx, client, opts)
+	}
+
 	out("Adding blockdevice-based Rook OSDs and removing all Hostpath-based OSDs to allow upgrading Rook")
 	// start rook-ceph-tools deployment if not present
 	err := startToolbox(ctx, client)
@@ -36,15 +59,23 @@ func HostpathToOsd(ctx context.Context, config *rest.Config) error {
 	}
 
 	out("Rook is currently healthy, checking if a migration from directory-based storage is required")
-	dirOSDs, blockOSDs, err := countRookOSDs(ctx, client)
+	plan, err := getHostpathOSDPlan(ctx, client, opts.Nodes)
 	if err != nil {
-		return fmt.Errorf("failed to determine how many OSDs needed migration: %w", err)
+		return fmt.Errorf("failed to determine the hostpath OSDs to migrate: %w", err)
 	}
-	if dirOSDs == 0 {
-		out("No directory OSDs exist, and so no migration is required.")
+	if len(plan.osds()) == 0 {
+		if len(plan.Skipped) > 0 {
+			out(fmt.Sprintf("No directory OSDs exist on the selected nodes, %d remain on other nodes.", len(plan.Skipped)))
+		} else {
+			out("No directory OSDs exist, and so no migration is required.")
+		}
 		return nil
 	}
-	out(fmt.Sprintf("%d directory OSDs exist, and %d nodes with block-based OSDs. Continuing with migration.", dirOSDs, blockOSDs))
+	_, blockOSDs, err := countRookOSDs(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to determine how many OSDs needed migration: %w", err)
+	}
+	out(fmt.Sprintf("%d directory OSDs to migrate, and %d nodes with block-based OSDs. Continuing with migration.", len(plan.osds()), blockOSDs))
 
 	// change cephcluster to use OSDs not hostpath (if not already done)
 	err = enableBlockDevices(ctx, client, cephClient)
@@ -59,32 +90,189 @@ func HostpathToOsd(ctx context.Context, config *rest.Config) error {
 		return fmt.Errorf("failed to wait for block device OSDs to be added: %w", err)
 	}
 
-	out("Determining the list of hostpath OSDs to migrate")
-	// determine the list of hostpath OSDs
-	allOSDs, err := getRookOSDs(ctx, client)
+	for _, osd := range plan.Interrupted {
+		out(fmt.Sprintf("Resuming the interrupted removal of osd.%d", osd.Num))
+	}
+	out(fmt.Sprintf("Removing hostpath OSDs %s from the cluster", osdListString(plan.osds())))
+	for idx, osd := range plan.osds() {
+		if idx > 0 && opts.PauseBetweenRemovals > 0 {
+			out(fmt.Sprintf("Pausing for %s before removing osd.%d to let recovery settle", opts.PauseBetweenRemovals, osd.Num))
+			select {
+			case <-time.After(opts.PauseBetweenRemovals):
+			case <-ctx.Done():
+				return fmt.Errorf("migration interrupted before removing osd.%d, run it again to resume: %w", osd.Num, ctx.Err())
+			}
+		}
+
+		if idx < len(plan.Interrupted) {
+			err = resumeRemoveOSD(ctx, client, osd.Num)
+		} else {
+			err = safeRemoveOSD(ctx, client, osd.Num)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to safely remove OSD %d, run the migration again to resume: %w", osd.Num, err)
+		}
+	}
+
+	if len(plan.Skipped) > 0 {
+		out(fmt.Sprintf("Migration of the selected nodes completed successfully, hostpath OSDs %s remain on other nodes.", osdListString(plan.Skipped)))
+		return nil
+	}
+	out("Migration completed successfully!")
+
+	return nil
+}
+
+// hostpathOSDPlan lists the hostpath OSDs to remove, in order.
+type hostpathOSDPlan struct {
+	// Interrupted are the OSDs whose deployment was scaled down by a previous run that did not purge them.
+	Interrupted []RookOSD
+	// Remove are the running hostpath OSDs on the selected nodes.
+	Remove []RookOSD
+	// Skipped are the running hostpath OSDs on nodes that were not selected.
+	Skipped []RookOSD
+}
+
+// osds returns the OSDs to remove, the interrupted ones first as their data has already been moved.
+func (p hostpathOSDPlan) osds() []RookOSD {
+	return append(append([]RookOSD{}, p.Interrupted...), p.Remove...)
+}
+
+func getHostpathOSDPlan(ctx context.Context, client kubernetes.Interface, nodes []string) (hostpathOSDPlan, error) {
+	if err := checkNodesExist(ctx, client, nodes); err != nil {
+		return hostpathOSDPlan{}, err
+	}
+	osds, err := getRookOSDs(ctx, client)
+	if err != nil {
+		return hostpathOSDPlan{}, fmt.Errorf("failed to get the current list of OSDs: %w", err)
+	}
+	scaledDown, err := getScaledDownHostpathOSDs(ctx, client)
 	if err != nil {
-		return fmt.Errorf("failed to get the current list of OSDs: %w", err)
+		return hostpathOSDPlan{}, fmt.Errorf("failed to get scaled down hostpath OSDs: %w", err)
 	}
-	hostPathOSDs := hostOSDs(allOSDs)
+	return buildHostpathOSDPlan(osds, scaledDown, nodes), nil
+}
 
-	osdListStrings := []string{}
-	for _, osd := range hostPathOSDs {
-		osdListStrings = append(osdListStrings, fmt.Sprintf("osd.%d", osd))
+// buildHostpathOSDPlan selects the hostpath OSDs on the given nodes, or on all nodes if none are given. scaled down
+// OSDs that still have a pod are treated as running.
+func buildHostpathOSDPlan(osds, scaledDown []RookOSD, nodes []string) hostpathOSDPlan {
+	plan := hostpathOSDPlan{}
+	running := map[int64]bool{}
+	for _, osd := range osds {
+		running[osd.Num] = true
+		if !osd.IsHostpath {
+			continue
+		}
+		if osdOnNodes(osd, nodes) {
+			plan.Remove = append(plan.Remove, osd)
+		} else {
+			plan.Skipped = append(plan.Skipped, osd)
+		}
+	}
+	for _, osd := range scaledDown {
+		if !running[osd.Num] && osdOnNodes(osd, nodes) {
+			plan.Interrupted = append(plan.Interrupted, osd)
+		}
 	}
 
-	out(fmt.Sprintf("Removing hostpath OSDs %s from the cluster", strings.Join(osdListStrings, ", ")))
-	for _, osdNum := range hostPathOSDs {
-		err = safeRemoveOSD(ctx, client, osdNum)
-		if err != nil {
-			return fmt.Errorf("failed to safely remove OSD %d: %w", osdNum, err)
+	for _, list := range [][]RookOSD{plan.Interrupted, plan.Remove, plan.Skipped} {
+		sort.Slice(list, func(i, j int) bool { return list[i].Num < list[j].Num })
+	}
+	return plan
+}
+
+// checkNodesExist returns an error if any of the nodes does not match the name or the internal IP of a node in the
+// cluster, so a mistyped node is not reported as a node without hostpath OSDs.
+func checkNodesExist(ctx context.Context, client kubernetes.Interface, nodes []string) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	nodeList, err := client.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to list nodes: %w", err)
+	}
+	known := map[string]bool{}
+	for _, node := range nodeList.Items {
+		known[node.Name] = true
+		if ip, err := k8sutil.NodeInternalIP(node); err == nil {
+			known[ip] = true
+		}
+	}
+	for _, node := range nodes {
+		if !known[node] {
+			return fmt.Errorf("node %q does not match the name or the internal IP of any node in the cluster", node)
 		}
 	}
+	return nil
+}
 
-	out("Migration completed successfully!")
+func osdOnNodes(osd RookOSD, nodes []string) bool {
+	if len(nodes) == 0 {
+		return true
+	}
+	for _, node := range nodes {
+		if node == osd.NodeName || (osd.Node != "" && node == osd.Node) {
+			return true
+		}
+	}
+	return false
+}
+
+// printHostpathToOsdPlan prints the OSDs a migration would remove without changing anything in the cluster.
+func printHostpathToOsdPlan(ctx context.Context, client kubernetes.Interface, opts HostpathToOsdOptions) error {
+	plan, err := getHostpathOSDPlan(ctx, client, opts.Nodes)
+	if err != nil {
+		return fmt.Errorf("failed to determine the hostpath OSDs to migrate: %w", err)
+	}
+	sufficientBlockOSDs, err := HasSufficientBlockOSDs(ctx, client)
+	if err != nil {
+		return fmt.Errorf("failed to determine if there are enough block device OSDs: %w", err)
+	}
 
+	out("Dry run, the cluster will not be changed")
+	if sufficientBlockOSDs {
+		out("Enough block device OSDs are present")
+	} else {
+		out("Block device storage would be enabled and the migration would wait for block device OSDs to be added")
+	}
+	if len(plan.osds()) == 0 {
+		out("No hostpath OSDs would be removed")
+	} else {
+		out("Hostpath OSDs that would be removed, in order:")
+		for _, osd := range plan.Interrupted {
+			out(fmt.Sprintf("  osd.%d on %s (interrupted removal, resumed first)", osd.Num, osdNodeString(osd)))
+		}
+		for _, osd := range plan.Remove {
+			out(fmt.Sprintf("  osd.%d on %s", osd.Num, osdNodeString(osd)))
+		}
+		if opts.PauseBetweenRemovals > 0 && len(plan.osds()) > 1 {
+			out(fmt.Sprintf("The migration would pause for %s between OSD removals", opts.PauseBetweenRemovals))
+		}
+	}
+	if len(plan.Skipped) > 0 {
+		out("Hostpath OSDs on other nodes that would be kept:")
+		for _, osd := range plan.Skipped {
+			out(fmt.Sprintf("  osd.%d on %s", osd.Num, osdNodeString(osd)))
+		}
+	}
 	return nil
 }
 
+func osdNodeString(osd RookOSD) string {
+	if osd.Node == "" {
+		return osd.NodeName
+	}
+	return fmt.Sprintf("%s (%s)", osd.NodeName, osd.Node)
+}
+
+func osdListString(osds []RookOSD) string {
+	osdListStrings := []string{}
+	for _, osd := range osds {
+		osdListStrings = append(osdListStrings, fmt.Sprintf("osd.%d", osd.Num))
+	}
+	return strings.Join(osdListStrings, ", ")
+}
+
 // enableBlockDevices runs kubectl commands directly to edit the cephcluster object
 // this is because later versions of the go library do not have the relevant fields
 func enableBlockDevices(ctx context.Context, client kubernetes.Interface, cephClient *cephv1.CephV1Client) error {
@@ -219,6 +407,40 @@ func safeRemoveOSD(ctx context.Context, client kubernetes.Interface, osdNum int6
 	return nil
 }
 
+// resumeRemoveOSD finishes the removal of an OSD interrupted after its deployment was scaled down. if the OSD was
+// already purged only its deployment is left to delete, otherwise the removal starts over.
+func resumeRemoveOSD(ctx context.Context, client kubernetes.Interface, osdNum int64) error {
+	exists, err := osdExists(ctx, client, osdNum)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return safeRemoveOSD(ctx, client, osdNum)
+	}
+
+	out(fmt.Sprintf("osd.%d has already been purged, deleting its deployment", osdNum))
+	err = client.AppsV1().Deployments("rook-ceph").Delete(ctx, fmt.Sprintf("rook-ceph-osd-%d", osdNum), metav1.DeleteOptions{})
+	if err != nil && !k8sErrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete deployment for osd %d: %w", osdNum, err)
+	}
+	out(fmt.Sprintf("Successfully purged osd.%d", osdNum))
+	return nil
+}
+
+// osdExists returns true if the OSD is listed by 'ceph osd ls'.
+func osdExists(ctx context.Context, client kubernetes.Interface, osdNum int64) (bool, error) {
+	stdout, _, err := runToolboxCommand(ctx, client, []string{"ceph", "osd", "ls"})
+	if err != nil {
+		return false, fmt.Errorf("failed to run 'ceph osd ls': %w", err)
+	}
+	for _, line := range strings.Fields(stdout) {
+		if line == strconv.FormatInt(osdNum, 10) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func awaitDeploymentScale(ctx context.Context, client kubernetes.Interface, namespace, name string, desiredScale int32) error {
 	out(fmt.Sprintf("Waiting for deployment %s in %s to reach scale of %d", name, namespace, desiredScale))
 	errCount := 0
@@ -294,6 +516,7 @@ func waitForBlockOSDs(ctx context.Context, client kubernetes.Interface) error {
 type RookOSD struct {
 	Num        int64
 	Node       string
+	NodeName   string
 	IsHostpath bool
 }
 
@@ -306,7 +529,8 @@ func getRookOSDs(ctx context.Context, client kubernetes.Interface) ([]RookOSD, e
 	osds := []RookOSD{}
 	for _, pod := range pods.Items {
 		newOSD := RookOSD{
-			Node: pod.Status.HostIP,
+			Node:     pod.Status.HostIP,
+			NodeName: pod.Spec.NodeName,
 		}
 
 		osdNum, err := strconv.ParseInt(pod.Labels["ceph-osd-id"], 10, 32)
@@ -315,13 +539,7 @@ func getRookOSDs(ctx context.Context, client kubernetes.Interface) ([]RookOSD, e
 		}
 		newOSD.Num = osdNum
 
-		for _, container := range pod.Spec.Containers {
-			for _, mnt := range container.VolumeMounts {
-				if mnt.MountPath == "/opt/replicated/rook" {
-					newOSD.IsHostpath = true
-				}
-			}
-		}
+		newOSD.IsHostpath = hasHostpathMount(pod.Spec)
 
 		osds = append(osds, newOSD)
 	}
@@ -329,6 +547,56 @@ func getRookOSDs(ctx context.Context, client kubernetes.Interface) ([]RookOSD, e
 	return osds, nil
 }
 
+// getScaledDownHostpathOSDs returns the hostpath OSDs whose deployment has been scaled to 0 but not deleted, which
+// is the state safeRemoveOSD leaves an OSD in if it is interrupted before the purge. these have no pod and so are not
+// returned by getRookOSDs, the node IP is the internal IP of the node the deployment is pinned to.
+func getScaledDownHostpathOSDs(ctx context.Context, client kubernetes.Interface) ([]RookOSD, error) {
+	deployments, err := client.AppsV1().Deployments("rook-ceph").List(ctx, metav1.ListOptions{LabelSelector: "app=rook-ceph-osd"})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get deployments in rook-ceph: %w", err)
+	}
+
+	osds := []RookOSD{}
+	for _, deployment := range deployments.Items {
+		if deployment.Spec.Replicas == nil || *deployment.Spec.Replicas != 0 || !hasHostpathMount(deployment.Spec.Template.Spec) {
+			continue
+		}
+
+		osdNum, err := strconv.ParseInt(deployment.Labels["ceph-osd-id"], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to parse OSD number of deployment %q: %w", deployment.Name, err)
+		}
+		osd := RookOSD{
+			Num:        osdNum,
+			NodeName:   deployment.Spec.Template.Spec.NodeSelector[corev1.LabelHostname],
+			IsHostpath: true,
+		}
+		if osd.NodeName != "" {
+			node, err := client.CoreV1().Nodes().Get(ctx, osd.NodeName, metav1.GetOptions{})
+			if err != nil && !k8sErrors.IsNotFound(err) {
+				return nil, fmt.Errorf("unable to get node %s: %w", osd.NodeName, err)
+			} else if err == nil {
+				// nodes without an internal IP are only matched by name.
+				osd.Node, _ = k8sutil.NodeInternalIP(*node)
+			}
+		}
+		osds = append(osds, osd)
+	}
+
+	return osds, nil
+}
+
+func hasHostpathMount(spec corev1.PodSpec) bool {
+	for _, container := range spec.Containers {
+		for _, mnt := range container.VolumeMounts {
+			if mnt.MountPath == "/opt/replicated/rook" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // returns the number of nodes with hostpath-based osds, the number of nodes with block device based osds, and an error.
 func countRookOSDs(ctx context.Context, client kubernetes.Interface) (int, int, error) {
 	osds, err := getRookOSDs(ctx, client)
@@ -378,13 +646,3 @@ func HasSufficientBlockOSDs(ctx context.Context, client kubernetes.Interface) (b
 
 	return blockOSDCount >= desiredBlockCount, nil
 }
-
-// returns the list of OSDs that are hostpath OSDs
-func hostOSDs(osds []RookOSD) (hostOSDNums []int64) {
-	for _, osd := range osds {
-		if osd.IsHostpath {
-			hostOSDNums = append(hostOSDNums, osd.Num)
-		}
-	}
-	return
-}
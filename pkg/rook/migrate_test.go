@@ -1,15 +1,21 @@
 package rook
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/replicatedhq/kurl/pkg/rook/testfiles"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	k8sErrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
 )
 
 // test function only, contains panics
@@ -73,31 +79,37 @@ func Test_getRookOSDs(t *testing.T) {
 				{
 					Num:        3,
 					Node:       "10.128.0.101",
+					NodeName:   "laverya-rookmigrate-healthwait-main",
 					IsHostpath: false,
 				},
 				{
 					Num:        4,
 					Node:       "10.128.0.108",
+					NodeName:   "laverya-rookmigrate-healthwait-main2",
 					IsHostpath: false,
 				},
 				{
 					Num:        5,
 					Node:       "10.128.0.112",
+					NodeName:   "laverya-rookmigrate-healthwait-main3",
 					IsHostpath: false,
 				},
 				{
 					Num:        6,
 					Node:       "10.128.0.101",
+					NodeName:   "laverya-rookmigrate-healthwait-main",
 					IsHostpath: false,
 				},
 				{
 					Num:        7,
 					Node:       "10.128.0.108",
+					NodeName:   "laverya-rookmigrate-healthwait-main2",
 					IsHostpath: false,
 				},
 				{
 					Num:        8,
 					Node:       "10.128.0.112",
+					NodeName:   "laverya-rookmigrate-healthwait-main3",
 					IsHostpath: false,
 				},
 			},
@@ -109,6 +121,7 @@ func Test_getRookOSDs(t *testing.T) {
 				{
 					Num:        0,
 					Node:       "10.128.15.193",
+					NodeName:   "laverya-singlenoderook",
 					IsHostpath: true,
 				},
 			},
@@ -125,3 +138,169 @@ func Test_getRookOSDs(t *testing.T) {
 		})
 	}
 }
+
+func Test_buildHostpathOSDPlan(t *testing.T) {
+	osds := []RookOSD{
+		{Num: 3, Node: "10.0.0.2", NodeName: "node2", IsHostpath: true},
+		{Num: 1, Node: "10.0.0.1", NodeName: "node1", IsHostpath: true},
+		{Num: 2, Node: "10.0.0.1", NodeName: "node1", IsHostpath: true},
+		{Num: 5, Node: "10.0.0.1", NodeName: "node1", IsHostpath: false},
+		{Num: 4, Node: "10.0.0.3", NodeName: "node3", IsHostpath: true},
+	}
+	scaledDown := []RookOSD{
+		{Num: 0, NodeName: "node1", IsHostpath: true},
+		{Num: 4, NodeName: "node3", IsHostpath: true}, // still has a pod
+		{Num: 6, NodeName: "node3", IsHostpath: true},
+	}
+	tests := []struct {
+		name  string
+		nodes []string
+		want  hostpathOSDPlan
+	}{
+		{
+			name:  "all nodes",
+			nodes: nil,
+			want: hostpathOSDPlan{
+				Interrupted: []RookOSD{scaledDown[0], scaledDown[2]},
+				Remove:      []RookOSD{osds[1], osds[2], osds[0], osds[4]},
+			},
+		},
+		{
+			name:  "by node name and IP",
+			nodes: []string{"node1", "10.0.0.2"},
+			want: hostpathOSDPlan{
+				Interrupted: []RookOSD{scaledDown[0]},
+				Remove:      []RookOSD{osds[1], osds[2], osds[0]},
+				Skipped:     []RookOSD{osds[4]},
+			},
+		},
+		{
+			name:  "unknown node",
+			nodes: []string{"node4"},
+			want: hostpathOSDPlan{
+				Skipped: []RookOSD{osds[1], osds[2], osds[0], osds[4]},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := buildHostpathOSDPlan(append([]RookOSD{}, osds...), scaledDown, tt.nodes)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_getScaledDownHostpathOSDs(t *testing.T) {
+	req := require.New(t)
+	resources := runtimeFromDeploymentlistJSON(testfiles.RookHostpathDeployments)
+	clientset := fake.NewClientset(resources...)
+
+	osds, err := getScaledDownHostpathOSDs(context.Background(), clientset)
+	req.NoError(err)
+	req.Empty(osds)
+
+	_, err = clientset.AppsV1().Deployments("rook-ceph").Patch(context.Background(), "rook-ceph-osd-0", types.JSONPatchType, []byte(`[{"op":"replace", "path":"/spec/replicas", "value":0}]`), metav1.PatchOptions{})
+	req.NoError(err)
+
+	osds, err = getScaledDownHostpathOSDs(context.Background(), clientset)
+	req.NoError(err)
+	req.Equal([]RookOSD{{Num: 0, NodeName: "laverya-singlenoderook", IsHostpath: true}}, osds)
+
+	// the node IP is resolved from the node so that --nodes given as IPs match interrupted OSDs.
+	_, err = clientset.CoreV1().Nodes().Create(context.Background(), &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "laverya-singlenoderook"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeHostName, Address: "laverya-singlenoderook"},
+			{Type: corev1.NodeInternalIP, Address: "10.128.15.193"},
+		}},
+	}, metav1.CreateOptions{})
+	req.NoError(err)
+
+	osds, err = getScaledDownHostpathOSDs(context.Background(), clientset)
+	req.NoError(err)
+	req.Equal([]RookOSD{{Num: 0, Node: "10.128.15.193", NodeName: "laverya-singlenoderook", IsHostpath: true}}, osds)
+	req.True(osdOnNodes(osds[0], []string{"10.128.15.193"}))
+}
+
+func Test_resumeRemoveOSD(t *testing.T) {
+	toolbox := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "rook-ceph-tools-abc", Namespace: "rook-ceph", Labels: map[string]string{"app": "rook-ceph-tools"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "rook-ceph-tools"}}},
+	}
+	tests := []struct {
+		name    string
+		osds    string
+		wantErr string
+	}{
+		{
+			name: "purged osd only has its deployment deleted",
+			osds: "1\n2\n",
+		},
+		{
+			name:    "osd still in the cluster is removed again",
+			osds:    "0\n1\n2\n",
+			wantErr: "failed to run 'ceph osd reweight osd.0 0'",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			clientset := fake.NewClientset(append(runtimeFromDeploymentlistJSON(testfiles.RookHostpathDeployments), toolbox)...)
+			InitWriter(testWriter{t: t})
+			defer InitWriter(nil)
+			setToolboxExecFunc(execResponses{
+				"ceph - osd - ls - rook-ceph - rook-ceph-tools-abc - rook-ceph-tools": {stdout: tt.osds},
+			})
+			conf = &restclient.Config{} // set the rest client so that runToolboxCommand does not attempt to fetch it
+
+			err := resumeRemoveOSD(context.Background(), clientset, 0)
+			if tt.wantErr != "" {
+				req.ErrorContains(err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+			_, err = clientset.AppsV1().Deployments("rook-ceph").Get(context.Background(), "rook-ceph-osd-0", metav1.GetOptions{})
+			req.True(k8sErrors.IsNotFound(err), err)
+		})
+	}
+}
+
+func Test_printHostpathToOsdPlan(t *testing.T) {
+	req := require.New(t)
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "laverya-singlenoderook"}}
+	other := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "other"},
+		Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+			{Type: corev1.NodeInternalIP, Address: "10.128.15.194"},
+		}},
+	}
+	clientset := fake.NewClientset(append(runtimeFromPodlistJSON(testfiles.HostpathPods), node, other)...)
+
+	var buf bytes.Buffer
+	InitWriter(&buf)
+	defer InitWriter(nil)
+	rewriteType = rewriteNone // earlier tests may have left a spinner line open
+
+	err := printHostpathToOsdPlan(context.Background(), clientset, HostpathToOsdOptions{DryRun: true, PauseBetweenRemovals: time.Minute})
+	req.NoError(err)
+	req.Equal(`Dry run, the cluster will not be changed
+Block device storage would be enabled and the migration would wait for block device OSDs to be added
+Hostpath OSDs that would be removed, in order:
+  osd.0 on laverya-singlenoderook (10.128.15.193)
+`, buf.String())
+
+	for _, nodes := range [][]string{{"other"}, {"10.128.15.194"}} {
+		buf.Reset()
+		err = printHostpathToOsdPlan(context.Background(), clientset, HostpathToOsdOptions{DryRun: true, Nodes: nodes})
+		req.NoError(err)
+		req.Equal(`Dry run, the cluster will not be changed
+Block device storage would be enabled and the migration would wait for block device OSDs to be added
+No hostpath OSDs would be removed
+Hostpath OSDs on other nodes that would be kept:
+  osd.0 on laverya-singlenoderook (10.128.15.193)
+`, buf.String())
+	}
+
+	err = printHostpathToOsdPlan(context.Background(), clientset, HostpathToOsdOptions{DryRun: true, Nodes: []string{"other", "unknown"}})
+	req.EqualError(err, `failed to determine the hostpath OSDs to migrate: node "unknown" does not match the name or the internal IP of any node in the cluster`)
+}
@@ -3,6 +3,7 @@ package rook
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
 	"time"
 
@@ -53,14 +54,9 @@ func hasRookOrCephVersion(deployments *appsv1.DeploymentList, desiredVersion str
 	notreadyNames := []string{}
 	// compare labels with desired version
 	for _, dep := range deployments.Items {
-		rookVer := normalizeRookVersion(dep.Labels[labelKey])
+		rookVer, ok := deploymentVersion(dep, labelKey)
 		if rookVer != desiredVersion {
-			if rookVer == "" {
-				// Ignore this scenario because the label may not be set yet.
-				continue
-			}
-			if strings.Contains(rookVer, "0.0.0") {
-				// Ignore this scenario because Rook versions < 1.4.8 has a bug where the version is not set.
+			if !ok {
 				continue
 			}
 			_, ok := oldVersions[rookVer]
@@ -91,6 +87,40 @@ func hasRookOrCephVersion(deployments *appsv1.DeploymentList, desiredVersion str
 	return false, messages
 }
 
+// deploymentVersion returns the rook or ceph version, depending on the label key, of a Rook-Ceph deployment. returns
+// false when the version is unknown.
+func deploymentVersion(dep appsv1.Deployment, labelKey string) (string, bool) {
+	version := normalizeRookVersion(dep.Labels[labelKey])
+	if version == "" {
+		// the label may not be set yet.
+		return "", false
+	}
+	if strings.Contains(version, "0.0.0") {
+		// Rook versions < 1.4.8 has a bug where the version is not set.
+		return "", false
+	}
+	return version, true
+}
+
+// RookOrCephVersions returns the sorted rook or ceph versions, depending on the label key, run by the Rook-Ceph
+// deployments. more than one version is returned during upgrades.
+func RookOrCephVersions(ctx context.Context, client kubernetes.Interface, labelKey string) ([]string, error) {
+	deployments, err := client.AppsV1().Deployments("rook-ceph").List(ctx, metav1.ListOptions{LabelSelector: "rook_cluster=rook-ceph"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list Rook deployments: %w", err)
+	}
+	seen := map[string]bool{}
+	var versions []string
+	for _, dep := range deployments.Items {
+		if version, ok := deploymentVersion(dep, labelKey); ok && !seen[version] {
+			seen[version] = true
+			versions = append(versions, version)
+		}
+	}
+	sort.Strings(versions)
+	return versions, nil
+}
+
 // normalizeRookVersion trims the "v" prefix from a rook version.
 func normalizeRookVersion(v string) string {
 	return strings.TrimPrefix(v, "v")
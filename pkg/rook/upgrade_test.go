@@ -1,12 +1,15 @@
 package rook
 
 import (
+	"context"
 	"encoding/json"
 	"testing"
 
 	"github.com/replicatedhq/kurl/pkg/rook/testfiles"
 	"github.com/stretchr/testify/assert"
 	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
 )
 
 func Test_normalizeRookVersion(t *testing.T) {
@@ -112,3 +115,20 @@ func Test_hasRookOrCephVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestRookOrCephVersions(t *testing.T) {
+	deploymentList := deploymentListFromDeploymentsJson(testfiles.WaitForRookVersionOldVersions)
+	var objects []runtime.Object
+	for i := range deploymentList.Items {
+		objects = append(objects, &deploymentList.Items[i])
+	}
+	client := fake.NewClientset(objects...)
+
+	rookVersions, err := RookOrCephVersions(context.Background(), client, "rook-version")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1.7.11", "1.8.10"}, rookVersions)
+
+	cephVersions, err := RookOrCephVersions(context.Background(), client, "ceph-version")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"16.2.9-0"}, cephVersions)
+}
@@ -22,3 +22,15 @@ func Fprint(w io.Writer) {
 func Version() string {
 	return version
 }
+
+// Info is the build information of the kurl binary.
+type Info struct {
+	Version   string `json:"version"`
+	GitSHA    string `json:"sha"`
+	BuildTime string `json:"time"`
+}
+
+// Get returns the build information injected at build time.
+func Get() Info {
+	return Info{Version: version, GitSHA: gitSHA, BuildTime: buildTime}
+}
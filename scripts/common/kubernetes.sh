@@ -925,6 +925,19 @@ spec:
   - name: pod
     image: $KURL_UTIL_IMAGE
     command: [/usr/local/bin/network, --server]
+    env:
+    - name: NODE_NAME
+      valueFrom:
+        fieldRef:
+          fieldPath: spec.nodeName
+    - name: POD_NAME
+      valueFrom:
+        fieldRef:
+          fieldPath: metadata.name
+    - name: POD_IP
+      valueFrom:
+        fieldRef:
+          fieldPath: status.podIP
 ---
 apiVersion: v1
 kind: Pod
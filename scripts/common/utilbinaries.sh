@@ -22,6 +22,7 @@ function download_util_binaries() {
 
     mkdir -p /tmp/kurl-bin-utils/specs
     MERGED_YAML_SPEC=/tmp/kurl-bin-utils/specs/merged.yaml
+    MERGED_YAML_PROVENANCE=/tmp/kurl-bin-utils/specs/merged-provenance.yaml
     VENDOR_PREFLIGHT_SPEC=/tmp/kurl-bin-utils/specs/vendor-preflight.yaml
 
     PARSED_YAML_SPEC=/tmp/kurl-bin-utils/scripts/variables.sh
@@ -255,7 +256,7 @@ EOL
 ${INSTALLER_YAML}
 EOL
 
-    $BIN_INSTALLERMERGE -m $MERGED_YAML_SPEC -b /tmp/vendor_kurl_installer_spec_docker.yaml -o $INSTALLER_SPEC_FILE
+    $BIN_INSTALLERMERGE -schema -m $MERGED_YAML_SPEC -p $MERGED_YAML_PROVENANCE -b /tmp/vendor_kurl_installer_spec_docker.yaml -o $INSTALLER_SPEC_FILE
 }
 
 function apply_docker_config() {
@@ -267,15 +268,21 @@ function apply_docker_config() {
         return
     fi
 
-    local previous_contents="$(cat /etc/docker/daemon.json 2>/dev/null | xargs)" # xargs trims whitespace
+    # prints none, reload or restart depending on the keys changed in daemon.json
+    local action=
+    action="$($BIN_DOCKER_CONFIG -c /etc/docker/daemon.json -s $MERGED_YAML_SPEC)"
 
-    $BIN_DOCKER_CONFIG -c /etc/docker/daemon.json -s $MERGED_YAML_SPEC
-
-    if [ "$previous_contents" = "$(cat /etc/docker/daemon.json 2>/dev/null | xargs)" ]; then
+    if [ "$action" = "none" ]; then
         # if the spec has not changed do not restart docker
         return
     fi
 
+    if [ "$action" = "reload" ] && systemctl is-active --quiet docker ; then
+        # all changed settings are applied on SIGHUP
+        systemctl reload docker
+        return
+    fi
+
     if ! commandExists kubectl ; then
         restart_docker
         return